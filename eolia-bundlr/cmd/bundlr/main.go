@@ -1,38 +1,165 @@
 package main
 
 import (
+	"context"
 	"eolia-bundlr/config"
 	"eolia-bundlr/internal/bundlr"
 	"eolia-bundlr/internal/rpc"
-	"eolia-bundlr/internal/types"
+	"eolia-common/logging"
+	"eolia-common/settings"
+	"eolia-common/tracing"
+	"flag"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
 func main() {
-	cfg := config.LoadConfig("config/config.yaml")
+	defaultPath, pathFromEnv := os.LookupEnv(config.ENV_PREFIX + "CONFIG")
+	if !pathFromEnv {
+		defaultPath = "config/config.yaml"
+	}
+
+	var overrides settings.Overrides
+	configPath := flag.String("config", defaultPath, "path to the config file (env "+config.ENV_PREFIX+"CONFIG)")
+	flag.Var(&overrides, "set", "override a setting, e.g. -set bundling.mode=manual (repeatable)")
+	flag.Parse()
+
+	// The default file may be absent when everything comes from the environment.
+	pathRequired := pathFromEnv || isFlagSet("config")
+	cfg := config.LoadConfig(*configPath, pathRequired, overrides)
+
+	if err := logging.Setup(cfg.Log, "eolia-bundlr"); err != nil {
+		log.Fatalf("invalid log config: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, "eolia-bundlr")
+	if err != nil {
+		fatal("invalid tracing config", "error", err)
+	}
 
 	app := fiber.New()
+	app.Use(logging.RequestLogger(slog.LevelDebug))
+	app.Use(rpc.RequestTracer())
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000, http://127.0.0.1:8080",
 		AllowCredentials: true,
 	}))
 
-	bundlr := bundlr.NewBundlr(cfg)
-	rpc.Bundlr = bundlr
+	chains, err := cfg.ChainConfigs()
+	if err != nil {
+		fatal("invalid chains config", "error", err)
+	}
+
+	for i := range chains {
+		b, err := bundlr.NewBundlr(&chains[i])
+		if err != nil {
+			fatal("failed to create bundlr", "chain_id", chains[i].ChainID, "error", err)
+		}
+		rpc.Bundlrs = append(rpc.Bundlrs, b)
+	}
 
 	rpc.SetupRoutes(app)
+	rpc.SetupWebSocketRoutes(app, cfg.AdminToken)
+	if cfg.DebugRPC {
+		rpc.SetupDebugRoutes(app, cfg.AdminToken)
+	}
+	rpc.SetupAdminRoutes(app, cfg)
+	var metricsApp *fiber.App
+	if cfg.MetricsListen != "" {
+		metricsApp = fiber.New()
+		rpc.SetupMetricsListener(metricsApp)
+	} else {
+		rpc.SetupMetricsRoutes(app, cfg.AdminToken)
+	}
+	rpc.SetupHealthRoutes(app)
 
-	types.ChainID = bundlr.ChainID
-	types.EntryPoint = bundlr.Validator.EntryPoint
+	for _, b := range rpc.Bundlrs {
+		b.StartBundlerLoop()
+	}
 
-	bundlr.StartBundlerLoop()
+	slog.Info("listening", "addr", cfg.Listen, "chains", len(rpc.Bundlrs))
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(cfg.Listen)
+	}()
+	if metricsApp != nil {
+		slog.Info("serving metrics", "addr", cfg.MetricsListen)
+		go func() {
+			listenErr <- metricsApp.Listen(cfg.MetricsListen)
+		}()
+	}
 
-	err := app.Listen(":8181")
-	if err != nil {
-		log.Fatalf("fiber listen failed: %v", err)
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			fatal("fiber listen failed", "error", err)
+		}
+		return
+	case <-sigCtx.Done():
+	}
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	slog.Info("shutting down", "deadline", timeout.String())
+
+	// The HTTP server stays up while draining so clients can still poll receipts;
+	// ProcessUserOperation rejects new ops from here on.
+	var wg sync.WaitGroup
+	for _, b := range rpc.Bundlrs {
+		wg.Add(1)
+		go func(b *bundlr.Bundlr) {
+			defer wg.Done()
+			if err := b.Shutdown(ctx); err != nil {
+				slog.Error("bundlr shutdown", "chain", b.ChainLabel(), "error", err)
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		slog.Error("fiber shutdown", "error", err)
+	}
+	if metricsApp != nil {
+		if err := metricsApp.ShutdownWithContext(ctx); err != nil {
+			slog.Error("metrics server shutdown", "error", err)
+		}
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
 	}
 }
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -1,31 +1,229 @@
 package config
 
 import (
+	"eolia-common/logging"
+	"eolia-common/settings"
+	"eolia-common/tracing"
+	"fmt"
 	"log"
-	"os"
-
-	"gopkg.in/yaml.v3"
+	"math/big"
+	"strings"
+	"time"
 )
 
+// Environment variables override the file, e.g. EOLIA_BUNDLR_RPC_URL or EOLIA_BUNDLR_BUNDLING_MODE.
+const ENV_PREFIX = "EOLIA_BUNDLR_"
+
+const DEFAULT_LISTEN = ":8181"
+
 type Config struct {
-	ChainID          int64  `yaml:"chain_id"`
-	RPCURL           string `yaml:"rpc_url"`
-	EntryPoint       string `yaml:"entry_point"`
-	Factory          string `yaml:"factory"`
-	BundlrAddress    string `yaml:"bundlr_address"`
-	BundlrPrivateKey string `yaml:"bundlr_private_key"`
+	// A single-chain deployment configures its chain at the top level.
+	ChainConfig `yaml:",inline"`
+	// Chains served by this process. Takes precedence over the top-level chain fields.
+	Chains []ChainConfig `yaml:"chains"`
+
+	// Address the HTTP server listens on (default :8181).
+	Listen string `yaml:"listen"`
+	// Separate address serving GET /metrics without authentication, meant for a private
+	// interface. Empty serves it on listen behind admin_token.
+	MetricsListen string `yaml:"metrics_listen"`
+
+	// Deadline for draining the in-flight bundle and shutting the HTTP server down.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Log level and format (json | text).
+	Log logging.Config `yaml:"log"`
+	// OTLP trace exporter; tracing is off without an endpoint.
+	Tracing tracing.Config `yaml:"tracing"`
+
+	// Enables the debug_bundler_* RPC namespace on /rpc/debug.
+	DebugRPC bool `yaml:"debug_rpc"`
+	// Bearer token required by the debug namespace.
+	AdminToken string `yaml:"admin_token" secret:"true"`
+}
+
+// ChainConfig is everything one Bundlr instance needs.
+type ChainConfig struct {
+	// Route prefix of the chain besides its chain id, e.g. "xlayer-testnet". Must not be numeric.
+	Name    string `yaml:"name"`
+	ChainID int64  `yaml:"chain_id"`
+	RPCURL  string `yaml:"rpc_url" secret:"url"`
+	// Extra nodes of the same chain; requests fail over between rpc_url and these.
+	RPCURLs          []string `yaml:"rpc_urls" secret:"url"`
+	EntryPoint       string   `yaml:"entry_point"`
+	Factory          string   `yaml:"factory"`
+	BundlrAddress    string   `yaml:"bundlr_address"`
+	BundlrPrivateKey string   `yaml:"bundlr_private_key" secret:"true"`
+
+	Upstream   UpstreamConfig   `yaml:"upstream"`
+	Bundling   BundlingConfig   `yaml:"bundling"`
+	Validation ValidationConfig `yaml:"validation"`
+	Fees       FeeConfig        `yaml:"fees"`
+	PVG        PVGConfig        `yaml:"pvg"`
+	Health     HealthConfig     `yaml:"health"`
+
+	// File the op queue is flushed to on shutdown and restored from on startup (empty = no persistence).
+	QueueFile string `yaml:"queue_file"`
 }
 
-func LoadConfig(path string) *Config {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+// UpstreamConfig controls the health checks of the chain's RPC nodes.
+type UpstreamConfig struct {
+	// How often each node is probed (0 = 5s).
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// Probe timeout (0 = 3s).
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// Blocks a node may lag behind the highest one before it is skipped (0 = 5).
+	MaxLag uint64 `yaml:"max_lag"`
+}
+
+// URLs returns rpc_url followed by rpc_urls.
+func (c *ChainConfig) URLs() []string {
+	var urls []string
+	if c.RPCURL != "" {
+		urls = append(urls, c.RPCURL)
 	}
+	return append(urls, c.RPCURLs...)
+}
 
+// HealthConfig sets the thresholds of the chain's /readyz checks.
+type HealthConfig struct {
+	// Executor balance in wei, as a decimal string, below which the chain is not ready
+	// (empty or 0 = no threshold). A string, since useful thresholds overflow uint64.
+	MinBalance string `yaml:"min_balance"`
+	// How long one bundler loop iteration may run before the loop counts as stalled (0 = 2m).
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+}
+
+// MinBalanceWei parses MinBalance; Validate has rejected malformed values.
+func (h HealthConfig) MinBalanceWei() *big.Int {
+	minBalance, ok := new(big.Int).SetString(h.MinBalance, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return minBalance
+}
+
+// BundlingConfig controls when the bundler loop builds and sends a bundle.
+type BundlingConfig struct {
+	// interval | new_head | mempool_size | mempool_gas | manual (default: interval)
+	Mode string `yaml:"mode"`
+	// Bundle period in interval mode; max wait for an op in the mempool_* modes (0 = no max wait).
+	Interval time.Duration `yaml:"interval"`
+	// How often the chain head is polled in new_head mode.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Mempool size that triggers a bundle in mempool_size mode.
+	MaxOps int `yaml:"max_ops"`
+	// Total mempool gas that triggers a bundle in mempool_gas mode.
+	MaxGas uint64 `yaml:"max_gas"`
+}
+
+// ValidationConfig holds the sanity limits applied to incoming ops before simulation.
+type ValidationConfig struct {
+	// Maximum size of an op in bytes (0 = 32 KiB).
+	MaxOpSize int `yaml:"max_op_size"`
+	// Minimum maxPriorityFeePerGas in wei.
+	MinPriorityFee uint64 `yaml:"min_priority_fee"`
+	// Factories accepted in initCode, on top of `factory`.
+	Factories []string `yaml:"factories"`
+	// TokenPaymasters whose ops must come from a sender holding the tokens they may be charged.
+	TokenPaymasters []string `yaml:"token_paymasters"`
+	// Hardhat artifact of EntryPointSimulations, needed by eth_estimateUserOperationGas.
+	EntryPointSimulations string `yaml:"entrypoint_simulations"`
+}
+
+// FeeConfig is the fee policy of the chain: how fees are sampled and what ops must pay.
+type FeeConfig struct {
+	// Number of blocks sampled with eth_feeHistory (0 = 20).
+	Window uint64 `yaml:"window"`
+	// Reward percentile used for the priority fee suggestion (0 = 50).
+	Percentile float64 `yaml:"percentile"`
+	// Ops must declare maxFeePerGas >= base fee * (100 + premium) / 100; handleOps
+	// transactions pay the same on top of the priority fee. Must not be negative.
+	BaseFeePremium int64 `yaml:"base_fee_premium"`
+	// Floor of the priority fee suggestion, in wei.
+	MinPriorityFee uint64 `yaml:"min_priority_fee"`
+	// How long a sample is reused (0 = 2s).
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// PVGConfig controls the preVerificationGas ops are required to pay.
+type PVGConfig struct {
+	// Gas of the bundle transaction itself (0 = 21000).
+	FixedOverhead uint64 `yaml:"fixed_overhead"`
+	// Per-op EntryPoint bookkeeping gas (0 = 18300).
+	PerUserOpOverhead uint64 `yaml:"per_userop_overhead"`
+	// Number of ops the fixed overhead is split over (0 = 1).
+	ExpectedBundleSize uint64 `yaml:"expected_bundle_size"`
+	// L1 data fee oracle: "" (none) | optimism | zkevm
+	L1Oracle string `yaml:"l1_oracle"`
+	// GasPriceOracle address for optimism (default: the 0x42..0F predeploy).
+	GasPriceOracle string `yaml:"gas_price_oracle"`
+	// L1 RPC the zkevm oracle reads the L1 gas price from.
+	L1RPCURL string `yaml:"l1_rpc_url" secret:"url"`
+}
+
+// LoadConfig layers the config file at path, EOLIA_BUNDLR_* environment variables and the
+// -set overrides, in that order, and validates the result. It exits on any error.
+// A missing file is accepted when pathRequired is false, for env-only deployments.
+func LoadConfig(path string, pathRequired bool, overrides settings.Overrides) *Config {
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		log.Fatalf("Failed to parse config: %v", err)
+	if err := settings.LoadFile(path, pathRequired, &cfg); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := settings.ApplyEnv(&cfg, ENV_PREFIX); err != nil {
+		log.Fatalf("Invalid config environment: %v", err)
+	}
+
+	if err := overrides.Apply(&cfg); err != nil {
+		log.Fatalf("Invalid config override: %v", err)
+	}
+
+	if cfg.Listen == "" {
+		cfg.Listen = DEFAULT_LISTEN
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	return &cfg
 }
+
+// ChainConfigs returns the chains to serve, the first one being the default chain.
+func (c *Config) ChainConfigs() ([]ChainConfig, error) {
+	chains := c.Chains
+	if len(chains) == 0 {
+		chains = []ChainConfig{c.ChainConfig}
+	}
+
+	ids := make(map[int64]bool)
+	names := make(map[string]bool)
+	queueFiles := make(map[string]bool)
+	for _, chain := range chains {
+		if ids[chain.ChainID] {
+			return nil, fmt.Errorf("duplicate chain_id %d", chain.ChainID)
+		}
+		ids[chain.ChainID] = true
+
+		if chain.Name != "" {
+			if names[chain.Name] || chain.Name == "rpc" || strings.Contains(chain.Name, "/") {
+				return nil, fmt.Errorf("invalid or duplicate chain name %q", chain.Name)
+			}
+			// Routes are served under /{chainId} too; a numeric name could take another chain's.
+			if strings.Trim(chain.Name, "0123456789") == "" {
+				return nil, fmt.Errorf("chain name %q must not be numeric", chain.Name)
+			}
+			names[chain.Name] = true
+		}
+
+		if chain.QueueFile != "" {
+			if queueFiles[chain.QueueFile] {
+				return nil, fmt.Errorf("duplicate queue_file %s", chain.QueueFile)
+			}
+			queueFiles[chain.QueueFile] = true
+		}
+	}
+
+	return chains, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"eolia-common/settings"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var check settings.Checker
+
+	chains, err := c.ChainConfigs()
+	if err != nil {
+		check.Fail("%v", err)
+	}
+
+	for i := range chains {
+		prefix := ""
+		if len(c.Chains) > 0 {
+			prefix = fmt.Sprintf("chains[%d].", i)
+		}
+		chains[i].validate(&check, prefix)
+	}
+
+	if err := c.Log.Validate(); err != nil {
+		check.Fail("log: %v", err)
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		check.Fail("tracing: %v", err)
+	}
+
+	if c.DebugRPC && c.AdminToken == "" {
+		check.Fail("debug_rpc requires admin_token")
+	}
+
+	if c.MetricsListen != "" && c.MetricsListen == c.Listen {
+		check.Fail("metrics_listen must differ from listen")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		check.Fail("shutdown_timeout must not be negative")
+	}
+
+	return check.Err()
+}
+
+func (c *ChainConfig) validate(check *settings.Checker, prefix string) {
+	if c.ChainID <= 0 {
+		check.Fail("%schain_id is required", prefix)
+	}
+
+	urls := c.URLs()
+	if len(urls) == 0 {
+		check.Fail("%srpc_url is required", prefix)
+	}
+	for _, url := range urls {
+		check.URL(prefix+"rpc_url", url, "http", "https")
+	}
+
+	check.Address(prefix+"entry_point", c.EntryPoint, true)
+	check.Address(prefix+"factory", c.Factory, false)
+	check.Address(prefix+"bundlr_address", c.BundlrAddress, false)
+
+	if key, ok := check.PrivateKey(prefix+"bundlr_private_key", c.BundlrPrivateKey); ok && c.BundlrAddress != "" {
+		if common.IsHexAddress(c.BundlrAddress) && common.HexToAddress(c.BundlrAddress) != key {
+			check.Fail("%sbundlr_address %s does not match bundlr_private_key (%s)", prefix, c.BundlrAddress, key.Hex())
+		}
+	}
+
+	for i, f := range c.Validation.Factories {
+		check.Address(fmt.Sprintf("%svalidation.factories[%d]", prefix, i), f, true)
+	}
+	for i, p := range c.Validation.TokenPaymasters {
+		check.Address(fmt.Sprintf("%svalidation.token_paymasters[%d]", prefix, i), p, true)
+	}
+
+	switch strings.ToLower(c.PVG.L1Oracle) {
+	case "", "optimism":
+	case "zkevm":
+		if check.Required(prefix+"pvg.l1_rpc_url", c.PVG.L1RPCURL) {
+			check.URL(prefix+"pvg.l1_rpc_url", c.PVG.L1RPCURL, "http", "https", "ws", "wss")
+		}
+	default:
+		check.Fail("%spvg.l1_oracle must be optimism or zkevm, got %q", prefix, c.PVG.L1Oracle)
+	}
+	check.Address(prefix+"pvg.gas_price_oracle", c.PVG.GasPriceOracle, false)
+
+	if c.Health.MinBalance != "" {
+		if minBalance, ok := new(big.Int).SetString(c.Health.MinBalance, 10); !ok || minBalance.Sign() < 0 {
+			check.Fail("%shealth.min_balance must be a non-negative amount of wei, got %q", prefix, c.Health.MinBalance)
+		}
+	}
+	if c.Health.StallTimeout < 0 {
+		check.Fail("%shealth.stall_timeout must not be negative", prefix)
+	}
+
+	if c.Fees.Percentile < 0 || c.Fees.Percentile > 100 {
+		check.Fail("%sfees.percentile must be between 0 and 100", prefix)
+	}
+	if c.Fees.BaseFeePremium < 0 {
+		check.Fail("%sfees.base_fee_premium must not be negative", prefix)
+	}
+}
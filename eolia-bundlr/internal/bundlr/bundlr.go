@@ -4,72 +4,223 @@ import (
 	"context"
 	"encoding/hex"
 	"eolia-bundlr/config"
+	"eolia-bundlr/internal/fees"
+	"eolia-bundlr/internal/metrics"
 	"eolia-bundlr/internal/signer"
 	"eolia-bundlr/internal/types"
 	"eolia-bundlr/internal/validator"
+	"eolia-common/logging"
+	"eolia-common/tracing"
+	"eolia-common/upstream"
+	"eolia-common/userop"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	gtypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// BundleAndSend polls for the receipt of a bundle it just sent RECEIPT_POLLS times; a
+	// bundle mined later is settled on a following round.
+	RECEIPT_POLLS          = 40
+	RECEIPT_POLL_INTERVAL  = 100 * time.Millisecond
+	RECEIPT_LOOKUP_TIMEOUT = 5 * time.Second
+	// A bundle transaction the node doesn't know anymore after RESUBMIT_AFTER was dropped.
+	RESUBMIT_AFTER = time.Minute
 )
 
 type Bundlr struct {
-	ChainID   *big.Int
-	Signer    *signer.LocalSigner
-	Queue     *OpQueue
-	Validator *validator.Validator
-	Ctx       context.Context
-	cancel    context.CancelFunc
+	Name       string
+	ChainID    *big.Int
+	Signer     *signer.LocalSigner
+	Queue      *OpQueue
+	Reputation *Reputation
+	Validator  *validator.Validator
+	Scheduler  *Scheduler
+	Upstreams  *upstream.Pool
+	Events     *OpEvents
+	Ctx        context.Context
+	cancel     context.CancelFunc
+	bundleMu   sync.Mutex
+	loopDone   chan struct{}
+	closed     atomic.Bool
+	queueFile  string
+	log        *slog.Logger
+
+	health    config.HealthConfig
+	busySince atomic.Int64
+	lastBeat  atomic.Int64
 }
 
-func NewBundlr(cfg *config.Config) *Bundlr {
+func NewBundlr(cfg *config.ChainConfig) (*Bundlr, error) {
+	scheduler, err := NewScheduler(cfg.Bundling)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundling config: %w", err)
+	}
+
+	logger := slog.Default().With("chain", chainLabel(cfg.Name, cfg.ChainID))
+
+	queue := NewOpQueue()
+	if cfg.QueueFile != "" {
+		n, err := queue.Load(cfg.QueueFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("restored op queue", "ops", n, "file", cfg.QueueFile)
+	}
+
+	pool, err := upstream.NewPool(cfg.URLs(), upstream.Config{
+		CheckInterval: cfg.Upstream.CheckInterval,
+		CheckTimeout:  cfg.Upstream.CheckTimeout,
+		MaxLag:        cfg.Upstream.MaxLag,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream config: %w", err)
+	}
+
+	v, err := validator.NewValidator(pool.Client(), common.HexToAddress(cfg.EntryPoint), common.HexToAddress(cfg.BundlrAddress), common.HexToAddress(cfg.Factory), cfg.Validation)
+	if err != nil {
+		return nil, err
+	}
+	v.Fees = fees.NewOracle(v.Client, cfg.Fees)
+	v.PVG, err = validator.NewPVGCalculator(cfg.PVG, v.Client)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pvg config: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Bundlr{
-		ChainID:   big.NewInt(cfg.ChainID),
-		Signer:    signer.NewLocalSigner(cfg.BundlrPrivateKey, big.NewInt(cfg.ChainID)),
-		Queue:     NewOpQueue(),
-		Validator: validator.NewValidator(cfg.RPCURL, common.HexToAddress(cfg.EntryPoint), common.HexToAddress(cfg.BundlrAddress), common.HexToAddress(cfg.Factory)),
-		Ctx:       ctx,
-		cancel:    cancel,
-	}
+		Name:       cfg.Name,
+		ChainID:    big.NewInt(cfg.ChainID),
+		Signer:     signer.NewLocalSigner(cfg.BundlrPrivateKey, big.NewInt(cfg.ChainID)),
+		Queue:      queue,
+		Reputation: NewReputation(),
+		Validator:  v,
+		Scheduler:  scheduler,
+		Upstreams:  pool,
+		Events:     NewOpEvents(),
+		Ctx:        ctx,
+		cancel:     cancel,
+		queueFile:  cfg.QueueFile,
+		log:        logger,
+		health:     cfg.Health,
+	}, nil
 }
 
-func (b *Bundlr) ProcessUserOperation(op *types.PackedUserOperation, opHash *common.Hash) error {
-	err := b.Validator.SimulateHandleOp(op)
+// ProcessUserOperation validates and simulates op and adds it to the mempool.
+// ctx carries the request's logger and id, which are kept with the queued op.
+func (b *Bundlr) ProcessUserOperation(ctx context.Context, op *types.PackedUserOperation, opHash *common.Hash) (err error) {
+	ctx, span := tracing.Start(ctx, "bundlr.ProcessUserOperation",
+		attribute.String("chain", b.ChainLabel()), attribute.String("userop.hash", opHash.Hex()), attribute.String("userop.sender", op.Sender.Hex()))
+	defer func() { tracing.End(span, err) }()
+
+	log := logging.FromContext(ctx).With("chain", b.ChainLabel(), "userOpHash", opHash.Hex(), "sender", op.Sender.Hex())
+
+	reject := func(reason string, err error) error {
+		log.Info("op rejected", "reason", reason, "error", err)
+		span.SetAttributes(attribute.String("userop.reject_reason", reason))
+		return b.reject(reason, err)
+	}
+
+	if b.closed.Load() {
+		return reject("shutting_down", ErrShuttingDown)
+	}
+
+	if addr, status, rejected := b.rejectedEntity(op); rejected {
+		return reject(string(status), fmt.Errorf("entity %s is %s", addr.Hex(), status))
+	}
+
+	validateCtx, validateSpan := tracing.Start(ctx, "bundlr.validate")
+	err = b.Validator.ValidateUserOp(validateCtx, op)
+	tracing.End(validateSpan, err)
+	if err != nil {
+		return reject(RejectReason(err, "invalid"), err)
+	}
+
+	simulateCtx, simulateSpan := tracing.Start(ctx, "bundlr.simulate")
+	start := time.Now()
+	err = b.Validator.SimulateHandleOp(simulateCtx, op)
+	metrics.SimulationSeconds.WithLabelValues(b.ChainLabel(), "single").Observe(time.Since(start).Seconds())
+	tracing.End(simulateSpan, err)
 	if err != nil {
-		return fmt.Errorf("UserOperation simulation failed: %w", err)
+		return reject(RejectReason(err, "simulation"), fmt.Errorf("UserOperation simulation failed: %w", err))
 	}
 
-	err = b.Queue.Add(op, opHash)
+	err = b.Queue.Add(op, opHash, logging.RequestID(ctx), tracing.Carrier(ctx))
 	if err != nil {
-		return fmt.Errorf("OpQueue insert failed: %w", err)
+		return reject("queue", fmt.Errorf("OpQueue insert failed: %w", err))
 	}
 
+	b.forEachEntity(op, b.Reputation.UpdateSeen)
+
 	b.Queue.SetAsBundled(GetOpKey(op))
+	b.Scheduler.OnNewOp(b.Queue)
+
+	if queued, err := b.Queue.Get(op); err == nil {
+		b.Events.publish(opEvent(queued, OP_STATUS_PENDING))
+	}
 
+	log.Info("op accepted", "nonce", op.Nonce.String())
 	return nil
 }
 
-func (b *Bundlr) BundleAndSend() error {
-	queuedOps := b.Queue.GetAll()
+// opLogger returns the bundler's logger with the fields that identify a queued op,
+// including the id of the request that submitted it.
+func (b *Bundlr) opLogger(op *QueuedOp) *slog.Logger {
+	log := b.log.With("userOpHash", op.OpHash.Hex(), "sender", op.Op.Sender.Hex())
+	if op.RequestID != "" {
+		log = log.With("request_id", op.RequestID)
+	}
+	return log
+}
+
+func (b *Bundlr) BundleAndSend() (err error) {
+	// Bundles sent earlier are settled first, so their ops are neither bundled twice nor
+	// re-simulated after they were already included.
+	b.settleSubmitted()
 
-	if len(queuedOps) == 0 {
+	var candidates []*QueuedOp
+	for _, v := range b.Queue.GetAll() {
+		if v.State == "pending" || v.State == "bundled" {
+			candidates = append(candidates, v)
+		}
+	}
+
+	if len(candidates) == 0 {
+		b.log.Debug("no ops to bundle")
 		return nil
 	}
 
-	var packedOps []types.PackedUserOperation
-	for _, v := range queuedOps {
-		if v.State != "sent" {
-			packedOps = append(packedOps, *v.Op)
-		}
+	packedOps := make([]types.PackedUserOperation, 0, len(candidates))
+	for _, v := range candidates {
+		packedOps = append(packedOps, *v.Op)
+	}
+
+	// The bundle gets its own trace, linked to the traces of the requests that submitted its ops.
+	ctx, span := tracing.StartLinked(context.Background(), "bundlr.BundleAndSend", opLinks(candidates),
+		attribute.String("chain", b.ChainLabel()), attribute.Int("bundle.candidates", len(packedOps)))
+	defer func() { tracing.End(span, err) }()
+
+	// Ops were simulated one by one when they were received; any of them may have become invalid since.
+	packedOps, err = b.simulateBundle(ctx, packedOps)
+	if err != nil {
+		return err
 	}
 
 	if len(packedOps) == 0 {
-		fmt.Println("No ops to bundle")
+		b.log.Info("no valid ops left to bundle")
 		return nil
 	}
 
@@ -78,14 +229,333 @@ func (b *Bundlr) BundleAndSend() error {
 		return fmt.Errorf("abi.Pack failed: %w", err)
 	}
 
-	nonce, err := b.Validator.Client.PendingNonceAt(context.Background(), b.Signer.Address())
+	// Nonce, submission and receipt polling all go to one node, so the bundle isn't
+	// sent twice or looked up on a node that never saw it.
+	client := b.Upstreams.Pin()
+
+	signedTx, err := b.submitBundle(ctx, client, calldata, len(packedOps))
 	if err != nil {
-		return fmt.Errorf("failed to get nonce: %w", err)
+		return err
 	}
+	txHash := signedTx.Hash()
+	sentAt := time.Now()
+	span.SetAttributes(attribute.String("tx.hash", txHash.Hex()), attribute.Int("bundle.ops", len(packedOps)))
+
+	txLog := b.log.With("tx_hash", txHash.Hex())
+	txLog.Info("bundle sent", "ops", len(packedOps), "nonce", signedTx.Nonce(), "gas_price", signedTx.GasPrice().String())
+	metrics.BundlesSent.WithLabelValues(b.ChainLabel()).Inc()
+
+	bundled := make(map[string]bool, len(packedOps))
+	for i := range packedOps {
+		bundled[GetOpKey(&packedOps[i])] = true
+	}
+	for _, op := range candidates {
+		key := GetOpKey(op.Op)
+		if !bundled[key] {
+			continue
+		}
+		b.Queue.SetAsSubmitted(key, txHash)
+		b.opLogger(op).Info("op bundled", "tx_hash", txHash.Hex())
+		ev := opEvent(op, OP_STATUS_SUBMITTED)
+		ev.TransactionHash = txHash.Hex()
+		b.Events.publish(ev)
+		tracing.Record(op.TraceContext, "bundlr.mempool", op.Timestamp, sentAt, span.SpanContext(),
+			attribute.String("chain", b.ChainLabel()), attribute.String("tx.hash", txHash.Hex()))
+	}
+
+	waitCtx, waitSpan := tracing.Start(ctx, "bundlr.waitForReceipt", attribute.String("tx.hash", txHash.Hex()))
+	defer waitSpan.End()
 
-	gasPrice, err := b.Validator.Client.SuggestGasPrice(context.Background())
+	for attempt := 0; attempt < RECEIPT_POLLS; attempt++ {
+		waitSpan.SetAttributes(attribute.Int("receipt.polls", attempt+1))
+		receipt, err := client.TransactionReceipt(waitCtx, txHash)
+		if err == nil {
+			waitSpan.SetAttributes(attribute.Int64("tx.status", int64(receipt.Status)), attribute.Int64("tx.gas_used", int64(receipt.GasUsed)))
+			b.settleBundle(receipt, span.SpanContext())
+			return nil
+		}
+		select {
+		case <-waitCtx.Done():
+			txLog.Info("stopped waiting for the bundle receipt", "error", waitCtx.Err())
+			return nil
+		case <-time.After(RECEIPT_POLL_INTERVAL):
+		}
+	}
+
+	// The ops stay submitted; settleSubmitted picks the receipt up on a later round.
+	txLog.Info("bundle not mined yet", "polls", RECEIPT_POLLS)
+	return nil
+}
+
+// settleSubmitted looks up the handleOps transactions that were not mined yet when
+// BundleAndSend stopped polling for them.
+func (b *Bundlr) settleSubmitted() {
+	for txHash, ops := range b.Queue.Submitted() {
+		b.settleSubmittedTx(txHash, ops[0].SubmittedAt)
+	}
+}
+
+func (b *Bundlr) settleSubmittedTx(txHash common.Hash, submittedAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), RECEIPT_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	receipt, err := b.Validator.Client.TransactionReceipt(ctx, txHash)
+	if err == nil {
+		b.settleBundle(receipt, trace.SpanContext{})
+		return
+	}
+	if !errors.Is(err, ethereum.NotFound) || time.Since(submittedAt) < RESUBMIT_AFTER {
+		return
+	}
+
+	if !b.droppedEverywhere(ctx, txHash) {
+		return
+	}
+	n := b.Queue.Resubmit(txHash)
+	b.log.Warn("bundle transaction was dropped, bundling its ops again", "tx_hash", txHash.Hex(), "ops", n)
+}
+
+// droppedEverywhere tells whether no healthy upstream knows txHash at all. Only such a
+// transaction has been dropped: a pending one may still be mined, and bundling its ops
+// again would fail them with AA25. Each node is asked, not the failover client, since the
+// bundle was sent through one of them and the others may never have seen it.
+func (b *Bundlr) droppedEverywhere(ctx context.Context, txHash common.Hash) bool {
+	clients := b.Upstreams.Healthy()
+	if len(clients) == 0 {
+		return false
+	}
+	for _, client := range clients {
+		if _, _, err := client.TransactionByHash(ctx, txHash); !errors.Is(err, ethereum.NotFound) {
+			return false
+		}
+	}
+	return true
+}
+
+// settleBundle records the outcome of a mined handleOps transaction. Every op with a
+// UserOperationEvent is marked as sent; the ops of a reverted bundle go back to the mempool.
+func (b *Bundlr) settleBundle(receipt *gtypes.Receipt, link trace.SpanContext) {
+	b.observeBundleReceipt(receipt)
+	txLog := b.log.With("tx_hash", receipt.TxHash.Hex())
+	txLog.Info("bundle mined", "status", receipt.Status, "block", receipt.BlockNumber.String(), "gas_used", receipt.GasUsed)
+
+	waiting := make(map[string]QueuedOp)
+	for _, op := range b.Queue.Submitted()[receipt.TxHash] {
+		waiting[op.OpHash.Hex()] = op
+	}
+
+	event := b.Validator.EntryPointABI.Events["UserOperationEvent"]
+	revertReasons := b.revertReasons(receipt)
+	tokenPayments := b.tokenPayments(receipt)
+
+	for _, log := range receipt.Logs {
+		if len(log.Topics) < 3 || log.Topics[0] != event.ID {
+			continue
+		}
+
+		userOpHash := log.Topics[1].Hex()
+		queuedOp, ok := waiting[userOpHash]
+		if !ok {
+			continue
+		}
+
+		dataMap := map[string]interface{}{}
+		if err := event.Inputs.UnpackIntoMap(dataMap, log.Data); err != nil {
+			continue
+		}
+
+		sender := common.BytesToAddress(log.Topics[2][:]).Hex()
+		nonce := dataMap["nonce"].(*big.Int)
+		success := dataMap["success"].(bool)
+		actualGasCost := dataMap["actualGasCost"].(*big.Int)
+		actualGasUsed := dataMap["actualGasUsed"].(*big.Int)
+
+		b.opLogger(&queuedOp).Info("op included",
+			"tx_hash", receipt.TxHash.Hex(), "nonce", nonce.String(), "success", success,
+			"actual_gas_used", actualGasUsed.String(), "actual_gas_cost", actualGasCost.String())
+		b.forEachEntity(queuedOp.Op, b.Reputation.UpdateIncluded)
+		metrics.TimeToInclusion.WithLabelValues(b.ChainLabel()).Observe(time.Since(queuedOp.Timestamp).Seconds())
+		tracing.Record(queuedOp.TraceContext, "bundlr.inclusion", queuedOp.SubmittedAt, time.Now(), link,
+			attribute.String("tx.hash", receipt.TxHash.Hex()), attribute.Bool("userop.success", success))
+		b.Queue.SetAsSent(GetOpKey(queuedOp.Op), &types.UserOperationReceipt{
+			UserOpHash:    userOpHash,
+			Sender:        sender,
+			Nonce:         "0x" + nonce.Text(16),
+			Paymaster:     "0x" + hex.EncodeToString(queuedOp.Op.PaymasterAndData),
+			Success:       success,
+			ActualGasUsed: "0x" + actualGasUsed.Text(16),
+			ActualGasCost: "0x" + actualGasCost.Text(16),
+			Reason:        revertReasons[userOpHash],
+			TokenPayment:  tokenPayments[userOpHash],
+			Receipt: &types.TxReceipt{
+				TransactionHash:   receipt.TxHash.Hex(),
+				BlockHash:         receipt.BlockHash.Hex(),
+				BlockNumber:       "0x" + receipt.BlockNumber.Text(16),
+				Logs:              []any{}, // opsiyonel parse
+				LogsBloom:         "0x" + hex.EncodeToString(receipt.Bloom[:]),
+				GasUsed:           "0x" + strconv.FormatUint(receipt.GasUsed, 16),
+				CumulativeGasUsed: "0x" + strconv.FormatUint(receipt.CumulativeGasUsed, 16),
+				EffectiveGasPrice: "0x" + receipt.EffectiveGasPrice.Text(16),
+			},
+		})
+		if included, err := b.Queue.Get(queuedOp.Op); err == nil {
+			b.Events.publish(queuedOpEvent(included))
+		}
+		delete(waiting, userOpHash)
+	}
+
+	if len(waiting) == 0 {
+		return
+	}
+
+	// A successful handleOps emits an event for each of its ops, so only a reverted bundle gets here.
+	if receipt.Status != gtypes.ReceiptStatusSuccessful {
+		ops := make([]types.PackedUserOperation, 0, len(waiting))
+		for _, op := range waiting {
+			ops = append(ops, *op.Op)
+		}
+		b.blameRevertedBundle(ops)
+	}
+	n := b.Queue.Resubmit(receipt.TxHash)
+	txLog.Warn("bundle mined without some of its ops, bundling them again", "ops", n)
+}
+
+// blameRevertedBundle replays the ops of a reverted handleOps transaction and crashes the
+// factory or paymaster that fails them.
+func (b *Bundlr) blameRevertedBundle(ops []types.PackedUserOperation) {
+	ctx, cancel := context.WithTimeout(context.Background(), RECEIPT_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	var failed *validator.FailedOpError
+	err := b.Validator.SimulateHandleOps(ctx, ops)
+	if !errors.As(err, &failed) || failed.OpIndex < 0 || failed.OpIndex >= len(ops) {
+		return
+	}
+	if entity, ok := b.crashedEntity(&ops[failed.OpIndex], failed); ok {
+		b.log.Warn("entity crashed a bundle", "entity", entity.Hex(), "reason", failed.Reason)
+		b.Reputation.CrashedHandleOps(entity)
+	}
+}
+
+// revertReasons decodes the UserOperationRevertReason events of a bundle receipt, by userOpHash.
+func (b *Bundlr) revertReasons(receipt *gtypes.Receipt) map[string]string {
+	event, ok := b.Validator.EntryPointABI.Events["UserOperationRevertReason"]
+	if !ok {
+		return nil
+	}
+
+	reasons := make(map[string]string)
+	for _, log := range receipt.Logs {
+		if len(log.Topics) < 2 || log.Topics[0] != event.ID {
+			continue
+		}
+		values, err := event.Inputs.NonIndexed().Unpack(log.Data)
+		if err != nil || len(values) < 2 {
+			continue
+		}
+		if data, ok := values[1].([]byte); ok {
+			reasons[log.Topics[1].Hex()] = userop.DecodeRevertReason(data)
+		}
+	}
+	return reasons
+}
+
+// tokenPayments decodes the TokenCharged events the configured TokenPaymasters emitted in a
+// bundle receipt, by userOpHash.
+func (b *Bundlr) tokenPayments(receipt *gtypes.Receipt) map[string]*types.TokenPayment {
+	if len(b.Validator.TokenPaymasters) == 0 {
+		return nil
+	}
+	event, ok := b.Validator.TokenPaymasterABI.Events["TokenCharged"]
+	if !ok {
+		return nil
+	}
+
+	payments := make(map[string]*types.TokenPayment)
+	for _, log := range receipt.Logs {
+		if len(log.Topics) < 4 || log.Topics[0] != event.ID {
+			continue
+		}
+		if _, ok := b.Validator.TokenPaymasters[log.Address]; !ok {
+			continue
+		}
+		values, err := event.Inputs.NonIndexed().Unpack(log.Data)
+		if err != nil || len(values) < 2 {
+			continue
+		}
+		amount, ok1 := values[0].(*big.Int)
+		rate, ok2 := values[1].(*big.Int)
+		if !ok1 || !ok2 {
+			continue
+		}
+		payments[log.Topics[1].Hex()] = &types.TokenPayment{
+			Token:        common.BytesToAddress(log.Topics[3][:]).Hex(),
+			Amount:       "0x" + amount.Text(16),
+			ExchangeRate: "0x" + rate.Text(16),
+		}
+	}
+	return payments
+}
+
+// simulateBundle simulates the whole bundle and evicts the op the EntryPoint points at with FailedOp
+// until the remaining ops simulate cleanly. The ops passed validation when they were received, so
+// as in ERC-7562 the factory (AA1x) or paymaster (AA3x) that fails this second validation is
+// crashed. A sender (AA2x) is only dropped, see crashedEntity.
+func (b *Bundlr) simulateBundle(ctx context.Context, ops []types.PackedUserOperation) (_ []types.PackedUserOperation, err error) {
+	ctx, span := tracing.Start(ctx, "bundlr.simulateBundle", attribute.Int("bundle.candidates", len(ops)))
+	defer func() { tracing.End(span, err) }()
+
+	for len(ops) > 0 {
+		start := time.Now()
+		err := b.Validator.SimulateHandleOps(ctx, ops)
+		metrics.SimulationSeconds.WithLabelValues(b.ChainLabel(), "bundle").Observe(time.Since(start).Seconds())
+		if err == nil {
+			return ops, nil
+		}
+
+		var failed *validator.FailedOpError
+		if !errors.As(err, &failed) || failed.OpIndex < 0 || failed.OpIndex >= len(ops) {
+			return nil, fmt.Errorf("bundle simulation failed: %w", err)
+		}
+
+		bad := ops[failed.OpIndex]
+		if queued, err := b.Queue.Get(&bad); err == nil {
+			b.opLogger(queued).Warn("op dropped from bundle", "reason", failed.Reason)
+			ev := opEvent(queued, OP_STATUS_DROPPED)
+			ev.Reason = failed.Reason
+			b.Events.publish(ev)
+		} else {
+			b.log.Warn("op dropped from bundle", "sender", bad.Sender.Hex(), "nonce", bad.Nonce.String(), "reason", failed.Reason)
+		}
+
+		if entity, ok := b.crashedEntity(&bad, failed); ok {
+			b.log.Warn("entity failed bundle simulation", "entity", entity.Hex(), "reason", failed.Reason)
+			b.Reputation.CrashedHandleOps(entity)
+		}
+
+		b.Queue.Remove(&bad)
+		metrics.OpsDropped.WithLabelValues(b.ChainLabel()).Inc()
+		span.AddEvent("op dropped", trace.WithAttributes(attribute.String("userop.sender", bad.Sender.Hex()), attribute.String("reason", failed.Reason)))
+
+		ops = append(ops[:failed.OpIndex:failed.OpIndex], ops[failed.OpIndex+1:]...)
+	}
+
+	return ops, nil
+}
+
+// submitBundle prices, signs and sends the handleOps transaction through client.
+func (b *Bundlr) submitBundle(ctx context.Context, client *ethclient.Client, calldata []byte, ops int) (_ *gtypes.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "bundlr.submit", attribute.Int("bundle.ops", ops))
+	defer func() { tracing.End(span, err) }()
+
+	nonce, err := client.PendingNonceAt(ctx, b.Signer.Address())
 	if err != nil {
-		return fmt.Errorf("failed to get gas price: %w", err)
+		return nil, fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	gasPrice, err := b.Validator.Fees.GasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %w", err)
 	}
 
 	gasLimit := uint64(8_000_000)
@@ -100,90 +570,107 @@ func (b *Bundlr) BundleAndSend() error {
 
 	signedTx, err := b.Signer.Sign(tx)
 	if err != nil {
-		return fmt.Errorf("signing tx failed: %w", err)
+		return nil, fmt.Errorf("signing tx failed: %w", err)
 	}
 
-	err = b.Validator.Client.SendTransaction(context.Background(), signedTx)
+	err = client.SendTransaction(ctx, signedTx)
 	if err != nil {
-		return fmt.Errorf("send tx failed: %w", err)
+		return nil, fmt.Errorf("send tx failed: %w", err)
+	}
+
+	span.SetAttributes(attribute.String("tx.hash", signedTx.Hash().Hex()), attribute.Int64("tx.nonce", int64(nonce)))
+	return signedTx, nil
+}
+
+func (b *Bundlr) observeBundleReceipt(receipt *gtypes.Receipt) {
+	chain := b.ChainLabel()
+	if receipt.Status == gtypes.ReceiptStatusSuccessful {
+		metrics.BundlesMined.WithLabelValues(chain).Inc()
+	} else {
+		metrics.BundlesReverted.WithLabelValues(chain).Inc()
 	}
+	metrics.BundleGasUsed.WithLabelValues(chain).Observe(float64(receipt.GasUsed))
+}
 
-	fmt.Printf("Bundled %d ops and sent tx %s\n", len(packedOps), signedTx.Hash().Hex())
+// crashedEntity picks the entity responsible for a FailedOp from its AAxx code: AA1x the
+// factory, AA2x the sender, AA3x the paymaster. Only factories and paymasters are crashed.
+// Unlike ERC-7562, a sender is never banned: an AA2x failure between validations usually
+// means another op used up its nonce or deposit, so it only costs the sender inclusion rate.
+// Neither is a paymaster whose signature merely expired (AA32).
+func (b *Bundlr) crashedEntity(op *types.PackedUserOperation, failed *validator.FailedOpError) (common.Address, bool) {
+	_, factory, paymaster := GetEntities(op)
+	switch failed.EntityCode() {
+	case 1:
+		if factory != nil {
+			return *factory, true
+		}
+	case 3:
+		if paymaster != nil && !strings.HasPrefix(failed.Reason, "AA32") {
+			return *paymaster, true
+		}
+	}
+	return common.Address{}, false
+}
 
-	var copyQueue []QueuedOp
-	for _, op := range queuedOps {
-		copyQueue = append(copyQueue, *op)
+func (b *Bundlr) forEachEntity(op *types.PackedUserOperation, fn func(common.Address)) {
+	sender, factory, paymaster := GetEntities(op)
+	fn(sender)
+	if factory != nil {
+		fn(*factory)
+	}
+	if paymaster != nil {
+		fn(*paymaster)
 	}
+}
 
-	for attempt := 0; attempt < 40; attempt++ {
-		receipt, err := b.Validator.Client.TransactionReceipt(context.Background(), signedTx.Hash())
-		if err == nil {
-			eventSig := []byte("UserOperationEvent(bytes32,address,address,uint256,bool,uint256,uint256)")
-			eventHash := crypto.Keccak256Hash(eventSig)
-			event := b.Validator.EntryPointABI.Events["UserOperationEvent"]
-
-			for _, log := range receipt.Logs {
-				if len(log.Topics) == 0 || log.Topics[0] != eventHash {
-					continue
-				}
-
-				dataMap := map[string]interface{}{}
-				err := event.Inputs.UnpackIntoMap(dataMap, log.Data)
-				if err != nil {
-					continue
-				}
-
-				userOpHash := log.Topics[1].Hex()
-				sender := log.Topics[2].Hex()
-				nonce := dataMap["nonce"].(*big.Int)
-				success := dataMap["success"].(bool)
-				actualGasCost := dataMap["actualGasCost"].(*big.Int)
-				actualGasUsed := dataMap["actualGasUsed"].(*big.Int)
-
-				for _, queuedOp := range copyQueue {
-					if queuedOp.OpHash.Hex() == userOpHash {
-						fmt.Printf("TX Hash: %s, UserOpHash: %s, Sender: %s, Nonce: %s, Success: %t, ActualGasUsed: %s, ActualGasCost: %s\n",
-							receipt.TxHash.Hex(), userOpHash, sender, nonce.Text(16), success, actualGasUsed.Text(16), actualGasCost.Text(16))
-						b.Queue.SetAsSent(GetOpKey(queuedOp.Op), &types.UserOperationReceipt{
-							UserOpHash:    userOpHash,
-							Sender:        sender,
-							Nonce:         "0x" + nonce.Text(16),
-							Paymaster:     "0x" + hex.EncodeToString(queuedOp.Op.PaymasterAndData),
-							Success:       success,
-							ActualGasUsed: "0x" + actualGasUsed.Text(16),
-							ActualGasCost: "0x" + actualGasCost.Text(16),
-							Receipt: &types.TxReceipt{
-								TransactionHash:   receipt.TxHash.Hex(),
-								BlockHash:         receipt.BlockHash.Hex(),
-								BlockNumber:       "0x" + receipt.BlockNumber.Text(16),
-								Logs:              []any{}, // opsiyonel parse
-								LogsBloom:         "0x" + hex.EncodeToString(receipt.Bloom[:]),
-								GasUsed:           "0x" + strconv.FormatUint(receipt.GasUsed, 16),
-								CumulativeGasUsed: "0x" + strconv.FormatUint(receipt.CumulativeGasUsed, 16),
-								EffectiveGasPrice: "0x" + receipt.EffectiveGasPrice.Text(16),
-							},
-						})
-						fmt.Printf("UserOperation %s processed successfully\n", userOpHash)
-						break
-					}
-				}
-
-				return nil
+// rejectedEntity returns the first entity of op that may not add ops to the mempool: a banned
+// one, or a throttled one that already has THROTTLED_ENTITY_MEMPOOL_COUNT ops waiting.
+func (b *Bundlr) rejectedEntity(op *types.PackedUserOperation) (common.Address, ReputationStatus, bool) {
+	var (
+		rejected *common.Address
+		status   ReputationStatus
+		waiting  map[common.Address]int
+	)
+	b.forEachEntity(op, func(addr common.Address) {
+		if rejected != nil {
+			return
+		}
+		switch b.Reputation.Status(addr) {
+		case ReputationBanned:
+			rejected, status = &addr, ReputationBanned
+		case ReputationThrottled:
+			if waiting == nil {
+				waiting = b.waitingOpsByEntity()
+			}
+			if waiting[addr] >= THROTTLED_ENTITY_MEMPOOL_COUNT {
+				rejected, status = &addr, ReputationThrottled
 			}
 		}
-		time.Sleep(100 * time.Millisecond)
+	})
+	if rejected == nil {
+		return common.Address{}, ReputationOK, false
 	}
-	return nil
+	return *rejected, status, true
 }
 
-func (b *Bundlr) StartBundlerLoop() {
-	go func() {
-		for {
-			err := b.BundleAndSend()
-			if err != nil {
-				fmt.Println("BundleAndSend error:", err)
-			}
-			time.Sleep(3 * time.Second)
+// waitingOpsByEntity counts the ops that are not included yet, per entity.
+func (b *Bundlr) waitingOpsByEntity() map[common.Address]int {
+	counts := make(map[common.Address]int)
+	for _, queued := range b.Queue.GetAll() {
+		if queued.State != "sent" {
+			b.forEachEntity(queued.Op, func(addr common.Address) { counts[addr]++ })
 		}
-	}()
+	}
+	return counts
+}
+
+// opLinks links a bundle span to the traces of the requests that submitted its ops.
+func opLinks(ops []*QueuedOp) []trace.Link {
+	var links []trace.Link
+	for _, op := range ops {
+		if link, ok := tracing.Link(op.TraceContext, attribute.String("userop.hash", op.OpHash.Hex())); ok {
+			links = append(links, link)
+		}
+	}
+	return links
 }
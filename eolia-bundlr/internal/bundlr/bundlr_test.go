@@ -0,0 +1,278 @@
+package bundlr
+
+import (
+	"encoding/json"
+	"eolia-bundlr/internal/types"
+	"eolia-bundlr/internal/validator"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	testEntryPoint = common.HexToAddress("0x379FF91b96c038ECb0dc6aCFb44366a39f0de566")
+	testFactory    = common.HexToAddress("0x00000000000000000000000000000000000000fa")
+	testPaymaster  = common.HexToAddress("0x00000000000000000000000000000000000000fb")
+)
+
+// fakeEntryPoint answers the eth_calls of handleOps simulations: the first op whose sender
+// has a reason in failures reverts the call with FailedOp, anything else succeeds.
+type fakeEntryPoint struct {
+	abi      *abi.ABI
+	failures map[common.Address]string
+	// Reverts every call with a plain Error(string) instead.
+	broken bool
+
+	mu      sync.Mutex
+	bundles [][]common.Address
+}
+
+func (f *fakeEntryPoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_call" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	var call struct {
+		Input hexutil.Bytes `json:"input"`
+		Data  hexutil.Bytes `json:"data"`
+	}
+	json.Unmarshal(req.Params[0], &call)
+	input := call.Input
+	if input == nil {
+		input = call.Data
+	}
+	values, err := f.abi.Methods["handleOps"].Inputs.Unpack(input[4:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ops := *abi.ConvertType(values[0], new([]types.PackedUserOperation)).(*[]types.PackedUserOperation)
+
+	senders := make([]common.Address, len(ops))
+	for i, op := range ops {
+		senders[i] = op.Sender
+	}
+	f.mu.Lock()
+	f.bundles = append(f.bundles, senders)
+	f.mu.Unlock()
+
+	revert := func(data []byte) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"error":   map[string]any{"code": 3, "message": "execution reverted", "data": hexutil.Encode(data)},
+		})
+	}
+	if f.broken {
+		stringType, _ := abi.NewType("string", "", nil)
+		packed, _ := abi.Arguments{{Type: stringType}}.Pack("boom")
+		revert(append(common.FromHex("0x08c379a0"), packed...))
+		return
+	}
+	for i, op := range ops {
+		if reason, ok := f.failures[op.Sender]; ok {
+			failedOp := f.abi.Errors["FailedOp"]
+			packed, _ := failedOp.Inputs.Pack(big.NewInt(int64(i)), reason)
+			revert(append(failedOp.ID[:4:4], packed...))
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": "0x"})
+}
+
+func loadEntryPointABI(t *testing.T) *abi.ABI {
+	t.Helper()
+	data, err := os.ReadFile("../validator/entrypoint/entrypoint.abi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &parsed
+}
+
+// newTestBundlr returns a Bundlr whose node is node, with nothing else running.
+func newTestBundlr(t *testing.T, entryPointABI *abi.ABI, node http.Handler) *Bundlr {
+	t.Helper()
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return &Bundlr{
+		ChainID:    big.NewInt(196),
+		Queue:      NewOpQueue(),
+		Reputation: NewReputation(),
+		Validator:  &validator.Validator{Client: client, EntryPoint: testEntryPoint, EntryPointABI: entryPointABI},
+		Events:     NewOpEvents(),
+		log:        slog.Default(),
+	}
+}
+
+func testOp(sender byte, nonce int64) types.PackedUserOperation {
+	return types.PackedUserOperation{
+		Sender:             common.BytesToAddress([]byte{sender}),
+		Nonce:              big.NewInt(nonce),
+		InitCode:           []byte{},
+		CallData:           []byte{},
+		PreVerificationGas: big.NewInt(50_000),
+		PaymasterAndData:   []byte{},
+		Signature:          []byte{},
+	}
+}
+
+func TestSimulateBundle(t *testing.T) {
+	entryPointABI := loadEntryPointABI(t)
+
+	withFactory := testOp(3, 0)
+	withFactory.InitCode = append(testFactory.Bytes(), 0x01)
+	withPaymaster := testOp(3, 0)
+	withPaymaster.PaymasterAndData = append(testPaymaster.Bytes(), make([]byte, 32)...)
+
+	sender := func(n byte) common.Address { return common.BytesToAddress([]byte{n}) }
+
+	tests := []struct {
+		name     string
+		ops      []types.PackedUserOperation
+		failures map[common.Address]string
+		// Senders of the ops left in the bundle, and of every bundle simulated.
+		kept      []common.Address
+		simulated [][]common.Address
+		// Entity expected to be banned afterwards.
+		crashed *common.Address
+	}{
+		{
+			name:      "all valid",
+			ops:       []types.PackedUserOperation{testOp(1, 0), testOp(2, 0)},
+			kept:      []common.Address{sender(1), sender(2)},
+			simulated: [][]common.Address{{sender(1), sender(2)}},
+		},
+		{
+			name:      "sender failure is dropped and the rest re-simulated",
+			ops:       []types.PackedUserOperation{testOp(1, 0), testOp(2, 0), testOp(3, 0)},
+			failures:  map[common.Address]string{sender(2): "AA25 invalid account nonce"},
+			kept:      []common.Address{sender(1), sender(3)},
+			simulated: [][]common.Address{{sender(1), sender(2), sender(3)}, {sender(1), sender(3)}},
+		},
+		{
+			name:      "factory failure crashes the factory",
+			ops:       []types.PackedUserOperation{testOp(1, 0), withFactory},
+			failures:  map[common.Address]string{sender(3): "AA13 initCode failed or OOG"},
+			kept:      []common.Address{sender(1)},
+			simulated: [][]common.Address{{sender(1), sender(3)}, {sender(1)}},
+			crashed:   &testFactory,
+		},
+		{
+			name:      "paymaster failure crashes the paymaster",
+			ops:       []types.PackedUserOperation{withPaymaster, testOp(1, 0)},
+			failures:  map[common.Address]string{sender(3): "AA33 reverted"},
+			kept:      []common.Address{sender(1)},
+			simulated: [][]common.Address{{sender(3), sender(1)}, {sender(1)}},
+			crashed:   &testPaymaster,
+		},
+		{
+			name:      "expired paymaster signature is not a crash",
+			ops:       []types.PackedUserOperation{withPaymaster},
+			failures:  map[common.Address]string{sender(3): "AA32 paymaster expired or not due"},
+			kept:      []common.Address{},
+			simulated: [][]common.Address{{sender(3)}},
+		},
+		{
+			name:      "every op fails",
+			ops:       []types.PackedUserOperation{testOp(1, 0), testOp(2, 0)},
+			failures:  map[common.Address]string{sender(1): "AA21 didn't pay prefund", sender(2): "AA23 reverted"},
+			kept:      []common.Address{},
+			simulated: [][]common.Address{{sender(1), sender(2)}, {sender(2)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &fakeEntryPoint{abi: entryPointABI, failures: tt.failures}
+			b := newTestBundlr(t, entryPointABI, node)
+			for i := range tt.ops {
+				hash := common.BigToHash(big.NewInt(int64(i)))
+				if err := b.Queue.Add(&tt.ops[i], &hash, "", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			kept, err := b.simulateBundle(t.Context(), append([]types.PackedUserOperation(nil), tt.ops...))
+			if err != nil {
+				t.Fatalf("simulateBundle: %v", err)
+			}
+
+			got := make([]common.Address, len(kept))
+			for i, op := range kept {
+				got[i] = op.Sender
+			}
+			if !equalAddresses(got, tt.kept) {
+				t.Errorf("kept %v, want %v", got, tt.kept)
+			}
+			if len(node.bundles) != len(tt.simulated) {
+				t.Fatalf("simulated %v, want %v", node.bundles, tt.simulated)
+			}
+			for i := range tt.simulated {
+				if !equalAddresses(node.bundles[i], tt.simulated[i]) {
+					t.Errorf("simulation %d had %v, want %v", i, node.bundles[i], tt.simulated[i])
+				}
+			}
+
+			if n, _ := b.Queue.PendingStats(); n != len(tt.kept) {
+				t.Errorf("queue holds %d ops, want %d", n, len(tt.kept))
+			}
+			for _, e := range b.Reputation.Dump() {
+				banned := b.Reputation.Status(e.Address) == ReputationBanned
+				if want := tt.crashed != nil && e.Address == *tt.crashed; banned != want {
+					t.Errorf("%s banned = %v, want %v", e.Address.Hex(), banned, want)
+				}
+			}
+			if tt.crashed != nil && b.Reputation.Status(*tt.crashed) != ReputationBanned {
+				t.Errorf("%s is %s, want banned", tt.crashed.Hex(), b.Reputation.Status(*tt.crashed))
+			}
+		})
+	}
+}
+
+func TestSimulateBundleOtherRevert(t *testing.T) {
+	entryPointABI := loadEntryPointABI(t)
+	b := newTestBundlr(t, entryPointABI, &fakeEntryPoint{abi: entryPointABI, broken: true})
+
+	op := testOp(1, 0)
+	if _, err := b.simulateBundle(t.Context(), []types.PackedUserOperation{op}); err == nil {
+		t.Error("simulateBundle succeeded on a revert that is not FailedOp")
+	}
+}
+
+func equalAddresses(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
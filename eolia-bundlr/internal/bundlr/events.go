@@ -0,0 +1,184 @@
+package bundlr
+
+import (
+	"eolia-bundlr/internal/types"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Lifecycle of an op as pushed to subscribers.
+const (
+	// Accepted into the mempool.
+	OP_STATUS_PENDING = "pending"
+	// Part of a handleOps transaction that was broadcast.
+	OP_STATUS_SUBMITTED = "submitted"
+	// Its UserOperationEvent was mined; the event carries the receipt.
+	OP_STATUS_INCLUDED = "included"
+	// Mined, but the op's call reverted (success = false); carries the receipt and revertReason.
+	OP_STATUS_REVERTED = "reverted"
+	// Evicted from the mempool after failing bundle simulation.
+	OP_STATUS_DROPPED = "dropped"
+)
+
+// Events a subscriber may lag behind before it is dropped.
+const OP_EVENT_BUFFER = 64
+
+var ErrSlowSubscriber = errors.New("subscription dropped: events not consumed in time")
+
+type OpEvent struct {
+	UserOpHash      string                      `json:"userOpHash"`
+	Sender          string                      `json:"sender"`
+	Nonce           string                      `json:"nonce"`
+	Status          string                      `json:"status"`
+	TransactionHash string                      `json:"transactionHash,omitempty"`
+	Reason          string                      `json:"reason,omitempty"`
+	RevertReason    string                      `json:"revertReason,omitempty"`
+	Receipt         *types.UserOperationReceipt `json:"receipt,omitempty"`
+}
+
+// OpFilter selects the ops a subscription follows. Set fields must all match; an empty
+// filter follows every op, which only admins may ask for.
+type OpFilter struct {
+	UserOpHash *common.Hash    `json:"userOpHash,omitempty"`
+	Sender     *common.Address `json:"sender,omitempty"`
+}
+
+// Empty reports whether the filter follows every op.
+func (f OpFilter) Empty() bool {
+	return f.UserOpHash == nil && f.Sender == nil
+}
+
+func (f OpFilter) matches(hash common.Hash, sender common.Address) bool {
+	if f.UserOpHash != nil && *f.UserOpHash != hash {
+		return false
+	}
+	if f.Sender != nil && *f.Sender != sender {
+		return false
+	}
+	return true
+}
+
+type OpSubscription struct {
+	// Receives the events; closed by Unsubscribe or when the subscriber is dropped.
+	C <-chan OpEvent
+
+	ch     chan OpEvent
+	filter OpFilter
+	events *OpEvents
+	err    error
+}
+
+// Err is ErrSlowSubscriber once C was closed because the subscriber fell behind.
+func (s *OpSubscription) Err() error {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+
+	return s.err
+}
+
+func (s *OpSubscription) Unsubscribe() {
+	s.events.remove(s, nil)
+}
+
+// OpEvents fans op lifecycle events out to subscriptions. Publishing never blocks the
+// bundler: a subscriber whose buffer is full is dropped.
+type OpEvents struct {
+	mu   sync.Mutex
+	subs map[*OpSubscription]struct{}
+}
+
+func NewOpEvents() *OpEvents {
+	return &OpEvents{subs: make(map[*OpSubscription]struct{})}
+}
+
+func (e *OpEvents) Subscribe(filter OpFilter) *OpSubscription {
+	ch := make(chan OpEvent, OP_EVENT_BUFFER)
+	s := &OpSubscription{C: ch, ch: ch, filter: filter, events: e}
+
+	e.mu.Lock()
+	e.subs[s] = struct{}{}
+	e.mu.Unlock()
+
+	return s
+}
+
+func (e *OpEvents) remove(s *OpSubscription, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.removeLocked(s, err)
+}
+
+func (e *OpEvents) removeLocked(s *OpSubscription, err error) {
+	if _, ok := e.subs[s]; !ok {
+		return
+	}
+	delete(e.subs, s)
+	s.err = err
+	close(s.ch)
+}
+
+func (e *OpEvents) publish(ev OpEvent) {
+	hash, sender := common.HexToHash(ev.UserOpHash), common.HexToAddress(ev.Sender)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for s := range e.subs {
+		if !s.filter.matches(hash, sender) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			e.removeLocked(s, ErrSlowSubscriber)
+		}
+	}
+}
+
+// SubscribeOps subscribes to the events of the ops matching filter and returns the
+// current status of those already in the mempool, so a subscriber that arrives late
+// doesn't miss a transition. An event may be in both.
+func (b *Bundlr) SubscribeOps(filter OpFilter) (*OpSubscription, []OpEvent) {
+	sub := b.Events.Subscribe(filter)
+
+	var current []OpEvent
+	for _, op := range b.Queue.GetAll() {
+		if filter.matches(*op.OpHash, op.Op.Sender) {
+			current = append(current, queuedOpEvent(op))
+		}
+	}
+	return sub, current
+}
+
+// queuedOpEvent describes the last known status of a queued op.
+func queuedOpEvent(op *QueuedOp) OpEvent {
+	ev := opEvent(op, OP_STATUS_PENDING)
+	if op.State == "submitted" {
+		ev.Status = OP_STATUS_SUBMITTED
+		ev.TransactionHash = op.TxHash
+	}
+	if op.State == "sent" && op.Receipt != nil {
+		ev.Status = OP_STATUS_INCLUDED
+		ev.Receipt = op.Receipt
+		if !op.Receipt.Success {
+			ev.Status = OP_STATUS_REVERTED
+			ev.RevertReason = op.Receipt.Reason
+		}
+		if op.Receipt.Receipt != nil {
+			ev.TransactionHash = op.Receipt.Receipt.TransactionHash
+		}
+	}
+	return ev
+}
+
+func opEvent(op *QueuedOp, status string) OpEvent {
+	return OpEvent{
+		UserOpHash: op.OpHash.Hex(),
+		Sender:     op.Op.Sender.Hex(),
+		Nonce:      "0x" + op.Op.Nonce.Text(16),
+		Status:     status,
+	}
+}
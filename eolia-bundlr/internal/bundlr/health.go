@@ -0,0 +1,85 @@
+package bundlr
+
+import (
+	"context"
+	"eolia-common/health"
+	"fmt"
+	"time"
+)
+
+const (
+	DEFAULT_STALL_TIMEOUT = 2 * time.Minute
+	BALANCE_CHECK_TTL     = 10 * time.Second
+)
+
+// beat records that the bundler loop is alive: idle in its select, or busy with an
+// iteration (head poll and bundle) that started now.
+func (b *Bundlr) beat(busy bool) {
+	now := time.Now().UnixNano()
+	b.lastBeat.Store(now)
+	if busy {
+		b.busySince.Store(now)
+	} else {
+		b.busySince.Store(0)
+	}
+}
+
+// LoopHealth reports whether the bundler loop is running and not stuck in an iteration
+// for longer than health.stall_timeout. It is down while the bundler shuts down.
+func (b *Bundlr) LoopHealth(ctx context.Context) health.Component {
+	details := map[string]any{"mode": b.Scheduler.Mode()}
+	if beat := b.lastBeat.Load(); beat != 0 {
+		details["last_beat"] = time.Unix(0, beat).UTC().Format(time.RFC3339)
+	}
+
+	if b.closed.Load() {
+		return health.Down(ErrShuttingDown, details)
+	}
+	if b.loopDone == nil {
+		return health.Down(fmt.Errorf("bundler loop not started"), details)
+	}
+	select {
+	case <-b.loopDone:
+		return health.Down(fmt.Errorf("bundler loop exited"), details)
+	default:
+	}
+
+	stallTimeout := b.health.StallTimeout
+	if stallTimeout <= 0 {
+		stallTimeout = DEFAULT_STALL_TIMEOUT
+	}
+	if since := b.busySince.Load(); since != 0 {
+		busy := time.Since(time.Unix(0, since))
+		details["busy_for"] = busy.Round(time.Millisecond).String()
+		if busy > stallTimeout {
+			return health.Down(fmt.Errorf("bundler loop stalled for %s", busy.Round(time.Second)), details)
+		}
+	}
+	return health.OK(details)
+}
+
+// BalanceHealth compares the executor balance with health.min_balance. The balance is
+// read at most every BALANCE_CHECK_TTL.
+func (b *Bundlr) BalanceHealth() health.CheckFunc {
+	minBalance := b.health.MinBalanceWei()
+
+	return health.Cached(BALANCE_CHECK_TTL, func(ctx context.Context) health.Component {
+		details := map[string]any{"address": b.Signer.Address().Hex(), "min_balance": minBalance.String()}
+
+		balance, err := b.Validator.Client.BalanceAt(ctx, b.Signer.Address(), nil)
+		if err != nil {
+			return health.Down(fmt.Errorf("failed to read executor balance: %w", err), details)
+		}
+		details["balance"] = balance.String()
+
+		if balance.Cmp(minBalance) < 0 {
+			return health.Down(fmt.Errorf("executor balance below min_balance"), details)
+		}
+		return health.OK(details)
+	})
+}
+
+// UpstreamHealth reports the sync status of the chain's RPC nodes.
+func (b *Bundlr) UpstreamHealth() health.CheckFunc {
+	return health.Upstreams(b.Upstreams.Stats)
+}
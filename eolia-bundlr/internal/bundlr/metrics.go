@@ -0,0 +1,147 @@
+package bundlr
+
+import (
+	"context"
+	"eolia-bundlr/internal/metrics"
+	"eolia-bundlr/internal/validator"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const BALANCE_SCRAPE_TIMEOUT = 2 * time.Second
+
+var (
+	mempoolDesc = prometheus.NewDesc(metrics.NAMESPACE+"_mempool_ops", "Ops in the mempool, by state.", []string{"chain", "state"}, nil)
+	balanceDesc = prometheus.NewDesc(metrics.NAMESPACE+"_executor_balance_wei", "Balance of the account paying for bundles.", []string{"chain"}, nil)
+
+	upstreamHealthyDesc   = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_healthy", "1 when the RPC node passes its health check.", []string{"chain", "upstream"}, nil)
+	upstreamHeightDesc    = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_block_height", "Last head reported by the RPC node.", []string{"chain", "upstream"}, nil)
+	upstreamLatencyDesc   = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_latency_seconds", "Latency of the last health check.", []string{"chain", "upstream"}, nil)
+	upstreamRequestsDesc  = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_requests_total", "Requests sent to the RPC node.", []string{"chain", "upstream"}, nil)
+	upstreamErrorsDesc    = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_errors_total", "Requests to the RPC node that failed.", []string{"chain", "upstream"}, nil)
+	upstreamFailoversDesc = prometheus.NewDesc(metrics.NAMESPACE+"_upstream_failovers_total", "Requests the RPC node served after another node failed.", []string{"chain", "upstream"}, nil)
+)
+
+// Rejection reasons reported for ValidationErrors, keyed by their JSON-RPC code.
+var rejectReasons = map[int]string{
+	validator.ERR_INVALID_FORMAT:             "invalid_format",
+	validator.ERR_FIELD_TOO_WIDE:             "field_too_wide",
+	validator.ERR_OP_TOO_LARGE:               "op_too_large",
+	validator.ERR_ZERO_SENDER:                "zero_sender",
+	validator.ERR_ZERO_FEE:                   "zero_fee",
+	validator.ERR_PRIORITY_FEE_ABOVE_MAX:     "priority_fee_above_max",
+	validator.ERR_PRIORITY_FEE_TOO_LOW:       "priority_fee_too_low",
+	validator.ERR_FACTORY_NOT_ALLOWED:        "factory_not_allowed",
+	validator.ERR_SENDER_NOT_DEPLOYED:        "sender_not_deployed",
+	validator.ERR_INVALID_INIT_CODE:          "invalid_init_code",
+	validator.ERR_SENDER_MISMATCH:            "sender_mismatch",
+	validator.ERR_MAX_FEE_TOO_LOW:            "max_fee_too_low",
+	validator.ERR_PVG_TOO_LOW:                "pvg_too_low",
+	validator.ERR_INSUFFICIENT_TOKEN_BALANCE: "insufficient_token_balance",
+}
+
+// RejectReason labels err for the ops_rejected_total metric, or returns fallback
+// when err carries no ValidationError.
+func RejectReason(err error, fallback string) string {
+	var validationErr *validator.ValidationError
+	if errors.As(err, &validationErr) {
+		if reason, ok := rejectReasons[validationErr.Code]; ok {
+			return reason
+		}
+	}
+	return fallback
+}
+
+// ChainLabel is the value of the chain label on this bundler's metrics.
+func (b *Bundlr) ChainLabel() string {
+	return chainLabel(b.Name, b.ChainID.Int64())
+}
+
+func chainLabel(name string, chainID int64) string {
+	if name != "" {
+		return name
+	}
+	return strconv.FormatInt(chainID, 10)
+}
+
+// RecordRejected counts an op turned away for reason, before or during ProcessUserOperation.
+func (b *Bundlr) RecordRejected(reason string) {
+	metrics.OpsRejected.WithLabelValues(b.ChainLabel(), reason).Inc()
+}
+
+func (b *Bundlr) reject(reason string, err error) error {
+	b.RecordRejected(reason)
+	return err
+}
+
+// Collector reports the state that is read at scrape time rather than counted:
+// mempool size, executor balance and the RPC upstreams of every chain.
+type Collector struct {
+	bundlrs []*Bundlr
+}
+
+func NewCollector(bundlrs []*Bundlr) *Collector {
+	return &Collector{bundlrs: bundlrs}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{mempoolDesc, balanceDesc, upstreamHealthyDesc, upstreamHeightDesc, upstreamLatencyDesc, upstreamRequestsDesc, upstreamErrorsDesc, upstreamFailoversDesc} {
+		ch <- d
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, b := range c.bundlrs {
+		chain := b.ChainLabel()
+
+		counts := map[string]int{"pending": 0, "bundled": 0, "submitted": 0, "sent": 0}
+		for _, op := range b.Queue.GetAll() {
+			counts[op.State]++
+		}
+		for state, n := range counts {
+			ch <- prometheus.MustNewConstMetric(mempoolDesc, prometheus.GaugeValue, float64(n), chain, state)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), BALANCE_SCRAPE_TIMEOUT)
+		balance, err := b.Validator.Client.BalanceAt(ctx, b.Signer.Address(), nil)
+		cancel()
+		if err == nil {
+			wei, _ := new(big.Float).SetInt(balance).Float64()
+			ch <- prometheus.MustNewConstMetric(balanceDesc, prometheus.GaugeValue, wei, chain)
+		}
+
+		seen := make(map[string]int)
+		for _, s := range b.Upstreams.Stats() {
+			// Two keys on the same provider share a host; keep their series apart.
+			upstream := upstreamLabel(s.URL)
+			if seen[upstream]++; seen[upstream] > 1 {
+				upstream = fmt.Sprintf("%s#%d", upstream, seen[upstream])
+			}
+			healthy := 0.0
+			if s.Healthy {
+				healthy = 1
+			}
+			ch <- prometheus.MustNewConstMetric(upstreamHealthyDesc, prometheus.GaugeValue, healthy, chain, upstream)
+			ch <- prometheus.MustNewConstMetric(upstreamHeightDesc, prometheus.GaugeValue, float64(s.Height), chain, upstream)
+			ch <- prometheus.MustNewConstMetric(upstreamLatencyDesc, prometheus.GaugeValue, s.Latency.Seconds(), chain, upstream)
+			ch <- prometheus.MustNewConstMetric(upstreamRequestsDesc, prometheus.CounterValue, float64(s.Requests), chain, upstream)
+			ch <- prometheus.MustNewConstMetric(upstreamErrorsDesc, prometheus.CounterValue, float64(s.Errors), chain, upstream)
+			ch <- prometheus.MustNewConstMetric(upstreamFailoversDesc, prometheus.CounterValue, float64(s.Failovers), chain, upstream)
+		}
+	}
+}
+
+// upstreamLabel keeps only the host of an RPC URL: paths and userinfo often carry API keys.
+func upstreamLabel(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Host
+}
@@ -1,9 +1,12 @@
 package bundlr
 
 import (
+	"encoding/json"
 	"eolia-bundlr/internal/types"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -17,8 +20,19 @@ type QueuedOp struct {
 	Attempts  int
 	State     string
 	Receipt   *types.UserOperationReceipt
+	// Hash of the handleOps transaction the op was submitted in, while State is "submitted".
+	TxHash      string    `json:",omitempty"`
+	SubmittedAt time.Time `json:",omitempty"`
+	// X-Request-ID of the request that submitted the op, for log correlation.
+	RequestID string `json:",omitempty"`
+	// Trace context of that request; bundle spans link back to it.
+	TraceContext map[string]string `json:",omitempty"`
 }
 
+// Pending ops older than this are not saved: their signatures or paymaster approvals have
+// most likely expired by the time the queue is restored.
+const SNAPSHOT_MAX_OP_AGE = time.Hour
+
 type OpQueue struct {
 	mu  sync.Mutex
 	ops map[string]*QueuedOp
@@ -34,7 +48,7 @@ func GetOpKey(op *types.PackedUserOperation) string {
 	return fmt.Sprintf("%s:%s", op.Sender, op.Nonce.String())
 }
 
-func (q *OpQueue) Add(op *types.PackedUserOperation, opHash *common.Hash) error {
+func (q *OpQueue) Add(op *types.PackedUserOperation, opHash *common.Hash, requestID string, traceContext map[string]string) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
@@ -50,9 +64,10 @@ func (q *OpQueue) Add(op *types.PackedUserOperation, opHash *common.Hash) error
 		State:     "pending",
 		Receipt:   nil,
 		OpHash:    opHash,
-	}
+		RequestID: requestID,
 
-	fmt.Printf("OP Hash: %s\n", q.ops[key].OpHash.Hex())
+		TraceContext: traceContext,
+	}
 
 	return nil
 }
@@ -69,6 +84,17 @@ func (q *OpQueue) GetByHash(opHash *common.Hash) (*QueuedOp, error) {
 	return nil, fmt.Errorf("op not found: %s", opHash.Hex())
 }
 
+func (q *OpQueue) Get(op *types.PackedUserOperation) (*QueuedOp, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queuedOp, exists := q.ops[GetOpKey(op)]
+	if !exists {
+		return nil, fmt.Errorf("op not found: %s", GetOpKey(op))
+	}
+	return queuedOp, nil
+}
+
 func (q *OpQueue) GetAll() []*QueuedOp {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -80,6 +106,22 @@ func (q *OpQueue) GetAll() []*QueuedOp {
 	return result
 }
 
+// PendingStats returns the number of ops waiting to be bundled and the total gas they may use.
+func (q *OpQueue) PendingStats() (int, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := 0
+	var gas uint64
+	for _, v := range q.ops {
+		if v.State == "pending" || v.State == "bundled" {
+			count++
+			gas += v.Op.TotalGasLimit()
+		}
+	}
+	return count, gas
+}
+
 func (q *OpQueue) Remove(op *types.PackedUserOperation) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -137,3 +179,118 @@ func (q *OpQueue) SetAsBundled(opKey string) {
 		queuedOp.State = "bundled"
 	}
 }
+
+// SetAsSubmitted marks an op as part of the handleOps transaction txHash. Submitted ops
+// are not bundled again until that transaction is settled.
+func (q *OpQueue) SetAsSubmitted(opKey string, txHash common.Hash) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queuedOp, exists := q.ops[opKey]; exists {
+		queuedOp.State = "submitted"
+		queuedOp.TxHash = txHash.Hex()
+		queuedOp.SubmittedAt = time.Now()
+	}
+}
+
+// Submitted returns copies of the submitted ops, grouped by transaction hash.
+func (q *OpQueue) Submitted() map[common.Hash][]QueuedOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := make(map[common.Hash][]QueuedOp)
+	for _, v := range q.ops {
+		if v.State == "submitted" {
+			hash := common.HexToHash(v.TxHash)
+			result[hash] = append(result[hash], *v)
+		}
+	}
+	return result
+}
+
+// Resubmit returns the ops still waiting on txHash to the bundle candidates.
+func (q *OpQueue) Resubmit(txHash common.Hash) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := 0
+	for _, v := range q.ops {
+		if v.State == "submitted" && common.HexToHash(v.TxHash) == txHash {
+			v.State = "bundled"
+			v.TxHash = ""
+			v.SubmittedAt = time.Time{}
+			count++
+		}
+	}
+	return count
+}
+
+// Save writes the queue to path as JSON, replacing the previous snapshot atomically.
+// Included ops and pending ops older than SNAPSHOT_MAX_OP_AGE are left out; submitted
+// ops are always kept, their transaction may still be mined. It returns the number of
+// ops saved.
+func (q *OpQueue) Save(path string) (int, error) {
+	q.mu.Lock()
+	now := time.Now()
+	snapshot := make([]*QueuedOp, 0, len(q.ops))
+	for _, v := range q.ops {
+		if v.State == "sent" {
+			continue
+		}
+		if v.State != "submitted" && now.Sub(v.Timestamp) > SNAPSHOT_MAX_OP_AGE {
+			continue
+		}
+		snapshot = append(snapshot, v)
+	}
+	data, err := json.Marshal(snapshot)
+	q.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode queue: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return 0, fmt.Errorf("failed to create queue dir: %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return 0, fmt.Errorf("failed to write queue: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("failed to replace queue file: %w", err)
+	}
+
+	return len(snapshot), nil
+}
+
+// Load restores a queue saved with Save and returns the number of ops restored; malformed
+// entries are skipped. A missing file is not an error.
+func (q *OpQueue) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	var snapshot []*QueuedOp
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("failed to decode queue: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	restored := 0
+	for _, v := range snapshot {
+		if v.Op == nil || v.Op.Nonce == nil || v.Op.PreVerificationGas == nil {
+			continue
+		}
+		q.ops[GetOpKey(v.Op)] = v
+		restored++
+	}
+	return restored, nil
+}
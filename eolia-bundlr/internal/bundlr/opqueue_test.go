@@ -0,0 +1,135 @@
+package bundlr
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestOpQueueSaveLoad(t *testing.T) {
+	txHash := common.HexToHash("0xb1")
+
+	tests := []struct {
+		name  string
+		state string
+		age   time.Duration
+		saved bool
+	}{
+		{"fresh pending op", "pending", time.Minute, true},
+		{"fresh bundled op", "bundled", time.Minute, true},
+		{"old pending op", "pending", SNAPSHOT_MAX_OP_AGE + time.Minute, false},
+		{"old bundled op", "bundled", SNAPSHOT_MAX_OP_AGE + time.Minute, false},
+		{"old submitted op", "submitted", SNAPSHOT_MAX_OP_AGE + time.Minute, true},
+		{"included op", "sent", time.Minute, false},
+	}
+
+	queue := NewOpQueue()
+	for i, tt := range tests {
+		op := testOp(byte(i+1), int64(i))
+		hash := common.BytesToHash([]byte{byte(i + 1)})
+		if err := queue.Add(&op, &hash, "req-"+tt.name, map[string]string{"traceparent": tt.name}); err != nil {
+			t.Fatal(err)
+		}
+		key := GetOpKey(&op)
+		if tt.state == "submitted" {
+			queue.SetAsSubmitted(key, txHash)
+		}
+		queued := queue.ops[key]
+		queued.State = tt.state
+		queued.Timestamp = time.Now().Add(-tt.age)
+	}
+
+	path := filepath.Join(t.TempDir(), "queue", "opqueue.json")
+	saved, err := queue.Save(path)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	restored := NewOpQueue()
+	n, err := restored.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if n != saved {
+		t.Errorf("Load restored %d ops, Save wrote %d", n, saved)
+	}
+
+	want := 0
+	for i, tt := range tests {
+		op := testOp(byte(i+1), int64(i))
+		got, err := restored.Get(&op)
+		if !tt.saved {
+			if err == nil {
+				t.Errorf("%s: restored, want left out", tt.name)
+			}
+			continue
+		}
+		want++
+		if err != nil {
+			t.Errorf("%s: not restored", tt.name)
+			continue
+		}
+
+		original := queue.ops[GetOpKey(&op)]
+		if got.State != original.State || got.OpHash.Hex() != original.OpHash.Hex() || got.RequestID != original.RequestID ||
+			got.TraceContext["traceparent"] != tt.name || !got.Timestamp.Equal(original.Timestamp) {
+			t.Errorf("%s: restored %+v, want %+v", tt.name, got, original)
+		}
+		if got.Op.Sender != op.Sender || got.Op.Nonce.Cmp(op.Nonce) != 0 || got.Op.PreVerificationGas.Cmp(op.PreVerificationGas) != 0 {
+			t.Errorf("%s: restored op %+v, want %+v", tt.name, got.Op, op)
+		}
+		if tt.state == "submitted" && (got.TxHash != txHash.Hex() || got.SubmittedAt.IsZero()) {
+			t.Errorf("%s: restored tx %q submitted at %s", tt.name, got.TxHash, got.SubmittedAt)
+		}
+	}
+	if saved != want {
+		t.Errorf("Save wrote %d ops, want %d", saved, want)
+	}
+}
+
+func TestOpQueueLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	op := testOp(1, 0)
+	valid, err := json.Marshal(QueuedOp{Op: &op, State: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contents *string
+		restored int
+		wantErr  bool
+	}{
+		{name: "missing file", contents: nil, restored: 0},
+		{name: "empty snapshot", contents: ptr(`[]`), restored: 0},
+		{name: "malformed entries are skipped", contents: ptr(`[{"Op":null},{"Op":{"sender":"0x0000000000000000000000000000000000000002"}},` + string(valid) + `]`), restored: 1},
+		{name: "not JSON", contents: ptr(`{`), wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		if tt.contents != nil {
+			if err := os.WriteFile(path, []byte(*tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := NewOpQueue().Load(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Load error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if n != tt.restored {
+			t.Errorf("%s: Load restored %d ops, want %d", tt.name, n, tt.restored)
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,183 @@
+package bundlr
+
+import (
+	"eolia-bundlr/internal/types"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Reputation parameters from ERC-7562.
+const (
+	MIN_INCLUSION_RATE_DENOMINATOR = 10
+	THROTTLING_SLACK               = 10
+	BAN_SLACK                      = 50
+	CRASHED_OPS_SEEN               = 10000
+	THROTTLED_ENTITY_MEMPOOL_COUNT = 4
+)
+
+// Every REPUTATION_DECAY_INTERVAL both counters lose 1/REPUTATION_DECAY_DIVISOR of their value,
+// so a ban wears off once an entity stops misbehaving.
+const (
+	REPUTATION_DECAY_INTERVAL = time.Hour
+	REPUTATION_DECAY_DIVISOR  = 24
+)
+
+type ReputationStatus string
+
+const (
+	ReputationOK        ReputationStatus = "ok"
+	ReputationThrottled ReputationStatus = "throttled"
+	ReputationBanned    ReputationStatus = "banned"
+)
+
+type ReputationEntry struct {
+	Address     common.Address
+	OpsSeen     uint64
+	OpsIncluded uint64
+}
+
+// Reputation tracks how often the entities (sender, factory, paymaster) of an op were seen
+// versus how often they actually made it on chain.
+type Reputation struct {
+	mu      sync.Mutex
+	entries map[common.Address]*ReputationEntry
+}
+
+func NewReputation() *Reputation {
+	return &Reputation{
+		entries: make(map[common.Address]*ReputationEntry),
+	}
+}
+
+// entry must be called with r.mu held.
+func (r *Reputation) entry(addr common.Address) *ReputationEntry {
+	e, exists := r.entries[addr]
+	if !exists {
+		e = &ReputationEntry{Address: addr}
+		r.entries[addr] = e
+	}
+	return e
+}
+
+func (r *Reputation) UpdateSeen(addr common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entry(addr).OpsSeen++
+}
+
+func (r *Reputation) UpdateIncluded(addr common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entry(addr).OpsIncluded++
+}
+
+// CrashedHandleOps penalizes a factory or paymaster that made a mined bundle fail, which bans it
+// until the counters decay. Senders are never crashed: their failures only cost them inclusion rate.
+func (r *Reputation) CrashedHandleOps(addr common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.entry(addr)
+	e.OpsSeen = CRASHED_OPS_SEEN
+	e.OpsIncluded = 0
+}
+
+// Decay applies one decay step. Entities seen too rarely to ever be throttled are forgotten,
+// since integer decay would keep their counters forever.
+func (r *Reputation) Decay() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for addr, e := range r.entries {
+		e.OpsSeen -= e.OpsSeen / REPUTATION_DECAY_DIVISOR
+		e.OpsIncluded -= e.OpsIncluded / REPUTATION_DECAY_DIVISOR
+		if e.OpsSeen < REPUTATION_DECAY_DIVISOR {
+			delete(r.entries, addr)
+		}
+	}
+}
+
+func (r *Reputation) Status(addr common.Address) ReputationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, exists := r.entries[addr]
+	if !exists {
+		return ReputationOK
+	}
+
+	minExpectedIncluded := e.OpsSeen / MIN_INCLUSION_RATE_DENOMINATOR
+	switch {
+	case minExpectedIncluded <= e.OpsIncluded+THROTTLING_SLACK:
+		return ReputationOK
+	case minExpectedIncluded <= e.OpsIncluded+BAN_SLACK:
+		return ReputationThrottled
+	default:
+		return ReputationBanned
+	}
+}
+
+// GetEntities returns the sender, factory and paymaster of an op. Factory and paymaster
+// are nil when the op doesn't use them.
+func GetEntities(op *types.PackedUserOperation) (sender common.Address, factory *common.Address, paymaster *common.Address) {
+	sender = op.Sender
+	if len(op.InitCode) >= common.AddressLength {
+		f := common.BytesToAddress(op.InitCode[:common.AddressLength])
+		factory = &f
+	}
+	if len(op.PaymasterAndData) >= common.AddressLength {
+		p := common.BytesToAddress(op.PaymasterAndData[:common.AddressLength])
+		paymaster = &p
+	}
+	return sender, factory, paymaster
+}
+
+// Dump returns a copy of every reputation entry.
+func (r *Reputation) Dump() []ReputationEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]ReputationEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		result = append(result, *e)
+	}
+	return result
+}
+
+// Set overwrites the counters of the given entities.
+func (r *Reputation) Set(entries []ReputationEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range entries {
+		entry := r.entry(e.Address)
+		entry.OpsSeen = e.OpsSeen
+		entry.OpsIncluded = e.OpsIncluded
+	}
+}
+
+func (r *Reputation) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = make(map[common.Address]*ReputationEntry)
+}
+
+// decayReputation applies Reputation.Decay every REPUTATION_DECAY_INTERVAL until b.Ctx is cancelled.
+func (b *Bundlr) decayReputation() {
+	ticker := time.NewTicker(REPUTATION_DECAY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-ticker.C:
+			b.Reputation.Decay()
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package bundlr
+
+import (
+	"context"
+	"eolia-bundlr/config"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type BundlingMode string
+
+const (
+	// Bundle every Interval.
+	BundlingModeInterval BundlingMode = "interval"
+	// Bundle whenever the chain head moves.
+	BundlingModeNewHead BundlingMode = "new_head"
+	// Bundle as soon as the mempool holds MaxOps ops (or Interval elapsed).
+	BundlingModeMempoolSize BundlingMode = "mempool_size"
+	// Bundle as soon as the mempool's total gas crosses MaxGas (or Interval elapsed).
+	BundlingModeMempoolGas BundlingMode = "mempool_gas"
+	// Bundle only when explicitly triggered.
+	BundlingModeManual BundlingMode = "manual"
+)
+
+const (
+	DEFAULT_BUNDLING_INTERVAL = 3 * time.Second
+	DEFAULT_HEAD_POLL         = 1 * time.Second
+)
+
+// Scheduler decides when the bundler loop should call BundleAndSend.
+type Scheduler struct {
+	mu           sync.Mutex
+	mode         BundlingMode
+	autoMode     BundlingMode
+	interval     time.Duration
+	pollInterval time.Duration
+	maxOps       int
+	maxGas       uint64
+
+	trigger chan struct{}
+	changed chan struct{}
+}
+
+func ParseBundlingMode(mode string) (BundlingMode, error) {
+	switch BundlingMode(mode) {
+	case "":
+		return BundlingModeInterval, nil
+	case BundlingModeInterval, BundlingModeNewHead, BundlingModeMempoolSize, BundlingModeMempoolGas, BundlingModeManual:
+		return BundlingMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown bundling mode: %s", mode)
+}
+
+func NewScheduler(cfg config.BundlingConfig) (*Scheduler, error) {
+	mode, err := ParseBundlingMode(cfg.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	autoMode := mode
+	if autoMode == BundlingModeManual {
+		autoMode = BundlingModeInterval
+	}
+
+	s := &Scheduler{
+		mode:         mode,
+		autoMode:     autoMode,
+		interval:     cfg.Interval,
+		pollInterval: cfg.PollInterval,
+		maxOps:       cfg.MaxOps,
+		maxGas:       cfg.MaxGas,
+		trigger:      make(chan struct{}, 1),
+		changed:      make(chan struct{}, 1),
+	}
+
+	if s.interval <= 0 && autoMode == BundlingModeInterval {
+		s.interval = DEFAULT_BUNDLING_INTERVAL
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = DEFAULT_HEAD_POLL
+	}
+	if autoMode == BundlingModeMempoolSize && s.maxOps <= 0 {
+		return nil, fmt.Errorf("bundling mode %s requires max_ops", mode)
+	}
+	if autoMode == BundlingModeMempoolGas && s.maxGas == 0 {
+		return nil, fmt.Errorf("bundling mode %s requires max_gas", mode)
+	}
+
+	return s, nil
+}
+
+func (s *Scheduler) Mode() BundlingMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mode
+}
+
+// AutoMode is the configured automatic mode, used when leaving manual mode.
+func (s *Scheduler) AutoMode() BundlingMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.autoMode
+}
+
+// SetMode switches the bundling mode at runtime; the loop picks it up immediately.
+func (s *Scheduler) SetMode(mode BundlingMode) {
+	s.mu.Lock()
+	s.mode = mode
+	s.mu.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Trigger asks the loop to bundle as soon as possible, whatever the mode.
+func (s *Scheduler) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// OnNewOp is called after an op entered the mempool, and triggers a bundle when a threshold is crossed.
+func (s *Scheduler) OnNewOp(queue *OpQueue) {
+	s.mu.Lock()
+	mode, maxOps, maxGas := s.mode, s.maxOps, s.maxGas
+	s.mu.Unlock()
+
+	switch mode {
+	case BundlingModeMempoolSize:
+		if count, _ := queue.PendingStats(); count >= maxOps {
+			s.Trigger()
+		}
+	case BundlingModeMempoolGas:
+		if _, gas := queue.PendingStats(); gas >= maxGas {
+			s.Trigger()
+		}
+	}
+}
+
+// wait returns how long the loop should sleep before its next timed check, or 0 for no timer.
+func (s *Scheduler) wait() (BundlingMode, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.mode {
+	case BundlingModeNewHead:
+		return s.mode, s.pollInterval
+	case BundlingModeManual:
+		return s.mode, 0
+	}
+	return s.mode, s.interval
+}
+
+// StartBundlerLoop runs the scheduler until b.Ctx is cancelled, and starts the upstream health checks.
+func (b *Bundlr) StartBundlerLoop() {
+	b.Upstreams.Start()
+	b.loopDone = make(chan struct{})
+	go b.decayReputation()
+
+	go func() {
+		defer close(b.loopDone)
+
+		var lastHead uint64
+		for {
+			b.beat(false)
+			mode, wait := b.Scheduler.wait()
+
+			var timer *time.Timer
+			var tick <-chan time.Time
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				tick = timer.C
+			}
+
+			select {
+			case <-b.Ctx.Done():
+				stopTimer(timer)
+				return
+			case <-b.Scheduler.changed:
+				stopTimer(timer)
+				continue
+			case <-b.Scheduler.trigger:
+				stopTimer(timer)
+				b.beat(true)
+			case <-tick:
+				b.beat(true)
+				if mode == BundlingModeNewHead {
+					head, err := b.Validator.Client.BlockNumber(b.Ctx)
+					if err != nil {
+						b.log.Warn("failed to poll chain head", "error", err)
+						continue
+					}
+					if head <= lastHead {
+						continue
+					}
+					lastHead = head
+				}
+			}
+
+			if err := b.Bundle(); err != nil {
+				b.log.Error("bundle failed", "error", err)
+			}
+		}
+	}()
+}
+
+// Bundle runs BundleAndSend, making sure only one bundle is built at a time.
+func (b *Bundlr) Bundle() error {
+	b.bundleMu.Lock()
+	defer b.bundleMu.Unlock()
+
+	return b.BundleAndSend()
+}
+
+// Stop cancels the bundler context and waits for the loop to exit.
+func (b *Bundlr) Stop(ctx context.Context) error {
+	b.cancel()
+
+	if b.loopDone == nil {
+		return nil
+	}
+
+	select {
+	case <-b.loopDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
@@ -0,0 +1,188 @@
+package bundlr
+
+import (
+	"context"
+	"eolia-bundlr/config"
+	"eolia-common/upstream"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewScheduler(t *testing.T) {
+	tests := []struct {
+		cfg      config.BundlingConfig
+		mode     BundlingMode
+		autoMode BundlingMode
+		interval time.Duration
+		wantErr  bool
+	}{
+		{cfg: config.BundlingConfig{}, mode: BundlingModeInterval, autoMode: BundlingModeInterval, interval: DEFAULT_BUNDLING_INTERVAL},
+		{cfg: config.BundlingConfig{Mode: "interval", Interval: time.Second}, mode: BundlingModeInterval, autoMode: BundlingModeInterval, interval: time.Second},
+		{cfg: config.BundlingConfig{Mode: "manual"}, mode: BundlingModeManual, autoMode: BundlingModeInterval, interval: DEFAULT_BUNDLING_INTERVAL},
+		{cfg: config.BundlingConfig{Mode: "new_head"}, mode: BundlingModeNewHead, autoMode: BundlingModeNewHead},
+		{cfg: config.BundlingConfig{Mode: "mempool_size", MaxOps: 4}, mode: BundlingModeMempoolSize, autoMode: BundlingModeMempoolSize},
+		{cfg: config.BundlingConfig{Mode: "mempool_gas", MaxGas: 1_000_000}, mode: BundlingModeMempoolGas, autoMode: BundlingModeMempoolGas},
+		{cfg: config.BundlingConfig{Mode: "mempool_size"}, wantErr: true},
+		{cfg: config.BundlingConfig{Mode: "mempool_gas"}, wantErr: true},
+		{cfg: config.BundlingConfig{Mode: "hourly"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := NewScheduler(tt.cfg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewScheduler(%+v) succeeded, want an error", tt.cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewScheduler(%+v): %v", tt.cfg, err)
+			continue
+		}
+		if s.Mode() != tt.mode || s.AutoMode() != tt.autoMode || s.interval != tt.interval {
+			t.Errorf("NewScheduler(%+v) = %s/%s every %s, want %s/%s every %s", tt.cfg, s.Mode(), s.AutoMode(), s.interval, tt.mode, tt.autoMode, tt.interval)
+		}
+	}
+}
+
+func TestSchedulerWait(t *testing.T) {
+	s, err := NewScheduler(config.BundlingConfig{Interval: 2 * time.Second, PollInterval: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode BundlingMode
+		wait time.Duration
+	}{
+		{BundlingModeInterval, 2 * time.Second},
+		{BundlingModeNewHead, 500 * time.Millisecond},
+		{BundlingModeMempoolSize, 2 * time.Second},
+		{BundlingModeMempoolGas, 2 * time.Second},
+		{BundlingModeManual, 0},
+	}
+	for _, tt := range tests {
+		s.SetMode(tt.mode)
+		if mode, wait := s.wait(); mode != tt.mode || wait != tt.wait {
+			t.Errorf("wait() in %s = %s, %s, want %s", tt.mode, mode, wait, tt.wait)
+		}
+	}
+}
+
+func TestSchedulerOnNewOp(t *testing.T) {
+	tests := []struct {
+		cfg     config.BundlingConfig
+		ops     int
+		trigger bool
+	}{
+		{config.BundlingConfig{Mode: "mempool_size", MaxOps: 3}, 2, false},
+		{config.BundlingConfig{Mode: "mempool_size", MaxOps: 3}, 3, true},
+		// testOp ops have no gas limits: only their preVerificationGas counts.
+		{config.BundlingConfig{Mode: "mempool_gas", MaxGas: 100_000}, 1, false},
+		{config.BundlingConfig{Mode: "mempool_gas", MaxGas: 100_000}, 2, true},
+		{config.BundlingConfig{Mode: "interval"}, 10, false},
+		{config.BundlingConfig{Mode: "manual"}, 10, false},
+	}
+	for _, tt := range tests {
+		s, err := NewScheduler(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue := NewOpQueue()
+		for i := 0; i < tt.ops; i++ {
+			op := testOp(byte(i+1), 0)
+			if err := queue.Add(&op, nil, "", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s.OnNewOp(queue)
+		triggered := false
+		select {
+		case <-s.trigger:
+			triggered = true
+		default:
+		}
+		if triggered != tt.trigger {
+			t.Errorf("OnNewOp in %s with %d ops triggered = %v, want %v", tt.cfg.Mode, tt.ops, triggered, tt.trigger)
+		}
+	}
+}
+
+// newLoopBundlr returns a Bundlr in manual mode whose loop can be started: its upstream
+// pool points at a node that answers nothing.
+func newLoopBundlr(t *testing.T) *Bundlr {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	pool, err := upstream.NewPool([]string{server.URL}, upstream.Config{CheckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Stop)
+
+	scheduler, err := NewScheduler(config.BundlingConfig{Mode: "manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bundlr{
+		ChainID:    big.NewInt(196),
+		Queue:      NewOpQueue(),
+		Reputation: NewReputation(),
+		Scheduler:  scheduler,
+		Upstreams:  pool,
+		Events:     NewOpEvents(),
+		Ctx:        ctx,
+		cancel:     cancel,
+		log:        slog.Default(),
+	}
+}
+
+func TestStopBundlerLoop(t *testing.T) {
+	tests := []struct {
+		name string
+		// Hold the bundle lock and trigger a bundle, so the loop is stuck in Bundle.
+		busy    bool
+		wantErr error
+	}{
+		{name: "idle loop exits"},
+		{name: "busy loop outlives the stop timeout", busy: true, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newLoopBundlr(t)
+			b.StartBundlerLoop()
+
+			if tt.busy {
+				b.bundleMu.Lock()
+				defer b.bundleMu.Unlock()
+				b.Scheduler.Trigger()
+				// Let the loop pick the trigger up and block on the lock.
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if err := b.Stop(ctx); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Stop = %v, want %v", err, tt.wantErr)
+			}
+			if b.Ctx.Err() == nil {
+				t.Error("Stop didn't cancel the bundler context")
+			}
+		})
+	}
+}
+
+func TestStopWithoutLoop(t *testing.T) {
+	b := newLoopBundlr(t)
+	if err := b.Stop(context.Background()); err != nil {
+		t.Errorf("Stop before StartBundlerLoop = %v", err)
+	}
+}
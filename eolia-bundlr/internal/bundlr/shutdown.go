@@ -0,0 +1,52 @@
+package bundlr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrShuttingDown = errors.New("bundler is shutting down")
+
+// StopAccepting makes ProcessUserOperation reject every new op.
+func (b *Bundlr) StopAccepting() {
+	b.closed.Store(true)
+}
+
+// Shutdown stops accepting ops, lets the bundle in flight finish (including its receipt
+// polling) and flushes the queue to disk, all within ctx's deadline.
+func (b *Bundlr) Shutdown(ctx context.Context) error {
+	b.StopAccepting()
+
+	var errs []error
+	if err := b.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("bundler loop did not stop in time: %w", err))
+	}
+
+	// Wait for a bundle triggered outside the loop (e.g. a manual trigger) as well.
+	done := make(chan struct{})
+	go func() {
+		b.bundleMu.Lock()
+		b.bundleMu.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("in-flight bundle did not finish in time: %w", ctx.Err()))
+	}
+
+	b.Upstreams.Stop()
+
+	if b.queueFile != "" {
+		n, err := b.Queue.Save(b.queueFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush queue: %w", err))
+		} else {
+			b.log.Info("flushed op queue", "ops", n, "file", b.queueFile)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package fees
+
+import (
+	"context"
+	"eolia-bundlr/config"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	DEFAULT_FEE_WINDOW     = 20
+	DEFAULT_FEE_PERCENTILE = 50
+	DEFAULT_FEE_REFRESH    = 2 * time.Second
+)
+
+// Oracle samples eth_feeHistory over the last blocks and derives the fees the bundler
+// asks for. Chains without EIP-1559 fall back to eth_gasPrice as the base fee and no tip.
+type Oracle struct {
+	client *ethclient.Client
+
+	window         uint64
+	percentile     float64
+	basePremium    int64
+	minPriorityFee *big.Int
+	refresh        time.Duration
+
+	mu          sync.Mutex
+	baseFee     *big.Int
+	priorityFee *big.Int
+	updated     time.Time
+}
+
+func NewOracle(client *ethclient.Client, cfg config.FeeConfig) *Oracle {
+	o := &Oracle{
+		client:         client,
+		window:         cfg.Window,
+		percentile:     cfg.Percentile,
+		basePremium:    cfg.BaseFeePremium,
+		minPriorityFee: new(big.Int).SetUint64(cfg.MinPriorityFee),
+		refresh:        cfg.Refresh,
+	}
+	if o.window == 0 {
+		o.window = DEFAULT_FEE_WINDOW
+	}
+	if o.percentile <= 0 || o.percentile > 100 {
+		o.percentile = DEFAULT_FEE_PERCENTILE
+	}
+	if o.refresh <= 0 {
+		o.refresh = DEFAULT_FEE_REFRESH
+	}
+	return o
+}
+
+// Fees returns the expected base fee of the next block and the suggested priority fee.
+// Samples are cached for the configured refresh period.
+func (o *Oracle) Fees(ctx context.Context) (baseFee *big.Int, priorityFee *big.Int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.baseFee != nil && time.Since(o.updated) < o.refresh {
+		return new(big.Int).Set(o.baseFee), new(big.Int).Set(o.priorityFee), nil
+	}
+
+	baseFee, priorityFee, err = o.sample(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if priorityFee.Cmp(o.minPriorityFee) < 0 {
+		priorityFee = new(big.Int).Set(o.minPriorityFee)
+	}
+
+	o.baseFee, o.priorityFee, o.updated = baseFee, priorityFee, time.Now()
+	return new(big.Int).Set(baseFee), new(big.Int).Set(priorityFee), nil
+}
+
+func (o *Oracle) sample(ctx context.Context) (*big.Int, *big.Int, error) {
+	history, err := o.client.FeeHistory(ctx, o.window, nil, []float64{o.percentile})
+	if err == nil && len(history.BaseFee) > 0 && history.BaseFee[len(history.BaseFee)-1].Sign() > 0 {
+		// BaseFee has one more entry than the window: the base fee of the next block.
+		baseFee := history.BaseFee[len(history.BaseFee)-1]
+
+		var rewards []*big.Int
+		for _, r := range history.Reward {
+			if len(r) > 0 && r[0] != nil {
+				rewards = append(rewards, r[0])
+			}
+		}
+		return baseFee, median(rewards), nil
+	}
+
+	gasPrice, gpErr := o.client.SuggestGasPrice(ctx)
+	if gpErr != nil {
+		return nil, nil, fmt.Errorf("failed to sample fees: %w", gpErr)
+	}
+	return gasPrice, new(big.Int), nil
+}
+
+// MinMaxFeePerGas is the lowest maxFeePerGas an op may declare: the base fee plus the configured premium.
+func (o *Oracle) MinMaxFeePerGas(ctx context.Context) (*big.Int, error) {
+	baseFee, _, err := o.Fees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return withPremium(baseFee, o.basePremium), nil
+}
+
+// GasPrice is the price the bundler pays for its own handleOps transaction: the base fee
+// with the same premium ops must declare, so a rising base fee doesn't leave the bundle
+// behind, plus the suggested priority fee.
+func (o *Oracle) GasPrice(ctx context.Context) (*big.Int, error) {
+	baseFee, priorityFee, err := o.Fees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice := withPremium(baseFee, o.basePremium)
+	return gasPrice.Add(gasPrice, priorityFee), nil
+}
+
+func withPremium(fee *big.Int, percent int64) *big.Int {
+	result := new(big.Int).Mul(fee, big.NewInt(100+percent))
+	return result.Div(result, big.NewInt(100))
+}
+
+func median(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return new(big.Int)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+	return new(big.Int).Set(values[len(values)/2])
+}
@@ -0,0 +1,181 @@
+package fees
+
+import (
+	"encoding/json"
+	"eolia-bundlr/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// feeNode answers eth_feeHistory with history, or an error when it is nil, and
+// eth_gasPrice with gasPrice. It counts the eth_feeHistory calls.
+type feeNode struct {
+	history  map[string]any
+	gasPrice string
+	samples  atomic.Int32
+}
+
+func (n *feeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	switch {
+	case req.Method == "eth_feeHistory" && n.history != nil:
+		n.samples.Add(1)
+		resp["result"] = n.history
+	case req.Method == "eth_gasPrice":
+		resp["result"] = n.gasPrice
+	default:
+		resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestOracle(t *testing.T, node *feeNode, cfg config.FeeConfig) *Oracle {
+	t.Helper()
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return NewOracle(client, cfg)
+}
+
+// history has a 100 wei next base fee and the given rewards, one block each.
+func history(rewards ...string) map[string]any {
+	reward := make([][]string, len(rewards))
+	baseFees := []string{"0x64"}
+	for i, r := range rewards {
+		reward[i] = []string{r}
+		baseFees = append(baseFees, "0x64")
+	}
+	return map[string]any{
+		"oldestBlock":   "0x1",
+		"baseFeePerGas": baseFees,
+		"gasUsedRatio":  make([]float64, len(rewards)),
+		"reward":        reward,
+	}
+}
+
+func TestOracleFees(t *testing.T) {
+	tests := []struct {
+		name    string
+		node    *feeNode
+		cfg     config.FeeConfig
+		baseFee int64
+		tip     int64
+		// Expected MinMaxFeePerGas and GasPrice.
+		minMaxFee int64
+		gasPrice  int64
+	}{
+		{
+			name:    "median reward",
+			node:    &feeNode{history: history("0x1", "0x5", "0x3")},
+			baseFee: 100, tip: 3, minMaxFee: 100, gasPrice: 103,
+		},
+		{
+			name:    "even number of rewards takes the upper median",
+			node:    &feeNode{history: history("0x1", "0x2", "0x3", "0x4")},
+			baseFee: 100, tip: 3, minMaxFee: 100, gasPrice: 103,
+		},
+		{
+			name:    "base fee premium",
+			node:    &feeNode{history: history("0x2")},
+			cfg:     config.FeeConfig{BaseFeePremium: 25},
+			baseFee: 100, tip: 2, minMaxFee: 125, gasPrice: 127,
+		},
+		{
+			name:    "priority fee floor",
+			node:    &feeNode{history: history("0x2")},
+			cfg:     config.FeeConfig{MinPriorityFee: 10, BaseFeePremium: 10},
+			baseFee: 100, tip: 10, minMaxFee: 110, gasPrice: 120,
+		},
+		{
+			name:    "no EIP-1559: gas price as the base fee, no tip",
+			node:    &feeNode{gasPrice: "0x3e8"},
+			cfg:     config.FeeConfig{BaseFeePremium: 10},
+			baseFee: 1000, tip: 0, minMaxFee: 1100, gasPrice: 1100,
+		},
+	}
+	for _, tt := range tests {
+		o := newTestOracle(t, tt.node, tt.cfg)
+
+		baseFee, tip, err := o.Fees(t.Context())
+		if err != nil {
+			t.Errorf("%s: Fees: %v", tt.name, err)
+			continue
+		}
+		if baseFee.Int64() != tt.baseFee || tip.Int64() != tt.tip {
+			t.Errorf("%s: Fees = %s, %s, want %d, %d", tt.name, baseFee, tip, tt.baseFee, tt.tip)
+		}
+
+		minMaxFee, err := o.MinMaxFeePerGas(t.Context())
+		if err != nil || minMaxFee.Int64() != tt.minMaxFee {
+			t.Errorf("%s: MinMaxFeePerGas = %s, %v, want %d", tt.name, minMaxFee, err, tt.minMaxFee)
+		}
+		gasPrice, err := o.GasPrice(t.Context())
+		if err != nil || gasPrice.Int64() != tt.gasPrice {
+			t.Errorf("%s: GasPrice = %s, %v, want %d", tt.name, gasPrice, err, tt.gasPrice)
+		}
+	}
+}
+
+func TestOracleCachesSamples(t *testing.T) {
+	node := &feeNode{history: history("0x1")}
+	o := newTestOracle(t, node, config.FeeConfig{Refresh: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		baseFee, _, err := o.Fees(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Callers may modify what they get back.
+		baseFee.SetInt64(0)
+	}
+	if n := node.samples.Load(); n != 1 {
+		t.Errorf("sampled %d times within the refresh period, want 1", n)
+	}
+	if baseFee, _, _ := o.Fees(t.Context()); baseFee.Int64() != 100 {
+		t.Errorf("cached base fee = %s, want 100", baseFee)
+	}
+
+	o.updated = time.Now().Add(-2 * time.Hour)
+	if _, _, err := o.Fees(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if n := node.samples.Load(); n != 2 {
+		t.Errorf("sampled %d times after the refresh period, want 2", n)
+	}
+}
+
+func TestWithPremium(t *testing.T) {
+	tests := []struct {
+		fee     int64
+		percent int64
+		want    int64
+	}{
+		{100, 0, 100},
+		{100, 25, 125},
+		{101, 10, 111},
+		{7, 50, 10},
+		{0, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := withPremium(big.NewInt(tt.fee), tt.percent); got.Int64() != tt.want {
+			t.Errorf("withPremium(%d, %d) = %s, want %d", tt.fee, tt.percent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package metrics holds the Prometheus metrics of the bundler pipeline. Every metric
+// carries a chain label so one /metrics endpoint covers all chains of the process.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NAMESPACE = "eolia_bundlr"
+
+var (
+	OpsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ops_received_total",
+		Help:      "User operations submitted to eth_sendUserOperation.",
+	}, []string{"chain"})
+
+	OpsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ops_rejected_total",
+		Help:      "User operations rejected before entering the mempool, by reason.",
+	}, []string{"chain", "reason"})
+
+	OpsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ops_dropped_total",
+		Help:      "Mempool ops evicted because they failed bundle simulation.",
+	}, []string{"chain"})
+
+	SimulationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "simulation_duration_seconds",
+		Help:      "Latency of handleOps simulations, for single ops and whole bundles.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+	}, []string{"chain", "kind"})
+
+	BundlesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "bundles_sent_total",
+		Help:      "handleOps transactions broadcast.",
+	}, []string{"chain"})
+
+	BundlesMined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "bundles_mined_total",
+		Help:      "handleOps transactions mined successfully.",
+	}, []string{"chain"})
+
+	BundlesReverted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "bundles_reverted_total",
+		Help:      "handleOps transactions mined with a failed status.",
+	}, []string{"chain"})
+
+	BundleGasUsed = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "bundle_gas_used",
+		Help:      "Gas used by mined handleOps transactions.",
+		Buckets:   prometheus.ExponentialBuckets(50_000, 2, 10),
+	}, []string{"chain"})
+
+	TimeToInclusion = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "op_inclusion_seconds",
+		Help:      "Time from an op entering the mempool to its UserOperationEvent being mined.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"chain"})
+)
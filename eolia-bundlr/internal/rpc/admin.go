@@ -0,0 +1,24 @@
+package rpc
+
+import (
+	"eolia-bundlr/config"
+	"eolia-common/settings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupAdminRoutes exposes the effective config, secrets redacted, on GET /admin/config.
+// It needs admin_token: without one the endpoint isn't registered.
+func SetupAdminRoutes(app *fiber.App, cfg *config.Config) {
+	if cfg.AdminToken == "" {
+		return
+	}
+
+	effective := settings.Redact(cfg)
+	app.Get("/admin/config", func(c *fiber.Ctx) error {
+		if !hasAdminToken(c, cfg.AdminToken) {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		}
+		return c.JSON(effective)
+	})
+}
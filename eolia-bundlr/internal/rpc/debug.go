@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"eolia-bundlr/internal/bundlr"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReputationDump is the debug_bundler_dumpReputation / debug_bundler_setReputation entry format.
+type ReputationDump struct {
+	Address     common.Address `json:"address"`
+	OpsSeen     hexutil.Uint64 `json:"opsSeen"`
+	OpsIncluded hexutil.Uint64 `json:"opsIncluded"`
+	Status      string         `json:"status,omitempty"`
+}
+
+// SetupDebugRoutes exposes the debug_bundler_* namespace used by the ERC-4337 bundler spec tests,
+// on /rpc/debug and through the /rpc dispatcher. Requests must always carry adminToken as a bearer
+// token; with an empty adminToken every request is refused.
+func SetupDebugRoutes(app *fiber.App, adminToken string) {
+	debugHandler = func(c *fiber.Ctx) error {
+		if !hasAdminToken(c, adminToken) {
+			return c.Status(401).JSON(RPCResponse{
+				JSONRPC: "2.0",
+				Error:   &RPCError{Code: -32001, Message: "Unauthorized"},
+				ID:      nil,
+			})
+		}
+		return handleDebug(c)
+	}
+
+	forEachPrefix(func(prefix string, b *bundlr.Bundlr) {
+		app.Post(prefix+"/rpc/debug", withBundlr(b), debugHandler)
+	})
+}
+
+// hasAdminToken fails closed: without a configured token nobody is an admin.
+func hasAdminToken(c *fiber.Ctx, adminToken string) bool {
+	if adminToken == "" {
+		return false
+	}
+
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// checkEntryPoint validates the optional entryPoint argument of the debug calls.
+func checkEntryPoint(b *bundlr.Bundlr, raw json.RawMessage) error {
+	var entryPoint common.Address
+	if err := json.Unmarshal(raw, &entryPoint); err != nil {
+		return fmt.Errorf("invalid entryPoint: %w", err)
+	}
+	if entryPoint != b.Validator.EntryPoint {
+		return fmt.Errorf("unsupported entryPoint: %s", entryPoint.Hex())
+	}
+	return nil
+}
+
+func handleDebug(c *fiber.Ctx) error {
+	b := bundlrOf(c)
+
+	var req RPCRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error: &RPCError{
+				Code:    -32700,
+				Message: "Invalid JSON",
+			},
+			ID: nil,
+		})
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return debugError(c, req.ID, -32602, "Invalid params")
+		}
+	}
+
+	switch req.Method {
+	case "debug_bundler_clearState":
+		b.Queue.Clear()
+		b.Reputation.Clear()
+		return debugResult(c, req.ID, "ok")
+
+	case "debug_bundler_dumpMempool":
+		if len(params) > 0 {
+			if err := checkEntryPoint(b, params[0]); err != nil {
+				return debugError(c, req.ID, -32602, err.Error())
+			}
+		}
+
+		ops := []interface{}{}
+		for _, queuedOp := range b.Queue.GetAll() {
+			if queuedOp.State == "pending" || queuedOp.State == "bundled" {
+				ops = append(ops, formatUserOp(queuedOp.Op))
+			}
+		}
+		return debugResult(c, req.ID, ops)
+
+	case "debug_bundler_sendBundleNow":
+		if err := b.Bundle(); err != nil {
+			return debugError(c, req.ID, -32000, err.Error())
+		}
+		return debugResult(c, req.ID, "ok")
+
+	case "debug_bundler_setBundlingMode":
+		var mode string
+		if len(params) == 0 || json.Unmarshal(params[0], &mode) != nil {
+			return debugError(c, req.ID, -32602, "Invalid params")
+		}
+
+		if mode == "auto" {
+			b.Scheduler.SetMode(b.Scheduler.AutoMode())
+			return debugResult(c, req.ID, "ok")
+		}
+
+		parsed, err := bundlr.ParseBundlingMode(mode)
+		if err != nil || (parsed != bundlr.BundlingModeManual && parsed != b.Scheduler.AutoMode()) {
+			return debugError(c, req.ID, -32602, fmt.Sprintf("unsupported bundling mode: %s", mode))
+		}
+		b.Scheduler.SetMode(parsed)
+		return debugResult(c, req.ID, "ok")
+
+	case "debug_bundler_dumpReputation":
+		if len(params) > 0 {
+			if err := checkEntryPoint(b, params[0]); err != nil {
+				return debugError(c, req.ID, -32602, err.Error())
+			}
+		}
+
+		dump := []ReputationDump{}
+		for _, e := range b.Reputation.Dump() {
+			dump = append(dump, ReputationDump{
+				Address:     e.Address,
+				OpsSeen:     hexutil.Uint64(e.OpsSeen),
+				OpsIncluded: hexutil.Uint64(e.OpsIncluded),
+				Status:      string(b.Reputation.Status(e.Address)),
+			})
+		}
+		return debugResult(c, req.ID, dump)
+
+	case "debug_bundler_setReputation":
+		var dump []ReputationDump
+		if len(params) == 0 || json.Unmarshal(params[0], &dump) != nil {
+			return debugError(c, req.ID, -32602, "Invalid params")
+		}
+		if len(params) > 1 {
+			if err := checkEntryPoint(b, params[1]); err != nil {
+				return debugError(c, req.ID, -32602, err.Error())
+			}
+		}
+
+		entries := make([]bundlr.ReputationEntry, 0, len(dump))
+		for _, d := range dump {
+			entries = append(entries, bundlr.ReputationEntry{
+				Address:     d.Address,
+				OpsSeen:     uint64(d.OpsSeen),
+				OpsIncluded: uint64(d.OpsIncluded),
+			})
+		}
+		b.Reputation.Set(entries)
+		return debugResult(c, req.ID, "ok")
+	}
+
+	return debugError(c, req.ID, -32601, fmt.Sprintf("Method not found: %s", req.Method))
+}
+
+func debugResult(c *fiber.Ctx, id interface{}, result interface{}) error {
+	return c.JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      id,
+	})
+}
+
+func debugError(c *fiber.Ctx, id interface{}, code int, message string) error {
+	return c.Status(400).JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &RPCError{Code: code, Message: message},
+		ID:      id,
+	})
+}
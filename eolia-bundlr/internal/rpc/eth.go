@@ -0,0 +1,232 @@
+package rpc
+
+import (
+	"encoding/json"
+	"eolia-bundlr/internal/types"
+	"eolia-bundlr/internal/validator"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+)
+
+// debugHandler serves debug_bundler_* calls on /rpc once SetupDebugRoutes was called.
+var debugHandler fiber.Handler
+
+// handleRPC is the standard ERC-4337 JSON-RPC endpoint: a single URL dispatching on the method name.
+func handleRPC(c *fiber.Ctx) error {
+	b := bundlrOf(c)
+
+	var req RPCRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error: &RPCError{
+				Code:    -32700,
+				Message: "Invalid JSON",
+			},
+			ID: nil,
+		})
+	}
+
+	switch req.Method {
+	case "eth_chainId":
+		return handleChainID(c)
+	case "eth_supportedEntryPoints":
+		return c.JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Result:  []string{b.Validator.EntryPoint.Hex()},
+			ID:      req.ID,
+		})
+	case "eth_sendUserOperation":
+		return handleSendUserOperation(c)
+	case "eth_getUserOperationReceipt":
+		return handleEthGetUserOperationReceipt(c, &req)
+	case "eth_getUserOperationByHash":
+		return handleEthGetUserOperationByHash(c, &req)
+	case "eth_maxPriorityFeePerGas":
+		return handleMaxPriorityFeePerGas(c, &req)
+	case "eth_estimateUserOperationGas":
+		return handleEstimateUserOperationGas(c, &req)
+	}
+
+	if strings.HasPrefix(req.Method, "debug_bundler_") && debugHandler != nil {
+		return debugHandler(c)
+	}
+
+	return c.Status(400).JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &RPCError{Code: -32601, Message: fmt.Sprintf("Method not found: %s", req.Method)},
+		ID:      req.ID,
+	})
+}
+
+func parseHashParam(req *RPCRequest) (*common.Hash, error) {
+	var params []common.Hash
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return nil, fmt.Errorf("Invalid params")
+	}
+	return &params[0], nil
+}
+
+// handleEthGetUserOperationReceipt returns the receipt once the op was mined, null otherwise.
+func handleEthGetUserOperationReceipt(c *fiber.Ctx, req *RPCRequest) error {
+	b := bundlrOf(c)
+
+	userOpHash, err := parseHashParam(req)
+	if err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32602, Message: err.Error()},
+			ID:      req.ID,
+		})
+	}
+
+	var result interface{}
+	if queuedOp, err := b.Queue.GetByHash(userOpHash); err == nil && queuedOp.State == "sent" {
+		result = queuedOp.Receipt
+	}
+
+	return c.JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      req.ID,
+	})
+}
+
+// handleEthGetUserOperationByHash returns the op known under the hash, null otherwise.
+func handleEthGetUserOperationByHash(c *fiber.Ctx, req *RPCRequest) error {
+	b := bundlrOf(c)
+
+	userOpHash, err := parseHashParam(req)
+	if err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32602, Message: err.Error()},
+			ID:      req.ID,
+		})
+	}
+
+	queuedOp, err := b.Queue.GetByHash(userOpHash)
+	if err != nil {
+		return c.JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Result:  nil,
+			ID:      req.ID,
+		})
+	}
+
+	result := fiber.Map{
+		"userOperation":   formatUserOp(queuedOp.Op),
+		"entryPoint":      b.Validator.EntryPoint.Hex(),
+		"blockNumber":     nil,
+		"blockHash":       nil,
+		"transactionHash": nil,
+	}
+	if queuedOp.State == "sent" && queuedOp.Receipt != nil && queuedOp.Receipt.Receipt != nil {
+		result["blockNumber"] = queuedOp.Receipt.Receipt.BlockNumber
+		result["blockHash"] = queuedOp.Receipt.Receipt.BlockHash
+		result["transactionHash"] = queuedOp.Receipt.Receipt.TransactionHash
+	}
+
+	return c.JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      req.ID,
+	})
+}
+
+// handleMaxPriorityFeePerGas returns the priority fee the fee oracle currently suggests.
+func handleMaxPriorityFeePerGas(c *fiber.Ctx, req *RPCRequest) error {
+	b := bundlrOf(c)
+
+	_, priorityFee, err := b.Validator.Fees.Fees(c.UserContext())
+	if err != nil {
+		return c.Status(500).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32000, Message: err.Error()},
+			ID:      req.ID,
+		})
+	}
+
+	return c.JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  "0x" + priorityFee.Text(16),
+		ID:      req.ID,
+	})
+}
+
+// estimatedFields are the fields of an unpacked op eth_estimateUserOperationGas fills in
+// when the client leaves them out.
+var estimatedFields = []string{"callGasLimit", "verificationGasLimit", "preVerificationGas", "maxFeePerGas", "maxPriorityFeePerGas"}
+
+// parseEstimationOp parses the op of eth_estimateUserOperationGas, whose gas fields may be
+// missing: they are taken as zero, and zero gas limits are estimated.
+func parseEstimationOp(raw json.RawMessage) (*types.PackedUserOperation, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("invalid userOp: %w", err)
+	}
+
+	if _, packed := fields["accountGasLimits"]; !packed {
+		missing := append([]string{}, estimatedFields...)
+		if _, ok := fields["paymaster"]; ok {
+			missing = append(missing, "paymasterVerificationGasLimit", "paymasterPostOpGasLimit")
+		}
+		for _, name := range missing {
+			if value, ok := fields[name]; !ok || string(value) == "null" {
+				fields[name] = json.RawMessage(`"0x0"`)
+			}
+		}
+		raw, _ = json.Marshal(fields)
+	}
+	return parseUserOp(raw)
+}
+
+// handleEstimateUserOperationGas returns the preVerificationGas, verificationGasLimit and
+// callGasLimit the op needs, see Validator.EstimateUserOperationGas.
+func handleEstimateUserOperationGas(c *fiber.Ctx, req *RPCRequest) error {
+	b := bundlrOf(c)
+
+	var params []json.RawMessage
+	var entryPoint common.Address
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) < 2 || json.Unmarshal(params[1], &entryPoint) != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32602, Message: "Invalid params"},
+			ID:      req.ID,
+		})
+	}
+	if entryPoint != b.Validator.EntryPoint {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32602, Message: fmt.Sprintf("unsupported entryPoint: %s", entryPoint.Hex())},
+			ID:      req.ID,
+		})
+	}
+
+	op, err := parseEstimationOp(params[0])
+	if err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: validator.ERR_INVALID_FORMAT, Message: err.Error()},
+			ID:      req.ID,
+		})
+	}
+
+	estimate, err := b.Validator.EstimateUserOperationGas(c.UserContext(), op)
+	if err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: errorCode(err, -32000), Message: err.Error()},
+			ID:      req.ID,
+		})
+	}
+
+	return c.JSON(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  estimate,
+		ID:      req.ID,
+	})
+}
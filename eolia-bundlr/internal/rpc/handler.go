@@ -1,23 +1,27 @@
 package rpc
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"eolia-bundlr/internal/bundlr"
+	"eolia-bundlr/internal/metrics"
 	"eolia-bundlr/internal/types"
+	"eolia-bundlr/internal/validator"
+	"eolia-common/logging"
+	"eolia-common/userop"
+	"errors"
 	"fmt"
-	"math/big"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gofiber/fiber/v2"
 )
 
-var Bundlr *bundlr.Bundlr
-
 func hexToBytes32(s string) ([32]byte, error) {
 	var b32 [32]byte
-	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	b, err := hexutil.Decode(s)
 	if err != nil {
 		return b32, err
 	}
@@ -30,27 +34,30 @@ func hexToBytes32(s string) ([32]byte, error) {
 
 func parseRawUserOp(raw *types.RawPackedUserOperation) (*types.PackedUserOperation, error) {
 	// Parse address
+	if !strings.HasPrefix(raw.Sender, "0x") || !common.IsHexAddress(raw.Sender) {
+		return nil, fmt.Errorf("invalid sender: %s", raw.Sender)
+	}
 	sender := common.HexToAddress(raw.Sender)
 
 	// Parse nonce
-	nonce, ok := new(big.Int).SetString(strings.TrimPrefix(raw.Nonce, "0x"), 16)
-	if !ok {
-		return nil, fmt.Errorf("invalid nonce: %s", raw.Nonce)
+	nonce, err := hexutil.DecodeBig(raw.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
 	}
 
 	// Parse preVerificationGas
-	preVerificationGas, ok := new(big.Int).SetString(strings.TrimPrefix(raw.PreVerificationGas, "0x"), 16)
-	if !ok {
-		return nil, fmt.Errorf("invalid preVerificationGas: %s", raw.PreVerificationGas)
+	preVerificationGas, err := hexutil.DecodeBig(raw.PreVerificationGas)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preVerificationGas: %w", err)
 	}
 
 	// Parse bytes fields
-	initCode, err := hex.DecodeString(strings.TrimPrefix(raw.InitCode, "0x"))
+	initCode, err := hexutil.Decode(raw.InitCode)
 	if err != nil {
 		return nil, fmt.Errorf("invalid initCode: %w", err)
 	}
 
-	callData, err := hex.DecodeString(strings.TrimPrefix(raw.CallData, "0x"))
+	callData, err := hexutil.Decode(raw.CallData)
 	if err != nil {
 		return nil, fmt.Errorf("invalid callData: %w", err)
 	}
@@ -65,12 +72,12 @@ func parseRawUserOp(raw *types.RawPackedUserOperation) (*types.PackedUserOperati
 		return nil, fmt.Errorf("invalid gasFees: %w", err)
 	}
 
-	paymasterAndData, err := hex.DecodeString(strings.TrimPrefix(raw.PaymasterAndData, "0x"))
+	paymasterAndData, err := hexutil.Decode(raw.PaymasterAndData)
 	if err != nil {
 		return nil, fmt.Errorf("invalid paymasterAndData: %w", err)
 	}
 
-	signature, err := hex.DecodeString(strings.TrimPrefix(raw.Signature, "0x"))
+	signature, err := hexutil.Decode(raw.Signature)
 	if err != nil {
 		return nil, fmt.Errorf("invalid signature: %w", err)
 	}
@@ -91,7 +98,58 @@ func parseRawUserOp(raw *types.RawPackedUserOperation) (*types.PackedUserOperati
 	return op, nil
 }
 
+func toRawPackedUserOp(op *types.PackedUserOperation) types.RawPackedUserOperation {
+	return types.RawPackedUserOperation{
+		Sender:             op.Sender.Hex(),
+		Nonce:              "0x" + op.Nonce.Text(16),
+		InitCode:           "0x" + hex.EncodeToString(op.InitCode),
+		CallData:           "0x" + hex.EncodeToString(op.CallData),
+		AccountGasLimits:   "0x" + hex.EncodeToString(op.AccountGasLimits[:]),
+		PreVerificationGas: "0x" + op.PreVerificationGas.Text(16),
+		GasFees:            "0x" + hex.EncodeToString(op.GasFees[:]),
+		PaymasterAndData:   "0x" + hex.EncodeToString(op.PaymasterAndData),
+		Signature:          "0x" + hex.EncodeToString(op.Signature),
+	}
+}
+
+// parseUserOp accepts an op in the packed format (accountGasLimits, gasFees, ...) or in the
+// unpacked v0.7 RPC format (callGasLimit, maxFeePerGas, factory, paymaster, ...).
+func parseUserOp(raw json.RawMessage) (*types.PackedUserOperation, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("invalid userOp: %w", err)
+	}
+
+	if _, packed := fields["accountGasLimits"]; packed {
+		var op types.RawPackedUserOperation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, fmt.Errorf("invalid userOp: %w", err)
+		}
+		return parseRawUserOp(&op)
+	}
+
+	var op types.UserOperation
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&op); err != nil {
+		return nil, fmt.Errorf("invalid userOp: %w", err)
+	}
+	return op.Pack()
+}
+
+// formatUserOp returns op in the unpacked RPC format, falling back to the packed
+// format for ops whose initCode/paymasterAndData can't be split into fields.
+func formatUserOp(op *types.PackedUserOperation) interface{} {
+	unpacked, err := userop.Unpack(op)
+	if err != nil {
+		return toRawPackedUserOp(op)
+	}
+	return unpacked
+}
+
 func handleSendUserOperation(c *fiber.Ctx) error {
+	b := bundlrOf(c)
+
 	var req RPCRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(RPCResponse{
@@ -104,23 +162,64 @@ func handleSendUserOperation(c *fiber.Ctx) error {
 		})
 	}
 
+	metrics.OpsReceived.WithLabelValues(b.ChainLabel()).Inc()
+
 	type sendUserOpParams struct {
-		Ops    []types.RawPackedUserOperation `json:"ops"`
-		OpHash common.Hash                    `json:"opHash"`
+		Ops    []json.RawMessage `json:"ops"`
+		OpHash common.Hash       `json:"opHash"`
+	}
+
+	var rawOp json.RawMessage
+	var clientHash common.Hash
+
+	// Standard clients send positional params: [userOp, entryPoint].
+	var positional []json.RawMessage
+	if err := json.Unmarshal(req.Params, &positional); err == nil {
+		var entryPoint common.Address
+		if len(positional) != 2 || json.Unmarshal(positional[0], &rawOp) != nil || json.Unmarshal(positional[1], &entryPoint) != nil {
+			recordRejected(c, b, "invalid_params")
+			return c.Status(400).JSON(RPCResponse{
+				JSONRPC: "2.0",
+				Error:   &RPCError{Code: -32602, Message: "Invalid params"},
+				ID:      req.ID,
+			})
+		}
+		if entryPoint != b.Validator.EntryPoint {
+			recordRejected(c, b, "unsupported_entrypoint")
+			return c.Status(400).JSON(RPCResponse{
+				JSONRPC: "2.0",
+				Error:   &RPCError{Code: -32602, Message: fmt.Sprintf("unsupported entryPoint: %s", entryPoint.Hex())},
+				ID:      req.ID,
+			})
+		}
+	} else {
+		var params sendUserOpParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.Ops) == 0 {
+			recordRejected(c, b, "invalid_params")
+			return c.Status(400).JSON(RPCResponse{
+				JSONRPC: "2.0",
+				Error:   &RPCError{Code: -32602, Message: "Invalid params"},
+				ID:      req.ID,
+			})
+		}
+		rawOp = params.Ops[0]
+		clientHash = params.OpHash
 	}
 
-	var params sendUserOpParams
-	if err := json.Unmarshal(req.Params, &params); err != nil || len(params.Ops) == 0 {
+	packedOp, err := parseUserOp(rawOp)
+	if err != nil {
+		recordRejected(c, b, "invalid_format")
 		return c.Status(400).JSON(RPCResponse{
 			JSONRPC: "2.0",
-			Error:   &RPCError{Code: -32602, Message: "Invalid params"},
+			Error:   &RPCError{Code: validator.ERR_INVALID_FORMAT, Message: err.Error()},
 			ID:      req.ID,
 		})
 	}
 
-	op := &params.Ops[0]
-	packedOp, err := parseRawUserOp(op)
+	// The hash receipts are keyed on is always computed here; a hash sent by the client is only checked against it.
+	opHash, err := b.Validator.UserOpHash(packedOp, b.ChainID)
 	if err != nil {
+		recordRejected(c, b, "invalid_format")
 		return c.Status(400).JSON(RPCResponse{
 			JSONRPC: "2.0",
 			Error:   &RPCError{Code: -32602, Message: err.Error()},
@@ -128,21 +227,50 @@ func handleSendUserOperation(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := Bundlr.ProcessUserOperation(packedOp, &params.OpHash); err != nil {
+	if clientHash != (common.Hash{}) && clientHash != opHash {
+		logging.FromContext(c.UserContext()).Info("op rejected", "chain", b.ChainLabel(), "reason", "hash_mismatch",
+			"userOpHash", opHash.Hex(), "client_hash", clientHash.Hex(), "sender", packedOp.Sender.Hex())
+		b.RecordRejected("hash_mismatch")
 		return c.Status(400).JSON(RPCResponse{
 			JSONRPC: "2.0",
-			Error:   &RPCError{Code: -32000, Message: err.Error()},
+			Error:   &RPCError{Code: -32602, Message: fmt.Sprintf("userOpHash mismatch: got %s, computed %s", clientHash.Hex(), opHash.Hex())},
+			ID:      req.ID,
+		})
+	}
+
+	if err := b.ProcessUserOperation(c.UserContext(), packedOp, &opHash); err != nil {
+		return c.Status(400).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: errorCode(err, -32000), Message: err.Error()},
+			ID:      req.ID,
 		})
 	}
 
 	return c.JSON(RPCResponse{
 		JSONRPC: "2.0",
-		Result:  "ok",
+		Result:  opHash.Hex(),
 		ID:      req.ID,
 	})
 }
 
+// recordRejected counts and logs an op turned away before it reaches ProcessUserOperation.
+func recordRejected(c *fiber.Ctx, b *bundlr.Bundlr, reason string) {
+	logging.FromContext(c.UserContext()).Info("op rejected", "chain", b.ChainLabel(), "reason", reason)
+	b.RecordRejected(reason)
+}
+
+// errorCode returns the code of a *validator.ValidationError in err's chain, or fallback.
+func errorCode(err error, fallback int) int {
+	var validationErr *validator.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Code
+	}
+	return fallback
+}
+
 func handleGetUserOperationReceipt(c *fiber.Ctx) error {
+	b := bundlrOf(c)
+
 	var req RPCRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(RPCResponse{
@@ -165,7 +293,7 @@ func handleGetUserOperationReceipt(c *fiber.Ctx) error {
 	}
 
 	userOpHash := &params[0]
-	queuedOp, err := Bundlr.Queue.GetByHash(userOpHash)
+	queuedOp, err := b.Queue.GetByHash(userOpHash)
 	if err != nil {
 		return c.Status(404).JSON(RPCResponse{
 			JSONRPC: "2.0",
@@ -173,7 +301,7 @@ func handleGetUserOperationReceipt(c *fiber.Ctx) error {
 		})
 	}
 
-	fmt.Printf("GetUserOperationReceipt: %s, state: %s\n", queuedOp.OpHash.Hex(), queuedOp.State)
+	logging.FromContext(c.UserContext()).Debug("receipt lookup", "userOpHash", queuedOp.OpHash.Hex(), "state", queuedOp.State)
 
 	switch queuedOp.State {
 	case "pending":
@@ -194,6 +322,16 @@ func handleGetUserOperationReceipt(c *fiber.Ctx) error {
 			},
 			ID: req.ID,
 		})
+	case "submitted":
+		return c.Status(404).JSON(RPCResponse{
+			JSONRPC: "2.0",
+			Result: fiber.Map{
+				"opHash":          queuedOp.OpHash.Hex(),
+				"state":           queuedOp.State,
+				"transactionHash": queuedOp.TxHash,
+			},
+			ID: req.ID,
+		})
 	case "sent":
 		return c.Status(404).JSON(RPCResponse{
 			JSONRPC: "2.0",
@@ -217,6 +355,8 @@ func handleGetUserOperationReceipt(c *fiber.Ctx) error {
 }
 
 func handleChainID(c *fiber.Ctx) error {
+	b := bundlrOf(c)
+
 	var req RPCRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(RPCResponse{
@@ -229,11 +369,9 @@ func handleChainID(c *fiber.Ctx) error {
 		})
 	}
 
-	chainID := big.NewInt(196)
-
 	return c.JSON(RPCResponse{
 		JSONRPC: "2.0",
-		Result:  "0x" + chainID.Text(16),
+		Result:  "0x" + b.ChainID.Text(16),
 		ID:      req.ID,
 	})
 }
@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"context"
+	"eolia-common/health"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupHealthRoutes registers the probes of every chain. Call it after Bundlrs is populated.
+//
+// GET /healthz is the liveness probe: it only fails when a bundler loop is stuck or gone,
+// which a restart fixes. GET /readyz adds the dependencies the bundler can't work
+// without: a synced RPC node and an executor balance above health.min_balance.
+// Both answer 503 when a component is down, with the detail of every component.
+//
+// Each chain also has its own GET /{chainId}/readyz (and /{name}/readyz). With several
+// chains, an executor balance below min_balance only takes that chain's readiness down;
+// on GET /readyz it is degraded, so the other chains keep being served.
+func SetupHealthRoutes(app *fiber.App) {
+	var liveness, readiness health.Checker
+	for _, b := range Bundlrs {
+		chain := b.ChainLabel()
+		liveness.Add(chain+"/bundler_loop", b.LoopHealth)
+
+		balance := b.BalanceHealth()
+		readiness.Add(chain+"/bundler_loop", b.LoopHealth)
+		readiness.Add(chain+"/upstreams", b.UpstreamHealth())
+		if len(Bundlrs) > 1 {
+			readiness.Add(chain+"/executor_balance", degradeWhenDown(balance))
+		} else {
+			readiness.Add(chain+"/executor_balance", balance)
+		}
+
+		var chainReadiness health.Checker
+		chainReadiness.Add("bundler_loop", b.LoopHealth)
+		chainReadiness.Add("upstreams", b.UpstreamHealth())
+		chainReadiness.Add("executor_balance", balance)
+		app.Get(fmt.Sprintf("/%s/readyz", b.ChainID.String()), healthHandler(&chainReadiness))
+		if b.Name != "" {
+			app.Get("/"+b.Name+"/readyz", healthHandler(&chainReadiness))
+		}
+	}
+
+	app.Get("/healthz", healthHandler(&liveness))
+	app.Get("/readyz", healthHandler(&readiness))
+}
+
+// degradeWhenDown reports a down component as degraded.
+func degradeWhenDown(fn health.CheckFunc) health.CheckFunc {
+	return func(ctx context.Context) health.Component {
+		component := fn(ctx)
+		if component.Status == health.STATUS_DOWN {
+			return health.Degraded(component.Error, component.Details)
+		}
+		return component
+	}
+}
+
+func healthHandler(checker *health.Checker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := checker.Run(c.UserContext())
+		return c.Status(report.HTTPStatus()).JSON(report)
+	}
+}
@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"eolia-bundlr/internal/bundlr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// SetupMetricsRoutes serves the Prometheus metrics of every chain on GET /metrics.
+// The metrics carry executor balances and upstream hosts, so requests must send adminToken
+// as a bearer token; without one the endpoint isn't registered. Call it after Bundlrs is
+// populated.
+func SetupMetricsRoutes(app *fiber.App, adminToken string) {
+	if adminToken == "" {
+		return
+	}
+
+	handler := metricsHandler()
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		if !hasAdminToken(c, adminToken) {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		}
+		return handler(c)
+	})
+}
+
+// SetupMetricsListener serves GET /metrics without authentication on its own app, bound to
+// metrics_listen. Call it after Bundlrs is populated.
+func SetupMetricsListener(app *fiber.App) {
+	app.Get("/metrics", metricsHandler())
+}
+
+func metricsHandler() fiber.Handler {
+	prometheus.MustRegister(bundlr.NewCollector(Bundlrs))
+	return adaptor.HTTPHandler(promhttp.Handler())
+}
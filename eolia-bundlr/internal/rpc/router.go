@@ -1,11 +1,51 @@
 package rpc
 
 import (
+	"eolia-bundlr/internal/bundlr"
+	"fmt"
+
 	"github.com/gofiber/fiber/v2"
 )
 
+const bundlrKey = "bundlr"
+
+// Bundlrs holds one Bundlr per configured chain. The first one also serves the unprefixed routes.
+var Bundlrs []*bundlr.Bundlr
+
+// withBundlr makes b the chain served by the rest of the route's handlers.
+func withBundlr(b *bundlr.Bundlr) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(bundlrKey, b)
+		return c.Next()
+	}
+}
+
+func bundlrOf(c *fiber.Ctx) *bundlr.Bundlr {
+	return c.Locals(bundlrKey).(*bundlr.Bundlr)
+}
+
+// forEachPrefix calls fn with every route prefix and the chain it serves:
+// "" for the default chain, then "/<chainId>" and "/<name>" for each chain.
+func forEachPrefix(fn func(prefix string, b *bundlr.Bundlr)) {
+	if len(Bundlrs) == 0 {
+		return
+	}
+
+	fn("", Bundlrs[0])
+	for _, b := range Bundlrs {
+		fn(fmt.Sprintf("/%s", b.ChainID.String()), b)
+		if b.Name != "" {
+			fn("/"+b.Name, b)
+		}
+	}
+}
+
 func SetupRoutes(app *fiber.App) {
-	app.Post("/rpc/sendUserOp", handleSendUserOperation)
-	app.Post("/rpc/getUserOpReceipt", handleGetUserOperationReceipt)
-	app.Get("/rpc/getChainId", handleChainID)
+	forEachPrefix(func(prefix string, b *bundlr.Bundlr) {
+		use := withBundlr(b)
+		app.Post(prefix+"/rpc", use, handleRPC)
+		app.Post(prefix+"/rpc/sendUserOp", use, handleSendUserOperation)
+		app.Post(prefix+"/rpc/getUserOpReceipt", use, handleGetUserOperationReceipt)
+		app.Get(prefix+"/rpc/getChainId", use, handleChainID)
+	})
 }
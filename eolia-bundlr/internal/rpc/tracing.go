@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"eolia-common/logging"
+	"eolia-common/tracing"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// RequestTracer starts a server span per request, continuing the trace of the caller's
+// traceparent header (eolia-signer sends one), and adds the trace id to the request's logger.
+// Register it after logging.RequestLogger.
+func RequestTracer() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, span := tracing.StartServer(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()), c.Method()+" "+c.Path(),
+			attribute.String("http.request.method", c.Method()),
+			attribute.String("url.path", c.Path()),
+		)
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", sc.TraceID().String()))
+		}
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		span.SetName(c.Method() + " " + c.Route().Path)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		spanErr := err
+		if spanErr == nil && status >= fiber.StatusInternalServerError {
+			spanErr = fmt.Errorf("HTTP %d", status)
+		}
+		tracing.End(span, spanErr)
+		return err
+	}
+}
@@ -9,13 +9,27 @@ type RPCRequest struct {
 	ID      interface{}     `json:"id"`
 }
 
+// RPCResponse is a JSON-RPC 2.0 response: "result" is always present on success, null
+// included (e.g. an unknown op's receipt), and absent on error.
 type RPCResponse struct {
 	JSONRPC string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
+	Result  interface{} `json:"result"`
 	Error   *RPCError   `json:"error,omitempty"`
 	ID      interface{} `json:"id"`
 }
 
+func (r RPCResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string      `json:"jsonrpc"`
+			Error   *RPCError   `json:"error"`
+			ID      interface{} `json:"id"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	type response RPCResponse
+	return json.Marshal(response(r))
+}
+
 type RPCError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -0,0 +1,383 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"eolia-bundlr/internal/bundlr"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Subscription type of eth_subscribe that follows op lifecycle events.
+const SUBSCRIPTION_USER_OPERATIONS = "userOperationStatus"
+
+const (
+	WS_MAX_SUBSCRIPTIONS = 32
+	// Open connections over every chain, and per client IP. Admin connections (e.g. eolia-signer
+	// relaying the events of its users) only count towards the global cap.
+	WS_MAX_CONNECTIONS        = 1000
+	WS_MAX_CONNECTIONS_PER_IP = 20
+	WS_PING_INTERVAL          = 30 * time.Second
+	WS_WRITE_TIMEOUT          = 10 * time.Second
+)
+
+// Fiber local telling the WebSocket handler that the upgrade request carried the admin token.
+const wsAdminLocal = "wsAdmin"
+
+var wsConnections = &connLimiter{perIP: make(map[string]int)}
+
+// connLimiter caps the open WebSocket connections globally and, for non-admins, per client IP.
+type connLimiter struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func (l *connLimiter) acquire(ip string, admin bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.total >= WS_MAX_CONNECTIONS || (!admin && l.perIP[ip] >= WS_MAX_CONNECTIONS_PER_IP) {
+		return false
+	}
+	l.total++
+	if !admin {
+		l.perIP[ip]++
+	}
+	return true
+}
+
+func (l *connLimiter) release(ip string, admin bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if admin {
+		return
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+type subscriptionNotification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  subscriptionResult `json:"params"`
+}
+
+type subscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// SetupWebSocketRoutes serves JSON-RPC over WebSocket on GET /rpc (and the per-chain prefixes).
+// Besides every method of POST /rpc, a connection can eth_subscribe to "userOperationStatus"
+// with a {"userOpHash", "sender"} filter and receives an eth_subscription notification for
+// each status change of the matching ops. Only a connection opened with the admin token may
+// leave the filter empty and follow every op. Call it after SetupRoutes.
+func SetupWebSocketRoutes(app *fiber.App, adminToken string) {
+	forEachPrefix(func(prefix string, b *bundlr.Bundlr) {
+		path := prefix + "/rpc"
+		app.Get(path, requireUpgrade(adminToken), websocket.New(func(conn *websocket.Conn) {
+			log := slog.Default().With("chain", b.ChainLabel(), "remote", conn.RemoteAddr().String())
+
+			admin, _ := conn.Locals(wsAdminLocal).(bool)
+			ip := conn.IP()
+			if !wsConnections.acquire(ip, admin) {
+				log.Warn("websocket refused: too many connections")
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many connections"), time.Now().Add(WS_WRITE_TIMEOUT))
+				return
+			}
+			defer wsConnections.release(ip, admin)
+
+			ws := &wsConn{
+				conn:  conn,
+				b:     b,
+				app:   app,
+				path:  path,
+				admin: admin,
+				subs:  make(map[string]*bundlr.OpSubscription),
+				log:   log,
+			}
+			ws.serve()
+		}))
+	})
+}
+
+func requireUpgrade(adminToken string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		c.Locals(wsAdminLocal, hasAdminToken(c, adminToken))
+		return c.Next()
+	}
+}
+
+// wsConn is one WebSocket client: JSON-RPC calls in, responses and notifications out.
+type wsConn struct {
+	conn *websocket.Conn
+	b    *bundlr.Bundlr
+	app  *fiber.App
+	path string
+	log  *slog.Logger
+	// Opened with the admin token: may subscribe without a filter.
+	admin bool
+
+	writeMu sync.Mutex
+	subsMu  sync.Mutex
+	subs    map[string]*bundlr.OpSubscription
+
+	// Closed when serve returns. The goroutines writing to conn (ping, forward) exit on it,
+	// and serve waits for them in writers: contrib/websocket releases conn right after.
+	done    chan struct{}
+	writers sync.WaitGroup
+}
+
+func (w *wsConn) serve() {
+	w.log.Debug("websocket connected")
+	defer w.log.Debug("websocket closed")
+
+	w.done = make(chan struct{})
+	defer w.writers.Wait()
+	defer w.unsubscribeAll()
+	defer close(w.done)
+
+	w.conn.SetReadDeadline(time.Now().Add(2 * WS_PING_INTERVAL))
+	w.conn.SetPongHandler(func(string) error {
+		return w.conn.SetReadDeadline(time.Now().Add(2 * WS_PING_INTERVAL))
+	})
+	w.writers.Add(1)
+	go w.ping()
+
+	for {
+		_, msg, err := w.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		w.conn.SetReadDeadline(time.Now().Add(2 * WS_PING_INTERVAL))
+
+		if resp := w.handle(msg); resp != nil {
+			if err := w.write(resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (w *wsConn) ping() {
+	defer w.writers.Done()
+
+	ticker := time.NewTicker(WS_PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (w *wsConn) write(msg []byte) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	return w.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (w *wsConn) writeJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.write(msg)
+}
+
+// handle answers one message. Subscription methods are served here, everything else
+// by the POST handler of the same route.
+func (w *wsConn) handle(msg []byte) []byte {
+	var req RPCRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return w.call(msg)
+	}
+
+	switch req.Method {
+	case "eth_subscribe":
+		if err := w.subscribe(&req); err != nil {
+			return rpcErrorMessage(req.ID, -32602, err.Error())
+		}
+		return nil
+	case "eth_unsubscribe":
+		return w.unsubscribe(&req)
+	}
+	return w.call(msg)
+}
+
+// call runs a JSON-RPC request through the app as if it were POSTed to the route, so
+// both transports share the same methods and middlewares.
+func (w *wsConn) call(msg []byte) []byte {
+	var req fasthttp.Request
+	req.Header.SetMethod(fiber.MethodPost)
+	req.Header.SetContentType(fiber.MIMEApplicationJSON)
+	req.SetRequestURI(w.path)
+	req.SetBody(msg)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, w.conn.RemoteAddr(), nil)
+	w.app.Server().Handler(&ctx)
+
+	return append([]byte(nil), ctx.Response.Body()...)
+}
+
+// subscribe handles eth_subscribe ["userOperationStatus", {"userOpHash": ..., "sender": ...}].
+// At least one of the two is required unless the connection is an admin's. The
+// subscription id is answered first, then the current status of the matching ops already
+// in the mempool, then every change.
+func (w *wsConn) subscribe(req *RPCRequest) error {
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) != 2 {
+		return fmt.Errorf("Invalid params: expected [\"%s\", {userOpHash, sender}]", SUBSCRIPTION_USER_OPERATIONS)
+	}
+
+	var kind string
+	if err := json.Unmarshal(params[0], &kind); err != nil || kind != SUBSCRIPTION_USER_OPERATIONS {
+		return fmt.Errorf("Unsupported subscription: %s", params[0])
+	}
+
+	var filter bundlr.OpFilter
+	if err := json.Unmarshal(params[1], &filter); err != nil {
+		return fmt.Errorf("Invalid filter: %v", err)
+	}
+	if filter.Empty() && !w.admin {
+		return errors.New("A userOpHash or sender filter is required")
+	}
+
+	w.subsMu.Lock()
+	if len(w.subs) >= WS_MAX_SUBSCRIPTIONS {
+		w.subsMu.Unlock()
+		return fmt.Errorf("Too many subscriptions (max %d)", WS_MAX_SUBSCRIPTIONS)
+	}
+	id := newSubscriptionID()
+	sub, current := w.b.SubscribeOps(filter)
+	w.subs[id] = sub
+	w.subsMu.Unlock()
+
+	if err := w.writeJSON(RPCResponse{JSONRPC: "2.0", Result: id, ID: req.ID}); err != nil {
+		return nil
+	}
+
+	w.writers.Add(1)
+	go w.forward(id, sub, current)
+	return nil
+}
+
+// forward writes the events of sub until it ends or the connection closes; events still
+// buffered when the connection closes are dropped.
+func (w *wsConn) forward(id string, sub *bundlr.OpSubscription, current []bundlr.OpEvent) {
+	defer w.writers.Done()
+
+	notify := func(ev bundlr.OpEvent) error {
+		return w.writeJSON(subscriptionNotification{
+			JSONRPC: "2.0",
+			Method:  "eth_subscription",
+			Params:  subscriptionResult{Subscription: id, Result: ev},
+		})
+	}
+
+	for _, ev := range current {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		if notify(ev) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-w.done:
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				w.closeSlow(id, sub)
+				return
+			}
+			if notify(ev) != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeSlow closes the connection when sub was dropped because the client stopped reading
+// fast enough to keep up; it has to resubscribe. It runs on a writer goroutine, which serve
+// waits for, so conn is still the connection's.
+func (w *wsConn) closeSlow(id string, sub *bundlr.OpSubscription) {
+	if err := sub.Err(); err != nil {
+		w.log.Warn("closing websocket", "subscription", id, "error", err)
+		w.writeMu.Lock()
+		w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(WS_WRITE_TIMEOUT))
+		w.writeMu.Unlock()
+		w.conn.Close()
+	}
+}
+
+func (w *wsConn) unsubscribe(req *RPCRequest) []byte {
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) != 1 {
+		return rpcErrorMessage(req.ID, -32602, "Invalid params: expected [subscriptionId]")
+	}
+
+	w.subsMu.Lock()
+	sub, ok := w.subs[params[0]]
+	delete(w.subs, params[0])
+	w.subsMu.Unlock()
+
+	if ok {
+		sub.Unsubscribe()
+	}
+
+	resp, _ := json.Marshal(RPCResponse{JSONRPC: "2.0", Result: ok, ID: req.ID})
+	return resp
+}
+
+func (w *wsConn) unsubscribeAll() {
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+
+	for id, sub := range w.subs {
+		sub.Unsubscribe()
+		delete(w.subs, id)
+	}
+}
+
+func rpcErrorMessage(id interface{}, code int, message string) []byte {
+	resp, _ := json.Marshal(RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &RPCError{Code: code, Message: message},
+		ID:      id,
+	})
+	return resp
+}
+
+func newSubscriptionID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return "0x" + hex.EncodeToString(b[:])
+}
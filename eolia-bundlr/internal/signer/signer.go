@@ -3,7 +3,7 @@ package signer
 
 import (
 	"crypto/ecdsa"
-	"fmt"
+	"log/slog"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -20,7 +20,7 @@ type LocalSigner struct {
 func NewLocalSigner(hexKey string, chainID *big.Int) *LocalSigner {
 	privKey, err := crypto.HexToECDSA(hexKey)
 	if err != nil {
-		fmt.Printf("Failed to create signer: %v\n", err)
+		slog.Error("failed to create signer", "error", err)
 		return nil
 	}
 	address := crypto.PubkeyToAddress(privKey.PublicKey)
@@ -1,45 +1,13 @@
 package types
 
-import (
-	"math/big"
-
-	"github.com/ethereum/go-ethereum/common"
-)
-
-var ChainID *big.Int
-var EntryPoint common.Address
+import "eolia-common/userop"
 
 // PackedUserOperation represents a single ERC-4337 operation request in Entrypoint.
-// This struct matches the spec from:
-// https://eips.ethereum.org/EIPS/eip-4337
-type PackedUserOperation struct {
-	// The Account making the UserOperation.
-	Sender common.Address `json:"sender"`
-
-	// Anti-replay parameter.
-	Nonce *big.Int `json:"nonce"`
-
-	// Concatenation of factory address and factoryData (or empty), or EIP-7702 data.
-	InitCode []byte `json:"initCode"`
-
-	// The data to pass to the sender during the main execution call.
-	CallData []byte `json:"callData"`
-
-	// Concatenation of verificationGasLimit (16 bytes) and callGasLimit (16 bytes).
-	AccountGasLimits [32]byte `json:"accountGasLimits"`
+// It is shared with eolia-signer through eolia-common/userop.
+type PackedUserOperation = userop.PackedUserOperation
 
-	// Extra gas to pay the bundler.
-	PreVerificationGas *big.Int `json:"preVerificationGas"`
-
-	// Concatenation of maxPriorityFeePerGas (16 bytes) and maxFeePerGas (16 bytes).
-	GasFees [32]byte `json:"gasFees"`
-
-	// Concatenation of paymaster fields (or empty).
-	PaymasterAndData []byte `json:"paymasterAndData"`
-
-	// Data passed into the sender to verify authorization
-	Signature []byte `json:"signature"`
-}
+// UserOperation is the unpacked v0.7 RPC format (factory, callGasLimit, maxFeePerGas, paymaster, ...).
+type UserOperation = userop.UserOperation
 
 type RawPackedUserOperation struct {
 	Sender             string `json:"sender"`
@@ -54,14 +22,25 @@ type RawPackedUserOperation struct {
 }
 
 type UserOperationReceipt struct {
-	UserOpHash    string     `json:"userOpHash"`
-	Sender        string     `json:"sender"`
-	Nonce         string     `json:"nonce"`
-	Paymaster     string     `json:"paymaster,omitempty"`
-	Success       bool       `json:"success"`
-	ActualGasCost string     `json:"actualGasCost"`
-	ActualGasUsed string     `json:"actualGasUsed"`
-	Receipt       *TxReceipt `json:"receipt"` // EVM transaction receipt struct'ı
+	UserOpHash    string `json:"userOpHash"`
+	Sender        string `json:"sender"`
+	Nonce         string `json:"nonce"`
+	Paymaster     string `json:"paymaster,omitempty"`
+	Success       bool   `json:"success"`
+	ActualGasCost string `json:"actualGasCost"`
+	ActualGasUsed string `json:"actualGasUsed"`
+	// Decoded revert reason of the op's call when Success is false.
+	Reason string `json:"reason,omitempty"`
+	// What a TokenPaymaster charged the sender for gas, when the op paid in tokens.
+	TokenPayment *TokenPayment `json:"tokenPayment,omitempty"`
+	Receipt      *TxReceipt    `json:"receipt"` // EVM transaction receipt struct'ı
+}
+
+// TokenPayment is a TokenCharged event of a TokenPaymaster.
+type TokenPayment struct {
+	Token        string `json:"token"`
+	Amount       string `json:"amount"`
+	ExchangeRate string `json:"exchangeRate"`
 }
 
 type TxReceipt struct {
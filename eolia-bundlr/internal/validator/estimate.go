@@ -0,0 +1,285 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Error codes of eth_estimateUserOperationGas, as in ERC-7769.
+const (
+	// The account, factory or paymaster rejected the op during validation.
+	ERR_SIMULATE_VALIDATION = -32500
+	// The op's callData reverts.
+	ERR_EXECUTION_REVERTED = -32521
+)
+
+const (
+	// Gas limits an op is simulated with while its own are estimated.
+	ESTIMATE_VERIFICATION_GAS_LIMIT = 5_000_000
+	ESTIMATE_PAYMASTER_GAS_LIMIT    = 1_000_000
+	// Most gas the callData of an op is estimated at.
+	MAX_CALL_GAS_LIMIT = 10_000_000
+	// Gas of the eth_calls running the simulations.
+	SIMULATION_GAS_CAP = 30_000_000
+	// The callGasLimit search stops when the bounds are this close.
+	CALL_GAS_PRECISION = 1_000
+	// Added to the measured verification and call gas, in percent.
+	ESTIMATE_MARGIN_PERCENT = 10
+)
+
+// GasEstimate is the result of eth_estimateUserOperationGas.
+type GasEstimate struct {
+	PreVerificationGas   *hexutil.Big `json:"preVerificationGas"`
+	VerificationGasLimit *hexutil.Big `json:"verificationGasLimit"`
+	CallGasLimit         *hexutil.Big `json:"callGasLimit"`
+}
+
+// ExecutionResult is what EntryPointSimulations.simulateHandleOp returns.
+type ExecutionResult struct {
+	PreOpGas                *big.Int
+	Paid                    *big.Int
+	AccountValidationData   *big.Int
+	PaymasterValidationData *big.Int
+	TargetSuccess           bool
+	TargetResult            []byte
+}
+
+// simulationsArtifact is the part of a Hardhat artifact of EntryPointSimulations the
+// estimation needs.
+type simulationsArtifact struct {
+	ABI              json.RawMessage `json:"abi"`
+	DeployedBytecode string          `json:"deployedBytecode"`
+}
+
+// loadSimulations reads the ABI and runtime code of EntryPointSimulations from a Hardhat
+// artifact of eolia-contracts.
+func loadSimulations(path string) (*abi.ABI, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read EntryPointSimulations artifact: %w", err)
+	}
+
+	var artifact simulationsArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse EntryPointSimulations artifact: %w", err)
+	}
+	simulationsAbi, err := abi.JSON(strings.NewReader(string(artifact.ABI)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse EntryPointSimulations ABI: %w", err)
+	}
+	code, err := hexutil.Decode(artifact.DeployedBytecode)
+	if err != nil || len(code) == 0 {
+		return nil, nil, fmt.Errorf("EntryPointSimulations artifact has no deployedBytecode")
+	}
+	return &simulationsAbi, code, nil
+}
+
+// EstimateUserOperationGas estimates the gas fields of op, whose zero gas limits are left
+// to the estimate. The op runs through EntryPointSimulations.simulateHandleOp, swapped in
+// for the EntryPoint's code with a state override, so a placeholder signature only fails
+// the signature check instead of reverting:
+//   - verificationGasLimit is the gas the validation used (account, factory and paymaster),
+//   - callGasLimit is the least gas with which the callData, run from the EntryPoint once
+//     the op is validated, doesn't fail,
+//   - preVerificationGas is what ValidatePreVerificationGas requires with those limits.
+//
+// The measured gas gets ESTIMATE_MARGIN_PERCENT on top.
+func (v *Validator) EstimateUserOperationGas(ctx context.Context, op *types.PackedUserOperation) (*GasEstimate, error) {
+	if v.SimulationsABI == nil {
+		return nil, errors.New("gas estimation needs validation.entrypoint_simulations")
+	}
+
+	sim, err := simulationOp(op)
+	if err != nil {
+		return nil, validationError(ERR_INVALID_FORMAT, "%v", err)
+	}
+
+	result, err := v.simulateHandleOp(ctx, sim, op.Sender, op.CallData, SIMULATION_GAS_CAP)
+	if err != nil {
+		return nil, err
+	}
+	if len(op.CallData) > 0 && !result.TargetSuccess {
+		return nil, validationError(ERR_EXECUTION_REVERTED, "execution reverted: %s", userop.DecodeRevertReason(result.TargetResult))
+	}
+
+	verificationGas := new(big.Int).Sub(result.PreOpGas, sim.PreVerificationGas)
+	callGas, err := v.estimateCallGas(ctx, sim, op)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationGasLimit := withMargin(verificationGas)
+	callGasLimit := withMargin(callGas)
+	estimated := *op
+	if estimated.AccountGasLimits, err = userop.PackAccountGasLimits(verificationGasLimit, callGasLimit); err != nil {
+		return nil, err
+	}
+	if estimated.GasFees, err = v.estimationFees(ctx, op); err != nil {
+		return nil, err
+	}
+	preVerificationGas, err := v.RequiredPreVerificationGas(ctx, &estimated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GasEstimate{
+		PreVerificationGas:   (*hexutil.Big)(preVerificationGas),
+		VerificationGasLimit: (*hexutil.Big)(verificationGasLimit),
+		CallGasLimit:         (*hexutil.Big)(callGasLimit),
+	}, nil
+}
+
+// simulationOp is op as it is simulated: the gas limits to estimate raised so they don't
+// get in the way, a zero callGasLimit since the callData is measured on its own, and zero
+// fees so that nobody has to prefund the simulation.
+func simulationOp(op *types.PackedUserOperation) (*types.PackedUserOperation, error) {
+	sim := *op
+	if sim.PreVerificationGas == nil {
+		sim.PreVerificationGas = new(big.Int)
+	}
+
+	verificationGasLimit, _ := userop.UnpackAccountGasLimits(op.AccountGasLimits)
+	if verificationGasLimit.Sign() == 0 {
+		verificationGasLimit.SetUint64(ESTIMATE_VERIFICATION_GAS_LIMIT)
+	}
+	accountGasLimits, err := userop.PackAccountGasLimits(verificationGasLimit, new(big.Int))
+	if err != nil {
+		return nil, err
+	}
+	sim.AccountGasLimits = accountGasLimits
+	sim.GasFees = [32]byte{}
+
+	pm, err := userop.UnpackPaymasterAndData(op.PaymasterAndData)
+	if err != nil {
+		return nil, err
+	}
+	if pm != nil {
+		if pm.PaymasterVerificationGasLimit.Sign() == 0 {
+			pm.PaymasterVerificationGasLimit.SetUint64(ESTIMATE_PAYMASTER_GAS_LIMIT)
+		}
+		if pm.PaymasterPostOpGasLimit.Sign() == 0 {
+			pm.PaymasterPostOpGasLimit.SetUint64(ESTIMATE_PAYMASTER_GAS_LIMIT)
+		}
+		if sim.PaymasterAndData, err = userop.PackPaymasterAndData(pm); err != nil {
+			return nil, err
+		}
+	}
+	return &sim, nil
+}
+
+// estimateCallGas finds the gas the callData of op needs: the least gas the simulation
+// needs with the callData run as its target, minus what it needs without.
+func (v *Validator) estimateCallGas(ctx context.Context, sim, op *types.PackedUserOperation) (*big.Int, error) {
+	if len(op.CallData) == 0 {
+		return new(big.Int), nil
+	}
+
+	base, err := v.leastSimulationGas(ctx, 0, SIMULATION_GAS_CAP, func(gas uint64) bool {
+		_, err := v.simulateHandleOp(ctx, sim, common.Address{}, nil, gas)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	withCall, err := v.leastSimulationGas(ctx, base, min(base+MAX_CALL_GAS_LIMIT, SIMULATION_GAS_CAP), func(gas uint64) bool {
+		result, err := v.simulateHandleOp(ctx, sim, op.Sender, op.CallData, gas)
+		return err == nil && result.TargetSuccess
+	})
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetUint64(withCall - base), nil
+}
+
+// leastSimulationGas binary-searches the least gas in (low, high] for which ok holds, to
+// within CALL_GAS_PRECISION.
+func (v *Validator) leastSimulationGas(ctx context.Context, low, high uint64, ok func(gas uint64) bool) (uint64, error) {
+	if !ok(high) {
+		return 0, validationError(ERR_EXECUTION_REVERTED, "execution needs more than %d gas", high)
+	}
+	for high-low > CALL_GAS_PRECISION {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		mid := low + (high-low)/2
+		if ok(mid) {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return high, nil
+}
+
+// simulateHandleOp runs EntryPointSimulations.simulateHandleOp over op with eth_call, the
+// EntryPoint's code replaced by the simulation contract. A FailedOp revert is returned as a
+// ValidationError with ERR_SIMULATE_VALIDATION.
+func (v *Validator) simulateHandleOp(ctx context.Context, op *types.PackedUserOperation, target common.Address, targetCallData []byte, gas uint64) (*ExecutionResult, error) {
+	if targetCallData == nil {
+		targetCallData = []byte{}
+	}
+	calldata, err := v.SimulationsABI.Pack("simulateHandleOp", *op, target, targetCallData)
+	if err != nil {
+		return nil, fmt.Errorf("abi.Pack failed: %w", err)
+	}
+
+	msg := map[string]interface{}{
+		"from":  v.Bundlr,
+		"to":    v.EntryPoint,
+		"input": hexutil.Bytes(calldata),
+		"gas":   hexutil.Uint64(gas),
+	}
+	overrides := map[common.Address]map[string]interface{}{
+		v.EntryPoint: {"code": hexutil.Bytes(v.SimulationsCode)},
+	}
+
+	var out hexutil.Bytes
+	if err := v.Client.Client().CallContext(ctx, &out, "eth_call", msg, "latest", overrides); err != nil {
+		if failed := v.decodeFailedOp(err); failed != nil {
+			return nil, validationError(ERR_SIMULATE_VALIDATION, "%s", failed.Reason)
+		}
+		return nil, fmt.Errorf("simulateHandleOp failed: %w", err)
+	}
+
+	values, err := v.SimulationsABI.Unpack("simulateHandleOp", out)
+	if err != nil || len(values) != 1 {
+		return nil, fmt.Errorf("invalid simulateHandleOp result: %x", out)
+	}
+	result := *abi.ConvertType(values[0], new(ExecutionResult)).(*ExecutionResult)
+	return &result, nil
+}
+
+// estimationFees are the op's fees, or when it has none yet the least the bundler accepts,
+// so that the L1 data fee of the preVerificationGas can be priced.
+func (v *Validator) estimationFees(ctx context.Context, op *types.PackedUserOperation) ([32]byte, error) {
+	_, maxFeePerGas := userop.UnpackGasFees(op.GasFees)
+	if maxFeePerGas.Sign() > 0 || v.Fees == nil {
+		return op.GasFees, nil
+	}
+
+	_, priorityFee, err := v.Fees.Fees(ctx)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	minMaxFee, err := v.Fees.MinMaxFeePerGas(ctx)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return userop.PackGasFees(priorityFee, minMaxFee.Add(minMaxFee, priorityFee))
+}
+
+func withMargin(gas *big.Int) *big.Int {
+	result := new(big.Int).Mul(gas, big.NewInt(100+ESTIMATE_MARGIN_PERCENT))
+	return result.Div(result, big.NewInt(100))
+}
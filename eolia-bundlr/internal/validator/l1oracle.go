@@ -0,0 +1,117 @@
+package validator
+
+import (
+	"context"
+	"eolia-bundlr/config"
+	"eolia-common/userop"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// OP-stack predeploy pricing the L1 data of L2 transactions.
+var OPTIMISM_GAS_PRICE_ORACLE = common.HexToAddress("0x420000000000000000000000000000000000000F")
+
+const gasPriceOracleABI = `[{"inputs":[{"internalType":"bytes","name":"_data","type":"bytes"}],"name":"getL1Fee","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+// L1Oracle prices the L1 data cost a rollup charges an op for, in wei. calldata is the
+// handleOps calldata with the op alone, envelope the same calldata without any op: the
+// bytes every bundle posts whatever its ops.
+type L1Oracle interface {
+	L1Fee(ctx context.Context, calldata, envelope []byte) (*big.Int, error)
+}
+
+// NewL1Oracle returns the oracle of the configured rollup type, or nil for chains without an L1 data fee.
+func NewL1Oracle(cfg config.PVGConfig, client *ethclient.Client) (L1Oracle, error) {
+	switch strings.ToLower(cfg.L1Oracle) {
+	case "":
+		return nil, nil
+	case "optimism":
+		return NewOptimismOracle(client, cfg.GasPriceOracle)
+	case "zkevm":
+		if cfg.L1RPCURL == "" {
+			return nil, fmt.Errorf("l1 oracle zkevm requires l1_rpc_url")
+		}
+		l1, err := ethclient.Dial(cfg.L1RPCURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial L1 RPC: %w", err)
+		}
+		bundleSize := cfg.ExpectedBundleSize
+		if bundleSize == 0 {
+			bundleSize = DEFAULT_EXPECTED_BUNDLE_SIZE
+		}
+		return &ZkEvmOracle{L1: l1, ExpectedBundleSize: bundleSize}, nil
+	}
+	return nil, fmt.Errorf("unknown l1 oracle: %s", cfg.L1Oracle)
+}
+
+// OptimismOracle asks the OP-stack GasPriceOracle predeploy for the L1 fee. The fee of
+// compressed data doesn't split into envelope and op, so the op pays for the whole calldata.
+type OptimismOracle struct {
+	client  *ethclient.Client
+	address common.Address
+	abi     abi.ABI
+}
+
+func NewOptimismOracle(client *ethclient.Client, address string) (*OptimismOracle, error) {
+	parsed, err := abi.JSON(strings.NewReader(gasPriceOracleABI))
+	if err != nil {
+		return nil, err
+	}
+
+	oracle := OPTIMISM_GAS_PRICE_ORACLE
+	if address != "" {
+		oracle = common.HexToAddress(address)
+	}
+
+	return &OptimismOracle{client: client, address: oracle, abi: parsed}, nil
+}
+
+func (o *OptimismOracle) L1Fee(ctx context.Context, calldata, envelope []byte) (*big.Int, error) {
+	data, err := o.abi.Pack("getL1Fee", calldata)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := o.client.CallContract(ctx, ethereum.CallMsg{To: &o.address, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getL1Fee failed: %w", err)
+	}
+
+	values, err := o.abi.Unpack("getL1Fee", out)
+	if err != nil || len(values) != 1 {
+		return nil, fmt.Errorf("invalid getL1Fee result: %x", out)
+	}
+	fee, ok := values[0].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("invalid getL1Fee result: %x", out)
+	}
+	return fee, nil
+}
+
+// ZkEvmOracle prices the calldata at the L1 gas price, which is what the zkEVM sequencer
+// has to recover when it posts the batch. Like the L2 fixed overhead, the envelope is split
+// over ExpectedBundleSize ops; the bytes the op adds are paid in full.
+type ZkEvmOracle struct {
+	L1                 *ethclient.Client
+	ExpectedBundleSize uint64
+}
+
+func (o *ZkEvmOracle) L1Fee(ctx context.Context, calldata, envelope []byte) (*big.Int, error) {
+	l1GasPrice, err := o.L1.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get L1 gas price: %w", err)
+	}
+	return new(big.Int).Mul(l1GasPrice, new(big.Int).SetUint64(o.calldataGas(calldata, envelope))), nil
+}
+
+// calldataGas is the op's share of the EIP-2028 gas of calldata.
+func (o *ZkEvmOracle) calldataGas(calldata, envelope []byte) uint64 {
+	envelopeGas := userop.CalldataGas(envelope)
+	return userop.CalldataGas(calldata) - envelopeGas + envelopeGas/max(o.ExpectedBundleSize, 1)
+}
@@ -0,0 +1,111 @@
+package validator
+
+import (
+	"context"
+	"eolia-bundlr/config"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	DEFAULT_FIXED_OVERHEAD_GAS   = 21000
+	DEFAULT_PER_USEROP_OVERHEAD  = 18300
+	DEFAULT_EXPECTED_BUNDLE_SIZE = 1
+)
+
+// PVGCalculator holds what the preVerificationGas of an op has to cover: its share of the
+// bundle transaction's fixed costs, the calldata it adds, and on rollups the L1 data fee.
+type PVGCalculator struct {
+	FixedOverhead      uint64
+	PerUserOpOverhead  uint64
+	ExpectedBundleSize uint64
+	L1                 L1Oracle
+}
+
+func NewPVGCalculator(cfg config.PVGConfig, client *ethclient.Client) (*PVGCalculator, error) {
+	l1, err := NewL1Oracle(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PVGCalculator{
+		FixedOverhead:      cfg.FixedOverhead,
+		PerUserOpOverhead:  cfg.PerUserOpOverhead,
+		ExpectedBundleSize: cfg.ExpectedBundleSize,
+		L1:                 l1,
+	}
+	if p.FixedOverhead == 0 {
+		p.FixedOverhead = DEFAULT_FIXED_OVERHEAD_GAS
+	}
+	if p.PerUserOpOverhead == 0 {
+		p.PerUserOpOverhead = DEFAULT_PER_USEROP_OVERHEAD
+	}
+	if p.ExpectedBundleSize == 0 {
+		p.ExpectedBundleSize = DEFAULT_EXPECTED_BUNDLE_SIZE
+	}
+	return p, nil
+}
+
+// RequiredPreVerificationGas returns the minimum preVerificationGas for op.
+func (v *Validator) RequiredPreVerificationGas(ctx context.Context, op *types.PackedUserOperation) (*big.Int, error) {
+	p := v.PVG
+
+	empty, err := v.EntryPointABI.Pack("handleOps", []types.PackedUserOperation{}, v.Bundlr)
+	if err != nil {
+		return nil, fmt.Errorf("pack for size failed: %w", err)
+	}
+	single, err := v.EntryPointABI.Pack("handleOps", []types.PackedUserOperation{*op}, v.Bundlr)
+	if err != nil {
+		return nil, fmt.Errorf("pack for size failed: %w", err)
+	}
+
+	// What the bundle costs whatever its ops is split over the expected bundle size;
+	// the bytes the op adds to handleOps are paid in full.
+	bundleGas := p.FixedOverhead + userop.CalldataGas(empty)
+	opCalldataGas := userop.CalldataGas(single) - userop.CalldataGas(empty)
+	required := new(big.Int).SetUint64(bundleGas/p.ExpectedBundleSize + p.PerUserOpOverhead + opCalldataGas)
+
+	if p.L1 == nil {
+		return required, nil
+	}
+
+	l1Fee, err := p.L1.L1Fee(ctx, single, empty)
+	if err != nil {
+		return nil, err
+	}
+
+	// The L1 fee is charged in wei; convert it to L2 gas at the price the op will pay.
+	gasPrice, err := v.effectiveGasPrice(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	if gasPrice.Sign() == 0 {
+		return nil, fmt.Errorf("cannot price the L1 data fee at a zero gas price")
+	}
+
+	l1Gas := new(big.Int).Add(l1Fee, new(big.Int).Sub(gasPrice, big.NewInt(1)))
+	l1Gas.Div(l1Gas, gasPrice)
+	return required.Add(required, l1Gas), nil
+}
+
+// effectiveGasPrice is min(maxFeePerGas, baseFee + maxPriorityFeePerGas).
+func (v *Validator) effectiveGasPrice(ctx context.Context, op *types.PackedUserOperation) (*big.Int, error) {
+	maxPriorityFee, maxFee := userop.UnpackGasFees(op.GasFees)
+	if v.Fees == nil {
+		return maxFee, nil
+	}
+
+	baseFee, _, err := v.Fees.Fees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	price := baseFee.Add(baseFee, maxPriorityFee)
+	if price.Cmp(maxFee) > 0 {
+		return maxFee, nil
+	}
+	return price, nil
+}
@@ -0,0 +1,171 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"eolia-bundlr/config"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// fixedL1Oracle charges fee for any calldata.
+type fixedL1Oracle struct {
+	fee *big.Int
+}
+
+func (o fixedL1Oracle) L1Fee(ctx context.Context, calldata, envelope []byte) (*big.Int, error) {
+	return new(big.Int).Set(o.fee), nil
+}
+
+func TestRequiredPreVerificationGas(t *testing.T) {
+	v := newTestValidator(t, fakeNode{}, config.ValidationConfig{})
+
+	empty, err := v.EntryPointABI.Pack("handleOps", []types.PackedUserOperation{}, v.Bundlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundleGas := DEFAULT_FIXED_OVERHEAD_GAS + userop.CalldataGas(empty)
+
+	required := func(bundleSize uint64, callData []byte) uint64 {
+		t.Helper()
+		v.PVG = &PVGCalculator{FixedOverhead: DEFAULT_FIXED_OVERHEAD_GAS, PerUserOpOverhead: DEFAULT_PER_USEROP_OVERHEAD, ExpectedBundleSize: bundleSize}
+		op := validOp(t)
+		op.CallData = callData
+		pvg, err := v.RequiredPreVerificationGas(t.Context(), op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pvg.Uint64()
+	}
+
+	zeros := make([]byte, 100)
+	nonZeros := make([]byte, 100)
+	for i := range nonZeros {
+		nonZeros[i] = 0xff
+	}
+
+	tests := []struct {
+		name string
+		// Both ops are priced with each bundle size; want is required(b) - required(a).
+		a, b         []byte
+		sizeA, sizeB uint64
+		want         uint64
+	}{
+		{"non-zero calldata bytes cost 16 gas, zero bytes 4", zeros, nonZeros, 1, 1, 100 * (userop.NON_ZERO_BYTE_GAS - userop.ZERO_BYTE_GAS)},
+		{"the op's own calldata is not amortized", zeros, nonZeros, 4, 4, 100 * (userop.NON_ZERO_BYTE_GAS - userop.ZERO_BYTE_GAS)},
+		{"the bundle overhead is split over the bundle size", zeros, zeros, 4, 1, bundleGas - bundleGas/4},
+		{"a larger expected bundle lowers the share further", zeros, zeros, 10, 4, bundleGas/4 - bundleGas/10},
+	}
+	for _, tt := range tests {
+		a, b := required(tt.sizeA, tt.a), required(tt.sizeB, tt.b)
+		if b-a != tt.want {
+			t.Errorf("%s: required PVG went from %d to %d, want a difference of %d", tt.name, a, b, tt.want)
+		}
+	}
+
+	// One op alone pays the whole bundle transaction plus its own overhead.
+	op := validOp(t)
+	single, err := v.EntryPointABI.Pack("handleOps", []types.PackedUserOperation{*op}, v.Bundlr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uint64(DEFAULT_FIXED_OVERHEAD_GAS + DEFAULT_PER_USEROP_OVERHEAD + userop.CalldataGas(single))
+	if got := required(1, op.CallData); got != want {
+		t.Errorf("required PVG of a bundle of one = %d, want %d", got, want)
+	}
+}
+
+func TestRequiredPreVerificationGasL1Fee(t *testing.T) {
+	v := newTestValidator(t, fakeNode{}, config.ValidationConfig{})
+	v.PVG = &PVGCalculator{FixedOverhead: DEFAULT_FIXED_OVERHEAD_GAS, PerUserOpOverhead: DEFAULT_PER_USEROP_OVERHEAD, ExpectedBundleSize: 1}
+
+	// Without a fee oracle an op's gas price is its maxFeePerGas.
+	price := new(big.Int).Mul(gwei, big.NewInt(2))
+	tests := []struct {
+		name    string
+		l1Fee   *big.Int
+		l1Gas   int64
+		maxFee  *big.Int
+		wantErr bool
+	}{
+		{name: "no L1 fee", l1Fee: big.NewInt(0), l1Gas: 0, maxFee: price},
+		{name: "whole gas", l1Fee: new(big.Int).Mul(price, big.NewInt(10)), l1Gas: 10, maxFee: price},
+		{name: "rounded up", l1Fee: new(big.Int).Add(new(big.Int).Mul(price, big.NewInt(10)), big.NewInt(1)), l1Gas: 11, maxFee: price},
+		{name: "zero gas price", l1Fee: big.NewInt(1), maxFee: big.NewInt(0), wantErr: true},
+	}
+	for _, tt := range tests {
+		op := validOp(t)
+		setFees(t, op, big.NewInt(0), tt.maxFee)
+
+		v.PVG.L1 = nil
+		l2Only, err := v.RequiredPreVerificationGas(t.Context(), op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v.PVG.L1 = fixedL1Oracle{fee: tt.l1Fee}
+		got, err := v.RequiredPreVerificationGas(t.Context(), op)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: RequiredPreVerificationGas = %s, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: RequiredPreVerificationGas: %v", tt.name, err)
+			continue
+		}
+		if l1Gas := new(big.Int).Sub(got, l2Only).Int64(); l1Gas != tt.l1Gas {
+			t.Errorf("%s: L1 fee of %s wei at %s wei/gas added %d gas, want %d", tt.name, tt.l1Fee, tt.maxFee, l1Gas, tt.l1Gas)
+		}
+	}
+}
+
+func TestZkEvmOracleCalldataGas(t *testing.T) {
+	// 4 non-zero envelope bytes (64 gas) and 2 zero bytes added by the op (8 gas).
+	envelope := []byte{1, 2, 3, 4}
+	calldata := append(append([]byte{}, envelope...), 0, 0)
+
+	tests := []struct {
+		bundleSize uint64
+		want       uint64
+	}{
+		{0, 72},
+		{1, 72},
+		{3, 8 + 64/3},
+		{4, 8 + 16},
+		{100, 8},
+	}
+	for _, tt := range tests {
+		o := &ZkEvmOracle{ExpectedBundleSize: tt.bundleSize}
+		if got := o.calldataGas(calldata, envelope); got != tt.want {
+			t.Errorf("calldataGas over %d ops = %d, want %d", tt.bundleSize, got, tt.want)
+		}
+	}
+}
+
+func TestZkEvmOracleL1Fee(t *testing.T) {
+	server := httptest.NewServer(fakeNode{
+		"eth_gasPrice": func(params []json.RawMessage) any { return "0x3e8" },
+	})
+	t.Cleanup(server.Close)
+	l1, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l1.Close)
+
+	o := &ZkEvmOracle{L1: l1, ExpectedBundleSize: 4}
+	fee, err := o.L1Fee(t.Context(), []byte{1, 2, 3, 4, 0, 0}, []byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Int64() != 1000*24 {
+		t.Errorf("L1Fee = %s, want %d", fee, 1000*24)
+	}
+}
@@ -0,0 +1,95 @@
+package validator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// FailedOpError is the decoded form of the EntryPoint's FailedOp and
+// FailedOpWithRevert errors. OpIndex points into the ops array that was
+// passed to handleOps.
+type FailedOpError struct {
+	OpIndex int
+	Reason  string
+	Inner   []byte
+}
+
+func (e *FailedOpError) Error() string {
+	if len(e.Inner) > 0 {
+		return fmt.Sprintf("FailedOpWithRevert(%d, %q, %s)", e.OpIndex, e.Reason, hexutil.Encode(e.Inner))
+	}
+	return fmt.Sprintf("FailedOp(%d, %q)", e.OpIndex, e.Reason)
+}
+
+// revertData pulls the raw revert bytes out of an eth_call error, if the node returned any.
+func revertData(err error) []byte {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil
+	}
+
+	str, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil
+	}
+
+	data, decErr := hexutil.Decode(str)
+	if decErr != nil {
+		return nil
+	}
+	return data
+}
+
+// decodeFailedOp tries to decode a FailedOp / FailedOpWithRevert revert out of an eth_call error.
+// It returns nil when the revert is something else.
+func (v *Validator) decodeFailedOp(err error) *FailedOpError {
+	data := revertData(err)
+	if len(data) < 4 {
+		return nil
+	}
+
+	for _, name := range []string{"FailedOp", "FailedOpWithRevert"} {
+		abiErr, ok := v.EntryPointABI.Errors[name]
+		if !ok || !bytes.Equal(data[:4], abiErr.ID[:4]) {
+			continue
+		}
+
+		values, unpackErr := abiErr.Inputs.Unpack(data[4:])
+		if unpackErr != nil || len(values) < 2 {
+			return nil
+		}
+
+		opIndex, _ := values[0].(*big.Int)
+		reason, _ := values[1].(string)
+		if opIndex == nil || !opIndex.IsInt64() {
+			return nil
+		}
+
+		failed := &FailedOpError{OpIndex: int(opIndex.Int64()), Reason: reason}
+		if len(values) > 2 {
+			failed.Inner, _ = values[2].([]byte)
+		}
+		return failed
+	}
+
+	return nil
+}
+
+// EntityCode returns the "AAxx" prefix group of the failure reason (1 = factory, 2 = account, 3 = paymaster),
+// or 0 when the reason doesn't follow the EntryPoint's error code convention.
+func (e *FailedOpError) EntityCode() int {
+	if !strings.HasPrefix(e.Reason, "AA") || len(e.Reason) < 3 {
+		return 0
+	}
+	c := e.Reason[2]
+	if c < '0' || c > '9' {
+		return 0
+	}
+	return int(c - '0')
+}
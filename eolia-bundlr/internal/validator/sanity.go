@@ -0,0 +1,131 @@
+package validator
+
+import (
+	"context"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Error codes returned to RPC clients when an op fails the sanity checks, specific to this
+// bundler. Malformed requests other than the op itself stay the JSON-RPC "invalid params" -32602.
+const (
+	ERR_FIELD_TOO_WIDE             = -32610
+	ERR_OP_TOO_LARGE               = -32611
+	ERR_ZERO_SENDER                = -32612
+	ERR_ZERO_FEE                   = -32613
+	ERR_PRIORITY_FEE_ABOVE_MAX     = -32614
+	ERR_PRIORITY_FEE_TOO_LOW       = -32615
+	ERR_FACTORY_NOT_ALLOWED        = -32616
+	ERR_SENDER_NOT_DEPLOYED        = -32617
+	ERR_INVALID_INIT_CODE          = -32618
+	ERR_SENDER_MISMATCH            = -32619
+	ERR_MAX_FEE_TOO_LOW            = -32620
+	ERR_PVG_TOO_LOW                = -32621
+	ERR_INSUFFICIENT_TOKEN_BALANCE = -32622
+	ERR_INVALID_FORMAT             = -32623
+)
+
+const DEFAULT_MAX_OP_SIZE = 32 * 1024
+
+// ValidationError is a rejected op, with the RPC error code to report it under.
+type ValidationError struct {
+	Code    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func validationError(code int, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// OpSize is the number of bytes an op adds to handleOps calldata, not counting ABI padding.
+func OpSize(op *types.PackedUserOperation) int {
+	// sender, nonce, accountGasLimits, preVerificationGas, gasFees
+	const fixed = common.AddressLength + 4*32
+	return fixed + len(op.InitCode) + len(op.CallData) + len(op.PaymasterAndData) + len(op.Signature)
+}
+
+// ValidateUserOp runs the cheap checks on an op before it is simulated.
+func (v *Validator) ValidateUserOp(ctx context.Context, op *types.PackedUserOperation) error {
+	if op.Sender == (common.Address{}) {
+		return validationError(ERR_ZERO_SENDER, "sender is the zero address")
+	}
+
+	// The nonce can't be wider than uint256: the RPC decoding already rejects it.
+	if op.Nonce == nil {
+		return validationError(ERR_INVALID_FORMAT, "missing nonce")
+	}
+	if op.PreVerificationGas == nil || op.PreVerificationGas.Sign() < 0 || op.PreVerificationGas.BitLen() > 128 {
+		return validationError(ERR_FIELD_TOO_WIDE, "preVerificationGas does not fit in uint128")
+	}
+
+	if size := OpSize(op); size > v.MaxOpSize {
+		return validationError(ERR_OP_TOO_LARGE, "op size %d exceeds the maximum of %d bytes", size, v.MaxOpSize)
+	}
+
+	maxPriorityFee, maxFee := userop.UnpackGasFees(op.GasFees)
+	if maxFee.Sign() == 0 {
+		return validationError(ERR_ZERO_FEE, "maxFeePerGas is zero")
+	}
+	if maxPriorityFee.Cmp(maxFee) > 0 {
+		return validationError(ERR_PRIORITY_FEE_ABOVE_MAX, "maxPriorityFeePerGas %s is above maxFeePerGas %s", maxPriorityFee, maxFee)
+	}
+	if maxPriorityFee.Cmp(v.MinPriorityFee) < 0 {
+		return validationError(ERR_PRIORITY_FEE_TOO_LOW, "maxPriorityFeePerGas %s is below the minimum of %s", maxPriorityFee, v.MinPriorityFee)
+	}
+	if v.Fees != nil {
+		minMaxFee, err := v.Fees.MinMaxFeePerGas(ctx)
+		if err != nil {
+			return err
+		}
+		if maxFee.Cmp(minMaxFee) < 0 {
+			return validationError(ERR_MAX_FEE_TOO_LOW, "maxFeePerGas %s is below the required %s", maxFee, minMaxFee)
+		}
+	}
+
+	if err := v.validateTokenPayment(ctx, op); err != nil {
+		return err
+	}
+
+	if len(op.InitCode) > 0 {
+		if userop.IsEip7702InitCode(op.InitCode) {
+			return nil
+		}
+		if len(op.InitCode) < common.AddressLength {
+			return validationError(ERR_INVALID_FORMAT, "initCode is shorter than a factory address")
+		}
+		factory := common.BytesToAddress(op.InitCode[:common.AddressLength])
+		if !v.factoryAllowed(factory) {
+			return validationError(ERR_FACTORY_NOT_ALLOWED, "factory %s is not allowed", factory.Hex())
+		}
+		return v.validateSender(ctx, op.Sender, op.InitCode)
+	}
+
+	code, err := v.Client.CodeAt(ctx, op.Sender, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get sender code: %w", err)
+	}
+	if len(code) == 0 {
+		return validationError(ERR_SENDER_NOT_DEPLOYED, "sender %s has no code and the op has no initCode", op.Sender.Hex())
+	}
+
+	return nil
+}
+
+// factoryAllowed accepts the configured factory and the allowlist. With neither set, any factory is accepted.
+func (v *Validator) factoryAllowed(factory common.Address) bool {
+	if v.Factory == (common.Address{}) && len(v.Factories) == 0 {
+		return true
+	}
+	if factory == v.Factory {
+		return true
+	}
+	_, ok := v.Factories[factory]
+	return ok
+}
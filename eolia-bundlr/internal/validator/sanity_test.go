@@ -0,0 +1,337 @@
+package validator
+
+import (
+	"encoding/json"
+	"eolia-bundlr/config"
+	"eolia-bundlr/internal/fees"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	testEntryPoint     = common.HexToAddress("0x379FF91b96c038ECb0dc6aCFb44366a39f0de566")
+	testBundlr         = common.HexToAddress("0x00000000000000000000000000000000000000b0")
+	testFactory        = common.HexToAddress("0x00000000000000000000000000000000000000fa")
+	testTokenPaymaster = common.HexToAddress("0x00000000000000000000000000000000000000fb")
+	testToken          = common.HexToAddress("0x00000000000000000000000000000000000000fc")
+
+	deployedSender = common.HexToAddress("0x0000000000000000000000000000000000000a01")
+	newSender      = common.HexToAddress("0x0000000000000000000000000000000000000a02")
+	poorSender     = common.HexToAddress("0x0000000000000000000000000000000000000a03")
+
+	gwei = big.NewInt(1e9)
+)
+
+// revertWith makes a fakeNode method revert the call with data.
+type revertWith []byte
+
+// fakeNode answers JSON-RPC requests with methods[method](params). A revertWith result
+// is sent as an execution reverted error.
+type fakeNode map[string]func(params []json.RawMessage) any
+
+func (n fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	method, ok := n[req.Method]
+	if !ok {
+		resp["error"] = map[string]any{"code": -32601, "message": "method not found: " + req.Method}
+	} else if result := method(req.Params); result != nil {
+		if data, ok := result.(revertWith); ok {
+			resp["error"] = map[string]any{"code": 3, "message": "execution reverted", "data": hexutil.Encode(data)}
+		} else {
+			resp["result"] = result
+		}
+	} else {
+		resp["result"] = nil
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// callArgs decodes the call object of an eth_call.
+func callArgs(params []json.RawMessage) (common.Address, []byte) {
+	var call struct {
+		To    common.Address `json:"to"`
+		Input hexutil.Bytes  `json:"input"`
+		Data  hexutil.Bytes  `json:"data"`
+	}
+	json.Unmarshal(params[0], &call)
+	if call.Input == nil {
+		return call.To, call.Data
+	}
+	return call.To, call.Input
+}
+
+// newTestValidator builds a Validator the way NewValidator does, against node.
+func newTestValidator(t *testing.T, node http.Handler, limits config.ValidationConfig) *Validator {
+	t.Helper()
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	// NewValidator reads the ABIs relative to the module root.
+	t.Chdir("../..")
+	v, err := NewValidator(client, testEntryPoint, testBundlr, testFactory, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// sanityNode is a chain where deployedSender has code, initCode deploys the address in its
+// factory data (0xff reverts), poorSender holds only 100 token units and the base fee is 1 gwei.
+func sanityNode(t *testing.T) fakeNode {
+	entryPointABI := loadABI(t, "entrypoint/entrypoint.abi.json")
+	tokenABI := loadABI(t, "tokenpaymaster/tokenpaymaster.abi.json")
+
+	return fakeNode{
+		"eth_getCode": func(params []json.RawMessage) any {
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == deployedSender {
+				return "0x6000"
+			}
+			return "0x"
+		},
+		"eth_feeHistory": func(params []json.RawMessage) any {
+			return map[string]any{
+				"oldestBlock":   "0x1",
+				"baseFeePerGas": []string{"0x3b9aca00", "0x3b9aca00"},
+				"gasUsedRatio":  []float64{0.5},
+				"reward":        [][]string{{"0x5f5e100"}},
+			}
+		},
+		"eth_call": func(params []json.RawMessage) any {
+			to, input := callArgs(params)
+			switch to {
+			case testEntryPoint:
+				values, err := entryPointABI.Methods["getSenderAddress"].Inputs.Unpack(input[4:])
+				if err != nil {
+					t.Errorf("unexpected EntryPoint call: %x", input)
+					return nil
+				}
+				initCode := values[0].([]byte)
+				if len(initCode) == 21 && initCode[20] == 0xff {
+					return revertWith(common.FromHex("0x08c379a0"))
+				}
+				result := entryPointABI.Errors["SenderAddressResult"]
+				packed, _ := result.Inputs.Pack(common.BytesToAddress(initCode[20:]))
+				return revertWith(append(result.ID[:4:4], packed...))
+			case testToken:
+				values, _ := tokenABI.Methods["balanceOf"].Inputs.Unpack(input[4:])
+				balance := new(big.Int).Lsh(big.NewInt(1), 200)
+				if values[0].(common.Address) == poorSender {
+					balance = big.NewInt(100)
+				}
+				return hexutil.Encode(common.BigToHash(balance).Bytes())
+			}
+			t.Errorf("unexpected call to %s", to.Hex())
+			return nil
+		},
+	}
+}
+
+// loadABI reads an ABI next to the package sources.
+func loadABI(t *testing.T, path string) *abi.ABI {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &parsed
+}
+
+// validOp passes every sanity check on sanityNode.
+func validOp(t *testing.T) *types.PackedUserOperation {
+	t.Helper()
+	accountGasLimits, err := userop.PackAccountGasLimits(big.NewInt(100_000), big.NewInt(100_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasFees, err := userop.PackGasFees(gwei, new(big.Int).Mul(gwei, big.NewInt(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.PackedUserOperation{
+		Sender:             deployedSender,
+		Nonce:              big.NewInt(0),
+		InitCode:           []byte{},
+		CallData:           []byte{},
+		AccountGasLimits:   accountGasLimits,
+		PreVerificationGas: big.NewInt(100_000),
+		GasFees:            gasFees,
+		PaymasterAndData:   []byte{},
+		Signature:          make([]byte, 65),
+	}
+}
+
+func setFees(t *testing.T, op *types.PackedUserOperation, priorityFee, maxFee *big.Int) {
+	t.Helper()
+	gasFees, err := userop.PackGasFees(priorityFee, maxFee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.GasFees = gasFees
+}
+
+// tokenPaymasterAndData pays through testTokenPaymaster in testToken at one token unit per wei.
+func tokenPaymasterAndData(t *testing.T) []byte {
+	t.Helper()
+	data := make([]byte, TOKEN_PAYMASTER_DATA_LENGTH)
+	copy(data[12:32], testToken.Bytes())
+	big.NewInt(1e18).FillBytes(data[32:64])
+	packed, err := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     testTokenPaymaster,
+		PaymasterVerificationGasLimit: big.NewInt(50_000),
+		PaymasterPostOpGasLimit:       big.NewInt(50_000),
+		PaymasterData:                 data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func TestValidateUserOp(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(op *types.PackedUserOperation)
+		code   int
+	}{
+		{"valid deployed account", func(op *types.PackedUserOperation) {}, 0},
+		{"valid initCode", func(op *types.PackedUserOperation) {
+			op.Sender = newSender
+			op.InitCode = append(testFactory.Bytes(), newSender.Bytes()...)
+		}, 0},
+		{"valid token payment", func(op *types.PackedUserOperation) { op.PaymasterAndData = tokenPaymasterAndData(t) }, 0},
+		{"zero sender", func(op *types.PackedUserOperation) { op.Sender = common.Address{} }, ERR_ZERO_SENDER},
+		{"missing nonce", func(op *types.PackedUserOperation) { op.Nonce = nil }, ERR_INVALID_FORMAT},
+		{"preVerificationGas over uint128", func(op *types.PackedUserOperation) {
+			op.PreVerificationGas = new(big.Int).Lsh(big.NewInt(1), 128)
+		}, ERR_FIELD_TOO_WIDE},
+		{"op too large", func(op *types.PackedUserOperation) { op.CallData = make([]byte, DEFAULT_MAX_OP_SIZE) }, ERR_OP_TOO_LARGE},
+		{"zero maxFeePerGas", func(op *types.PackedUserOperation) { setFees(t, op, big.NewInt(0), big.NewInt(0)) }, ERR_ZERO_FEE},
+		{"priority fee above max fee", func(op *types.PackedUserOperation) {
+			setFees(t, op, new(big.Int).Mul(gwei, big.NewInt(3)), new(big.Int).Mul(gwei, big.NewInt(2)))
+		}, ERR_PRIORITY_FEE_ABOVE_MAX},
+		{"priority fee below the minimum", func(op *types.PackedUserOperation) {
+			setFees(t, op, big.NewInt(99), new(big.Int).Mul(gwei, big.NewInt(2)))
+		}, ERR_PRIORITY_FEE_TOO_LOW},
+		{"max fee below the base fee", func(op *types.PackedUserOperation) { setFees(t, op, big.NewInt(100), big.NewInt(999_999_999)) }, ERR_MAX_FEE_TOO_LOW},
+		{"malformed token paymaster data", func(op *types.PackedUserOperation) {
+			op.PaymasterAndData = tokenPaymasterAndData(t)[:100]
+		}, ERR_INVALID_FORMAT},
+		{"zero token exchange rate", func(op *types.PackedUserOperation) {
+			op.PaymasterAndData = tokenPaymasterAndData(t)
+			clear(op.PaymasterAndData[userop.PAYMASTER_DATA_OFFSET+32 : userop.PAYMASTER_DATA_OFFSET+64])
+		}, ERR_INVALID_FORMAT},
+		{"insufficient token balance", func(op *types.PackedUserOperation) {
+			op.Sender = poorSender
+			op.InitCode = append(testFactory.Bytes(), poorSender.Bytes()...)
+			op.PaymasterAndData = tokenPaymasterAndData(t)
+		}, ERR_INSUFFICIENT_TOKEN_BALANCE},
+		{"initCode shorter than an address", func(op *types.PackedUserOperation) { op.InitCode = testFactory.Bytes()[:19] }, ERR_INVALID_FORMAT},
+		{"factory not allowed", func(op *types.PackedUserOperation) {
+			op.InitCode = append(common.HexToAddress("0xbad").Bytes(), deployedSender.Bytes()...)
+		}, ERR_FACTORY_NOT_ALLOWED},
+		{"account not deployed", func(op *types.PackedUserOperation) { op.Sender = newSender }, ERR_SENDER_NOT_DEPLOYED},
+		{"initCode reverts", func(op *types.PackedUserOperation) {
+			op.Sender = newSender
+			op.InitCode = append(testFactory.Bytes(), 0xff)
+		}, ERR_INVALID_INIT_CODE},
+		{"initCode deploys another account", func(op *types.PackedUserOperation) {
+			op.Sender = newSender
+			op.InitCode = append(testFactory.Bytes(), deployedSender.Bytes()...)
+		}, ERR_SENDER_MISMATCH},
+	}
+
+	v := newTestValidator(t, sanityNode(t), config.ValidationConfig{
+		MinPriorityFee:  100,
+		TokenPaymasters: []string{testTokenPaymaster.Hex()},
+	})
+	v.Fees = fees.NewOracle(v.Client, config.FeeConfig{})
+
+	for _, tt := range tests {
+		op := validOp(t)
+		tt.mutate(op)
+
+		err := v.ValidateUserOp(t.Context(), op)
+		if tt.code == 0 {
+			if err != nil {
+				t.Errorf("%s: ValidateUserOp = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Code != tt.code {
+			t.Errorf("%s: ValidateUserOp = %v, want code %d", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestValidatePreVerificationGas(t *testing.T) {
+	v := newTestValidator(t, fakeNode{}, config.ValidationConfig{})
+	v.PVG = &PVGCalculator{FixedOverhead: DEFAULT_FIXED_OVERHEAD_GAS, PerUserOpOverhead: DEFAULT_PER_USEROP_OVERHEAD, ExpectedBundleSize: 1}
+
+	// The requirement depends on the bytes of the preVerificationGas itself: settle on the
+	// value that exactly covers it.
+	op := validOp(t)
+	for {
+		required, err := v.RequiredPreVerificationGas(t.Context(), op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if required.Cmp(op.PreVerificationGas) == 0 {
+			break
+		}
+		op.PreVerificationGas = required
+	}
+	required := op.PreVerificationGas
+
+	tests := []struct {
+		pvg  *big.Int
+		code int
+	}{
+		{required, 0},
+		{new(big.Int).Add(required, big.NewInt(1)), 0},
+		{new(big.Int).Sub(required, big.NewInt(1)), ERR_PVG_TOO_LOW},
+		{big.NewInt(0), ERR_PVG_TOO_LOW},
+	}
+	for _, tt := range tests {
+		op.PreVerificationGas = tt.pvg
+		err := v.ValidatePreVerificationGas(t.Context(), op)
+		var validationErr *ValidationError
+		if tt.code == 0 && err != nil || tt.code != 0 && (!errors.As(err, &validationErr) || validationErr.Code != tt.code) {
+			t.Errorf("ValidatePreVerificationGas with %s (required %s) = %v, want code %d", tt.pvg, required, err, tt.code)
+		}
+	}
+}
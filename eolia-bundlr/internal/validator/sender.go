@@ -0,0 +1,55 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SenderAddress asks the EntryPoint which account initCode deploys. getSenderAddress always
+// reverts, returning the address in a SenderAddressResult error.
+func (v *Validator) SenderAddress(ctx context.Context, initCode []byte) (common.Address, error) {
+	calldata, err := v.EntryPointABI.Pack("getSenderAddress", initCode)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("abi.Pack failed: %w", err)
+	}
+
+	_, err = v.Client.CallContract(ctx, ethereum.CallMsg{
+		To:   &v.EntryPoint,
+		Data: calldata,
+	}, nil)
+	if err == nil {
+		return common.Address{}, fmt.Errorf("getSenderAddress did not revert")
+	}
+
+	data := revertData(err)
+	abiErr := v.EntryPointABI.Errors["SenderAddressResult"]
+	if len(data) < 4 || !bytes.Equal(data[:4], abiErr.ID[:4]) {
+		return common.Address{}, fmt.Errorf("getSenderAddress failed: %w", err)
+	}
+
+	values, err := abiErr.Inputs.Unpack(data[4:])
+	if err != nil || len(values) != 1 {
+		return common.Address{}, fmt.Errorf("invalid SenderAddressResult: %x", data)
+	}
+	sender, ok := values[0].(common.Address)
+	if !ok {
+		return common.Address{}, fmt.Errorf("invalid SenderAddressResult: %x", data)
+	}
+	return sender, nil
+}
+
+// validateSender checks that the account initCode deploys is the op's sender.
+func (v *Validator) validateSender(ctx context.Context, sender common.Address, initCode []byte) error {
+	computed, err := v.SenderAddress(ctx, initCode)
+	if err != nil {
+		return validationError(ERR_INVALID_INIT_CODE, "initCode does not resolve to a sender: %v", err)
+	}
+	if computed != sender {
+		return validationError(ERR_SENDER_MISMATCH, "sender mismatch: initCode creates %s, op declares %s", computed.Hex(), sender.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package validator
+
+import (
+	"context"
+	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TokenPaymaster data is abi.encode(address token, uint256 exchangeRate, uint48 validUntil,
+// uint48 validAfter) followed by a 65-byte signature.
+const TOKEN_PAYMASTER_DATA_LENGTH = 4*32 + 65
+
+// Exchange rates are token units worth 1e18 wei.
+var tokenRateDenominator = big.NewInt(1e18)
+
+// TokenPaymasterData is the paymaster data of an op paying for gas through a TokenPaymaster.
+type TokenPaymasterData struct {
+	Token        common.Address
+	ExchangeRate *big.Int
+	ValidUntil   uint64
+	ValidAfter   uint64
+	Signature    []byte
+}
+
+// ParseTokenPaymasterData decodes the part of paymasterAndData after the paymaster gas limits.
+func ParseTokenPaymasterData(data []byte) (*TokenPaymasterData, error) {
+	if len(data) != TOKEN_PAYMASTER_DATA_LENGTH {
+		return nil, fmt.Errorf("token paymaster data is %d bytes, expected %d", len(data), TOKEN_PAYMASTER_DATA_LENGTH)
+	}
+	for _, word := range [][]byte{data[:12], data[64:90], data[96:122]} {
+		for _, b := range word {
+			if b != 0 {
+				return nil, fmt.Errorf("token paymaster data is not abi-encoded")
+			}
+		}
+	}
+	return &TokenPaymasterData{
+		Token:        common.BytesToAddress(data[12:32]),
+		ExchangeRate: new(big.Int).SetBytes(data[32:64]),
+		ValidUntil:   new(big.Int).SetBytes(data[90:96]).Uint64(),
+		ValidAfter:   new(big.Int).SetBytes(data[122:128]).Uint64(),
+		Signature:    data[128:],
+	}, nil
+}
+
+// MaxTokenCost is the most the TokenPaymaster can charge op: its gas limits at
+// maxFeePerGas, converted at the exchange rate and rounded up.
+func MaxTokenCost(op *types.PackedUserOperation, exchangeRate *big.Int) *big.Int {
+	_, maxFeePerGas := userop.UnpackGasFees(op.GasFees)
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(op.TotalGasLimit()), maxFeePerGas)
+	cost.Mul(cost, exchangeRate)
+	cost.Add(cost, new(big.Int).Sub(tokenRateDenominator, big.NewInt(1)))
+	return cost.Div(cost, tokenRateDenominator)
+}
+
+// validateTokenPayment checks that an op paying through a configured TokenPaymaster is
+// well-formed and that its sender holds the tokens it may be charged. The paymaster takes
+// that amount during validation, so simulation would fail anyway; checking first gives the
+// client a clearer error.
+func (v *Validator) validateTokenPayment(ctx context.Context, op *types.PackedUserOperation) error {
+	if len(v.TokenPaymasters) == 0 || len(op.PaymasterAndData) < common.AddressLength {
+		return nil
+	}
+	pm, err := userop.UnpackPaymasterAndData(op.PaymasterAndData)
+	if err != nil {
+		return validationError(ERR_INVALID_FORMAT, "%v", err)
+	}
+	if _, ok := v.TokenPaymasters[pm.Paymaster]; !ok {
+		return nil
+	}
+
+	data, err := ParseTokenPaymasterData(pm.PaymasterData)
+	if err != nil {
+		return validationError(ERR_INVALID_FORMAT, "%v", err)
+	}
+	if data.ExchangeRate.Sign() == 0 {
+		return validationError(ERR_INVALID_FORMAT, "token paymaster exchange rate is zero")
+	}
+
+	balance, err := v.tokenBalance(ctx, data.Token, op.Sender)
+	if err != nil {
+		return fmt.Errorf("failed to get token balance: %w", err)
+	}
+	if cost := MaxTokenCost(op, data.ExchangeRate); balance.Cmp(cost) < 0 {
+		return validationError(ERR_INSUFFICIENT_TOKEN_BALANCE, "sender holds %s of token %s, op may be charged %s", balance, data.Token.Hex(), cost)
+	}
+	return nil
+}
+
+func (v *Validator) tokenBalance(ctx context.Context, token, account common.Address) (*big.Int, error) {
+	calldata, err := v.TokenPaymasterABI.Pack("balanceOf", account)
+	if err != nil {
+		return nil, fmt.Errorf("abi.Pack failed: %w", err)
+	}
+	out, err := v.Client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: calldata}, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := v.TokenPaymasterABI.Unpack("balanceOf", out)
+	if err != nil || len(values) != 1 {
+		return nil, fmt.Errorf("invalid balanceOf result: %x", out)
+	}
+	balance, ok := values[0].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("invalid balanceOf result: %x", out)
+	}
+	return balance, nil
+}
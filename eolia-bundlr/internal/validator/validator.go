@@ -3,8 +3,12 @@ package validator
 import (
 	"bytes"
 	"context"
+	"eolia-bundlr/config"
+	"eolia-bundlr/internal/fees"
 	"eolia-bundlr/internal/types"
+	"eolia-common/userop"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"os"
 	"strings"
@@ -15,90 +19,117 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
-const (
-	FIXED_OVERHEAD_GAS   = 21000
-	PER_USEROP_OVERHEAD  = 18300
-	PER_WORD_OVERHEAD    = 6
-	EXPECTED_BUNDLE_SIZE = 1
-	TRANSACTION_STIPEND  = 2300
-)
-
 type Validator struct {
 	Client        *ethclient.Client
 	EntryPoint    common.Address
 	EntryPointABI *abi.ABI
 	Bundlr        common.Address
 	Factory       common.Address
-}
 
-func NewValidator(rpcURL string, entryAddr common.Address, bundlrAddr common.Address, factoryAddr common.Address) *Validator {
-	client, err := ethclient.Dial(rpcURL)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to dial RPC: %v\n", err)
-		return nil
-	}
+	// TokenPaymasters whose ops are checked for the sender's token balance.
+	TokenPaymasters   map[common.Address]struct{}
+	TokenPaymasterABI *abi.ABI
+
+	Factories      map[common.Address]struct{}
+	MaxOpSize      int
+	MinPriorityFee *big.Int
 
+	// Fees is the chain's fee oracle; ops below its minimum maxFeePerGas are rejected.
+	Fees *fees.Oracle
+	PVG  *PVGCalculator
+
+	// EntryPointSimulations, run in place of the EntryPoint to estimate gas. Nil when
+	// validation.entrypoint_simulations isn't set.
+	SimulationsABI  *abi.ABI
+	SimulationsCode []byte
+}
+
+func NewValidator(client *ethclient.Client, entryAddr common.Address, bundlrAddr common.Address, factoryAddr common.Address, limits config.ValidationConfig) (*Validator, error) {
 	abiData, err := os.ReadFile("internal/validator/entrypoint/entrypoint.abi.json")
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to read EntryPoint ABI: %w", err)
 	}
 
 	entryAbi, err := abi.JSON(strings.NewReader(string(abiData)))
 	if err != nil {
-		return nil
-	}
-
-	return &Validator{
-		Client:        client,
-		EntryPoint:    entryAddr,
-		EntryPointABI: &entryAbi,
-		Bundlr:        bundlrAddr,
-		Factory:       factoryAddr,
+		return nil, fmt.Errorf("failed to parse EntryPoint ABI: %w", err)
 	}
-}
 
-func extractRevertReason(err string) string {
-	start := strings.Index(err, "FailedOp")
-	if start == -1 {
-		return err
+	tokenAbiData, err := os.ReadFile("internal/validator/tokenpaymaster/tokenpaymaster.abi.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TokenPaymaster ABI: %w", err)
 	}
-	return err[start:]
-}
 
-func (v *Validator) AccountNeedsInitialization(op *types.PackedUserOperation) (*types.PackedUserOperation, error) {
-	byteCode, err := v.Client.CodeAt(context.Background(), op.Sender, nil)
+	tokenAbi, err := abi.JSON(strings.NewReader(string(tokenAbiData)))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse TokenPaymaster ABI: %w", err)
 	}
 
-	if len(byteCode) == 0 && string(byteCode) == "0x" {
-		abiJSON := `[{"inputs":[{"internalType":"address","name":"owner","type":"address"},{"internalType":"uint256","name":"salt","type":"uint256"}],"name":"createAccount","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
+	factories := make(map[common.Address]struct{})
+	for _, f := range limits.Factories {
+		if !common.IsHexAddress(f) {
+			return nil, fmt.Errorf("invalid factory address in validation config: %s", f)
+		}
+		factories[common.HexToAddress(f)] = struct{}{}
+	}
 
-		parsedAbi, err := abi.JSON(bytes.NewReader([]byte(abiJSON)))
-		if err != nil {
-			return nil, err
+	tokenPaymasters := make(map[common.Address]struct{})
+	for _, p := range limits.TokenPaymasters {
+		if !common.IsHexAddress(p) {
+			return nil, fmt.Errorf("invalid token paymaster address in validation config: %s", p)
 		}
+		tokenPaymasters[common.HexToAddress(p)] = struct{}{}
+	}
 
-		encodedFunction, err := parsedAbi.Pack("createAccount", op.Sender, big.NewInt(0))
-		if err != nil {
+	var simulationsAbi *abi.ABI
+	var simulationsCode []byte
+	if limits.EntryPointSimulations != "" {
+		if simulationsAbi, simulationsCode, err = loadSimulations(limits.EntryPointSimulations); err != nil {
 			return nil, err
 		}
+	}
 
-		initCode := append(v.Factory.Bytes(), encodedFunction...)
-
-		op.InitCode = initCode
-		return op, nil
+	maxOpSize := limits.MaxOpSize
+	if maxOpSize <= 0 {
+		maxOpSize = DEFAULT_MAX_OP_SIZE
 	}
 
-	return op, nil
+	return &Validator{
+		Client:            client,
+		EntryPoint:        entryAddr,
+		EntryPointABI:     &entryAbi,
+		Bundlr:            bundlrAddr,
+		Factory:           factoryAddr,
+		TokenPaymasters:   tokenPaymasters,
+		TokenPaymasterABI: &tokenAbi,
+		Factories:         factories,
+		MaxOpSize:         maxOpSize,
+		MinPriorityFee:    new(big.Int).SetUint64(limits.MinPriorityFee),
+		SimulationsABI:    simulationsAbi,
+		SimulationsCode:   simulationsCode,
+	}, nil
+}
+
+func extractRevertReason(err string) string {
+	start := strings.Index(err, "FailedOp")
+	if start == -1 {
+		return err
+	}
+	return err[start:]
 }
 
-func (v *Validator) SimulateHandleOp(op *types.PackedUserOperation) error {
-	if err := v.ValidatePreVerificationGas(op); err != nil {
+func (v *Validator) SimulateHandleOp(ctx context.Context, op *types.PackedUserOperation) error {
+	if err := v.ValidatePreVerificationGas(ctx, op); err != nil {
 		return fmt.Errorf("preVerificationGas validation failed: %w", err)
 	}
 
-	ops := []types.PackedUserOperation{*op}
+	return v.SimulateHandleOps(ctx, []types.PackedUserOperation{*op})
+}
+
+// SimulateHandleOps runs handleOps over the whole bundle with eth_call.
+// If the EntryPoint rejects one of the ops, the returned error wraps a *FailedOpError
+// carrying the index of the offending op.
+func (v *Validator) SimulateHandleOps(ctx context.Context, ops []types.PackedUserOperation) error {
 	calldata, err := v.EntryPointABI.Pack("handleOps", ops, v.Bundlr)
 	if err != nil {
 		return fmt.Errorf("abi.Pack failed: %w", err)
@@ -112,9 +143,12 @@ func (v *Validator) SimulateHandleOp(op *types.PackedUserOperation) error {
 		Gas:               15_000_000,
 	}
 
-	_, err = v.Client.CallContract(context.Background(), msg, nil)
+	_, err = v.Client.CallContract(ctx, msg, nil)
 	if err != nil {
-		fmt.Println("SimulateHandleOp error:", err)
+		slog.Debug("handleOps simulation failed", "ops", len(ops), "error", err)
+		if failed := v.decodeFailedOp(err); failed != nil {
+			return fmt.Errorf("simulate failed with EntryPoint revert: %w", failed)
+		}
 		if strings.Contains(err.Error(), "FailedOp") {
 			return fmt.Errorf("simulate failed with EntryPoint revert: %s", extractRevertReason(err.Error()))
 		}
@@ -124,24 +158,34 @@ func (v *Validator) SimulateHandleOp(op *types.PackedUserOperation) error {
 	return nil
 }
 
-func (v *Validator) ValidatePreVerificationGas(op *types.PackedUserOperation) error {
-	ops := []types.PackedUserOperation{*op}
-	packedBytes, err := v.EntryPointABI.Pack("handleOps", ops, v.Bundlr)
+func (v *Validator) ValidatePreVerificationGas(ctx context.Context, op *types.PackedUserOperation) error {
+	required, err := v.RequiredPreVerificationGas(ctx, op)
 	if err != nil {
-		return fmt.Errorf("pack for size failed: %w", err)
+		return err
 	}
 
-	wordCount := (len(packedBytes) + 31) / 32
-	userOpOverhead := PER_USEROP_OVERHEAD + wordCount*PER_WORD_OVERHEAD
-	bundleShare := FIXED_OVERHEAD_GAS / EXPECTED_BUNDLE_SIZE
-	stipend := TRANSACTION_STIPEND / EXPECTED_BUNDLE_SIZE
+	if op.PreVerificationGas.Cmp(required) < 0 {
+		return validationError(ERR_PVG_TOO_LOW, "preVerificationGas too low: got %s, required %s", op.PreVerificationGas.String(), required.String())
+	}
 
-	minPreVerificationGas := big.NewInt(int64(userOpOverhead + bundleShare + stipend))
+	return nil
+}
 
-	// kontrol
-	if op.PreVerificationGas.Cmp(minPreVerificationGas) < 0 {
-		return fmt.Errorf("preVerificationGas too low: %s < %s", op.PreVerificationGas.String(), minPreVerificationGas.String())
+// UserOpHash computes the userOpHash of op locally, the way EntryPoint.getUserOpHash does.
+// For EIP-7702 ops the delegate is read from the sender's code, as the EntryPoint does.
+func (v *Validator) UserOpHash(op *types.PackedUserOperation, chainID *big.Int) (common.Hash, error) {
+	if !userop.IsEip7702InitCode(op.InitCode) {
+		return op.Hash(v.EntryPoint, chainID), nil
 	}
 
-	return nil
+	code, err := v.Client.CodeAt(context.Background(), op.Sender, nil)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to get sender code: %w", err)
+	}
+	if len(code) != 23 || !bytes.HasPrefix(code, []byte{0xef, 0x01, 0x00}) {
+		return common.Hash{}, fmt.Errorf("sender %s is not an EIP-7702 account", op.Sender.Hex())
+	}
+
+	initCodeHash := userop.Eip7702InitCodeHash(common.BytesToAddress(code[3:]), op.InitCode)
+	return op.HashWithInitCodeOverride(v.EntryPoint, chainID, initCodeHash), nil
 }
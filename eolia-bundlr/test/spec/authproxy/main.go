@@ -0,0 +1,36 @@
+// authproxy forwards requests to the bundler with an admin bearer token, for the spec
+// tests, which can't send one with their debug_bundler_* calls.
+//
+//	go run ./test/spec/authproxy -listen 127.0.0.1:8182 -target http://127.0.0.1:8181 -token ...
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8182", "address to listen on")
+	target := flag.String("target", "http://127.0.0.1:8181", "bundler base URL")
+	token := flag.String("token", "", "admin_token of the bundler")
+	flag.Parse()
+
+	if *token == "" {
+		log.Fatal("-token is required")
+	}
+	targetURL, err := url.Parse(*target)
+	if err != nil {
+		log.Fatalf("invalid -target: %v", err)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(targetURL)
+			r.Out.Header.Set("Authorization", "Bearer "+*token)
+		},
+	}
+	log.Fatal(http.ListenAndServe(*listen, proxy))
+}
@@ -0,0 +1,146 @@
+#!/usr/bin/env bash
+# Runs the eth-infinitism bundler-spec-tests against eolia-bundlr on a local devnet:
+#   1. boots anvil (or geth --dev),
+#   2. deploys EntryPoint and SimpleAccountFactory from the eolia-contracts artifacts
+#      and checks eolia-common's offline userOpHash against the deployed EntryPoint,
+#   3. compiles EntryPointSimulations from the EntryPoint's solc input, for gas estimation,
+#   4. starts the bundler against it with the debug namespace enabled, behind a proxy adding
+#      the admin token the debug calls need,
+#   5. runs the spec tests (extra arguments are passed to pytest, e.g. -k "test_eth_sendUserOperation").
+#
+# Requires: go, foundry (anvil/cast) or geth, solc 0.8.28, jq, git, curl and pdm (for the spec tests).
+set -euo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BUNDLR_DIR="$(cd "$SCRIPT_DIR/../.." && pwd)"
+CONTRACTS_DIR="${CONTRACTS_DIR:-$BUNDLR_DIR/../eolia-contracts}"
+ARTIFACTS_DIR="${ARTIFACTS_DIR:-$CONTRACTS_DIR/deployments/xlayer}"
+
+NODE="${NODE:-anvil}" # anvil | geth
+RPC_PORT="${RPC_PORT:-8545}"
+RPC_URL="http://127.0.0.1:$RPC_PORT"
+BUNDLER_PORT="${BUNDLER_PORT:-8181}"
+PROXY_PORT="${PROXY_PORT:-8182}"
+BUNDLER_URL="http://127.0.0.1:$PROXY_PORT/rpc"
+SOLC="${SOLC:-solc}"
+
+SPEC_TESTS_REPO="${SPEC_TESTS_REPO:-https://github.com/eth-infinitism/bundler-spec-tests.git}"
+SPEC_TESTS_REF="${SPEC_TESTS_REF:-releases/v0.8}"
+
+WORK_DIR="${WORK_DIR:-$(mktemp -d -t eolia-spec-XXXXXX)}"
+SPEC_TESTS_DIR="${SPEC_TESTS_DIR:-$WORK_DIR/bundler-spec-tests}"
+
+# Well-known anvil dev account #0. Never use outside a devnet.
+BUNDLER_KEY="ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+BUNDLER_ADDRESS="0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+ADMIN_TOKEN="$(od -An -tx1 -N16 /dev/urandom | tr -d ' \n')"
+
+PIDS=()
+cleanup() {
+	for pid in "${PIDS[@]}"; do
+		kill "$pid" 2>/dev/null || true
+	done
+}
+trap cleanup EXIT
+
+wait_for() {
+	local name="$1" url="$2" body="$3"
+	for _ in $(seq 1 60); do
+		if curl -sf -X POST -H "Content-Type: application/json" -d "$body" "$url" >/dev/null; then
+			return 0
+		fi
+		sleep 1
+	done
+	echo "$name did not come up at $url" >&2
+	exit 1
+}
+
+echo "==> work dir: $WORK_DIR"
+
+echo "==> starting $NODE on :$RPC_PORT"
+case "$NODE" in
+anvil)
+	anvil --port "$RPC_PORT" --silent --steps-tracing &
+	PIDS+=($!)
+	;;
+geth)
+	geth --dev --http --http.port "$RPC_PORT" --http.api eth,net,web3,debug \
+		--datadir "$WORK_DIR/geth" --verbosity 1 &
+	PIDS+=($!)
+	;;
+*)
+	echo "unknown NODE: $NODE (anvil | geth)" >&2
+	exit 1
+	;;
+esac
+wait_for "$NODE" "$RPC_URL" '{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}'
+
+CHAIN_ID="$(cast chain-id --rpc-url "$RPC_URL")"
+
+if [ "$NODE" = "geth" ]; then
+	# geth --dev only has its own unlocked account: fund the bundler key from it.
+	DEV_ACCOUNT="$(cast rpc --rpc-url "$RPC_URL" eth_accounts | jq -r '.[0]')"
+	cast send --rpc-url "$RPC_URL" --unlocked --from "$DEV_ACCOUNT" --value 1000ether "$BUNDLER_ADDRESS" >/dev/null
+fi
+
+echo "==> deploying EntryPoint and SimpleAccountFactory"
+deploy() {
+	cast send --rpc-url "$RPC_URL" --private-key "0x$BUNDLER_KEY" --json --create "$@" | jq -r .contractAddress
+}
+ENTRY_POINT="$(deploy "$(jq -r .bytecode "$ARTIFACTS_DIR/EntryPoint.json")")"
+FACTORY="$(deploy "$(jq -r .bytecode "$ARTIFACTS_DIR/SimpleAccountFactory.json")" "constructor(address)" "$ENTRY_POINT")"
+echo "    EntryPoint:           $ENTRY_POINT"
+echo "    SimpleAccountFactory: $FACTORY"
+
+echo "==> checking offline userOpHash against EntryPoint.getUserOpHash"
+(cd "$BUNDLR_DIR/../eolia-common" && go run ./cmd/hashcheck -rpc "$RPC_URL" -entrypoint "$ENTRY_POINT")
+
+echo "==> compiling EntryPointSimulations"
+SOLC_INPUT="$ARTIFACTS_DIR/solcInputs/$(jq -r .solcInputHash "$ARTIFACTS_DIR/EntryPoint.json").json"
+jq '.settings.outputSelection = {"contracts/core/EntryPointSimulations.sol": {"EntryPointSimulations": ["abi", "evm.deployedBytecode.object"]}}' "$SOLC_INPUT" |
+	"$SOLC" --standard-json |
+	jq '.contracts["contracts/core/EntryPointSimulations.sol"].EntryPointSimulations | {abi, deployedBytecode: ("0x" + .evm.deployedBytecode.object)}' \
+		>"$WORK_DIR/EntryPointSimulations.json"
+
+cat >"$WORK_DIR/config.yaml" <<YAML
+chain_id: $CHAIN_ID
+rpc_url: "$RPC_URL"
+entry_point: "$ENTRY_POINT"
+factory: "$FACTORY"
+bundlr_address: "$BUNDLER_ADDRESS"
+bundlr_private_key: "$BUNDLER_KEY"
+listen: "127.0.0.1:$BUNDLER_PORT"
+bundling:
+  mode: "interval"
+  interval: 1s
+validation:
+  entrypoint_simulations: "$WORK_DIR/EntryPointSimulations.json"
+debug_rpc: true
+admin_token: "$ADMIN_TOKEN"
+YAML
+
+echo "==> starting eolia-bundlr"
+(cd "$BUNDLR_DIR" && go build -o "$WORK_DIR/eolia-bundlr" ./cmd/bundlr)
+(cd "$BUNDLR_DIR" && exec "$WORK_DIR/eolia-bundlr" -config "$WORK_DIR/config.yaml" >"$WORK_DIR/bundlr.log" 2>&1) &
+PIDS+=($!)
+(cd "$BUNDLR_DIR" && go build -o "$WORK_DIR/authproxy" ./test/spec/authproxy)
+"$WORK_DIR/authproxy" -listen "127.0.0.1:$PROXY_PORT" -target "http://127.0.0.1:$BUNDLER_PORT" -token "$ADMIN_TOKEN" &
+PIDS+=($!)
+wait_for "eolia-bundlr" "$BUNDLER_URL" '{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}'
+
+echo "==> fetching bundler-spec-tests ($SPEC_TESTS_REF)"
+if [ ! -d "$SPEC_TESTS_DIR" ]; then
+	git clone --depth 1 --recurse-submodules --branch "$SPEC_TESTS_REF" "$SPEC_TESTS_REPO" "$SPEC_TESTS_DIR"
+fi
+(cd "$SPEC_TESTS_DIR" && pdm install)
+
+echo "==> running spec tests"
+status=0
+(cd "$SPEC_TESTS_DIR" && pdm run test \
+	--url "$BUNDLER_URL" \
+	--entry-point "$ENTRY_POINT" \
+	--ethereum-node "$RPC_URL" \
+	"$@") || status=$?
+
+echo "==> bundler log: $WORK_DIR/bundlr.log"
+exit $status
@@ -0,0 +1,136 @@
+// hashcheck compares userop.Hash against EntryPoint.getUserOpHash on a live node,
+// for a set of ops covering empty, short and long fields and paymaster data.
+//
+//	go run ./cmd/hashcheck -rpc http://127.0.0.1:8545 -entrypoint 0x...
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"eolia-common/userop"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const getUserOpHashABI = `[{"inputs":[{"components":[{"internalType":"address","name":"sender","type":"address"},{"internalType":"uint256","name":"nonce","type":"uint256"},{"internalType":"bytes","name":"initCode","type":"bytes"},{"internalType":"bytes","name":"callData","type":"bytes"},{"internalType":"bytes32","name":"accountGasLimits","type":"bytes32"},{"internalType":"uint256","name":"preVerificationGas","type":"uint256"},{"internalType":"bytes32","name":"gasFees","type":"bytes32"},{"internalType":"bytes","name":"paymasterAndData","type":"bytes"},{"internalType":"bytes","name":"signature","type":"bytes"}],"internalType":"struct PackedUserOperation","name":"userOp","type":"tuple"}],"name":"getUserOpHash","outputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"stateMutability":"view","type":"function"}]`
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("rand: %v", err)
+	}
+	return b
+}
+
+func testOps() []*userop.PackedUserOperation {
+	maxUint128 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	limits, _ := userop.PackAccountGasLimits(big.NewInt(150_000), big.NewInt(300_000))
+	maxLimits, _ := userop.PackAccountGasLimits(maxUint128, maxUint128)
+	fees, _ := userop.PackGasFees(big.NewInt(1_000_000_000), big.NewInt(30_000_000_000))
+	factory := common.BytesToAddress(randomBytes(20))
+	paymaster, _ := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     common.BytesToAddress(randomBytes(20)),
+		PaymasterVerificationGasLimit: big.NewInt(100_000),
+		PaymasterPostOpGasLimit:       big.NewInt(50_000),
+		PaymasterData:                 randomBytes(97),
+	})
+
+	return []*userop.PackedUserOperation{
+		{
+			Sender:             common.Address{},
+			Nonce:              big.NewInt(0),
+			InitCode:           []byte{},
+			CallData:           []byte{},
+			PreVerificationGas: big.NewInt(0),
+			PaymasterAndData:   []byte{},
+			Signature:          []byte{},
+		},
+		{
+			Sender:             common.BytesToAddress(randomBytes(20)),
+			Nonce:              new(big.Int).Lsh(big.NewInt(7), 64),
+			InitCode:           userop.PackInitCode(&factory, randomBytes(68)),
+			CallData:           randomBytes(228),
+			AccountGasLimits:   limits,
+			PreVerificationGas: big.NewInt(48_000),
+			GasFees:            fees,
+			PaymasterAndData:   []byte{},
+			Signature:          randomBytes(65),
+		},
+		{
+			Sender:             common.BytesToAddress(randomBytes(20)),
+			Nonce:              new(big.Int).SetBytes(randomBytes(32)),
+			InitCode:           []byte{},
+			CallData:           randomBytes(1),
+			AccountGasLimits:   maxLimits,
+			PreVerificationGas: new(big.Int).SetBytes(randomBytes(32)),
+			GasFees:            fees,
+			PaymasterAndData:   paymaster,
+			Signature:          randomBytes(65),
+		},
+	}
+}
+
+func main() {
+	rpcURL := flag.String("rpc", "http://127.0.0.1:8545", "node RPC URL")
+	entryPointHex := flag.String("entrypoint", "", "EntryPoint address")
+	flag.Parse()
+
+	if !common.IsHexAddress(*entryPointHex) {
+		log.Fatalf("invalid -entrypoint: %q", *entryPointHex)
+	}
+	entryPoint := common.HexToAddress(*entryPointHex)
+
+	client, err := ethclient.Dial(*rpcURL)
+	if err != nil {
+		log.Fatalf("failed to dial RPC: %v", err)
+	}
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		log.Fatalf("failed to get chain id: %v", err)
+	}
+
+	entryAbi, err := abi.JSON(strings.NewReader(getUserOpHashABI))
+	if err != nil {
+		log.Fatalf("failed to parse ABI: %v", err)
+	}
+
+	failed := 0
+	for i, op := range testOps() {
+		calldata, err := entryAbi.Pack("getUserOpHash", *op)
+		if err != nil {
+			log.Fatalf("op %d: abi.Pack failed: %v", i, err)
+		}
+
+		output, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &entryPoint, Data: calldata}, nil)
+		if err != nil {
+			log.Fatalf("op %d: getUserOpHash call failed: %v", i, err)
+		}
+
+		var onChain common.Hash
+		if err := entryAbi.UnpackIntoInterface(&onChain, "getUserOpHash", output); err != nil {
+			log.Fatalf("op %d: failed to unpack getUserOpHash: %v", i, err)
+		}
+
+		offline := op.Hash(entryPoint, chainID)
+		if offline != onChain {
+			fmt.Printf("op %d: MISMATCH offline %s, EntryPoint %s\n", i, offline.Hex(), onChain.Hex())
+			failed++
+			continue
+		}
+		fmt.Printf("op %d: ok %s\n", i, offline.Hex())
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
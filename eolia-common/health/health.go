@@ -0,0 +1,210 @@
+// Package health runs the liveness and readiness checks of the Eolia services and
+// reports them component by component, e.g.
+//
+//	{"status": "degraded", "components": {"db": {"status": "ok", "latency_ms": 2}, ...}}
+//
+// A report is as bad as its worst component. Degraded components (a lagging RPC node
+// while others are fine) are reported but don't make a service unready; a down one does.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"eolia-common/upstream"
+)
+
+const (
+	STATUS_OK       = "ok"
+	STATUS_DEGRADED = "degraded"
+	STATUS_DOWN     = "down"
+
+	// Deadline of each check; a check that doesn't answer in time is down.
+	DEFAULT_CHECK_TIMEOUT = 3 * time.Second
+)
+
+type Component struct {
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	LatencyMs int64          `json:"latency_ms"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// HTTPStatus is 200 unless a component is down, in which case it is 503.
+func (r Report) HTTPStatus() int {
+	if r.Status == STATUS_DOWN {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// CheckFunc checks one component. It should honour ctx's deadline.
+type CheckFunc func(ctx context.Context) Component
+
+func OK(details map[string]any) Component {
+	return Component{Status: STATUS_OK, Details: details}
+}
+
+func Degraded(reason string, details map[string]any) Component {
+	return Component{Status: STATUS_DEGRADED, Error: reason, Details: details}
+}
+
+func Down(err error, details map[string]any) Component {
+	return Component{Status: STATUS_DOWN, Error: err.Error(), Details: details}
+}
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker runs a set of named checks concurrently.
+type Checker struct {
+	Timeout time.Duration
+	checks  []check
+}
+
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+func (c *Checker) Run(ctx context.Context) Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_CHECK_TIMEOUT
+	}
+
+	report := Report{Status: STATUS_OK, Components: make(map[string]Component, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chk := range c.checks {
+		wg.Add(1)
+		go func(chk check) {
+			defer wg.Done()
+
+			component := run(ctx, chk.fn, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[chk.name] = component
+			report.Status = worst(report.Status, component.Status)
+		}(chk)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// run calls fn under timeout, turning a check that overruns it or panics into a down component.
+func run(ctx context.Context, fn CheckFunc, timeout time.Duration) (component Component) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan Component, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- Down(fmt.Errorf("check panicked: %v", r), nil)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	select {
+	case component = <-done:
+	case <-ctx.Done():
+		component = Down(fmt.Errorf("check timed out after %s", timeout), nil)
+	}
+	component.LatencyMs = time.Since(start).Milliseconds()
+	return component
+}
+
+func worst(a, b string) string {
+	rank := map[string]int{STATUS_OK: 0, STATUS_DEGRADED: 1, STATUS_DOWN: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// Cached reuses the result of fn for ttl, for checks that cost an API call or are
+// rate limited (Turnkey, balances) when probes hit the endpoint every few seconds.
+func Cached(ttl time.Duration, fn CheckFunc) CheckFunc {
+	var mu sync.Mutex
+	var last Component
+	var at time.Time
+
+	return func(ctx context.Context) Component {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !at.IsZero() && time.Since(at) < ttl {
+			return last
+		}
+		last, at = fn(ctx), time.Now()
+		return last
+	}
+}
+
+// Ping checks a dependency that can be pinged, e.g. a *pgxpool.Pool.
+func Ping(pinger interface{ Ping(context.Context) error }) CheckFunc {
+	return func(ctx context.Context) Component {
+		if err := pinger.Ping(ctx); err != nil {
+			return Down(err, nil)
+		}
+		return OK(nil)
+	}
+}
+
+// Upstreams reports the sync status of a pool's RPC nodes from their last health check:
+// ok when every node is synced, degraded when some are down or lagging, down when none is usable.
+func Upstreams(stats func() []upstream.Stats) CheckFunc {
+	return func(context.Context) Component {
+		nodes := stats()
+
+		var highest uint64
+		for _, s := range nodes {
+			if s.Reachable && s.Height > highest {
+				highest = s.Height
+			}
+		}
+
+		healthy := 0
+		details := make([]map[string]any, 0, len(nodes))
+		for _, s := range nodes {
+			if s.Healthy {
+				healthy++
+			}
+			node := map[string]any{
+				"upstream":   s.Host(),
+				"healthy":    s.Healthy,
+				"reachable":  s.Reachable,
+				"height":     s.Height,
+				"latency_ms": s.Latency.Milliseconds(),
+			}
+			if s.Reachable {
+				node["lag"] = highest - s.Height
+			}
+			details = append(details, node)
+		}
+
+		summary := map[string]any{"healthy": healthy, "total": len(nodes), "head": highest, "nodes": details}
+		switch {
+		case healthy == 0:
+			return Down(fmt.Errorf("no synced RPC node"), summary)
+		case healthy < len(nodes):
+			return Degraded(fmt.Sprintf("%d of %d RPC nodes down or lagging", len(nodes)-healthy, len(nodes)), summary)
+		}
+		return OK(summary)
+	}
+}
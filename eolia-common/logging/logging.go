@@ -0,0 +1,127 @@
+// Package logging sets up structured (slog) logging for the Eolia services and carries
+// a per-request logger through contexts.
+//
+// A request id travels between services in the X-Request-ID header: the signer
+// generates one for each incoming request (or reuses the caller's), sends it along to
+// the bundler, and both attach it as request_id to every log line of that request.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+
+	// Longest request id accepted from a caller; longer ones are replaced.
+	MAX_REQUEST_ID_LENGTH = 128
+
+	FORMAT_JSON = "json"
+	FORMAT_TEXT = "text"
+)
+
+type Config struct {
+	// debug, info, warn or error. Defaults to info.
+	Level string `yaml:"level"`
+	// json (default) or text.
+	Format string `yaml:"format"`
+}
+
+// Setup makes a logger built from cfg the slog default, tagging every line with service.
+// Lines written through the standard log package go to it as well.
+func Setup(cfg Config, service string) error {
+	logger, err := New(cfg, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger.With("service", service))
+	return nil
+}
+
+// Validate reports an unknown level or format.
+func (c Config) Validate() error {
+	_, err := New(c, io.Discard)
+	return err
+}
+
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", FORMAT_JSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FORMAT_TEXT:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want json or text)", cfg.Format)
+	}
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// NewRequestID returns a random 16-byte hex id.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ValidRequestID reports whether an id received from a caller can be logged as is.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDKey struct{}
+type loggerKey struct{}
+
+// WithRequest returns a context carrying id and a logger that adds it as request_id.
+func WithRequest(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, FromContext(ctx).With("request_id", id))
+}
+
+// RequestID returns the id stored by WithRequest, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestLogger gives every request an id (the caller's X-Request-ID if it sent a usable
+// one), returns it in the response header and stores a logger carrying it in the user
+// context, for handlers to get with FromContext(c.UserContext()). eolia-signer forwards the
+// id to the bundler, so both services log the same request_id. Once the request is served,
+// it is logged at level.
+func RequestLogger(level slog.Level) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(REQUEST_ID_HEADER)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+		c.Set(REQUEST_ID_HEADER, id)
+
+		ctx := WithRequest(c.UserContext(), id)
+		c.SetUserContext(ctx)
+
+		start := time.Now()
+		err := c.Next()
+
+		FromContext(ctx).Log(ctx, level, "request served",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", c.Response().StatusCode(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Checker accumulates validation errors so a service can report all of them at once.
+type Checker struct {
+	errs []string
+}
+
+func (c *Checker) Fail(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+// Err returns every failure, one per line, or nil.
+func (c *Checker) Err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(c.errs, "\n  "))
+}
+
+func (c *Checker) Required(name, value string) bool {
+	if value == "" {
+		c.Fail("%s is required", name)
+		return false
+	}
+	return true
+}
+
+// Address checks a 0x-prefixed 20-byte hex address. Empty is accepted unless required.
+func (c *Checker) Address(name, value string, required bool) {
+	if value == "" {
+		if required {
+			c.Fail("%s is required", name)
+		}
+		return
+	}
+	if !strings.HasPrefix(value, "0x") || !common.IsHexAddress(value) {
+		c.Fail("%s is not a valid address: %q", name, value)
+	}
+}
+
+// PrivateKey checks a hex secp256k1 key and returns its address.
+func (c *Checker) PrivateKey(name, value string) (common.Address, bool) {
+	if !c.Required(name, value) {
+		return common.Address{}, false
+	}
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(value, "0x"))
+	if err != nil {
+		// The error can quote the key; don't echo it.
+		c.Fail("%s is not a valid private key", name)
+		return common.Address{}, false
+	}
+	return crypto.PubkeyToAddress(key.PublicKey), true
+}
+
+// URL checks an absolute URL with one of the given schemes.
+func (c *Checker) URL(name, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		c.Fail("%s is not a valid URL", name)
+		return
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return
+		}
+	}
+	c.Fail("%s must use one of %s", name, strings.Join(schemes, ", "))
+}
@@ -0,0 +1,267 @@
+// Package settings layers configuration for the Eolia services: a YAML file, then
+// environment variables, then command-line overrides, all addressed through the
+// config struct's yaml tags.
+//
+// A field tagged `yaml:"rpc_url"` inside a struct tagged `yaml:"bundling"` is
+// set by the env var <PREFIX>BUNDLING_RPC_URL and by the override bundling.rpc_url=....
+// Fields tagged `secret:"true"` are masked by Redact; `secret:"url"` keeps only the
+// scheme and host of URLs, which often carry API keys in their path.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile decodes the YAML file at path into cfg. A missing file is not an error
+// unless required is set, so a service can be configured from the environment alone.
+func LoadFile(path string, required bool, cfg interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv sets every field of cfg that has a matching, non-empty environment variable.
+func ApplyEnv(cfg interface{}, prefix string) error {
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value, _ reflect.StructField) {
+		name := prefix + strings.ToUpper(strings.Join(path, "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		if err := setValue(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Overrides collects repeated -set key=value flags.
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *Overrides) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// Apply sets the fields named by the overrides, e.g. "bundling.mode=manual".
+func (o Overrides) Apply(cfg interface{}) error {
+	values := make(map[string]string)
+	for _, kv := range o {
+		key, value, _ := strings.Cut(kv, "=")
+		values[strings.TrimSpace(key)] = value
+	}
+
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value, _ reflect.StructField) {
+		key := strings.Join(path, ".")
+		value, ok := values[key]
+		if !ok {
+			return
+		}
+		delete(values, key)
+		if err := setValue(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	})
+
+	for key := range values {
+		errs = append(errs, fmt.Errorf("unknown setting %s", key))
+	}
+	return errors.Join(errs...)
+}
+
+// Redact returns cfg as a map keyed like the YAML file, with secrets masked.
+func Redact(cfg interface{}) map[string]interface{} {
+	return redactStruct(reflect.ValueOf(cfg).Elem())
+}
+
+func redactStruct(v reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, inline := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		if inline {
+			for k, val := range redactStruct(field) {
+				out[k] = val
+			}
+			continue
+		}
+		out[name] = redactValue(field, sf.Tag.Get("secret"))
+	}
+	return out
+}
+
+func redactValue(field reflect.Value, secret string) interface{} {
+	switch {
+	case field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)):
+		return redactStruct(field)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+		items := make([]interface{}, field.Len())
+		for i := range items {
+			items[i] = redactStruct(field.Index(i))
+		}
+		return items
+	case field.Kind() == reflect.Slice && secret != "":
+		items := make([]interface{}, field.Len())
+		for i := range items {
+			items[i] = redactValue(field.Index(i), secret)
+		}
+		return items
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		return time.Duration(field.Int()).String()
+	}
+
+	if secret == "" || field.IsZero() {
+		return field.Interface()
+	}
+	if secret == "url" && field.Kind() == reflect.String {
+		return redactURL(field.String())
+	}
+	return "***"
+}
+
+func redactURL(raw string) string {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return "***"
+	}
+	if i := strings.LastIndex(strings.SplitN(rest, "/", 2)[0], "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	host := strings.SplitN(rest, "/", 2)[0]
+	if host == rest {
+		return scheme + "://" + host
+	}
+	return scheme + "://" + host + "/***"
+}
+
+// walk calls fn for every settable leaf field, with its path of yaml names.
+// Slices of structs (e.g. a list of chains) are only configurable from the file.
+func walk(v reflect.Value, path []string, fn func(path []string, field reflect.Value, sf reflect.StructField)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, inline := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		if inline {
+			walk(field, path, fn)
+			continue
+		}
+
+		fieldPath := append(append([]string{}, path...), name)
+		switch {
+		case field.Kind() == reflect.Struct:
+			walk(field, fieldPath, fn)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+		default:
+			fn(fieldPath, field, sf)
+		}
+	}
+}
+
+func yamlName(sf reflect.StructField) (name string, inline bool) {
+	tag := sf.Tag.Get("yaml")
+	name, opts, _ := strings.Cut(tag, ",")
+	if strings.Contains(opts, "inline") {
+		return "", true
+	}
+	if name == "" {
+		name = strings.ToLower(sf.Name)
+	}
+	return name, false
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
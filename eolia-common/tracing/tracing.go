@@ -0,0 +1,156 @@
+// Package tracing sets up OpenTelemetry tracing for the Eolia services.
+//
+// Spans are exported over OTLP/HTTP (e.g. to a local collector on :4318). Trace context
+// travels between services in the W3C traceparent header, so a /sign request on the
+// signer and the eth_sendUserOperation it causes on the bundler share one trace.
+// Without an endpoint nothing is exported and spans cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const INSTRUMENTATION_NAME = "eolia"
+
+type Config struct {
+	// OTLP/HTTP collector, e.g. localhost:4318. Empty disables exporting.
+	Endpoint string `yaml:"endpoint"`
+	// Send spans over plain HTTP (local collectors).
+	Insecure bool `yaml:"insecure"`
+	// Fraction of new traces that are sampled (0 = 1, all of them). Traces started
+	// by a caller keep the caller's decision.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Setup installs the global tracer provider and the W3C propagators. The returned
+// function flushes pending spans; call it on shutdown.
+func Setup(ctx context.Context, cfg Config, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer starts the span of an incoming request, continuing the trace its
+// headers carry.
+func StartServer(ctx context.Context, carrier propagation.TextMapCarrier, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = Extract(ctx, carrier)
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// StartClient starts the span of an outgoing call to another service or node.
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a span that works on behalf of other traces, e.g. a bundle
+// built from ops submitted by several requests.
+func StartLinked(ctx context.Context, name string, links []trace.Link, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, trace.WithLinks(links...), trace.WithAttributes(attrs...))
+}
+
+// Record adds an already finished span covering start..end to the trace stored by
+// Carrier, linked to link. It accounts for work that happened outside the request,
+// e.g. the time an op waited in the mempool before a bundle picked it up.
+func Record(carrier map[string]string, name string, start, end time.Time, link trace.SpanContext, attrs ...attribute.KeyValue) {
+	parent := Extract(context.Background(), propagation.MapCarrier(carrier))
+	if !trace.SpanContextFromContext(parent).IsValid() {
+		return
+	}
+
+	opts := []trace.SpanStartOption{trace.WithTimestamp(start), trace.WithAttributes(attrs...)}
+	if link.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: link}))
+	}
+	_, span := otel.Tracer(INSTRUMENTATION_NAME).Start(parent, name, opts...)
+	span.End(trace.WithTimestamp(end))
+}
+
+// End records err (if any) on span and ends it. Use it as
+//
+//	defer func() { tracing.End(span, err) }()
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx into carrier, e.g. propagation.HeaderCarrier(req.Header).
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Extract returns ctx with the remote span context found in carrier.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Carrier serializes the trace context of ctx so it can be stored (e.g. with a queued op)
+// and turned into a span link later with Link.
+func Carrier(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Link returns a link to the span stored by Carrier, or false when there is none.
+func Link(carrier map[string]string, attrs ...attribute.KeyValue) (trace.Link, bool) {
+	sc := trace.SpanContextFromContext(Extract(context.Background(), propagation.MapCarrier(carrier)))
+	if !sc.IsValid() {
+		return trace.Link{}, false
+	}
+	return trace.Link{SpanContext: sc, Attributes: attrs}, true
+}
@@ -0,0 +1,211 @@
+// Package upstream spreads JSON-RPC traffic over several nodes of the same chain.
+//
+// A Pool health-checks its upstreams (block height lag and latency) and hands out an
+// *ethclient.Client whose HTTP transport sends each request to the best healthy node,
+// retrying idempotent calls on the next one when a node fails. Transactions are never
+// retried on another node; use Pin to keep a whole submission on one node.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	DEFAULT_CHECK_INTERVAL = 5 * time.Second
+	DEFAULT_CHECK_TIMEOUT  = 3 * time.Second
+	DEFAULT_MAX_LAG        = 5
+)
+
+type Config struct {
+	// How often every upstream is probed with eth_blockNumber.
+	CheckInterval time.Duration
+	// Probe timeout; a node that doesn't answer in time is unhealthy.
+	CheckTimeout time.Duration
+	// Blocks a node may lag behind the highest one before it is unhealthy.
+	MaxLag uint64
+}
+
+type Pool struct {
+	upstreams []*Upstream
+	cfg       Config
+	client    *ethclient.Client
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPool builds a pool over urls (http or https). It doesn't connect: upstreams start
+// healthy and are sorted out by the first health check.
+func NewPool(urls []string, cfg Config) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no RPC URL configured")
+	}
+
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DEFAULT_CHECK_INTERVAL
+	}
+	if cfg.CheckTimeout <= 0 {
+		cfg.CheckTimeout = DEFAULT_CHECK_TIMEOUT
+	}
+	if cfg.MaxLag == 0 {
+		cfg.MaxLag = DEFAULT_MAX_LAG
+	}
+
+	p := &Pool{cfg: cfg}
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("unsupported RPC URL %q: only http and https are pooled", url)
+		}
+		u, err := newUpstream(url)
+		if err != nil {
+			return nil, err
+		}
+		if u.pinned, err = dial(u.URL, &pinnedTransport{upstream: u}); err != nil {
+			return nil, err
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	client, err := dial(p.upstreams[0].URL, &failoverTransport{pool: p})
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+
+	return p, nil
+}
+
+// dial returns an ethclient whose requests all go through transport. url only has to
+// be valid: the transport decides where requests are sent.
+func dial(url string, transport http.RoundTripper) (*ethclient.Client, error) {
+	rpcClient, err := rpc.DialOptions(context.Background(), url, rpc.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create RPC client: %w", err)
+	}
+	return ethclient.NewClient(rpcClient), nil
+}
+
+// Client returns the pooled client. Idempotent calls fail over to the next healthy node.
+func (p *Pool) Client() *ethclient.Client {
+	return p.client
+}
+
+// Pin returns the client bound to the current best upstream, so that every call of one
+// submission (nonce, send, receipt) sees the same node. Each upstream has one such client,
+// shared by every submission pinned to it.
+func (p *Pool) Pin() *ethclient.Client {
+	return p.ordered()[0].pinned
+}
+
+// Healthy returns the pinned client of every healthy upstream, best first, for the calls
+// that must see one answer from each node rather than the first that succeeds.
+func (p *Pool) Healthy() []*ethclient.Client {
+	var clients []*ethclient.Client
+	for _, u := range p.ordered() {
+		if u.snapshot().Healthy {
+			clients = append(clients, u.pinned)
+		}
+	}
+	return clients
+}
+
+// ordered returns the upstreams to try, healthy ones first, fastest first.
+func (p *Pool) ordered() []*Upstream {
+	ordered := make([]*Upstream, len(p.upstreams))
+	copy(ordered, p.upstreams)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i].snapshot(), ordered[j].snapshot()
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		return a.Latency < b.Latency
+	})
+	return ordered
+}
+
+// Start runs the health checks until Stop is called.
+func (p *Pool) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.cfg.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			p.Check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel = nil
+	p.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Check probes every upstream once and updates its health.
+func (p *Pool) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			u.probe(ctx, p.cfg.CheckTimeout)
+		}(u)
+	}
+	wg.Wait()
+
+	var highest uint64
+	for _, u := range p.upstreams {
+		if s := u.snapshot(); s.Reachable && s.Height > highest {
+			highest = s.Height
+		}
+	}
+	for _, u := range p.upstreams {
+		u.setLagging(highest, p.cfg.MaxLag)
+	}
+}
+
+// Stats returns a snapshot of every upstream, in configuration order.
+func (p *Pool) Stats() []Stats {
+	stats := make([]Stats, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		stats = append(stats, u.snapshot())
+	}
+	return stats
+}
@@ -0,0 +1,254 @@
+package upstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// testNode is a JSON-RPC node at height that fails every request with status, if set.
+// It counts the calls of each method.
+type testNode struct {
+	height uint64
+	status int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	n.mu.Lock()
+	if n.calls == nil {
+		n.calls = make(map[string]int)
+	}
+	n.calls[req.Method]++
+	status := n.status
+	n.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	switch req.Method {
+	case "eth_blockNumber":
+		resp["result"] = hexutil.EncodeUint64(n.height)
+	case "eth_chainId":
+		resp["result"] = "0xc4"
+	case "eth_sendRawTransaction":
+		resp["result"] = "0x00000000000000000000000000000000000000000000000000000000000000b1"
+	default:
+		resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (n *testNode) count(method string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.calls[method]
+}
+
+// newTestPool serves each node and pools them in order. A nil node is a URL nothing
+// listens on.
+func newTestPool(t *testing.T, cfg Config, nodes ...*testNode) *Pool {
+	t.Helper()
+	urls := make([]string, len(nodes))
+	for i, node := range nodes {
+		if node == nil {
+			server := httptest.NewServer(http.NotFoundHandler())
+			server.Close()
+			urls[i] = server.URL
+			continue
+		}
+		server := httptest.NewServer(node)
+		t.Cleanup(server.Close)
+		urls[i] = server.URL
+	}
+
+	p, err := NewPool(urls, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func TestNewPool(t *testing.T) {
+	tests := []struct {
+		urls    []string
+		wantErr bool
+	}{
+		{urls: nil, wantErr: true},
+		{urls: []string{"ws://localhost:8546"}, wantErr: true},
+		{urls: []string{"http://localhost:8545", "/var/run/geth.ipc"}, wantErr: true},
+		{urls: []string{"http://localhost:8545", " https://rpc.example.com/key "}},
+	}
+	for _, tt := range tests {
+		p, err := NewPool(tt.urls, Config{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewPool(%q) error = %v, want error %v", tt.urls, err, tt.wantErr)
+			continue
+		}
+		if err == nil && len(p.Stats()) != len(tt.urls) {
+			t.Errorf("NewPool(%q) has %d upstreams", tt.urls, len(p.Stats()))
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  *testNode
+		method string
+		// Whether the call reaches the second node and succeeds there.
+		failover bool
+		wantErr  bool
+	}{
+		{name: "healthy node", first: &testNode{}, method: "eth_chainId"},
+		{name: "unreachable node", first: nil, method: "eth_chainId", failover: true},
+		{name: "5xx", first: &testNode{status: http.StatusBadGateway}, method: "eth_chainId", failover: true},
+		{name: "429", first: &testNode{status: http.StatusTooManyRequests}, method: "eth_chainId", failover: true},
+		{name: "4xx is the node's answer", first: &testNode{status: http.StatusBadRequest}, method: "eth_chainId", wantErr: true},
+		{name: "transaction on a 5xx", first: &testNode{status: http.StatusBadGateway}, method: "eth_sendRawTransaction", wantErr: true},
+		{name: "transaction on an unreachable node", first: nil, method: "eth_sendRawTransaction", wantErr: true},
+	}
+	for _, tt := range tests {
+		second := &testNode{}
+		p := newTestPool(t, Config{}, tt.first, second)
+
+		var err error
+		if tt.method == "eth_sendRawTransaction" {
+			var hash string
+			err = p.Client().Client().CallContext(t.Context(), &hash, "eth_sendRawTransaction", "0x01")
+		} else {
+			_, err = p.Client().ChainID(t.Context())
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %s error = %v, want error %v", tt.name, tt.method, err, tt.wantErr)
+		}
+		if got := second.count(tt.method) > 0; got != tt.failover {
+			t.Errorf("%s: %s reached the second node = %v, want %v", tt.name, tt.method, got, tt.failover)
+		}
+
+		stats := p.Stats()
+		wantFailovers := uint64(0)
+		if tt.failover {
+			wantFailovers = 1
+		}
+		if stats[1].Failovers != wantFailovers {
+			t.Errorf("%s: second node counted %d failovers, want %d", tt.name, stats[1].Failovers, wantFailovers)
+		}
+		if tt.first != nil && tt.first.status >= 500 && stats[0].Errors != 1 {
+			t.Errorf("%s: first node counted %d errors, want 1", tt.name, stats[0].Errors)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   []*testNode
+		healthy []bool
+	}{
+		{
+			name:    "all in sync",
+			nodes:   []*testNode{{height: 100}, {height: 100}},
+			healthy: []bool{true, true},
+		},
+		{
+			name:    "lag up to max_lag is tolerated",
+			nodes:   []*testNode{{height: 100}, {height: 95}, {height: 94}},
+			healthy: []bool{true, true, false},
+		},
+		{
+			name:    "unreachable node",
+			nodes:   []*testNode{nil, {height: 100}},
+			healthy: []bool{false, true},
+		},
+		{
+			name:    "failing node doesn't raise the highest block",
+			nodes:   []*testNode{{height: 200, status: http.StatusServiceUnavailable}, {height: 100}},
+			healthy: []bool{false, true},
+		},
+	}
+	for _, tt := range tests {
+		p := newTestPool(t, Config{MaxLag: 5, CheckTimeout: time.Second}, tt.nodes...)
+		p.Check(t.Context())
+
+		healthy := 0
+		for i, s := range p.Stats() {
+			if s.Healthy != tt.healthy[i] {
+				t.Errorf("%s: node %d healthy = %v, want %v", tt.name, i, s.Healthy, tt.healthy[i])
+			}
+			if s.Healthy {
+				healthy++
+			}
+		}
+		if got := len(p.Healthy()); got != healthy {
+			t.Errorf("%s: Healthy returned %d clients, want %d", tt.name, got, healthy)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	lagging, synced := &testNode{height: 90}, &testNode{height: 100}
+	p := newTestPool(t, Config{MaxLag: 5}, lagging, synced)
+	p.Check(t.Context())
+
+	// The lagging node comes first in the configuration but not in the pool's order.
+	pinned := p.Pin()
+	for i := 0; i < 3; i++ {
+		if _, err := pinned.ChainID(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lagging.count("eth_chainId") != 0 || synced.count("eth_chainId") != 3 {
+		t.Errorf("pinned calls reached the lagging node %d times, the synced one %d times, want 0 and 3",
+			lagging.count("eth_chainId"), synced.count("eth_chainId"))
+	}
+	if p.Pin() != pinned {
+		t.Error("Pin returned another client for the same upstream")
+	}
+
+	// A pinned client is never failed over, even when its node goes down.
+	synced.mu.Lock()
+	synced.status = http.StatusBadGateway
+	synced.mu.Unlock()
+	if _, err := pinned.ChainID(t.Context()); err == nil {
+		t.Error("pinned call to a failing node succeeded")
+	}
+	if lagging.count("eth_chainId") != 0 {
+		t.Error("pinned call failed over to another node")
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_call"}`, true},
+		{`[{"method":"eth_blockNumber"},{"method":"eth_getBalance"}]`, true},
+		{`{"method":"eth_sendRawTransaction"}`, false},
+		{`[{"method":"eth_blockNumber"},{"method":"eth_sendRawTransaction"}]`, false},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		if got := idempotent(rpcMethods([]byte(tt.body))); got != tt.want {
+			t.Errorf("idempotent(%s) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package upstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"eolia-common/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Methods that must not be sent twice: a retry on another node could broadcast
+// the same transaction twice or, worse, hide which node accepted it.
+var nonIdempotent = map[string]bool{
+	"eth_sendRawTransaction":            true,
+	"eth_sendTransaction":               true,
+	"eth_sendUserOperation":             true,
+	"eth_sendBundle":                    true,
+	"eth_sendRawTransactionConditional": true,
+}
+
+// failoverTransport sends each request to the best healthy upstream and retries
+// idempotent requests on the next one when the node can't be reached or answers 5xx/429.
+type failoverTransport struct {
+	pool *Pool
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := rpcMethods(body)
+	ctx, span := tracing.StartClient(req.Context(), spanName(methods), attribute.StringSlice("rpc.method", methods))
+	req = req.WithContext(ctx)
+
+	upstreams := t.pool.ordered()
+	if !idempotent(methods) {
+		upstreams = upstreams[:1]
+	}
+
+	var lastErr error
+	for i, u := range upstreams {
+		resp, err := send(req, u, body)
+		u.record(failedErr(err, resp), i > 0)
+		span.AddEvent("attempt", traceAttempt(u, resp, err))
+
+		last := i == len(upstreams)-1
+		if err == nil && (!retryable(resp) || last) {
+			span.SetAttributes(attribute.String("upstream", hostOf(u.URL)), attribute.Int("rpc.attempts", i+1))
+			tracing.End(span, nil)
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("%s answered %s", hostOf(u.URL), resp.Status)
+		}
+		lastErr = err
+
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+	tracing.End(span, sanitize(lastErr))
+	return nil, lastErr
+}
+
+// pinnedTransport sends every request to one upstream.
+type pinnedTransport struct {
+	upstream *Upstream
+}
+
+func (t *pinnedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := rpcMethods(body)
+	ctx, span := tracing.StartClient(req.Context(), spanName(methods),
+		attribute.StringSlice("rpc.method", methods), attribute.String("upstream", hostOf(t.upstream.URL)))
+	req = req.WithContext(ctx)
+
+	resp, err := send(req, t.upstream, body)
+	t.upstream.record(failedErr(err, resp), false)
+	tracing.End(span, sanitize(failedErr(err, resp)))
+	return resp, err
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return io.ReadAll(req.Body)
+}
+
+func send(req *http.Request, u *Upstream, body []byte) (*http.Response, error) {
+	target, err := url.Parse(u.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	out.URL = target
+	out.Host = target.Host
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+
+	return http.DefaultTransport.RoundTrip(out)
+}
+
+func retryable(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests)
+}
+
+func failedErr(err error, resp *http.Response) error {
+	if err != nil {
+		return err
+	}
+	if retryable(resp) {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// rpcMethods returns the methods of a (possibly batched) JSON-RPC body, or nil if it can't be parsed.
+func rpcMethods(body []byte) []string {
+	type call struct {
+		Method string `json:"method"`
+	}
+
+	var calls []call
+	if err := json.Unmarshal(body, &calls); err != nil {
+		var single call
+		if err := json.Unmarshal(body, &single); err != nil {
+			return nil
+		}
+		calls = []call{single}
+	}
+
+	methods := make([]string, len(calls))
+	for i, c := range calls {
+		methods[i] = c.Method
+	}
+	return methods
+}
+
+// idempotent reports whether every call of a JSON-RPC body may be retried.
+func idempotent(methods []string) bool {
+	if len(methods) == 0 {
+		return false
+	}
+	for _, m := range methods {
+		if nonIdempotent[m] {
+			return false
+		}
+	}
+	return true
+}
+
+func spanName(methods []string) string {
+	switch len(methods) {
+	case 0:
+		return "rpc"
+	case 1:
+		return "rpc " + methods[0]
+	default:
+		return "rpc batch"
+	}
+}
+
+func traceAttempt(u *Upstream, resp *http.Response, err error) trace.EventOption {
+	attrs := []attribute.KeyValue{attribute.String("upstream", hostOf(u.URL))}
+	if resp != nil {
+		attrs = append(attrs, attribute.Int("http.status_code", resp.StatusCode))
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", sanitize(err).Error()))
+	}
+	return trace.WithAttributes(attrs...)
+}
+
+// sanitize drops the request URL net/http puts in transport errors.
+func sanitize(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// hostOf keeps spans free of API keys carried in RPC URL paths.
+func hostOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid"
+	}
+	return u.Host
+}
@@ -0,0 +1,108 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Upstream is one RPC node of a pool.
+type Upstream struct {
+	URL    string
+	client *ethclient.Client
+	// pinned sends every request to this node only, see Pool.Pin.
+	pinned *ethclient.Client
+
+	mu        sync.Mutex
+	reachable bool
+	lagging   bool
+	height    uint64
+	latency   time.Duration
+	requests  uint64
+	errors    uint64
+	failovers uint64
+}
+
+// Stats is a point-in-time view of an upstream, for health endpoints and metrics.
+type Stats struct {
+	URL       string
+	Healthy   bool
+	Reachable bool
+	Height    uint64
+	Latency   time.Duration
+	Requests  uint64
+	Errors    uint64
+	Failovers uint64
+}
+
+// Host is the upstream's host, without the path or userinfo that may carry an API key.
+func (s Stats) Host() string {
+	return hostOf(s.URL)
+}
+
+func newUpstream(url string) (*Upstream, error) {
+	rpcClient, err := rpc.DialHTTP(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RPC URL %q: %w", url, err)
+	}
+	return &Upstream{URL: url, client: ethclient.NewClient(rpcClient), reachable: true}, nil
+}
+
+// probe fetches the node's head, recording its height and latency.
+func (u *Upstream) probe(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	height, err := u.client.BlockNumber(ctx)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reachable = err == nil
+	if err == nil {
+		u.height = height
+		u.latency = time.Since(start)
+	}
+}
+
+func (u *Upstream) setLagging(highest uint64, maxLag uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.lagging = u.height+maxLag < highest
+}
+
+// record accounts one request sent to the upstream.
+func (u *Upstream) record(err error, failover bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.requests++
+	if err != nil {
+		u.errors++
+	}
+	if failover {
+		u.failovers++
+	}
+}
+
+func (u *Upstream) snapshot() Stats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return Stats{
+		URL:       u.URL,
+		Healthy:   u.reachable && !u.lagging,
+		Reachable: u.reachable,
+		Height:    u.height,
+		Latency:   u.latency,
+		Requests:  u.requests,
+		Errors:    u.errors,
+		Failovers: u.failovers,
+	}
+}
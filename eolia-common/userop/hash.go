@@ -0,0 +1,102 @@
+package userop
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// EIP-712 constants used by the EntryPoint (see UserOperationLib and EntryPoint.getUserOpHash).
+var (
+	PACKED_USEROP_TYPEHASH = crypto.Keccak256Hash([]byte("PackedUserOperation(address sender,uint256 nonce,bytes initCode,bytes callData,bytes32 accountGasLimits,uint256 preVerificationGas,bytes32 gasFees,bytes paymasterAndData)"))
+	EIP712_DOMAIN_TYPEHASH = crypto.Keccak256Hash([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+	DOMAIN_NAME_HASH       = crypto.Keccak256Hash([]byte("ERC4337"))
+	DOMAIN_VERSION_HASH    = crypto.Keccak256Hash([]byte("1"))
+)
+
+// INITCODE_EIP7702_MARKER is the initCode prefix that flags an EIP-7702 account.
+var INITCODE_EIP7702_MARKER = []byte{0x77, 0x02}
+
+// IsEip7702InitCode reports whether initCode is an EIP-7702 authorization rather than factory data,
+// i.e. its first 20 bytes are 0x7702 padded with zeros.
+func IsEip7702InitCode(initCode []byte) bool {
+	if len(initCode) < 2 {
+		return false
+	}
+	var start [common.AddressLength]byte
+	copy(start[:], initCode)
+	var marker [common.AddressLength]byte
+	copy(marker[:], INITCODE_EIP7702_MARKER)
+	return start == marker
+}
+
+func word(b []byte) []byte {
+	return common.LeftPadBytes(b, 32)
+}
+
+// DomainSeparator is the EIP-712 domain separator of the EntryPoint at entryPoint on chainID.
+func DomainSeparator(entryPoint common.Address, chainID *big.Int) common.Hash {
+	return crypto.Keccak256Hash(
+		EIP712_DOMAIN_TYPEHASH[:],
+		DOMAIN_NAME_HASH[:],
+		DOMAIN_VERSION_HASH[:],
+		math.U256Bytes(new(big.Int).Set(chainID)),
+		word(entryPoint[:]),
+	)
+}
+
+// Encode is UserOperationLib.encode: the ABI encoding of the op's EIP-712 struct.
+// initCodeHash replaces keccak256(initCode) when non-zero (EIP-7702 accounts).
+func (op *PackedUserOperation) Encode(initCodeHash common.Hash) []byte {
+	if initCodeHash == (common.Hash{}) {
+		initCodeHash = crypto.Keccak256Hash(op.InitCode)
+	}
+
+	nonce, preVerificationGas := op.Nonce, op.PreVerificationGas
+	if nonce == nil {
+		nonce = new(big.Int)
+	}
+	if preVerificationGas == nil {
+		preVerificationGas = new(big.Int)
+	}
+
+	encoded := make([]byte, 0, 9*32)
+	encoded = append(encoded, PACKED_USEROP_TYPEHASH[:]...)
+	encoded = append(encoded, word(op.Sender[:])...)
+	encoded = append(encoded, math.U256Bytes(new(big.Int).Set(nonce))...)
+	encoded = append(encoded, initCodeHash[:]...)
+	encoded = append(encoded, crypto.Keccak256(op.CallData)...)
+	encoded = append(encoded, op.AccountGasLimits[:]...)
+	encoded = append(encoded, math.U256Bytes(new(big.Int).Set(preVerificationGas))...)
+	encoded = append(encoded, op.GasFees[:]...)
+	encoded = append(encoded, crypto.Keccak256(op.PaymasterAndData)...)
+	return encoded
+}
+
+// StructHash is UserOperationLib.hash: keccak256 of Encode.
+func (op *PackedUserOperation) StructHash(initCodeHash common.Hash) common.Hash {
+	return crypto.Keccak256Hash(op.Encode(initCodeHash))
+}
+
+// Hash computes the userOpHash exactly like EntryPoint.getUserOpHash, for ops that are not EIP-7702.
+// It needs no RPC: the EntryPoint address and chain id only enter through the EIP-712 domain.
+func (op *PackedUserOperation) Hash(entryPoint common.Address, chainID *big.Int) common.Hash {
+	return op.HashWithInitCodeOverride(entryPoint, chainID, common.Hash{})
+}
+
+// HashWithInitCodeOverride computes the userOpHash with initCodeHash in place of keccak256(initCode).
+func (op *PackedUserOperation) HashWithInitCodeOverride(entryPoint common.Address, chainID *big.Int, initCodeHash common.Hash) common.Hash {
+	domain := DomainSeparator(entryPoint, chainID)
+	structHash := op.StructHash(initCodeHash)
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, domain[:], structHash[:])
+}
+
+// Eip7702InitCodeHash is the initCode hash override of an EIP-7702 op whose sender delegates to delegate.
+func Eip7702InitCodeHash(delegate common.Address, initCode []byte) common.Hash {
+	if len(initCode) <= common.AddressLength {
+		return crypto.Keccak256Hash(delegate[:])
+	}
+	return crypto.Keccak256Hash(delegate[:], initCode[common.AddressLength:])
+}
@@ -0,0 +1,174 @@
+package userop
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// The EntryPoint v0.8 deployed on XLayer (eolia-contracts/deployments/xlayer/EntryPoint.json).
+var (
+	testEntryPoint = common.HexToAddress("0x379FF91b96c038ECb0dc6aCFb44366a39f0de566")
+	testChainID    = big.NewInt(196)
+)
+
+func mustPackUints(t *testing.T, high, low *big.Int) [32]byte {
+	t.Helper()
+	packed, err := PackUints(high, low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+// TestHashMatchesEntryPoint checks Hash against getUserOpHash of the deployed EntryPoint
+// bytecode, run at testEntryPoint on chain testChainID. The EIP-7702 cases delegate to
+// delegate.
+func TestHashMatchesEntryPoint(t *testing.T) {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	sender := common.HexToAddress("0xb6E4A1c4b8C7E1d2A3f40E5B6c7D8e9F0a1B2c3D")
+	sender7702 := common.HexToAddress("0x7702000000000000000000000000000000001234")
+	delegate := common.HexToAddress("0x5A7FC11397E9a8AD41BF10bf13F22B1a63eBc8ad")
+	factory := common.HexToAddress("0x91E60e0613810449d098b0b5Ec8b51A0FE8c8985")
+	marker := append([]byte{0x77, 0x02}, make([]byte, 18)...)
+
+	limits := mustPackUints(t, big.NewInt(150_000), big.NewInt(300_000))
+	fees := mustPackUints(t, big.NewInt(1_000_000_000), big.NewInt(30_000_000_000))
+	paymasterAndData, err := PackPaymasterAndData(&PaymasterFields{
+		Paymaster:                     common.HexToAddress("0x0000000000325602a77416A16136FDafd04b299f"),
+		PaymasterVerificationGasLimit: big.NewInt(100_000),
+		PaymasterPostOpGasLimit:       big.NewInt(50_000),
+		PaymasterData:                 hexutil.MustDecode("0xdeadbeef"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _ := new(big.Int).SetString("70000000000000005", 16)
+
+	tests := []struct {
+		name string
+		op   PackedUserOperation
+		want common.Hash
+	}{
+		{
+			name: "empty",
+			op:   PackedUserOperation{Sender: sender, Nonce: big.NewInt(0), PreVerificationGas: big.NewInt(0)},
+			want: common.HexToHash("0x6015040d2b739a8ca79befc24eddfb87b79d7ab32e9f28907df9e0befccca14b"),
+		},
+		{
+			name: "factory",
+			op: PackedUserOperation{
+				Sender:             sender,
+				Nonce:              nonce,
+				InitCode:           append(factory.Bytes(), hexutil.MustDecode("0x5fbfb9cf000000000000000000000000b6e4a1c4b8c7e1d2a3f40e5b6c7d8e9f0a1b2c3d0000000000000000000000000000000000000000000000000000000000000000")...),
+				CallData:           hexutil.MustDecode("0xb61d27f6"),
+				AccountGasLimits:   limits,
+				PreVerificationGas: big.NewInt(50_000),
+				GasFees:            fees,
+				Signature:          bytes.Repeat([]byte{0xff}, 65),
+			},
+			want: common.HexToHash("0x67896d4eafd63199d736f411be2c827dc9fc8ad9d9a7068f49546acb2a0c9cd2"),
+		},
+		{
+			name: "paymaster",
+			op: PackedUserOperation{
+				Sender:             sender,
+				Nonce:              big.NewInt(1),
+				CallData:           hexutil.MustDecode("0xb61d27f6"),
+				AccountGasLimits:   limits,
+				PreVerificationGas: big.NewInt(50_000),
+				GasFees:            fees,
+				PaymasterAndData:   paymasterAndData,
+			},
+			want: common.HexToHash("0xbda13bb3f67b5e0905a57c1ae24c42f093d5535c2584aea0a4f3d5949c45087b"),
+		},
+		{
+			name: "max values",
+			op: PackedUserOperation{
+				Sender:             sender,
+				Nonce:              maxUint256,
+				CallData:           bytes.Repeat([]byte{0xab, 0x00, 0xcd}, 400),
+				AccountGasLimits:   mustPackUints(t, maxUint128, maxUint128),
+				PreVerificationGas: maxUint256,
+				GasFees:            mustPackUints(t, maxUint128, maxUint128),
+				PaymasterAndData:   paymasterAndData,
+			},
+			want: common.HexToHash("0x469f2a3c00edca6d110e423dba4b0ffa39c311199b8b346fdc561a82539149dd"),
+		},
+		{
+			name: "eip-7702",
+			op: PackedUserOperation{
+				Sender:             sender7702,
+				Nonce:              big.NewInt(2),
+				InitCode:           marker,
+				CallData:           []byte{0x01},
+				AccountGasLimits:   limits,
+				PreVerificationGas: big.NewInt(50_000),
+				GasFees:            fees,
+			},
+			want: common.HexToHash("0x78b38d74bea005c6bced648f64e1c42cc3e83df6d0a26b57e4c563432651c891"),
+		},
+		{
+			name: "eip-7702 with init data",
+			op: PackedUserOperation{
+				Sender:             sender7702,
+				Nonce:              big.NewInt(3),
+				InitCode:           append(append([]byte{}, marker...), 0xc0, 0xff, 0xee),
+				CallData:           []byte{0x01},
+				AccountGasLimits:   limits,
+				PreVerificationGas: big.NewInt(50_000),
+				GasFees:            fees,
+			},
+			want: common.HexToHash("0x36c9212af6826f5e8999807f94942eb77150d54f1964603ac05d26e2a30b8432"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got common.Hash
+			if IsEip7702InitCode(tt.op.InitCode) {
+				got = tt.op.HashWithInitCodeOverride(testEntryPoint, testChainID, Eip7702InitCodeHash(delegate, tt.op.InitCode))
+			} else {
+				got = tt.op.Hash(testEntryPoint, testChainID)
+			}
+			if got != tt.want {
+				t.Errorf("hash = %s, want %s", got.Hex(), tt.want.Hex())
+			}
+		})
+	}
+}
+
+func TestHashDependsOnDomain(t *testing.T) {
+	op := PackedUserOperation{Sender: common.HexToAddress("0x01"), Nonce: big.NewInt(0), PreVerificationGas: big.NewInt(0)}
+	hash := op.Hash(testEntryPoint, testChainID)
+
+	if op.Hash(common.HexToAddress("0x4337084D9E255Ff0702461CF8895CE9E3b5Ff108"), testChainID) == hash {
+		t.Error("hash doesn't depend on the EntryPoint address")
+	}
+	if op.Hash(testEntryPoint, big.NewInt(1952)) == hash {
+		t.Error("hash doesn't depend on the chain id")
+	}
+}
+
+func TestIsEip7702InitCode(t *testing.T) {
+	tests := []struct {
+		initCode []byte
+		want     bool
+	}{
+		{nil, false},
+		{[]byte{0x77}, false},
+		{[]byte{0x77, 0x02}, true},
+		{append([]byte{0x77, 0x02}, make([]byte, 18)...), true},
+		{append(append([]byte{0x77, 0x02}, make([]byte, 18)...), 0x01), true},
+		{append([]byte{0x77, 0x02}, append(make([]byte, 17), 0x01)...), false},
+		{common.HexToAddress("0x91E60e0613810449d098b0b5Ec8b51A0FE8c8985").Bytes(), false},
+	}
+	for _, tt := range tests {
+		if got := IsEip7702InitCode(tt.initCode); got != tt.want {
+			t.Errorf("IsEip7702InitCode(%x) = %v, want %v", tt.initCode, got, tt.want)
+		}
+	}
+}
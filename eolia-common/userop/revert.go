@@ -0,0 +1,18 @@
+package userop
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// DecodeRevertReason turns the revertReason bytes of a UserOperationRevertReason event into
+// text: the message of Error(string), the code of Panic(uint256), or the raw hex otherwise.
+func DecodeRevertReason(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	return hexutil.Encode(data)
+}
@@ -0,0 +1,188 @@
+package userop
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// UserOperation is the unpacked ERC-4337 v0.7+ RPC representation of an op.
+// JSON decoding is strict: quantities must be 0x-prefixed hex without leading zeros,
+// addresses exactly 20 bytes and byte fields 0x-prefixed with an even length.
+type UserOperation struct {
+	// The Account making the UserOperation.
+	Sender common.Address `json:"sender"`
+
+	// Anti-replay parameter.
+	Nonce *hexutil.Big `json:"nonce"`
+
+	// Account Factory for new Accounts OR 0x7702 flag for EIP-7702 Accounts, otherwise absent.
+	Factory *common.Address `json:"factory,omitempty"`
+
+	// Data for the Account Factory if factory is provided OR EIP-7702 initialization data, or empty array.
+	FactoryData hexutil.Bytes `json:"factoryData,omitempty"`
+
+	// The data to pass to the sender during the main execution call.
+	CallData hexutil.Bytes `json:"callData"`
+
+	// The amount of gas to allocate the main execution call.
+	CallGasLimit *hexutil.Big `json:"callGasLimit"`
+
+	// The amount of gas to allocate for the verification step.
+	VerificationGasLimit *hexutil.Big `json:"verificationGasLimit"`
+
+	// Extra gas to pay the bundler.
+	PreVerificationGas *hexutil.Big `json:"preVerificationGas"`
+
+	// Maximum fee per gas.
+	MaxFeePerGas *hexutil.Big `json:"maxFeePerGas"`
+
+	// Maximum priority fee per gas.
+	MaxPriorityFeePerGas *hexutil.Big `json:"maxPriorityFeePerGas"`
+
+	// Address of paymaster contract, (or absent, if the sender pays for gas by itself).
+	Paymaster *common.Address `json:"paymaster,omitempty"`
+
+	// The amount of gas to allocate for the paymaster validation code (only if paymaster exists).
+	PaymasterVerificationGasLimit *hexutil.Big `json:"paymasterVerificationGasLimit,omitempty"`
+
+	// The amount of gas to allocate for the paymaster post-operation code (only if paymaster exists).
+	PaymasterPostOpGasLimit *hexutil.Big `json:"paymasterPostOpGasLimit,omitempty"`
+
+	// Data for paymaster (only if paymaster exists).
+	PaymasterData hexutil.Bytes `json:"paymasterData,omitempty"`
+
+	// Data passed into the sender to verify authorization
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+func required(name string, v *hexutil.Big) (*big.Int, error) {
+	if v == nil {
+		return nil, fmt.Errorf("missing %s", name)
+	}
+	return v.ToInt(), nil
+}
+
+// Pack converts the RPC representation into the PackedUserOperation the EntryPoint takes,
+// checking that every gas and fee value fits in its uint128 slot.
+func (op *UserOperation) Pack() (*PackedUserOperation, error) {
+	nonce, err := required("nonce", op.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if op.CallData == nil {
+		return nil, errors.New("missing callData")
+	}
+	if op.Signature == nil {
+		return nil, errors.New("missing signature")
+	}
+
+	values := []struct {
+		name  string
+		value *hexutil.Big
+	}{
+		{"callGasLimit", op.CallGasLimit},
+		{"verificationGasLimit", op.VerificationGasLimit},
+		{"preVerificationGas", op.PreVerificationGas},
+		{"maxFeePerGas", op.MaxFeePerGas},
+		{"maxPriorityFeePerGas", op.MaxPriorityFeePerGas},
+	}
+	for _, v := range values {
+		if _, err := required(v.name, v.value); err != nil {
+			return nil, err
+		}
+	}
+
+	accountGasLimits, err := PackAccountGasLimits(op.VerificationGasLimit.ToInt(), op.CallGasLimit.ToInt())
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas limits: %w", err)
+	}
+
+	gasFees, err := PackGasFees(op.MaxPriorityFeePerGas.ToInt(), op.MaxFeePerGas.ToInt())
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas fees: %w", err)
+	}
+
+	if op.Factory == nil && len(op.FactoryData) > 0 {
+		return nil, errors.New("factoryData given without factory")
+	}
+
+	var paymaster *PaymasterFields
+	if op.Paymaster != nil {
+		verificationGasLimit, err := required("paymasterVerificationGasLimit", op.PaymasterVerificationGasLimit)
+		if err != nil {
+			return nil, err
+		}
+		postOpGasLimit, err := required("paymasterPostOpGasLimit", op.PaymasterPostOpGasLimit)
+		if err != nil {
+			return nil, err
+		}
+		paymaster = &PaymasterFields{
+			Paymaster:                     *op.Paymaster,
+			PaymasterVerificationGasLimit: verificationGasLimit,
+			PaymasterPostOpGasLimit:       postOpGasLimit,
+			PaymasterData:                 op.PaymasterData,
+		}
+	} else if op.PaymasterVerificationGasLimit != nil || op.PaymasterPostOpGasLimit != nil || len(op.PaymasterData) > 0 {
+		return nil, errors.New("paymaster fields given without paymaster")
+	}
+
+	paymasterAndData, err := PackPaymasterAndData(paymaster)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackedUserOperation{
+		Sender:             op.Sender,
+		Nonce:              new(big.Int).Set(nonce),
+		InitCode:           PackInitCode(op.Factory, op.FactoryData),
+		CallData:           op.CallData,
+		AccountGasLimits:   accountGasLimits,
+		PreVerificationGas: new(big.Int).Set(op.PreVerificationGas.ToInt()),
+		GasFees:            gasFees,
+		PaymasterAndData:   paymasterAndData,
+		Signature:          op.Signature,
+	}, nil
+}
+
+// Unpack converts a PackedUserOperation into its RPC representation.
+func Unpack(packed *PackedUserOperation) (*UserOperation, error) {
+	factory, factoryData, err := UnpackInitCode(packed.InitCode)
+	if err != nil {
+		return nil, err
+	}
+
+	paymaster, err := UnpackPaymasterAndData(packed.PaymasterAndData)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationGasLimit, callGasLimit := UnpackAccountGasLimits(packed.AccountGasLimits)
+	maxPriorityFeePerGas, maxFeePerGas := UnpackGasFees(packed.GasFees)
+
+	op := &UserOperation{
+		Sender:               packed.Sender,
+		Nonce:                (*hexutil.Big)(new(big.Int).Set(packed.Nonce)),
+		Factory:              factory,
+		CallData:             packed.CallData,
+		CallGasLimit:         (*hexutil.Big)(callGasLimit),
+		VerificationGasLimit: (*hexutil.Big)(verificationGasLimit),
+		PreVerificationGas:   (*hexutil.Big)(new(big.Int).Set(packed.PreVerificationGas)),
+		MaxFeePerGas:         (*hexutil.Big)(maxFeePerGas),
+		MaxPriorityFeePerGas: (*hexutil.Big)(maxPriorityFeePerGas),
+		Signature:            packed.Signature,
+	}
+	if factory != nil {
+		op.FactoryData = factoryData
+	}
+	if paymaster != nil {
+		op.Paymaster = &paymaster.Paymaster
+		op.PaymasterVerificationGasLimit = (*hexutil.Big)(paymaster.PaymasterVerificationGasLimit)
+		op.PaymasterPostOpGasLimit = (*hexutil.Big)(paymaster.PaymasterPostOpGasLimit)
+		op.PaymasterData = paymaster.PaymasterData
+	}
+	return op, nil
+}
@@ -0,0 +1,143 @@
+package userop
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func testUserOp() *UserOperation {
+	factory := common.HexToAddress("0x91E60e0613810449d098b0b5Ec8b51A0FE8c8985")
+	paymaster := common.HexToAddress("0x0000000000325602a77416A16136FDafd04b299f")
+	return &UserOperation{
+		Sender:                        common.HexToAddress("0xb6E4A1c4b8C7E1d2A3f40E5B6c7D8e9F0a1B2c3D"),
+		Nonce:                         (*hexutil.Big)(big.NewInt(5)),
+		Factory:                       &factory,
+		FactoryData:                   hexutil.Bytes{0x5f, 0xbf, 0xb9, 0xcf},
+		CallData:                      hexutil.Bytes{0xb6, 0x1d, 0x27, 0xf6},
+		CallGasLimit:                  (*hexutil.Big)(big.NewInt(300_000)),
+		VerificationGasLimit:          (*hexutil.Big)(big.NewInt(150_000)),
+		PreVerificationGas:            (*hexutil.Big)(big.NewInt(50_000)),
+		MaxFeePerGas:                  (*hexutil.Big)(big.NewInt(30_000_000_000)),
+		MaxPriorityFeePerGas:          (*hexutil.Big)(big.NewInt(1_000_000_000)),
+		Paymaster:                     &paymaster,
+		PaymasterVerificationGasLimit: (*hexutil.Big)(big.NewInt(100_000)),
+		PaymasterPostOpGasLimit:       (*hexutil.Big)(big.NewInt(50_000)),
+		PaymasterData:                 hexutil.Bytes{0xde, 0xad, 0xbe, 0xef},
+		Signature:                     bytes.Repeat([]byte{0xff}, 65),
+	}
+}
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	withoutOptional := testUserOp()
+	withoutOptional.Factory, withoutOptional.FactoryData = nil, nil
+	withoutOptional.Paymaster, withoutOptional.PaymasterVerificationGasLimit, withoutOptional.PaymasterPostOpGasLimit, withoutOptional.PaymasterData = nil, nil, nil, nil
+
+	for name, op := range map[string]*UserOperation{"full": testUserOp(), "no factory or paymaster": withoutOptional} {
+		t.Run(name, func(t *testing.T) {
+			packed, err := op.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			unpacked, err := Unpack(packed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, _ := json.Marshal(op)
+			got, _ := json.Marshal(unpacked)
+			if !bytes.Equal(got, want) {
+				t.Errorf("Unpack(Pack(op)) = %s, want %s", got, want)
+			}
+
+			repacked, err := unpacked.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(repacked, packed) {
+				t.Errorf("Pack(Unpack(packed)) = %+v, want %+v", repacked, packed)
+			}
+		})
+	}
+}
+
+func TestPackFields(t *testing.T) {
+	op := testUserOp()
+	packed, err := op.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(packed.InitCode, append(op.Factory.Bytes(), op.FactoryData...)) {
+		t.Errorf("initCode = %x", packed.InitCode)
+	}
+	verificationGasLimit, callGasLimit := UnpackAccountGasLimits(packed.AccountGasLimits)
+	if verificationGasLimit.Cmp(op.VerificationGasLimit.ToInt()) != 0 || callGasLimit.Cmp(op.CallGasLimit.ToInt()) != 0 {
+		t.Errorf("accountGasLimits = %x", packed.AccountGasLimits)
+	}
+	maxPriorityFeePerGas, maxFeePerGas := UnpackGasFees(packed.GasFees)
+	if maxPriorityFeePerGas.Cmp(op.MaxPriorityFeePerGas.ToInt()) != 0 || maxFeePerGas.Cmp(op.MaxFeePerGas.ToInt()) != 0 {
+		t.Errorf("gasFees = %x", packed.GasFees)
+	}
+	if !bytes.HasPrefix(packed.PaymasterAndData, op.Paymaster.Bytes()) || !bytes.HasSuffix(packed.PaymasterAndData, op.PaymasterData) {
+		t.Errorf("paymasterAndData = %x", packed.PaymasterAndData)
+	}
+}
+
+func TestPackRejectsInvalidOps(t *testing.T) {
+	tooLarge := (*hexutil.Big)(new(big.Int).Add(maxUint128, big.NewInt(1)))
+	tests := []struct {
+		name   string
+		modify func(op *UserOperation)
+		want   string
+	}{
+		{"missing nonce", func(op *UserOperation) { op.Nonce = nil }, "missing nonce"},
+		{"missing callData", func(op *UserOperation) { op.CallData = nil }, "missing callData"},
+		{"missing signature", func(op *UserOperation) { op.Signature = nil }, "missing signature"},
+		{"missing callGasLimit", func(op *UserOperation) { op.CallGasLimit = nil }, "missing callGasLimit"},
+		{"missing maxFeePerGas", func(op *UserOperation) { op.MaxFeePerGas = nil }, "missing maxFeePerGas"},
+		{"gas limit over uint128", func(op *UserOperation) { op.VerificationGasLimit = tooLarge }, "invalid gas limits"},
+		{"fee over uint128", func(op *UserOperation) { op.MaxFeePerGas = tooLarge }, "invalid gas fees"},
+		{"factoryData without factory", func(op *UserOperation) { op.Factory = nil }, "factoryData given without factory"},
+		{"missing paymaster gas limit", func(op *UserOperation) { op.PaymasterPostOpGasLimit = nil }, "missing paymasterPostOpGasLimit"},
+		{"paymaster fields without paymaster", func(op *UserOperation) { op.Paymaster = nil }, "paymaster fields given without paymaster"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testUserOp()
+			tt.modify(op)
+			_, err := op.Pack()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Pack() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserOperationJSONRoundTrip(t *testing.T) {
+	op := testUserOp()
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded UserOperation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, op) {
+		t.Errorf("json round trip = %+v, want %+v", decoded, op)
+	}
+
+	for _, bad := range []string{`"0x05"`, `"5"`, `"0x"`} {
+		malformed := strings.Replace(string(data), `"nonce":"0x5"`, `"nonce":`+bad, 1)
+		if err := json.Unmarshal([]byte(malformed), &decoded); err == nil {
+			t.Errorf("nonce %s was accepted", bad)
+		}
+	}
+}
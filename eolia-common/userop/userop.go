@@ -0,0 +1,196 @@
+// Package userop packs, unpacks and hashes ERC-4337 PackedUserOperations the way the
+// EntryPoint in eolia-contracts does, without any RPC. It is shared by eolia-signer and eolia-bundlr.
+package userop
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Byte offsets in paymasterAndData, from UserOperationLib.
+const (
+	PAYMASTER_VALIDATION_GAS_OFFSET = 20
+	PAYMASTER_POSTOP_GAS_OFFSET     = 36
+	PAYMASTER_DATA_OFFSET           = 52
+)
+
+var maxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// PackedUserOperation represents a single ERC-4337 operation request in Entrypoint.
+// This struct matches the spec from:
+// https://eips.ethereum.org/EIPS/eip-4337
+type PackedUserOperation struct {
+	// The Account making the UserOperation.
+	Sender common.Address `json:"sender"`
+
+	// Anti-replay parameter.
+	Nonce *big.Int `json:"nonce"`
+
+	// Concatenation of factory address and factoryData (or empty), or EIP-7702 data.
+	InitCode []byte `json:"initCode"`
+
+	// The data to pass to the sender during the main execution call.
+	CallData []byte `json:"callData"`
+
+	// Concatenation of verificationGasLimit (16 bytes) and callGasLimit (16 bytes).
+	AccountGasLimits [32]byte `json:"accountGasLimits"`
+
+	// Extra gas to pay the bundler.
+	PreVerificationGas *big.Int `json:"preVerificationGas"`
+
+	// Concatenation of maxPriorityFeePerGas (16 bytes) and maxFeePerGas (16 bytes).
+	GasFees [32]byte `json:"gasFees"`
+
+	// Concatenation of paymaster fields (or empty).
+	PaymasterAndData []byte `json:"paymasterAndData"`
+
+	// Data passed into the sender to verify authorization
+	Signature []byte `json:"signature"`
+}
+
+// PackUints packs two uint128 values into one bytes32, high first.
+func PackUints(high, low *big.Int) ([32]byte, error) {
+	var packed [32]byte
+	if err := checkUint128(high); err != nil {
+		return packed, err
+	}
+	if err := checkUint128(low); err != nil {
+		return packed, err
+	}
+	high.FillBytes(packed[:16])
+	low.FillBytes(packed[16:])
+	return packed, nil
+}
+
+// UnpackUints splits a bytes32 into its high and low uint128 values.
+func UnpackUints(packed [32]byte) (high, low *big.Int) {
+	return new(big.Int).SetBytes(packed[:16]), new(big.Int).SetBytes(packed[16:])
+}
+
+func checkUint128(v *big.Int) error {
+	if v == nil || v.Sign() < 0 || v.Cmp(maxUint128) > 0 {
+		return fmt.Errorf("value %v does not fit in uint128", v)
+	}
+	return nil
+}
+
+// PackAccountGasLimits builds accountGasLimits from verificationGasLimit and callGasLimit.
+func PackAccountGasLimits(verificationGasLimit, callGasLimit *big.Int) ([32]byte, error) {
+	return PackUints(verificationGasLimit, callGasLimit)
+}
+
+// UnpackAccountGasLimits returns verificationGasLimit and callGasLimit.
+func UnpackAccountGasLimits(accountGasLimits [32]byte) (verificationGasLimit, callGasLimit *big.Int) {
+	return UnpackUints(accountGasLimits)
+}
+
+// PackGasFees builds gasFees from maxPriorityFeePerGas and maxFeePerGas.
+func PackGasFees(maxPriorityFeePerGas, maxFeePerGas *big.Int) ([32]byte, error) {
+	return PackUints(maxPriorityFeePerGas, maxFeePerGas)
+}
+
+// UnpackGasFees returns maxPriorityFeePerGas and maxFeePerGas.
+func UnpackGasFees(gasFees [32]byte) (maxPriorityFeePerGas, maxFeePerGas *big.Int) {
+	return UnpackUints(gasFees)
+}
+
+// PackInitCode concatenates the factory address and factoryData. No factory means empty initCode.
+func PackInitCode(factory *common.Address, factoryData []byte) []byte {
+	if factory == nil {
+		return []byte{}
+	}
+	return append(factory.Bytes(), factoryData...)
+}
+
+// UnpackInitCode splits initCode into factory and factoryData. Empty initCode means no factory.
+func UnpackInitCode(initCode []byte) (*common.Address, []byte, error) {
+	if len(initCode) == 0 {
+		return nil, []byte{}, nil
+	}
+	if len(initCode) < common.AddressLength {
+		return nil, nil, fmt.Errorf("initCode too short: %d bytes", len(initCode))
+	}
+	factory := common.BytesToAddress(initCode[:common.AddressLength])
+	return &factory, initCode[common.AddressLength:], nil
+}
+
+// PaymasterFields are the unpacked parts of paymasterAndData.
+type PaymasterFields struct {
+	Paymaster                     common.Address
+	PaymasterVerificationGasLimit *big.Int
+	PaymasterPostOpGasLimit       *big.Int
+	PaymasterData                 []byte
+}
+
+// PackPaymasterAndData encodes the paymaster fields. A nil paymaster means empty paymasterAndData.
+func PackPaymasterAndData(fields *PaymasterFields) ([]byte, error) {
+	if fields == nil {
+		return []byte{}, nil
+	}
+	if err := checkUint128(fields.PaymasterVerificationGasLimit); err != nil {
+		return nil, fmt.Errorf("paymasterVerificationGasLimit: %w", err)
+	}
+	if err := checkUint128(fields.PaymasterPostOpGasLimit); err != nil {
+		return nil, fmt.Errorf("paymasterPostOpGasLimit: %w", err)
+	}
+
+	packed := make([]byte, PAYMASTER_DATA_OFFSET, PAYMASTER_DATA_OFFSET+len(fields.PaymasterData))
+	copy(packed, fields.Paymaster[:])
+	fields.PaymasterVerificationGasLimit.FillBytes(packed[PAYMASTER_VALIDATION_GAS_OFFSET:PAYMASTER_POSTOP_GAS_OFFSET])
+	fields.PaymasterPostOpGasLimit.FillBytes(packed[PAYMASTER_POSTOP_GAS_OFFSET:PAYMASTER_DATA_OFFSET])
+	return append(packed, fields.PaymasterData...), nil
+}
+
+// UnpackPaymasterAndData decodes paymasterAndData. Empty paymasterAndData returns nil fields.
+func UnpackPaymasterAndData(paymasterAndData []byte) (*PaymasterFields, error) {
+	if len(paymasterAndData) == 0 {
+		return nil, nil
+	}
+	if len(paymasterAndData) < PAYMASTER_DATA_OFFSET {
+		return nil, fmt.Errorf("paymasterAndData too short: %d bytes, need at least %d", len(paymasterAndData), PAYMASTER_DATA_OFFSET)
+	}
+	return &PaymasterFields{
+		Paymaster:                     common.BytesToAddress(paymasterAndData[:PAYMASTER_VALIDATION_GAS_OFFSET]),
+		PaymasterVerificationGasLimit: new(big.Int).SetBytes(paymasterAndData[PAYMASTER_VALIDATION_GAS_OFFSET:PAYMASTER_POSTOP_GAS_OFFSET]),
+		PaymasterPostOpGasLimit:       new(big.Int).SetBytes(paymasterAndData[PAYMASTER_POSTOP_GAS_OFFSET:PAYMASTER_DATA_OFFSET]),
+		PaymasterData:                 paymasterAndData[PAYMASTER_DATA_OFFSET:],
+	}, nil
+}
+
+// TotalGasLimit is the most gas the op may consume in a bundle:
+// preVerificationGas + verificationGasLimit + callGasLimit + paymaster gas limits.
+func (op *PackedUserOperation) TotalGasLimit() uint64 {
+	total := new(big.Int).Set(op.PreVerificationGas)
+	verificationGasLimit, callGasLimit := UnpackAccountGasLimits(op.AccountGasLimits)
+	total.Add(total, verificationGasLimit)
+	total.Add(total, callGasLimit)
+	if pm, err := UnpackPaymasterAndData(op.PaymasterAndData); err == nil && pm != nil {
+		total.Add(total, pm.PaymasterVerificationGasLimit)
+		total.Add(total, pm.PaymasterPostOpGasLimit)
+	}
+	if !total.IsUint64() {
+		return ^uint64(0)
+	}
+	return total.Uint64()
+}
+
+// Calldata gas per EIP-2028.
+const (
+	ZERO_BYTE_GAS     = 4
+	NON_ZERO_BYTE_GAS = 16
+)
+
+// CalldataGas is what data costs as transaction calldata.
+func CalldataGas(data []byte) uint64 {
+	var gas uint64
+	for _, b := range data {
+		if b == 0 {
+			gas += ZERO_BYTE_GAS
+		} else {
+			gas += NON_ZERO_BYTE_GAS
+		}
+	}
+	return gas
+}
@@ -0,0 +1,173 @@
+package userop
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPackUnpackUints(t *testing.T) {
+	tests := []struct {
+		high, low *big.Int
+	}{
+		{big.NewInt(0), big.NewInt(0)},
+		{big.NewInt(150_000), big.NewInt(300_000)},
+		{maxUint128, big.NewInt(1)},
+		{big.NewInt(1), maxUint128},
+		{maxUint128, maxUint128},
+	}
+	for _, tt := range tests {
+		packed, err := PackUints(tt.high, tt.low)
+		if err != nil {
+			t.Fatalf("PackUints(%v, %v): %v", tt.high, tt.low, err)
+		}
+		high, low := UnpackUints(packed)
+		if high.Cmp(tt.high) != 0 || low.Cmp(tt.low) != 0 {
+			t.Errorf("UnpackUints(PackUints(%v, %v)) = %v, %v", tt.high, tt.low, high, low)
+		}
+	}
+}
+
+func TestPackUintsLayout(t *testing.T) {
+	limits, err := PackAccountGasLimits(big.NewInt(0x0102), big.NewInt(0x0304))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [32]byte
+	want[14], want[15], want[30], want[31] = 0x01, 0x02, 0x03, 0x04
+	if limits != want {
+		t.Errorf("accountGasLimits = %x, want %x", limits, want)
+	}
+
+	verificationGasLimit, callGasLimit := UnpackAccountGasLimits(limits)
+	if verificationGasLimit.Int64() != 0x0102 || callGasLimit.Int64() != 0x0304 {
+		t.Errorf("UnpackAccountGasLimits = %v, %v", verificationGasLimit, callGasLimit)
+	}
+
+	fees, err := PackGasFees(big.NewInt(1), big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxPriorityFeePerGas, maxFeePerGas := UnpackGasFees(fees)
+	if maxPriorityFeePerGas.Int64() != 1 || maxFeePerGas.Int64() != 2 {
+		t.Errorf("UnpackGasFees = %v, %v", maxPriorityFeePerGas, maxFeePerGas)
+	}
+}
+
+func TestPackUintsRejectsOutOfRange(t *testing.T) {
+	tooLarge := new(big.Int).Add(maxUint128, big.NewInt(1))
+	for _, v := range []*big.Int{nil, big.NewInt(-1), tooLarge} {
+		if _, err := PackUints(v, big.NewInt(0)); err == nil {
+			t.Errorf("PackUints(%v, 0) succeeded", v)
+		}
+		if _, err := PackUints(big.NewInt(0), v); err == nil {
+			t.Errorf("PackUints(0, %v) succeeded", v)
+		}
+	}
+}
+
+func TestPackUnpackInitCode(t *testing.T) {
+	factory := common.HexToAddress("0x91E60e0613810449d098b0b5Ec8b51A0FE8c8985")
+	tests := []struct {
+		name        string
+		factory     *common.Address
+		factoryData []byte
+	}{
+		{"none", nil, []byte{}},
+		{"factory only", &factory, []byte{}},
+		{"factory and data", &factory, []byte{0x5f, 0xbf, 0xb9, 0xcf, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initCode := PackInitCode(tt.factory, tt.factoryData)
+			gotFactory, gotData, err := UnpackInitCode(initCode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (gotFactory == nil) != (tt.factory == nil) || (gotFactory != nil && *gotFactory != *tt.factory) {
+				t.Errorf("factory = %v, want %v", gotFactory, tt.factory)
+			}
+			if !bytes.Equal(gotData, tt.factoryData) {
+				t.Errorf("factoryData = %x, want %x", gotData, tt.factoryData)
+			}
+		})
+	}
+
+	if _, _, err := UnpackInitCode(factory[:19]); err == nil {
+		t.Error("UnpackInitCode accepted an initCode shorter than an address")
+	}
+}
+
+func TestPackUnpackPaymasterAndData(t *testing.T) {
+	fields := &PaymasterFields{
+		Paymaster:                     common.HexToAddress("0x0000000000325602a77416A16136FDafd04b299f"),
+		PaymasterVerificationGasLimit: big.NewInt(100_000),
+		PaymasterPostOpGasLimit:       maxUint128,
+		PaymasterData:                 []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	packed, err := PackPaymasterAndData(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != PAYMASTER_DATA_OFFSET+len(fields.PaymasterData) {
+		t.Fatalf("len(paymasterAndData) = %d", len(packed))
+	}
+
+	got, err := UnpackPaymasterAndData(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Paymaster != fields.Paymaster ||
+		got.PaymasterVerificationGasLimit.Cmp(fields.PaymasterVerificationGasLimit) != 0 ||
+		got.PaymasterPostOpGasLimit.Cmp(fields.PaymasterPostOpGasLimit) != 0 ||
+		!bytes.Equal(got.PaymasterData, fields.PaymasterData) {
+		t.Errorf("UnpackPaymasterAndData(PackPaymasterAndData(%+v)) = %+v", fields, got)
+	}
+
+	if empty, err := PackPaymasterAndData(nil); err != nil || len(empty) != 0 {
+		t.Errorf("PackPaymasterAndData(nil) = %x, %v", empty, err)
+	}
+	if none, err := UnpackPaymasterAndData(nil); err != nil || none != nil {
+		t.Errorf("UnpackPaymasterAndData(nil) = %+v, %v", none, err)
+	}
+	if _, err := UnpackPaymasterAndData(packed[:PAYMASTER_DATA_OFFSET-1]); err == nil {
+		t.Error("UnpackPaymasterAndData accepted truncated gas limits")
+	}
+
+	fields.PaymasterVerificationGasLimit = new(big.Int).Add(maxUint128, big.NewInt(1))
+	if _, err := PackPaymasterAndData(fields); err == nil {
+		t.Error("PackPaymasterAndData accepted a gas limit over uint128")
+	}
+}
+
+func TestTotalGasLimit(t *testing.T) {
+	limits, _ := PackAccountGasLimits(big.NewInt(150_000), big.NewInt(300_000))
+	paymasterAndData, _ := PackPaymasterAndData(&PaymasterFields{
+		PaymasterVerificationGasLimit: big.NewInt(100_000),
+		PaymasterPostOpGasLimit:       big.NewInt(50_000),
+	})
+	op := &PackedUserOperation{AccountGasLimits: limits, PreVerificationGas: big.NewInt(50_000)}
+	if got := op.TotalGasLimit(); got != 500_000 {
+		t.Errorf("TotalGasLimit = %d, want 500000", got)
+	}
+	op.PaymasterAndData = paymasterAndData
+	if got := op.TotalGasLimit(); got != 650_000 {
+		t.Errorf("TotalGasLimit with paymaster = %d, want 650000", got)
+	}
+
+	op.AccountGasLimits, _ = PackAccountGasLimits(maxUint128, maxUint128)
+	if got := op.TotalGasLimit(); got != ^uint64(0) {
+		t.Errorf("TotalGasLimit = %d, want saturation at max uint64", got)
+	}
+}
+
+func TestCalldataGas(t *testing.T) {
+	if got := CalldataGas([]byte{0x00, 0x01, 0x00, 0xff}); got != 2*ZERO_BYTE_GAS+2*NON_ZERO_BYTE_GAS {
+		t.Errorf("CalldataGas = %d", got)
+	}
+	if got := CalldataGas(nil); got != 0 {
+		t.Errorf("CalldataGas(nil) = %d", got)
+	}
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.28;
+
+/* solhint-disable reason-string */
+
+import "@openzeppelin/contracts/token/ERC20/IERC20.sol";
+import "@openzeppelin/contracts/token/ERC20/utils/SafeERC20.sol";
+import "@openzeppelin/contracts/utils/cryptography/ECDSA.sol";
+import "@openzeppelin/contracts/utils/cryptography/MessageHashUtils.sol";
+import "../core/BasePaymaster.sol";
+import "../core/Helpers.sol";
+import "../core/UserOperationLib.sol";
+
+/**
+ * A paymaster that lets accounts pay for gas in an ERC-20 token.
+ * The off-chain signer (eolia-signer's paymaster service) quotes an exchange rate for the
+ * op's token and signs it with the op. The paymaster pays the EntryPoint in native tokens.
+ *
+ * The account is charged during validation: the op's maximum gas cost (requiredPreFund)
+ * converted at the signed rate is taken with transferFrom, before the op's calls run, so
+ * they can't move the tokens away or revoke the allowance first. postOp refunds what the
+ * actual gas cost didn't use. The account must therefore have approved the paymaster
+ * before the op is validated.
+ *
+ * It returns a context and writes token balances during validation, so it must be staked
+ * in the EntryPoint (addStake).
+ *
+ * paymasterAndData layout, after the paymaster address and gas limits (PAYMASTER_DATA_OFFSET):
+ *   abi.encode(address token, uint256 exchangeRate, uint48 validUntil, uint48 validAfter) || signature (65 bytes)
+ * exchangeRate is the token amount, in its smallest unit, worth 1e18 wei.
+ */
+contract TokenPaymaster is BasePaymaster {
+    using UserOperationLib for PackedUserOperation;
+    using SafeERC20 for IERC20;
+
+    // Immutable: the signer is read during validation (ERC-7562).
+    address public immutable verifyingSigner;
+
+    uint256 private constant TOKEN_DATA_OFFSET = PAYMASTER_DATA_OFFSET;
+    uint256 private constant SIGNATURE_OFFSET = TOKEN_DATA_OFFSET + 128;
+    uint256 private constant RATE_DENOMINATOR = 1e18;
+
+    /**
+     * What validation passes to postOp.
+     */
+    struct ChargeContext {
+        bytes32 userOpHash;
+        address sender;
+        address token;
+        uint256 exchangeRate;
+        uint256 maxTokenCost;
+        uint256 postOpGasLimit;
+    }
+
+    /**
+     * The account paid tokenAmount of token for the gas of userOpHash, after its refund.
+     */
+    event TokenCharged(
+        bytes32 indexed userOpHash,
+        address indexed sender,
+        address indexed token,
+        uint256 tokenAmount,
+        uint256 exchangeRate,
+        uint256 actualGasCost
+    );
+
+    constructor(IEntryPoint _entryPoint, address _verifyingSigner) BasePaymaster(_entryPoint) {
+        require(_verifyingSigner != address(0), "TokenPaymaster: signer is zero");
+        verifyingSigner = _verifyingSigner;
+    }
+
+    /**
+     * The hash the verifying signer signs (as an eth_sign message): every field of the op but
+     * its signature and the paymaster signature, the quote, this paymaster and the chain.
+     */
+    function getHash(
+        PackedUserOperation calldata userOp,
+        address token,
+        uint256 exchangeRate,
+        uint48 validUntil,
+        uint48 validAfter
+    ) public view returns (bytes32) {
+        return keccak256(
+            abi.encode(
+                userOp.sender,
+                userOp.nonce,
+                keccak256(userOp.initCode),
+                keccak256(userOp.callData),
+                userOp.accountGasLimits,
+                uint256(bytes32(userOp.paymasterAndData[PAYMASTER_VALIDATION_GAS_OFFSET : PAYMASTER_DATA_OFFSET])),
+                userOp.preVerificationGas,
+                userOp.gasFees,
+                block.chainid,
+                address(this),
+                token,
+                exchangeRate,
+                validUntil,
+                validAfter
+            )
+        );
+    }
+
+    function _validatePaymasterUserOp(
+        PackedUserOperation calldata userOp,
+        bytes32 userOpHash,
+        uint256 requiredPreFund
+    ) internal override returns (bytes memory context, uint256 validationData) {
+        (address token, uint256 exchangeRate, uint48 validUntil, uint48 validAfter, bytes calldata signature) =
+            parsePaymasterAndData(userOp.paymasterAndData);
+        require(signature.length == 65, "TokenPaymaster: invalid signature length in paymasterAndData");
+        require(exchangeRate != 0, "TokenPaymaster: zero exchange rate");
+
+        {
+            bytes32 hash = MessageHashUtils.toEthSignedMessageHash(getHash(userOp, token, exchangeRate, validUntil, validAfter));
+            (address recovered, ECDSA.RecoverError error, ) = ECDSA.tryRecover(hash, signature);
+            if (error != ECDSA.RecoverError.NoError || recovered != verifyingSigner) {
+                return ("", _packValidationData(true, validUntil, validAfter));
+            }
+        }
+
+        uint256 maxTokenCost = _tokenAmount(requiredPreFund, exchangeRate);
+        IERC20(token).safeTransferFrom(userOp.sender, address(this), maxTokenCost);
+
+        context = abi.encode(
+            ChargeContext(userOpHash, userOp.sender, token, exchangeRate, maxTokenCost, userOp.unpackPostOpGasLimit())
+        );
+        return (context, _packValidationData(false, validUntil, validAfter));
+    }
+
+    /**
+     * Settles the charge taken during validation: the account pays the actual gas cost, plus
+     * the gas of this postOp (estimated at its limit), at the signed exchange rate, rounded up
+     * and capped at the pre-charge; the rest is refunded.
+     */
+    function _postOp(
+        PostOpMode mode,
+        bytes calldata context,
+        uint256 actualGasCost,
+        uint256 actualUserOpFeePerGas
+    ) internal override {
+        (mode);
+        ChargeContext memory charge = abi.decode(context, (ChargeContext));
+
+        uint256 gasCost = actualGasCost + charge.postOpGasLimit * actualUserOpFeePerGas;
+        uint256 tokenAmount = _tokenAmount(gasCost, charge.exchangeRate);
+        if (tokenAmount > charge.maxTokenCost) {
+            tokenAmount = charge.maxTokenCost;
+        }
+
+        if (tokenAmount < charge.maxTokenCost) {
+            IERC20(charge.token).safeTransfer(charge.sender, charge.maxTokenCost - tokenAmount);
+        }
+        emit TokenCharged(charge.userOpHash, charge.sender, charge.token, tokenAmount, charge.exchangeRate, gasCost);
+    }
+
+    /**
+     * gasCost (wei) in token units at exchangeRate, rounded up.
+     */
+    function _tokenAmount(uint256 gasCost, uint256 exchangeRate) internal pure returns (uint256) {
+        return (gasCost * exchangeRate + RATE_DENOMINATOR - 1) / RATE_DENOMINATOR;
+    }
+
+    function parsePaymasterAndData(
+        bytes calldata paymasterAndData
+    )
+        public
+        pure
+        returns (address token, uint256 exchangeRate, uint48 validUntil, uint48 validAfter, bytes calldata signature)
+    {
+        (token, exchangeRate, validUntil, validAfter) =
+            abi.decode(paymasterAndData[TOKEN_DATA_OFFSET : SIGNATURE_OFFSET], (address, uint256, uint48, uint48));
+        signature = paymasterAndData[SIGNATURE_OFFSET:];
+    }
+
+    /**
+     * Withdraw the tokens collected from the accounts.
+     */
+    function withdrawToken(IERC20 token, address to, uint256 amount) external onlyOwner {
+        token.safeTransfer(to, amount);
+    }
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.28;
+
+/* solhint-disable reason-string */
+
+import "@openzeppelin/contracts/utils/cryptography/ECDSA.sol";
+import "@openzeppelin/contracts/utils/cryptography/MessageHashUtils.sol";
+import "../core/BasePaymaster.sol";
+import "../core/Helpers.sol";
+import "../core/UserOperationLib.sol";
+
+/**
+ * A paymaster that sponsors the ops approved by an off-chain signer.
+ * The signer (eolia-signer's paymaster service) evaluates its sponsorship policies, then signs
+ * the op together with a validity window. This contract only checks that signature; it pays
+ * from its EntryPoint deposit, so the owner must keep it funded (deposit()).
+ *
+ * paymasterAndData layout, after the paymaster address and gas limits (PAYMASTER_DATA_OFFSET):
+ *   abi.encode(uint48 validUntil, uint48 validAfter) || signature (65 bytes)
+ */
+contract VerifyingPaymaster is BasePaymaster {
+    using UserOperationLib for PackedUserOperation;
+
+    // Immutable: an unstaked paymaster may not read its own storage during validation (ERC-7562).
+    // Rotating the signer means deploying a new paymaster.
+    address public immutable verifyingSigner;
+
+    uint256 private constant VALID_TIMESTAMP_OFFSET = PAYMASTER_DATA_OFFSET;
+    uint256 private constant SIGNATURE_OFFSET = VALID_TIMESTAMP_OFFSET + 64;
+
+    constructor(IEntryPoint _entryPoint, address _verifyingSigner) BasePaymaster(_entryPoint) {
+        require(_verifyingSigner != address(0), "VerifyingPaymaster: signer is zero");
+        verifyingSigner = _verifyingSigner;
+    }
+
+    /**
+     * The hash the verifying signer signs (as an eth_sign message).
+     * It covers every field of the op but its signature and the paymaster signature itself,
+     * including the paymaster gas limits, and is bound to this paymaster and chain.
+     * The nonce makes a signature single-use.
+     */
+    function getHash(
+        PackedUserOperation calldata userOp,
+        uint48 validUntil,
+        uint48 validAfter
+    ) public view returns (bytes32) {
+        return keccak256(
+            abi.encode(
+                userOp.sender,
+                userOp.nonce,
+                keccak256(userOp.initCode),
+                keccak256(userOp.callData),
+                userOp.accountGasLimits,
+                uint256(bytes32(userOp.paymasterAndData[PAYMASTER_VALIDATION_GAS_OFFSET : PAYMASTER_DATA_OFFSET])),
+                userOp.preVerificationGas,
+                userOp.gasFees,
+                block.chainid,
+                address(this),
+                validUntil,
+                validAfter
+            )
+        );
+    }
+
+    /**
+     * Accepts the op when paymasterAndData carries a signature of getHash by verifyingSigner.
+     * A wrong signature is reported as SIG_VALIDATION_FAILED rather than reverted, so that
+     * simulation can tell it apart from a malformed op.
+     */
+    function _validatePaymasterUserOp(
+        PackedUserOperation calldata userOp,
+        bytes32 /*userOpHash*/,
+        uint256 requiredPreFund
+    ) internal view override returns (bytes memory context, uint256 validationData) {
+        (requiredPreFund);
+
+        (uint48 validUntil, uint48 validAfter, bytes calldata signature) = parsePaymasterAndData(userOp.paymasterAndData);
+        require(signature.length == 65, "VerifyingPaymaster: invalid signature length in paymasterAndData");
+
+        bytes32 hash = MessageHashUtils.toEthSignedMessageHash(getHash(userOp, validUntil, validAfter));
+        (address recovered, ECDSA.RecoverError error, ) = ECDSA.tryRecover(hash, signature);
+        if (error != ECDSA.RecoverError.NoError || recovered != verifyingSigner) {
+            return ("", _packValidationData(true, validUntil, validAfter));
+        }
+        return ("", _packValidationData(false, validUntil, validAfter));
+    }
+
+    function parsePaymasterAndData(
+        bytes calldata paymasterAndData
+    ) public pure returns (uint48 validUntil, uint48 validAfter, bytes calldata signature) {
+        (validUntil, validAfter) = abi.decode(paymasterAndData[VALID_TIMESTAMP_OFFSET : SIGNATURE_OFFSET], (uint48, uint48));
+        signature = paymasterAndData[SIGNATURE_OFFSET:];
+    }
+}
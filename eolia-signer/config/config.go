@@ -0,0 +1,164 @@
+package config
+
+import (
+	"eolia-common/logging"
+	"eolia-common/settings"
+	"eolia-common/tracing"
+	"eolia-signer/paymaster"
+	"eolia-signer/webhook"
+	"fmt"
+	"log"
+)
+
+// Environment variables override the file, e.g. EOLIA_SIGNER_DB_CONN_STR or EOLIA_SIGNER_RPC_URLS.
+const ENV_PREFIX = "EOLIA_SIGNER_"
+
+// Config of the signer. Each field can be set in the config file, by the environment
+// variable named after its yaml key in upper case behind ENV_PREFIX (EOLIA_SIGNER_DB_CONN_STR,
+// ...) and by -set key=value, each layer overriding the previous one.
+type Config struct {
+	// Address the HTTP server listens on.
+	Listen string `yaml:"listen"`
+	// Comma-separated in the environment; reads fail over between them.
+	RPCURLs    []string `yaml:"rpc_urls" secret:"url"`
+	EntryPoint string   `yaml:"entry_point"`
+	Factory    string   `yaml:"factory"`
+
+	DBConnStr string `yaml:"db_conn_str" secret:"true"`
+	JWTSecret string `yaml:"jwt_secret" secret:"true"`
+
+	TurnkeyOrganization string `yaml:"turnkey_organization"`
+	// Name of the Turnkey API key in the local key store.
+	TurnkeyAPIKeyName string `yaml:"turnkey_api_key_name"`
+
+	// Base URL of eolia-bundlr; signed ops are posted to <bundlr_url>/rpc/sendUserOp.
+	BundlrURL string `yaml:"bundlr_url"`
+	// admin_token of eolia-bundlr. The op event streams are sent with it, so they aren't
+	// held to the bundler's per-IP WebSocket cap.
+	BundlrAdminToken string `yaml:"bundlr_admin_token" secret:"true"`
+
+	// Log level and format (EOLIA_SIGNER_LOG_LEVEL, EOLIA_SIGNER_LOG_FORMAT).
+	Log logging.Config `yaml:"log"`
+	// OTLP/HTTP trace export (EOLIA_SIGNER_TRACING_ENDPOINT, ...); off without an endpoint.
+	Tracing tracing.Config `yaml:"tracing"`
+
+	// Comma-separated origins allowed by CORS.
+	CORSOrigins string `yaml:"cors_origins"`
+	// Bearer token of the /admin endpoints; they are disabled without one.
+	AdminToken string `yaml:"admin_token" secret:"true"`
+
+	// Gas sponsorship through a VerifyingPaymaster and gas paid in tokens through a
+	// TokenPaymaster (EOLIA_SIGNER_PAYMASTER_ADDRESS, EOLIA_SIGNER_PAYMASTER_TOKEN_ADDRESS, ...).
+	Paymaster paymaster.Config `yaml:"paymaster"`
+
+	// Delivery of op outcomes to the endpoints registered under /admin/webhooks.
+	Webhooks webhook.Config `yaml:"webhooks"`
+}
+
+func defaults() Config {
+	return Config{
+		Listen:              ":8080",
+		RPCURLs:             []string{"https://rpc.xlayer.tech"},
+		EntryPoint:          "0x379FF91b96c038ECb0dc6aCFb44366a39f0de566",
+		Factory:             "0xC924da88e33fD1eD04f4A8a1f6BD14Ad030a3dC9",
+		TurnkeyOrganization: "6c00de4c-46f5-4519-822e-4ab049960f41",
+		TurnkeyAPIKeyName:   "smart-apikey",
+		BundlrURL:           "http://127.0.0.1:8181",
+		CORSOrigins:         "http://localhost:3000",
+	}
+}
+
+// LoadConfig layers the defaults, the file at path, the environment and the overrides,
+// then validates the result. It exits on any error.
+func LoadConfig(path string, pathRequired bool, overrides settings.Overrides) *Config {
+	cfg := defaults()
+	if err := settings.LoadFile(path, pathRequired, &cfg); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := settings.ApplyEnv(&cfg, ENV_PREFIX); err != nil {
+		log.Fatalf("Invalid config environment: %v", err)
+	}
+
+	if err := overrides.Apply(&cfg); err != nil {
+		log.Fatalf("Invalid config override: %v", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	return &cfg
+}
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var check settings.Checker
+
+	check.Required("listen", c.Listen)
+	if len(c.RPCURLs) == 0 {
+		check.Fail("rpc_urls is required")
+	}
+	for _, url := range c.RPCURLs {
+		check.URL("rpc_urls", url, "http", "https")
+	}
+
+	check.Address("entry_point", c.EntryPoint, true)
+	check.Address("factory", c.Factory, true)
+
+	if check.Required("bundlr_url", c.BundlrURL) {
+		check.URL("bundlr_url", c.BundlrURL, "http", "https")
+	}
+	if err := c.Log.Validate(); err != nil {
+		check.Fail("log: %v", err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		check.Fail("tracing: %v", err)
+	}
+	if c.Paymaster.Enabled() {
+		check.Address("paymaster.address", c.Paymaster.Address, false)
+		if c.Paymaster.TurnkeySigner != "" {
+			check.Address("paymaster.turnkey_signer", c.Paymaster.TurnkeySigner, true)
+		} else {
+			check.PrivateKey("paymaster.signer_key", c.Paymaster.SignerKey)
+		}
+		for _, target := range c.Paymaster.Policy.Targets {
+			check.Address("paymaster.policy.targets", target, true)
+		}
+		if c.Paymaster.Validity < 0 {
+			check.Fail("paymaster.validity must not be negative")
+		}
+		if c.Paymaster.Policy.UserDailyOps < 0 {
+			check.Fail("paymaster.policy.user_daily_ops must not be negative")
+		}
+	}
+	if c.Paymaster.Token.Address != "" {
+		check.Address("paymaster.token.address", c.Paymaster.Token.Address, true)
+		if len(c.Paymaster.Token.Tokens) == 0 {
+			check.Fail("paymaster.token.tokens is required")
+		}
+		for i, token := range c.Paymaster.Token.Tokens {
+			name := fmt.Sprintf("paymaster.token.tokens[%d]", i)
+			check.Required(name+".symbol", token.Symbol)
+			check.Address(name+".address", token.Address, true)
+			check.Address(name+".oracle", token.Oracle, false)
+			if token.Oracle == "" && token.Price <= 0 {
+				check.Fail("%s needs an oracle or a positive price", name)
+			}
+		}
+	}
+	if err := c.Webhooks.Validate(); err != nil {
+		check.Fail("webhooks: %v", err)
+	}
+	if c.Webhooks.Enabled && c.BundlrAdminToken == "" {
+		check.Fail("webhooks.enabled requires bundlr_admin_token to follow every op of the bundler")
+	}
+
+	check.Required("db_conn_str", c.DBConnStr)
+	check.Required("jwt_secret", c.JWTSecret)
+
+	check.Required("turnkey_organization", c.TurnkeyOrganization)
+	check.Required("turnkey_api_key_name", c.TurnkeyAPIKeyName)
+
+	return check.Err()
+}
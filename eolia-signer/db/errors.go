@@ -7,4 +7,7 @@ var (
 	ErrWalletNameOccupied   = errors.New("wallet name is already taken")
 	ErrWalletIDExists       = errors.New("wallet ID already exists")
 	ErrAccountAddressExists = errors.New("account address already exists")
+	ErrWebhookNotFound      = errors.New("webhook endpoint not found")
+	ErrPaymasterKeyNotFound = errors.New("paymaster API key not found")
+	ErrSponsorshipExists    = errors.New("op already sponsored")
 )
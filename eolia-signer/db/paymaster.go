@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"eolia-signer/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Advisory lock serializing sponsorships, so that concurrent ops can't overrun a budget.
+const PAYMASTER_LOCK_KEY = 0x7061796d // "paym"
+
+// ReserveSponsorship records a sponsorship if check accepts the usage of the day so far.
+// Usage and the insert are read and written under one lock, so check sees every earlier
+// sponsorship. An error of check is returned as is, and ErrSponsorshipExists when the op
+// (its userOpHash) is already sponsored, so that only its first reservation is released.
+func (db *DB) ReserveSponsorship(ctx context.Context, s *models.Sponsorship, check func(models.SponsorshipUsage) error) error {
+	return pgx.BeginFunc(ctx, db.Pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, PAYMASTER_LOCK_KEY); err != nil {
+			return err
+		}
+
+		query := `
+			SELECT COALESCE(SUM(max_cost) FILTER (WHERE api_key_id IS NULL), 0)::text,
+				   COALESCE(SUM(max_cost) FILTER (WHERE api_key_id IS NOT NULL), 0)::text,
+				   COALESCE(SUM(max_cost) FILTER (WHERE wallet_name = $1), 0)::text,
+				   COUNT(*) FILTER (WHERE wallet_name = $1),
+				   COALESCE(SUM(max_cost) FILTER (WHERE api_key_id = $2), 0)::text,
+				   COUNT(*) FILTER (WHERE api_key_id = $2)
+			FROM paymaster_sponsorships
+			WHERE created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC')
+		`
+
+		var spent, keysSpent, userSpent, keySpent string
+		var usage models.SponsorshipUsage
+		if err := tx.QueryRow(ctx, query, s.WalletName, s.APIKeyID).Scan(&spent, &keysSpent, &userSpent, &usage.UserOps, &keySpent, &usage.KeyOps); err != nil {
+			return err
+		}
+		var err error
+		if usage.Spent, err = parseWei(spent); err != nil {
+			return err
+		}
+		if usage.KeysSpent, err = parseWei(keysSpent); err != nil {
+			return err
+		}
+		if usage.UserSpent, err = parseWei(userSpent); err != nil {
+			return err
+		}
+		if usage.KeySpent, err = parseWei(keySpent); err != nil {
+			return err
+		}
+
+		if err := check(usage); err != nil {
+			return err
+		}
+
+		// The same op asked for twice (same userOpHash) is only counted once.
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO paymaster_sponsorships (user_op_hash, wallet_name, api_key_id, sender, targets, max_cost, valid_until)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, 0), $4, $5, $6::text::numeric, to_timestamp($7) AT TIME ZONE 'UTC')
+			ON CONFLICT (user_op_hash) DO NOTHING
+		`, s.UserOpHash, s.WalletName, s.APIKeyID, s.Sender, s.Targets, s.MaxCost.String(), s.ValidUntil.Unix())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrSponsorshipExists
+		}
+		return nil
+	})
+}
+
+// ReleaseSponsorship gives back the budget of an op the bundler didn't accept.
+func (db *DB) ReleaseSponsorship(ctx context.Context, userOpHash string) error {
+	_, err := db.Exec(ctx, `DELETE FROM paymaster_sponsorships WHERE user_op_hash = $1`, userOpHash)
+	return err
+}
+
+// MarkSponsorshipsIncluded records that the ops of userOpHashes made it on chain, so their
+// sponsorships are kept for good.
+func (db *DB) MarkSponsorshipsIncluded(ctx context.Context, userOpHashes []string) error {
+	_, err := db.Exec(ctx, `UPDATE paymaster_sponsorships SET included = TRUE WHERE user_op_hash = ANY($1)`, userOpHashes)
+	return err
+}
+
+// ReleaseExpiredSponsorships gives back the budget of the sponsorships whose validity
+// ended before before without their op being included, and returns how many there were.
+func (db *DB) ReleaseExpiredSponsorships(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := db.Exec(ctx, `
+		DELETE FROM paymaster_sponsorships
+		WHERE NOT included AND valid_until < to_timestamp($1) AT TIME ZONE 'UTC'
+	`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetPaymasterCursor returns the last block the sponsorship settlement read up to; ok is
+// false before it first ran.
+func (db *DB) GetPaymasterCursor(ctx context.Context) (block uint64, ok bool, err error) {
+	var last int64
+	err = db.QueryRow(ctx, `SELECT last_block FROM paymaster_cursor`).Scan(&last)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(last), true, nil
+}
+
+// SetPaymasterCursor moves the cursor to block. It never moves back, so replicas can share it.
+func (db *DB) SetPaymasterCursor(ctx context.Context, block uint64) error {
+	query := `
+		INSERT INTO paymaster_cursor (id, last_block, updated_at)
+		VALUES (TRUE, $1, NOW())
+		ON CONFLICT (id) DO UPDATE
+		SET last_block = GREATEST(paymaster_cursor.last_block, EXCLUDED.last_block), updated_at = NOW()
+	`
+
+	_, err := db.Exec(ctx, query, int64(block))
+	return err
+}
+
+func (db *DB) AddPaymasterAPIKey(ctx context.Context, key *models.PaymasterAPIKey, keyHash string) error {
+	query := `
+		INSERT INTO paymaster_api_keys (name, key_hash, key_prefix, targets, daily_ops, daily_budget)
+		VALUES ($1, $2, $3, $4, $5, $6::text::numeric)
+		RETURNING id, active, created_at
+	`
+
+	return db.QueryRow(ctx, query, key.Name, keyHash, key.KeyPrefix, key.Targets, key.DailyOps, key.DailyBudget.String()).
+		Scan(&key.ID, &key.Active, &key.CreatedAt)
+}
+
+func (db *DB) GetPaymasterAPIKeys(ctx context.Context) ([]models.PaymasterAPIKey, error) {
+	query := `
+		SELECT id, name, key_prefix, targets, daily_ops, daily_budget::text, active, created_at
+		FROM paymaster_api_keys
+		ORDER BY id
+	`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.PaymasterAPIKey{}
+	for rows.Next() {
+		key, err := scanPaymasterAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// GetPaymasterAPIKeyByHash finds an active key by the hex SHA-256 of its value.
+func (db *DB) GetPaymasterAPIKeyByHash(ctx context.Context, keyHash string) (*models.PaymasterAPIKey, error) {
+	query := `
+		SELECT id, name, key_prefix, targets, daily_ops, daily_budget::text, active, created_at
+		FROM paymaster_api_keys
+		WHERE key_hash = $1 AND active
+	`
+
+	key, err := scanPaymasterAPIKey(db.QueryRow(ctx, query, keyHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPaymasterKeyNotFound
+	}
+	return key, err
+}
+
+// RevokePaymasterAPIKey deactivates a key. Its sponsorships stay on record.
+func (db *DB) RevokePaymasterAPIKey(ctx context.Context, id int) error {
+	tag, err := db.Exec(ctx, `UPDATE paymaster_api_keys SET active = FALSE WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPaymasterKeyNotFound
+	}
+	return nil
+}
+
+// GetPaymasterKeyUsage sums the sponsorships of a key per UTC day, over the last days days
+// (today included), newest first. Days without sponsorships are left out.
+func (db *DB) GetPaymasterKeyUsage(ctx context.Context, id int, days int) ([]models.PaymasterKeyUsage, error) {
+	query := `
+		SELECT date_trunc('day', created_at), COUNT(*), SUM(max_cost)::text
+		FROM paymaster_sponsorships
+		WHERE api_key_id = $1
+		  AND created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') - ($2::int - 1) * INTERVAL '1 day'
+		GROUP BY 1
+		ORDER BY 1 DESC
+	`
+
+	rows, err := db.Query(ctx, query, id, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []models.PaymasterKeyUsage{}
+	for rows.Next() {
+		var day time.Time
+		var spent string
+		var u models.PaymasterKeyUsage
+		if err := rows.Scan(&day, &u.Ops, &spent); err != nil {
+			return nil, err
+		}
+		if u.Spent, err = parseWei(spent); err != nil {
+			return nil, err
+		}
+		u.Day = day.Format(time.DateOnly)
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+func scanPaymasterAPIKey(row pgx.Row) (*models.PaymasterAPIKey, error) {
+	var key models.PaymasterAPIKey
+	var dailyBudget string
+	if err := row.Scan(&key.ID, &key.Name, &key.KeyPrefix, &key.Targets, &key.DailyOps, &dailyBudget, &key.Active, &key.CreatedAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if key.DailyBudget, err = parseWei(dailyBudget); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// parseWei parses a NUMERIC read as text.
+func parseWei(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid wei amount %q", s)
+	}
+	return v, nil
+}
@@ -7,7 +7,7 @@ import (
 	"eolia-signer/models"
 	"eolia-signer/types"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
@@ -33,21 +33,20 @@ func WaitForDB(connString string, maxAttempts int) (*pgxpool.Pool, error) {
 			}
 		}
 
-		log.Printf("DB not ready, retrying in 2s... (%d/%d)", i+1, maxAttempts)
+		slog.Warn("DB not ready, retrying in 2s", "attempt", i+1, "max_attempts", maxAttempts, "error", err)
 		time.Sleep(2 * time.Second)
 	}
 
 	return nil, fmt.Errorf("could not connect to DB after %d attempts: %w", maxAttempts, err)
 }
 
-func CreateDBPool() *DB {
-	dsn := os.Getenv("DB_CONN_STR")
-
+func CreateDBPool(dsn string) *DB {
 	pool, err := WaitForDB(dsn, 5)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		slog.Error("unable to connect to database", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Connected to database successfully")
+	slog.Info("connected to database")
 	return &DB{pool}
 }
 
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"eolia-signer/models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (db *DB) AddWebhookEndpoint(ctx context.Context, endpoint *models.WebhookEndpoint) error {
+	query := `
+		INSERT INTO webhook_endpoints (url, secret, event_types, senders)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, active, created_at
+	`
+
+	return db.QueryRow(ctx, query, endpoint.URL, endpoint.Secret, endpoint.EventTypes, endpoint.Senders).
+		Scan(&endpoint.ID, &endpoint.Active, &endpoint.CreatedAt)
+}
+
+// GetWebhookEndpoints lists the registered endpoints, without their secrets.
+func (db *DB) GetWebhookEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	query := `
+		SELECT id, url, event_types, senders, active, created_at
+		FROM webhook_endpoints
+		ORDER BY id
+	`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []models.WebhookEndpoint{}
+	for rows.Next() {
+		var e models.WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.URL, &e.EventTypes, &e.Senders, &e.Active, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+func (db *DB) DeleteWebhookEndpoint(ctx context.Context, id int) error {
+	tag, err := db.Exec(ctx, `DELETE FROM webhook_endpoints WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent queues a delivery of the event for every active endpoint that wants its
+// type and follows sender (lower case). An event already queued for an endpoint is skipped,
+// so the same event seen twice (e.g. after a reconnect) is delivered once.
+func (db *DB) EnqueueWebhookEvent(ctx context.Context, eventID, eventType, sender string, payload []byte) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		SELECT id, $1::text, $2::text, $3::jsonb
+		FROM webhook_endpoints
+		WHERE active
+		  AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+		  AND (cardinality(senders) = 0 OR $4 = ANY(senders))
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	`
+
+	tag, err := db.Exec(ctx, query, eventID, eventType, payload, sender)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries and pushes their next attempt
+// lease ahead, so that concurrent dispatchers (other signer replicas) skip them meanwhile.
+func (db *DB) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		FROM webhook_endpoints e
+		WHERE d.endpoint_id = e.id
+		  AND d.id IN (
+			SELECT dd.id
+			FROM webhook_deliveries dd
+			JOIN webhook_endpoints ee ON ee.id = dd.endpoint_id
+			WHERE dd.status = 'pending' AND dd.next_attempt_at <= NOW() AND ee.active
+			ORDER BY dd.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF dd SKIP LOCKED
+		  )
+		RETURNING d.id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.attempts, e.url, e.secret
+	`
+
+	rows, err := db.Query(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (db *DB) MarkWebhookDelivered(ctx context.Context, id int64, statusCode int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', attempts = attempts + 1, delivered_at = NOW(),
+			last_status_code = $2, last_error = NULL
+		WHERE id = $1
+	`
+
+	_, err := db.Exec(ctx, query, id, statusCode)
+	return err
+}
+
+// RetryWebhookDelivery records a failed attempt and schedules the next one after backoff.
+func (db *DB) RetryWebhookDelivery(ctx context.Context, id int64, statusCode *int, lastError string, backoff time.Duration) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond',
+			last_status_code = $2, last_error = $3
+		WHERE id = $1
+	`
+
+	_, err := db.Exec(ctx, query, id, statusCode, lastError, backoff.Milliseconds())
+	return err
+}
+
+// DeadLetterWebhookDelivery records the last failed attempt and moves the delivery to the dead letters.
+func (db *DB) DeadLetterWebhookDelivery(ctx context.Context, id int64, statusCode *int, lastError string) error {
+	return pgx.BeginFunc(ctx, db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'dead', attempts = attempts + 1, last_status_code = $2, last_error = $3
+			WHERE id = $1
+		`, id, statusCode, lastError)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO webhook_dead_letters (delivery_id, endpoint_id, event_type, attempts, last_status_code, last_error)
+			SELECT id, endpoint_id, event_type, attempts, last_status_code, last_error
+			FROM webhook_deliveries
+			WHERE id = $1
+			ON CONFLICT (delivery_id) DO NOTHING
+		`, id)
+		return err
+	})
+}
+
+// GetWebhookDeadLetters lists dead letters, newest first, of one endpoint or of all when endpointID is 0.
+func (db *DB) GetWebhookDeadLetters(ctx context.Context, endpointID int, limit int) ([]models.WebhookDeadLetter, error) {
+	query := `
+		SELECT l.id, l.delivery_id, l.endpoint_id, d.event_id, l.event_type, d.payload,
+			   l.attempts, l.last_status_code, l.last_error, l.failed_at
+		FROM webhook_dead_letters l
+		JOIN webhook_deliveries d ON d.id = l.delivery_id
+		WHERE $1 = 0 OR l.endpoint_id = $1
+		ORDER BY l.failed_at DESC
+		LIMIT $2
+	`
+
+	rows, err := db.Query(ctx, query, endpointID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []models.WebhookDeadLetter{}
+	for rows.Next() {
+		var l models.WebhookDeadLetter
+		if err := rows.Scan(&l.ID, &l.DeliveryID, &l.EndpointID, &l.EventID, &l.EventType, &l.Payload,
+			&l.Attempts, &l.LastStatusCode, &l.LastError, &l.FailedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+// ReplayWebhookDeadLetters puts dead letters back in the delivery queue with a fresh set of
+// attempts: the one with id deadLetterID, or every one of endpointID when deadLetterID is 0.
+// It returns how many were requeued.
+func (db *DB) ReplayWebhookDeadLetters(ctx context.Context, deadLetterID int64, endpointID int) (int64, error) {
+	query := `
+		WITH replayed AS (
+			DELETE FROM webhook_dead_letters
+			WHERE ($1 <> 0 AND id = $1) OR ($1 = 0 AND endpoint_id = $2)
+			RETURNING delivery_id
+		)
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), last_error = NULL, last_status_code = NULL
+		WHERE id IN (SELECT delivery_id FROM replayed)
+	`
+
+	tag, err := db.Exec(ctx, query, deadLetterID, endpointID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetWebhookCursor returns the last block the webhook listener backfilled up to; ok is false
+// before it first ran.
+func (db *DB) GetWebhookCursor(ctx context.Context) (block uint64, ok bool, err error) {
+	var last int64
+	err = db.QueryRow(ctx, `SELECT last_block FROM webhook_cursor`).Scan(&last)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(last), true, nil
+}
+
+// SetWebhookCursor moves the cursor to block. It never moves back, so replicas can share it.
+func (db *DB) SetWebhookCursor(ctx context.Context, block uint64) error {
+	query := `
+		INSERT INTO webhook_cursor (id, last_block, updated_at)
+		VALUES (TRUE, $1, NOW())
+		ON CONFLICT (id) DO UPDATE
+		SET last_block = GREATEST(webhook_cursor.last_block, EXCLUDED.last_block), updated_at = NOW()
+	`
+
+	_, err := db.Exec(ctx, query, int64(block))
+	return err
+}
+
+// KnownAccounts returns which of addresses are the account of a user, keyed in lower case.
+func (db *DB) KnownAccounts(ctx context.Context, addresses []string) (map[string]bool, error) {
+	lower := make([]string, len(addresses))
+	for i, a := range addresses {
+		lower[i] = strings.ToLower(a)
+	}
+
+	rows, err := db.Query(ctx, `SELECT LOWER(account_address) FROM users WHERE LOWER(account_address) = ANY($1)`, lower)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool)
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		known[address] = true
+	}
+	return known, rows.Err()
+}
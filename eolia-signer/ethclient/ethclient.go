@@ -2,32 +2,51 @@ package ethclient
 
 import (
 	"context"
+	"eolia-common/tracing"
+	"eolia-common/upstream"
+	"eolia-common/userop"
 	"eolia-signer/types"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/big"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Client struct {
 	eth               *ethclient.Client
+	pool              *upstream.Pool
+	chainID           *big.Int
 	entrypoint        *abi.ABI
 	entrypointAddress *common.Address
 	factory           *abi.ABI
 	factoryAddress    *common.Address
 	account           *abi.ABI
+	erc20             *abi.ABI
+	aggregator        *abi.ABI
 }
 
-func NewClient(url string, entrypoint string, factory string) *Client {
-	cli, err := ethclient.Dial(url)
+// NewClient connects to the chain through an upstream pool over urls, so that reads fail over
+// to another node when one is down or lagging.
+func NewClient(urls []string, entrypoint string, factory string) *Client {
+	pool, err := upstream.NewPool(urls, upstream.Config{})
 	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+		log.Fatalf("Failed to create the RPC upstream pool: %v", err)
+	}
+	pool.Start()
+	cli := pool.Client()
+
+	chainID, err := cli.ChainID(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get chain id: %v", err)
 	}
 
 	abiData, err := os.ReadFile("ethclient/abi/entrypoint.abi.json")
@@ -62,10 +81,33 @@ func NewClient(url string, entrypoint string, factory string) *Client {
 		log.Fatalf("Failed to parse account ABI: %v", err)
 	}
 
-	return &Client{eth: cli, entrypoint: &entryAbi, entrypointAddress: &entryPointAddress, factory: &factoryAbi, factoryAddress: &factoryAddress, account: &accountAbi}
+	abiData, err = os.ReadFile("ethclient/abi/erc20.abi.json")
+	if err != nil {
+		log.Fatalf("Failed to read ERC-20 ABI file: %v", err)
+	}
+
+	erc20Abi, err := abi.JSON(strings.NewReader(string(abiData)))
+	if err != nil {
+		log.Fatalf("Failed to parse ERC-20 ABI: %v", err)
+	}
+
+	abiData, err = os.ReadFile("ethclient/abi/aggregator.abi.json")
+	if err != nil {
+		log.Fatalf("Failed to read aggregator ABI file: %v", err)
+	}
+
+	aggregatorAbi, err := abi.JSON(strings.NewReader(string(abiData)))
+	if err != nil {
+		log.Fatalf("Failed to parse aggregator ABI: %v", err)
+	}
+
+	return &Client{eth: cli, pool: pool, chainID: chainID, entrypoint: &entryAbi, entrypointAddress: &entryPointAddress, factory: &factoryAbi, factoryAddress: &factoryAddress, account: &accountAbi, erc20: &erc20Abi, aggregator: &aggregatorAbi}
 }
 
-func (c *Client) GetCalculatedAddress(address common.Address, salt *big.Int) (common.Address, error) {
+func (c *Client) GetCalculatedAddress(ctx context.Context, address common.Address, salt *big.Int) (_ common.Address, err error) {
+	ctx, span := tracing.Start(ctx, "ethclient.GetCalculatedAddress", attribute.String("owner", address.Hex()))
+	defer func() { tracing.End(span, err) }()
+
 	data, err := c.factory.Pack("getAddress", address, salt)
 	if err != nil {
 		return common.Address{}, err
@@ -76,7 +118,7 @@ func (c *Client) GetCalculatedAddress(address common.Address, salt *big.Int) (co
 		Data: data,
 	}
 
-	output, err := c.eth.CallContract(context.Background(), callMsg, nil)
+	output, err := c.eth.CallContract(ctx, callMsg, nil)
 	if err != nil {
 		return common.Address{}, err
 	}
@@ -87,19 +129,21 @@ func (c *Client) GetCalculatedAddress(address common.Address, salt *big.Int) (co
 		return common.Address{}, err
 	}
 
-	fmt.Printf("Calculated address: %s\n", result.Hex())
+	slog.Debug("calculated account address", "owner", address.Hex(), "account", result.Hex())
 	return result, nil
 }
 
-func (c *Client) AccountNeedsInitialization(sender common.Address, owner common.Address) []byte {
-	byteCode, err := c.eth.CodeAt(context.Background(), sender, nil)
+func (c *Client) AccountNeedsInitialization(ctx context.Context, sender common.Address, owner common.Address) []byte {
+	ctx, span := tracing.Start(ctx, "ethclient.AccountNeedsInitialization", attribute.String("userop.sender", sender.Hex()))
+	defer span.End()
+
+	byteCode, err := c.eth.CodeAt(ctx, sender, nil)
 	if err != nil {
-		fmt.Printf("Error fetching bytecode for %s: %v\n", sender.Hex(), err)
+		span.RecordError(err)
+		slog.Error("failed to fetch account code", "sender", sender.Hex(), "error", err)
 		return nil
 	}
 
-	fmt.Printf("Bytecode length for %s: %d\n", sender.Hex(), len(byteCode))
-
 	if len(byteCode) == 0 {
 		encodedFunction, err := c.factory.Pack("createAccount", owner, big.NewInt(0))
 		if err != nil {
@@ -109,11 +153,7 @@ func (c *Client) AccountNeedsInitialization(sender common.Address, owner common.
 		addressInBytes := c.factoryAddress.Bytes()
 		initCode := append(addressInBytes, encodedFunction...)
 
-		fmt.Printf("Account needs initialization, init code: %x\n", initCode)
-		fmt.Printf("Factory address: %s\n", c.factoryAddress.Hex())
-		fmt.Printf("Sender address: %s\n", sender.Hex())
-		fmt.Printf("Owner address: %s\n", owner.Hex())
-		fmt.Printf("Encoded function: %x\n", encodedFunction)
+		slog.Debug("account needs initialization", "sender", sender.Hex(), "owner", owner.Hex(), "factory", c.factoryAddress.Hex())
 
 		return initCode
 	}
@@ -121,10 +161,14 @@ func (c *Client) AccountNeedsInitialization(sender common.Address, owner common.
 	return nil
 }
 
-func (c *Client) GetNonce(sender common.Address) *big.Int {
-	byteCode, err := c.eth.CodeAt(context.Background(), sender, nil)
+func (c *Client) GetNonce(ctx context.Context, sender common.Address) *big.Int {
+	ctx, span := tracing.Start(ctx, "ethclient.GetNonce", attribute.String("userop.sender", sender.Hex()))
+	defer span.End()
+
+	byteCode, err := c.eth.CodeAt(ctx, sender, nil)
 	if err != nil {
-		fmt.Printf("Error fetching bytecode for %s: %v\n", sender.Hex(), err)
+		span.RecordError(err)
+		slog.Error("failed to fetch account code", "sender", sender.Hex(), "error", err)
 		return nil
 	}
 
@@ -139,42 +183,273 @@ func (c *Client) GetNonce(sender common.Address) *big.Int {
 			To:   &sender,
 			Data: encodedFunction,
 		}
-		output, err := c.eth.CallContract(context.Background(), callMsg, nil)
+		output, err := c.eth.CallContract(ctx, callMsg, nil)
 		if err != nil {
-			fmt.Printf("Error calling getNonce for %s: %v\n", sender.Hex(), err)
+			span.RecordError(err)
+			slog.Error("getNonce call failed", "sender", sender.Hex(), "error", err)
 			return nil
 		}
 		var nonce *big.Int
 		err = c.account.UnpackIntoInterface(&nonce, "getNonce", output)
 		if err != nil {
-			fmt.Printf("Error unpacking getNonce result for %s: %v\n", sender.Hex(), err)
+			slog.Error("failed to unpack getNonce result", "sender", sender.Hex(), "error", err)
 			return nil
 		}
 		return nonce
 	}
 }
 
-func (c *Client) GetUserOpHash(userOp *types.PackedUserOperation) common.Hash {
-	encodedFunction, err := c.entrypoint.Pack("getUserOpHash", userOp)
+// GetUserOpHash computes the userOpHash offline, exactly like EntryPoint.getUserOpHash.
+func (c *Client) GetUserOpHash(ctx context.Context, userOp *types.PackedUserOperation) common.Hash {
+	_, span := tracing.Start(ctx, "ethclient.GetUserOpHash")
+	defer span.End()
+
+	hash := userOp.Hash(*c.entrypointAddress, c.chainID)
+	span.SetAttributes(attribute.String("userop.hash", hash.Hex()))
+	return hash
+}
+
+// Upstreams returns the health of every RPC node the client uses.
+func (c *Client) Upstreams() []upstream.Stats {
+	return c.pool.Stats()
+}
+
+// Close stops the health checks of the upstream pool.
+func (c *Client) Close() {
+	c.pool.Stop()
+}
+
+func (c *Client) EntryPoint() common.Address {
+	return *c.entrypointAddress
+}
+
+func (c *Client) ChainID() *big.Int {
+	return new(big.Int).Set(c.chainID)
+}
+
+// AccountCall is one call of an account's execute or executeBatch.
+type AccountCall struct {
+	Target common.Address `json:"target"`
+	Value  *big.Int       `json:"value"`
+	Data   []byte         `json:"data"`
+}
+
+// AccountCalls decodes an account's callData: the call of execute, or every call of
+// executeBatch. Empty callData (a bare deployment) makes no call.
+func (c *Client) AccountCalls(callData []byte) ([]AccountCall, error) {
+	if len(callData) == 0 {
+		return nil, nil
+	}
+
+	method, err := c.account.MethodById(callData)
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(callData[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.Name {
+	case "execute":
+		return []AccountCall{{Target: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte)}}, nil
+	case "executeBatch":
+		var calls []AccountCall
+		if err := method.Inputs.Copy(&calls, args); err != nil {
+			return nil, err
+		}
+		return calls, nil
+	}
+	return nil, fmt.Errorf("unsupported account call %s", method.Name)
+}
+
+// CallTargets returns the contracts an account's callData calls.
+func (c *Client) CallTargets(callData []byte) ([]common.Address, error) {
+	calls, err := c.AccountCalls(callData)
 	if err != nil {
-		log.Fatalf("Failed to pack getUserOpHash: %v", err)
+		return nil, err
+	}
+	targets := make([]common.Address, len(calls))
+	for i, call := range calls {
+		targets[i] = call.Target
 	}
+	return targets, nil
+}
 
-	callMsg := ethereum.CallMsg{
-		To:   c.entrypointAddress,
-		Data: encodedFunction,
+// EncodeCalls is the account callData running calls in order: execute for a single call,
+// executeBatch for several.
+func (c *Client) EncodeCalls(calls []AccountCall) ([]byte, error) {
+	switch len(calls) {
+	case 0:
+		return nil, fmt.Errorf("no call to encode")
+	case 1:
+		return c.account.Pack("execute", calls[0].Target, calls[0].Value, calls[0].Data)
+	}
+	return c.account.Pack("executeBatch", calls)
+}
+
+// HandleOpsCalldataGas is the calldata gas of a handleOps transaction bundling op alone,
+// which the bundler's preVerificationGas requirement grows with.
+func (c *Client) HandleOpsCalldataGas(op *types.PackedUserOperation) (uint64, error) {
+	data, err := c.entrypoint.Pack("handleOps", []types.PackedUserOperation{*op}, common.Address{})
+	if err != nil {
+		return 0, err
+	}
+	return userop.CalldataGas(data), nil
+}
+
+// AccountMethod is the name of the account method callData calls, e.g. executeBatch.
+func (c *Client) AccountMethod(callData []byte) (string, error) {
+	method, err := c.account.MethodById(callData)
+	if err != nil {
+		return "", err
+	}
+	return method.Name, nil
+}
+
+// IsContract reports whether address holds code.
+func (c *Client) IsContract(ctx context.Context, address common.Address) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "ethclient.IsContract", attribute.String("address", address.Hex()))
+	defer func() { tracing.End(span, err) }()
+
+	code, err := c.eth.CodeAt(ctx, address, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(code) > 0, nil
+}
+
+func (c *Client) TokenBalance(ctx context.Context, token, owner common.Address) (*big.Int, error) {
+	return c.callUint(ctx, c.erc20, token, "balanceOf", owner)
+}
+
+func (c *Client) TokenAllowance(ctx context.Context, token, owner, spender common.Address) (*big.Int, error) {
+	return c.callUint(ctx, c.erc20, token, "allowance", owner, spender)
+}
+
+// OraclePrice reads a Chainlink-style aggregator: the latest answer, its decimals and when it was updated.
+func (c *Client) OraclePrice(ctx context.Context, oracle common.Address) (_ *big.Int, _ uint8, _ time.Time, err error) {
+	ctx, span := tracing.Start(ctx, "ethclient.OraclePrice", attribute.String("oracle", oracle.Hex()))
+	defer func() { tracing.End(span, err) }()
+
+	output, err := c.call(ctx, c.aggregator, oracle, "decimals")
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	var decimals uint8
+	if err := c.aggregator.UnpackIntoInterface(&decimals, "decimals", output); err != nil {
+		return nil, 0, time.Time{}, err
+	}
+
+	output, err = c.call(ctx, c.aggregator, oracle, "latestRoundData")
+	if err != nil {
+		return nil, 0, time.Time{}, err
 	}
+	round, err := c.aggregator.Unpack("latestRoundData", output)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	updatedAt := time.Unix(round[3].(*big.Int).Int64(), 0)
+	return round[1].(*big.Int), decimals, updatedAt, nil
+}
+
+func (c *Client) call(ctx context.Context, contract *abi.ABI, to common.Address, method string, args ...interface{}) ([]byte, error) {
+	data, err := contract.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.eth.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+}
 
-	output, err := c.eth.CallContract(context.Background(), callMsg, nil)
+func (c *Client) callUint(ctx context.Context, contract *abi.ABI, to common.Address, method string, args ...interface{}) (*big.Int, error) {
+	output, err := c.call(ctx, contract, to, method, args...)
 	if err != nil {
-		log.Fatalf("Failed to call getUserOpHash: %v", err)
+		return nil, err
+	}
+	var result *big.Int
+	if err := contract.UnpackIntoInterface(&result, method, output); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
+
+// UserOperationEvent is the outcome of an op as the EntryPoint logged it.
+type UserOperationEvent struct {
+	UserOpHash    common.Hash
+	Sender        common.Address
+	Paymaster     common.Address
+	Nonce         *big.Int
+	Success       bool
+	ActualGasCost *big.Int
+	ActualGasUsed *big.Int
+	// Raw revert data of the op's call, from UserOperationRevertReason; nil on success.
+	RevertReason []byte
+
+	TxHash      common.Hash
+	BlockHash   common.Hash
+	BlockNumber uint64
+}
+
+// BlockNumber returns the number of the latest block.
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.eth.BlockNumber(ctx)
+}
 
-	var result common.Hash
-	err = c.entrypoint.UnpackIntoInterface(&result, "getUserOpHash", output)
+// UserOperationEvents reads the UserOperationEvent logs of the EntryPoint in blocks from..to,
+// with the revert data of the ops whose call reverted.
+func (c *Client) UserOperationEvents(ctx context.Context, from, to uint64) (_ []UserOperationEvent, err error) {
+	ctx, span := tracing.Start(ctx, "ethclient.UserOperationEvents", attribute.Int64("from", int64(from)), attribute.Int64("to", int64(to)))
+	defer func() { tracing.End(span, err) }()
+
+	opEvent := c.entrypoint.Events["UserOperationEvent"]
+	revertEvent := c.entrypoint.Events["UserOperationRevertReason"]
+	logs, err := c.eth.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{*c.entrypointAddress},
+		Topics:    [][]common.Hash{{opEvent.ID, revertEvent.ID}},
+	})
 	if err != nil {
-		log.Fatalf("Failed to unpack getUserOpHash result: %v", err)
+		return nil, err
+	}
+
+	reasons := make(map[common.Hash][]byte)
+	for _, l := range logs {
+		if len(l.Topics) < 2 || l.Topics[0] != revertEvent.ID {
+			continue
+		}
+		values, err := revertEvent.Inputs.NonIndexed().Unpack(l.Data)
+		if err != nil || len(values) < 2 {
+			continue
+		}
+		if reason, ok := values[1].([]byte); ok {
+			reasons[l.Topics[1]] = reason
+		}
 	}
 
-	return result
+	var events []UserOperationEvent
+	for _, l := range logs {
+		if len(l.Topics) < 4 || l.Topics[0] != opEvent.ID || l.Removed {
+			continue
+		}
+		values, err := opEvent.Inputs.NonIndexed().Unpack(l.Data)
+		if err != nil || len(values) < 4 {
+			continue
+		}
+		events = append(events, UserOperationEvent{
+			UserOpHash:    l.Topics[1],
+			Sender:        common.BytesToAddress(l.Topics[2][:]),
+			Paymaster:     common.BytesToAddress(l.Topics[3][:]),
+			Nonce:         values[0].(*big.Int),
+			Success:       values[1].(bool),
+			ActualGasCost: values[2].(*big.Int),
+			ActualGasUsed: values[3].(*big.Int),
+			RevertReason:  reasons[l.Topics[1]],
+			TxHash:        l.TxHash,
+			BlockHash:     l.BlockHash,
+			BlockNumber:   l.BlockNumber,
+		})
+	}
+	span.SetAttributes(attribute.Int("events", len(events)))
+	return events, nil
 }
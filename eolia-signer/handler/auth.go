@@ -3,11 +3,10 @@ package handler
 import (
 	"context"
 	"database/sql"
+	"eolia-common/logging"
 	"eolia-signer/db"
 	"eolia-signer/models"
 	"eolia-signer/utils"
-	"fmt"
-	"log"
 	"math/big"
 	"time"
 
@@ -26,12 +25,12 @@ func (h *Handler) AuthLoginHandler(c *fiber.Ctx) error {
 		if err == db.ErrUserNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
 		}
-		log.Printf("DB error in AuthLoginHandler: %v", err)
+		logging.FromContext(c.UserContext()).Error("user lookup failed", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
 	}
 
 	if err := h.SmartSigner.DB.UpdateLastLogin(c.Context(), user.WalletID); err != nil {
-		log.Printf("failed to update last login: %v", err)
+		logging.FromContext(c.UserContext()).Warn("failed to update last login", "wallet", user.WalletName, "error", err)
 	}
 
 	resp := models.AuthLoginResponse{
@@ -73,24 +72,25 @@ func (h *Handler) AuthRegisterHandler(c *fiber.Ctx) error {
 	}
 
 	ctx := c.Context()
+	log := logging.FromContext(c.UserContext()).With("wallet", req.WalletName)
 
 	user, err := h.SmartSigner.DB.GetUserByAuth(ctx, req.AuthProvider, req.AuthExternalID)
 	if err == nil && user != nil {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "user already exists"})
 	} else if err != nil && err != db.ErrUserNotFound {
-		log.Printf("DB error during user lookup: %v", err)
+		log.Error("user lookup failed", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
 	}
 
-	walletID, ownerAddress, err := h.SmartSigner.TurnkeyClient.CreateWallet(req.WalletName)
+	walletID, ownerAddress, err := h.SmartSigner.TurnkeyClient.CreateWallet(c.UserContext(), req.WalletName)
 	if err != nil {
-		log.Printf("failed to create wallet: %v", err)
+		log.Error("failed to create wallet", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "wallet creation failed"})
 	}
 
-	accountAddress, err := h.SmartSigner.EthClient.GetCalculatedAddress(common.HexToAddress(ownerAddress), big.NewInt(0))
+	accountAddress, err := h.SmartSigner.EthClient.GetCalculatedAddress(c.UserContext(), common.HexToAddress(ownerAddress), big.NewInt(0))
 	if err != nil {
-		log.Printf("failed to get calculated address: %v", err)
+		log.Error("failed to get calculated address", "owner", ownerAddress, "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "address calculation failed"})
 	}
 
@@ -98,8 +98,7 @@ func (h *Handler) AuthRegisterHandler(c *fiber.Ctx) error {
 		req.ProfileImageURL = "http://localhost:3000/user.png"
 	}
 
-	fmt.Printf("Registering user: %s, WalletID: %s, AccountAddress: %s, OwnerAddress: %s\n",
-		req.WalletName, walletID, accountAddress.Hex(), ownerAddress)
+	log.Info("registering user", "wallet_id", walletID, "account", accountAddress.Hex(), "owner", ownerAddress)
 
 	newUser := &models.User{
 		WalletName:      req.WalletName,
@@ -114,7 +113,7 @@ func (h *Handler) AuthRegisterHandler(c *fiber.Ctx) error {
 	}
 
 	if err := h.SmartSigner.DB.AddUser(ctx, newUser); err != nil {
-		log.Printf("failed to add user: %v", err)
+		log.Error("failed to add user", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user registration failed"})
 	}
 
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"eolia-signer/ethclient"
+	"eolia-signer/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Most calls one /sign request may batch.
+const MAX_BATCH_CALLS = 16
+
+// errInvalidCall wraps the reason a call of a /sign request was refused.
+var errInvalidCall = errors.New("invalid call")
+
+// encodeCalls checks the calls of a /sign request and encodes them into the account's
+// callData. A call must target a contract other than the account itself, or send bare
+// value to any address but the account's. A refused call gives an errInvalidCall error.
+func (h *Handler) encodeCalls(ctx context.Context, account common.Address, calls []models.Call) ([]byte, error) {
+	if len(calls) > MAX_BATCH_CALLS {
+		return nil, fmt.Errorf("%w: %d calls, at most %d", errInvalidCall, len(calls), MAX_BATCH_CALLS)
+	}
+
+	accountCalls := make([]ethclient.AccountCall, len(calls))
+	contracts := make(map[common.Address]bool)
+	for i, call := range calls {
+		if !common.IsHexAddress(call.To) {
+			return nil, fmt.Errorf("%w: calls[%d].to is not an address", errInvalidCall, i)
+		}
+		target := common.HexToAddress(call.To)
+		if target == (common.Address{}) {
+			return nil, fmt.Errorf("%w: calls[%d].to is the zero address", errInvalidCall, i)
+		}
+		if target == account {
+			return nil, fmt.Errorf("%w: calls[%d] calls the account itself", errInvalidCall, i)
+		}
+
+		value := new(big.Int)
+		if call.Value != "" {
+			v, err := hexutil.DecodeBig(call.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: calls[%d].value: %v", errInvalidCall, i, err)
+			}
+			value = v
+		}
+		data := []byte{}
+		if call.Data != "" {
+			d, err := hexutil.Decode(call.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%w: calls[%d].data: %v", errInvalidCall, i, err)
+			}
+			data = d
+		}
+
+		if len(data) > 0 {
+			isContract, known := contracts[target]
+			if !known {
+				var err error
+				if isContract, err = h.SmartSigner.EthClient.IsContract(ctx, target); err != nil {
+					return nil, err
+				}
+				contracts[target] = isContract
+			}
+			if !isContract {
+				return nil, fmt.Errorf("%w: calls[%d].to %s has no code", errInvalidCall, i, target.Hex())
+			}
+		}
+
+		accountCalls[i] = ethclient.AccountCall{Target: target, Value: value, Data: data}
+	}
+
+	return h.SmartSigner.EthClient.EncodeCalls(accountCalls)
+}
+
+// callPlan decodes the calls an op's callData runs.
+func (h *Handler) callPlan(callData []byte) (*models.CallPlan, error) {
+	method, err := h.SmartSigner.EthClient.AccountMethod(callData)
+	if err != nil {
+		return nil, err
+	}
+	calls, err := h.SmartSigner.EthClient.AccountCalls(callData)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &models.CallPlan{Method: method, Calls: make([]models.Call, len(calls))}
+	for i, call := range calls {
+		plan.Calls[i] = models.Call{
+			To:    call.Target.Hex(),
+			Value: hexutil.EncodeBig(call.Value),
+			Data:  hexutil.Encode(call.Data),
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"eolia-signer/ethclient"
+	"eolia-signer/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEncodeCalls(t *testing.T) {
+	account := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000c2")
+	eoa := common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	h := newTestHandler(t, contract, other)
+
+	tooMany := make([]models.Call, MAX_BATCH_CALLS+1)
+	for i := range tooMany {
+		tooMany[i] = models.Call{To: eoa.Hex(), Value: "0x1"}
+	}
+
+	tests := []struct {
+		name  string
+		calls []models.Call
+		// Account method of the callData and the calls it decodes back to.
+		method string
+		want   []ethclient.AccountCall
+		// Refused with errInvalidCall, or with any other error.
+		invalid bool
+		wantErr bool
+	}{
+		{
+			name:   "value transfer",
+			calls:  []models.Call{{To: eoa.Hex(), Value: "0xde0b6b3a7640000"}},
+			method: "execute",
+			want:   []ethclient.AccountCall{{Target: eoa, Value: big.NewInt(1e18), Data: []byte{}}},
+		},
+		{
+			name:   "contract call",
+			calls:  []models.Call{{To: contract.Hex(), Data: "0xa9059cbb"}},
+			method: "execute",
+			want:   []ethclient.AccountCall{{Target: contract, Value: big.NewInt(0), Data: []byte{0xa9, 0x05, 0x9c, 0xbb}}},
+		},
+		{
+			name: "batch",
+			calls: []models.Call{
+				{To: contract.Hex(), Data: "0x095ea7b3"},
+				{To: other.Hex(), Value: "0x2", Data: "0x12"},
+				{To: contract.Hex(), Data: "0x"},
+			},
+			method: "executeBatch",
+			want: []ethclient.AccountCall{
+				{Target: contract, Value: big.NewInt(0), Data: []byte{0x09, 0x5e, 0xa7, 0xb3}},
+				{Target: other, Value: big.NewInt(2), Data: []byte{0x12}},
+				{Target: contract, Value: big.NewInt(0), Data: []byte{}},
+			},
+		},
+		{name: "no call", calls: nil, wantErr: true},
+		{name: "too many calls", calls: tooMany, invalid: true},
+		{name: "target is not an address", calls: []models.Call{{To: "0x1234"}}, invalid: true},
+		{name: "zero address", calls: []models.Call{{To: common.Address{}.Hex(), Value: "0x1"}}, invalid: true},
+		{name: "the account itself", calls: []models.Call{{To: account.Hex(), Value: "0x1"}}, invalid: true},
+		{name: "decimal value", calls: []models.Call{{To: eoa.Hex(), Value: "100"}}, invalid: true},
+		{name: "malformed data", calls: []models.Call{{To: contract.Hex(), Data: "0xzz"}}, invalid: true},
+		{name: "data to an address without code", calls: []models.Call{{To: contract.Hex(), Data: "0x01"}, {To: eoa.Hex(), Data: "0x01"}}, invalid: true},
+	}
+	for _, tt := range tests {
+		callData, err := h.encodeCalls(t.Context(), account, tt.calls)
+		if tt.invalid || tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: encodeCalls succeeded, want an error", tt.name)
+			} else if errors.Is(err, errInvalidCall) != tt.invalid {
+				t.Errorf("%s: encodeCalls = %v, want errInvalidCall %v", tt.name, err, tt.invalid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: encodeCalls: %v", tt.name, err)
+			continue
+		}
+
+		eth := h.SmartSigner.EthClient
+		if method, err := eth.AccountMethod(callData); err != nil || method != tt.method {
+			t.Errorf("%s: callData calls %q (%v), want %s", tt.name, method, err, tt.method)
+		}
+		got, err := eth.AccountCalls(callData)
+		if err != nil {
+			t.Errorf("%s: AccountCalls: %v", tt.name, err)
+			continue
+		}
+		if !equalCalls(got, tt.want) {
+			t.Errorf("%s: callData decodes to %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func equalCalls(a, b []ethclient.AccountCall) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Target != b[i].Target || a[i].Value.Cmp(b[i].Value) != 0 || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"eolia-common/logging"
+	"eolia-common/tracing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// Subscription type of the bundler's eth_subscribe.
+	USEROP_SUBSCRIPTION = "userOperationStatus"
+
+	BUNDLR_DIAL_TIMEOUT = 5 * time.Second
+	// Comment lines sent on idle streams, so proxies keep them open and a gone client is noticed.
+	SSE_KEEPALIVE = 15 * time.Second
+	// Reconnection delay suggested to EventSource, in milliseconds.
+	SSE_RETRY_MS = 3000
+	// Open streams per account. The bundler doesn't cap the signer's admin connections per
+	// IP, so this is what keeps one user from holding all of them.
+	SSE_MAX_STREAMS_PER_ACCOUNT = 4
+)
+
+var accountStreams = &streamLimiter{open: make(map[string]int)}
+
+// streamLimiter counts the open event streams of each account.
+type streamLimiter struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func (l *streamLimiter) acquire(account string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.open[account] >= SSE_MAX_STREAMS_PER_ACCOUNT {
+		return false
+	}
+	l.open[account]++
+	return true
+}
+
+func (l *streamLimiter) release(account string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.open[account]--; l.open[account] <= 0 {
+		delete(l.open, account)
+	}
+}
+
+// UserOpEventsHandler streams the status changes of the caller's ops as server-sent events,
+// for browsers that can't keep a WebSocket to the bundler. Each event is the bundler's
+// status object ({"userOpHash", "sender", "nonce", "status", "transactionHash", "receipt", ...});
+// ?userOpHash= narrows the stream to one op. The stream is a bundler WebSocket subscription
+// filtered on the account address of the JWT, so users only see their own ops. An account
+// has at most SSE_MAX_STREAMS_PER_ACCOUNT streams open.
+func (h *Handler) UserOpEventsHandler(c *fiber.Ctx) error {
+	account := strings.ToLower(c.Locals("account_address").(string))
+	filter := map[string]string{"sender": account}
+	if hash := c.Query("userOpHash"); hash != "" {
+		if len(hash) != 66 || !strings.HasPrefix(hash, "0x") || common.FromHex(hash) == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid userOpHash"})
+		}
+		filter["userOpHash"] = hash
+	}
+
+	log := logging.FromContext(c.UserContext())
+
+	if !accountStreams.acquire(account) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "too many event streams"})
+	}
+	// Released by the stream writer once it runs, here if the stream never starts.
+	streaming := false
+	defer func() {
+		if !streaming {
+			accountStreams.release(account)
+		}
+	}()
+
+	dialCtx, cancelDial := context.WithTimeout(c.UserContext(), BUNDLR_DIAL_TIMEOUT)
+	defer cancelDial()
+
+	headers := http.Header{}
+	headers.Set(logging.REQUEST_ID_HEADER, logging.RequestID(c.UserContext()))
+	tracing.Inject(c.UserContext(), propagation.HeaderCarrier(headers))
+	if h.BundlrAdminToken != "" {
+		headers.Set(fiber.HeaderAuthorization, "Bearer "+h.BundlrAdminToken)
+	}
+
+	client, err := rpc.DialOptions(dialCtx, h.BundlrWebSocketURL(), rpc.WithHeaders(headers))
+	if err != nil {
+		log.Error("failed to connect to bundler websocket", "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "bundler unavailable"})
+	}
+
+	events := make(chan json.RawMessage, 16)
+	sub, err := client.EthSubscribe(dialCtx, events, USEROP_SUBSCRIPTION, filter)
+	if err != nil {
+		client.Close()
+		log.Error("failed to subscribe to op events", "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "bundler subscription failed"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// The writer runs after the handler returned, until the client or the bundler goes away.
+	streaming = true
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer accountStreams.release(account)
+		defer client.Close()
+		defer sub.Unsubscribe()
+
+		keepalive := time.NewTicker(SSE_KEEPALIVE)
+		defer keepalive.Stop()
+
+		fmt.Fprintf(w, "retry: %d\n\n", SSE_RETRY_MS)
+		for {
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case ev := <-events:
+				var data bytes.Buffer
+				if err := json.Compact(&data, ev); err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", data.Bytes())
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case err := <-sub.Err():
+				// Ending the stream makes EventSource reconnect and resubscribe.
+				log.Warn("op event subscription ended", "error", err)
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// BundlrWebSocketURL is the bundler's JSON-RPC endpoint with a ws(s) scheme.
+func (h *Handler) BundlrWebSocketURL() string {
+	url := h.BundlrURL + "/rpc"
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
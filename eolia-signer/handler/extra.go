@@ -2,8 +2,8 @@ package handler
 
 import (
 	"context"
+	"eolia-common/logging"
 	"eolia-signer/types"
-	"fmt"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -40,7 +40,7 @@ func (h *Handler) AddTxHandler(c *fiber.Ctx) error {
 
 	err := h.SmartSigner.DB.AddTx(context.Background(), walletName, body)
 	if err != nil {
-		fmt.Printf("Error adding transaction: %v\n", err)
+		logging.FromContext(c.UserContext()).Error("failed to add transaction", "wallet", walletName, "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
 	}
 
@@ -56,7 +56,7 @@ func (h *Handler) GetTxHandler(c *fiber.Ctx) error {
 
 	history, err := h.SmartSigner.DB.GetTxHistory(context.Background(), walletName)
 	if err != nil {
-		fmt.Printf("Error fetching tx history: %v\n", err)
+		logging.FromContext(c.UserContext()).Error("failed to fetch tx history", "wallet", walletName, "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
 	}
 
@@ -1,7 +1,16 @@
 package handler
 
-import "eolia-signer/signer"
+import (
+	"eolia-signer/signer"
+	"eolia-signer/webhook"
+)
 
 type Handler struct {
 	SmartSigner *signer.SmartSigner
+	// Base URL of eolia-bundlr.
+	BundlrURL string
+	// admin_token of eolia-bundlr, sent on its WebSocket; may be empty.
+	BundlrAdminToken string
+	// Woken up when dead letters are replayed; nil when webhooks are disabled.
+	Webhooks *webhook.Dispatcher
 }
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"time"
+
+	"eolia-common/health"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Turnkey rate-limits API keys; probes reuse the last whoami result for this long.
+const TURNKEY_CHECK_TTL = 30 * time.Second
+
+// Readiness returns the checks of /readyz: the database, the sync status of the RPC
+// nodes and Turnkey, without which /auth and /sign can't work.
+func (h *Handler) Readiness() *health.Checker {
+	var checker health.Checker
+	checker.Add("db", health.Ping(h.SmartSigner.DB))
+	checker.Add("upstreams", health.Upstreams(h.SmartSigner.EthClient.Upstreams))
+	checker.Add("turnkey", health.Cached(TURNKEY_CHECK_TTL, health.Ping(h.SmartSigner.TurnkeyClient)))
+	return &checker
+}
+
+// HealthHandler runs checker and answers 200, or 503 when a component is down,
+// with the status of every component.
+func HealthHandler(checker *health.Checker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := checker.Run(c.UserContext())
+		return c.Status(report.HTTPStatus()).JSON(report)
+	}
+}
@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"eolia-common/logging"
+	"eolia-common/userop"
+	"eolia-signer/db"
+	"eolia-signer/models"
+	"eolia-signer/paymaster"
+	"eolia-signer/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+// JSON-RPC error codes of the paymaster RPC. ERC-7677 leaves the application codes open.
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+	// The paymaster policy or the key's quotas turned the op down.
+	RPC_NOT_SPONSORED = -32001
+	// Missing, unknown or revoked API key.
+	RPC_UNAUTHORIZED = -32002
+)
+
+// Header carrying the paymaster API key, when it isn't in the apikey query parameter.
+const API_KEY_HEADER = "X-Api-Key"
+
+// PaymasterRPCHandler serves the ERC-7677 paymaster methods, pm_getPaymasterStubData and
+// pm_getPaymasterData, to dapps holding a paymaster API key. Standard SDKs are pointed at
+// /paymaster?apikey=<key>.
+func (h *Handler) PaymasterRPCHandler(c *fiber.Ctx) error {
+	var req types.RPCRequest
+	if err := c.BodyParser(&req); err != nil {
+		return rpcError(c, fiber.StatusBadRequest, nil, RPC_PARSE_ERROR, "Invalid JSON")
+	}
+
+	ctx := c.UserContext()
+	apiKey := c.Query("apikey")
+	if apiKey == "" {
+		apiKey = c.Get(API_KEY_HEADER)
+	}
+	if apiKey == "" {
+		return rpcError(c, fiber.StatusUnauthorized, req.ID, RPC_UNAUTHORIZED, "missing API key")
+	}
+	key, err := h.SmartSigner.DB.GetPaymasterAPIKeyByHash(ctx, hashAPIKey(apiKey))
+	if errors.Is(err, db.ErrPaymasterKeyNotFound) {
+		return rpcError(c, fiber.StatusUnauthorized, req.ID, RPC_UNAUTHORIZED, "invalid API key")
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to look up paymaster API key", "error", err)
+		return rpcError(c, fiber.StatusInternalServerError, req.ID, RPC_INTERNAL_ERROR, "internal error")
+	}
+
+	switch req.Method {
+	case "pm_getPaymasterStubData":
+		return h.paymasterStubData(c, &req, key)
+	case "pm_getPaymasterData":
+		return h.paymasterData(c, &req, key)
+	}
+	return rpcError(c, fiber.StatusBadRequest, req.ID, RPC_METHOD_NOT_FOUND, fmt.Sprintf("Method not found: %s", req.Method))
+}
+
+// paymasterStubData answers the paymaster fields to estimate the op's gas with, once the
+// op's calls pass the policy.
+func (h *Handler) paymasterStubData(c *fiber.Ctx, req *types.RPCRequest, key *models.PaymasterAPIKey) error {
+	pm := h.SmartSigner.Paymaster
+	op, _, err := h.parsePaymasterParams(req.Params, true)
+	if err != nil {
+		return rpcError(c, fiber.StatusBadRequest, req.ID, RPC_INVALID_PARAMS, err.Error())
+	}
+
+	log := logging.FromContext(c.UserContext()).With("api_key", key.ID, "sender", op.Sender.Hex())
+	if err := pm.CheckKeyCall(key, op); err != nil {
+		log.Info("paymaster stub data refused", "reason", err)
+		return rpcError(c, fiber.StatusOK, req.ID, RPC_NOT_SPONSORED, err.Error())
+	}
+
+	stub, err := pm.StubPaymasterAndData()
+	if err != nil {
+		log.Error("failed to build paymaster stub data", "error", err)
+		return rpcError(c, fiber.StatusInternalServerError, req.ID, RPC_INTERNAL_ERROR, "internal error")
+	}
+	fields, err := userop.UnpackPaymasterAndData(stub)
+	if err != nil {
+		log.Error("failed to build paymaster stub data", "error", err)
+		return rpcError(c, fiber.StatusInternalServerError, req.ID, RPC_INTERNAL_ERROR, "internal error")
+	}
+
+	return c.JSON(types.RPCResponse{
+		JSONRPC: "2.0",
+		Result: fiber.Map{
+			"paymaster":                     fields.Paymaster,
+			"paymasterData":                 hexutil.Bytes(fields.PaymasterData),
+			"paymasterVerificationGasLimit": (*hexutil.Big)(fields.PaymasterVerificationGasLimit),
+			"paymasterPostOpGasLimit":       (*hexutil.Big)(fields.PaymasterPostOpGasLimit),
+			"isFinal":                       false,
+		},
+		ID: req.ID,
+	})
+}
+
+// paymasterData sponsors the op with its final gas values, counting it against the key's
+// quotas, and answers the signed paymasterData.
+func (h *Handler) paymasterData(c *fiber.Ctx, req *types.RPCRequest, key *models.PaymasterAPIKey) error {
+	pm := h.SmartSigner.Paymaster
+	op, verificationGasLimit, err := h.parsePaymasterParams(req.Params, false)
+	if err != nil {
+		return rpcError(c, fiber.StatusBadRequest, req.ID, RPC_INVALID_PARAMS, err.Error())
+	}
+
+	log := logging.FromContext(c.UserContext()).With("api_key", key.ID, "sender", op.Sender.Hex())
+	userOpHash, err := pm.SponsorForKey(c.UserContext(), key, op, verificationGasLimit)
+	if errors.Is(err, paymaster.ErrNotSponsored) {
+		log.Info("user operation not sponsored", "reason", err)
+		return rpcError(c, fiber.StatusOK, req.ID, RPC_NOT_SPONSORED, err.Error())
+	}
+	if err != nil {
+		log.Error("failed to sponsor user operation", "error", err)
+		return rpcError(c, fiber.StatusInternalServerError, req.ID, RPC_INTERNAL_ERROR, "internal error")
+	}
+	log.Info("user operation sponsored", "userOpHash", userOpHash.Hex())
+
+	fields, err := userop.UnpackPaymasterAndData(op.PaymasterAndData)
+	if err != nil {
+		log.Error("failed to unpack paymaster data", "error", err)
+		return rpcError(c, fiber.StatusInternalServerError, req.ID, RPC_INTERNAL_ERROR, "internal error")
+	}
+
+	return c.JSON(types.RPCResponse{
+		JSONRPC: "2.0",
+		Result: fiber.Map{
+			"paymaster":     fields.Paymaster,
+			"paymasterData": hexutil.Bytes(fields.PaymasterData),
+		},
+		ID: req.ID,
+	})
+}
+
+// parsePaymasterParams decodes the ERC-7677 params [userOp, entryPoint, chainId, context]
+// into the packed op, without paymaster fields, and the paymaster verification gas limit
+// the op carries, if any. The context is not used. For a stub, gas values the SDK hasn't
+// estimated yet may be missing and count as zero.
+func (h *Handler) parsePaymasterParams(params json.RawMessage, stub bool) (*types.PackedUserOperation, *big.Int, error) {
+	var positional []json.RawMessage
+	if err := json.Unmarshal(params, &positional); err != nil || len(positional) < 3 {
+		return nil, nil, fmt.Errorf("Invalid params")
+	}
+
+	var op types.UserOperation
+	if err := json.Unmarshal(positional[0], &op); err != nil {
+		return nil, nil, fmt.Errorf("invalid userOp: %v", err)
+	}
+	var entryPoint common.Address
+	if err := json.Unmarshal(positional[1], &entryPoint); err != nil {
+		return nil, nil, fmt.Errorf("invalid entryPoint: %v", err)
+	}
+	if entryPoint != h.SmartSigner.EthClient.EntryPoint() {
+		return nil, nil, fmt.Errorf("unsupported entryPoint: %s", entryPoint.Hex())
+	}
+	var chainID hexutil.Big
+	if err := json.Unmarshal(positional[2], &chainID); err != nil {
+		return nil, nil, fmt.Errorf("invalid chainId: %v", err)
+	}
+	if chainID.ToInt().Cmp(h.SmartSigner.EthClient.ChainID()) != 0 {
+		return nil, nil, fmt.Errorf("unsupported chainId: %s", chainID.String())
+	}
+
+	var verificationGasLimit *big.Int
+	if op.PaymasterVerificationGasLimit != nil {
+		verificationGasLimit = op.PaymasterVerificationGasLimit.ToInt()
+	}
+	op.Paymaster, op.PaymasterVerificationGasLimit, op.PaymasterPostOpGasLimit, op.PaymasterData = nil, nil, nil, nil
+
+	if stub {
+		for _, v := range []**hexutil.Big{&op.CallGasLimit, &op.VerificationGasLimit, &op.PreVerificationGas, &op.MaxFeePerGas, &op.MaxPriorityFeePerGas} {
+			if *v == nil {
+				*v = new(hexutil.Big)
+			}
+		}
+	}
+	if op.Signature == nil {
+		op.Signature = hexutil.Bytes{}
+	}
+
+	packed, err := op.Pack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid userOp: %v", err)
+	}
+	return packed, verificationGasLimit, nil
+}
+
+// SetupPaymasterKeyRoutes registers the paymaster API key administration under
+// /admin/paymaster/keys, behind auth.
+func (h *Handler) SetupPaymasterKeyRoutes(app *fiber.App, auth fiber.Handler) {
+	admin := app.Group("/admin/paymaster/keys", auth)
+	admin.Post("/", h.CreatePaymasterKeyHandler)
+	admin.Get("/", h.ListPaymasterKeysHandler)
+	admin.Get("/:id/usage", h.PaymasterKeyUsageHandler)
+	admin.Delete("/:id", h.RevokePaymasterKeyHandler)
+}
+
+// CreatePaymasterKeyHandler issues an API key. The answer holds the key, which is never
+// shown again; only its SHA-256 is stored.
+func (h *Handler) CreatePaymasterKeyHandler(c *fiber.Ctx) error {
+	var req models.CreatePaymasterKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+	}
+	if req.DailyBudget == nil || req.DailyBudget.Sign() <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "daily_budget is required"})
+	}
+	if req.DailyOps < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "daily_ops must not be negative"})
+	}
+	targets := make([]string, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		if !common.IsHexAddress(target) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid target address: " + target})
+		}
+		targets = append(targets, common.HexToAddress(target).Hex())
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate key"})
+	}
+	apiKey := "pm_" + hex.EncodeToString(secret[:])
+
+	key := models.PaymasterAPIKey{
+		Name:        req.Name,
+		Key:         apiKey,
+		KeyPrefix:   apiKey[:10],
+		Targets:     targets,
+		DailyOps:    req.DailyOps,
+		DailyBudget: req.DailyBudget,
+	}
+
+	if err := h.SmartSigner.DB.AddPaymasterAPIKey(c.UserContext(), &key, hashAPIKey(apiKey)); err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to add paymaster API key", "name", req.Name, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(key)
+}
+
+func (h *Handler) ListPaymasterKeysHandler(c *fiber.Ctx) error {
+	keys, err := h.SmartSigner.DB.GetPaymasterAPIKeys(c.UserContext())
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to list paymaster API keys", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	return c.JSON(fiber.Map{"keys": keys})
+}
+
+// PaymasterKeyUsageHandler sums what a key had sponsored per UTC day, over the last
+// ?days= days (default 30).
+func (h *Handler) PaymasterKeyUsageHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 366 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 366"})
+	}
+
+	usage, err := h.SmartSigner.DB.GetPaymasterKeyUsage(c.UserContext(), id, days)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to get paymaster API key usage", "id", id, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	return c.JSON(fiber.Map{"usage": usage})
+}
+
+// RevokePaymasterKeyHandler deactivates a key; its usage stays on record.
+func (h *Handler) RevokePaymasterKeyHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	err = h.SmartSigner.DB.RevokePaymasterAPIKey(c.UserContext(), id)
+	if errors.Is(err, db.ErrPaymasterKeyNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to revoke paymaster API key", "id", id, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func rpcError(c *fiber.Ctx, status int, id interface{}, code int, message string) error {
+	return c.Status(status).JSON(types.RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &types.RPCError{Code: code, Message: message},
+		ID:      id,
+	})
+}
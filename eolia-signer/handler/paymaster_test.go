@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"eolia-signer/ethclient"
+	"eolia-signer/signer"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testEntryPoint = "0x379FF91b96c038ECb0dc6aCFb44366a39f0de566"
+
+// newTestHandler is a Handler on chain 196 (0xc4), with testEntryPoint, where only
+// contracts have code.
+func newTestHandler(t *testing.T, contracts ...common.Address) *Handler {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": "0x1"}
+		switch req.Method {
+		case "eth_chainId":
+			resp["result"] = "0xc4"
+		case "eth_getCode":
+			var address common.Address
+			json.Unmarshal(req.Params[0], &address)
+			resp["result"] = "0x"
+			if slices.Contains(contracts, address) {
+				resp["result"] = "0x6000"
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	// NewClient reads the ABIs relative to the module root.
+	t.Chdir("..")
+	client := ethclient.NewClient([]string{server.URL}, testEntryPoint, "0x00000000000000000000000000000000000000f0")
+	t.Cleanup(client.Close)
+	return &Handler{SmartSigner: &signer.SmartSigner{EthClient: client}}
+}
+
+func TestParsePaymasterParams(t *testing.T) {
+	h := newTestHandler(t)
+
+	const op = `{"sender":"0x00000000000000000000000000000000000000a1","nonce":"0x1","callData":"0x",` +
+		`"callGasLimit":"0x30d40","verificationGasLimit":"0x186a0","preVerificationGas":"0xc350",` +
+		`"maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0x3b9aca00"`
+	const unestimated = `{"sender":"0x00000000000000000000000000000000000000a1","nonce":"0x1","callData":"0x"}`
+	const withPaymaster = op + `,"paymaster":"0x00000000000000000000000000000000000000fa",` +
+		`"paymasterVerificationGasLimit":"0x11170","paymasterPostOpGasLimit":"0x0","paymasterData":"0x1234"}`
+
+	tests := []struct {
+		name   string
+		params string
+		stub   bool
+		// Paymaster verification gas limit carried by the op, 0 for none.
+		verificationGasLimit int64
+		wantErr              string
+	}{
+		{name: "estimated op", params: `[` + op + `}, "` + testEntryPoint + `", "0xc4", {}]`},
+		{name: "lower-case entryPoint, no context", params: `[` + op + `}, "` + strings.ToLower(testEntryPoint) + `", "0xc4"]`},
+		{name: "paymaster fields are dropped", params: `[` + withPaymaster + `, "` + testEntryPoint + `", "0xc4", {}]`, verificationGasLimit: 70_000},
+		{name: "stub of an unestimated op", params: `[` + unestimated + `, "` + testEntryPoint + `", "0xc4", {}]`, stub: true},
+		{name: "unestimated op", params: `[` + unestimated + `, "` + testEntryPoint + `", "0xc4", {}]`, wantErr: "invalid userOp"},
+		{name: "other entryPoint", params: `[` + op + `}, "0x0000000071727De22E5E9d8BAf0edAc6f37da032", "0xc4", {}]`, wantErr: "unsupported entryPoint"},
+		{name: "other chain", params: `[` + op + `}, "` + testEntryPoint + `", "0x1", {}]`, wantErr: "unsupported chainId"},
+		{name: "decimal chainId", params: `[` + op + `}, "` + testEntryPoint + `", "196", {}]`, wantErr: "invalid chainId"},
+		{name: "missing chainId", params: `[` + op + `}, "` + testEntryPoint + `"]`, wantErr: "Invalid params"},
+		{name: "named params", params: `{"userOp":` + op + `}}`, wantErr: "Invalid params"},
+		{name: "malformed op", params: `["op", "` + testEntryPoint + `", "0xc4"]`, wantErr: "invalid userOp"},
+	}
+	for _, tt := range tests {
+		packed, verificationGasLimit, err := h.parsePaymasterParams(json.RawMessage(tt.params), tt.stub)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: parsePaymasterParams error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parsePaymasterParams: %v", tt.name, err)
+			continue
+		}
+
+		if len(packed.PaymasterAndData) != 0 {
+			t.Errorf("%s: op kept paymasterAndData %x", tt.name, packed.PaymasterAndData)
+		}
+		if tt.verificationGasLimit == 0 && verificationGasLimit != nil {
+			t.Errorf("%s: paymaster verification gas limit = %s, want none", tt.name, verificationGasLimit)
+		}
+		if tt.verificationGasLimit != 0 && (verificationGasLimit == nil || verificationGasLimit.Int64() != tt.verificationGasLimit) {
+			t.Errorf("%s: paymaster verification gas limit = %v, want %d", tt.name, verificationGasLimit, tt.verificationGasLimit)
+		}
+	}
+}
@@ -5,16 +5,20 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"eolia-common/logging"
+	"eolia-common/tracing"
 	"eolia-signer/models"
+	"eolia-signer/paymaster"
 	"eolia-signer/types"
-	"fmt"
-	"log"
+	"errors"
 	"math/big"
 	"net/http"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func hexToBytes32(hexStr string) [32]byte {
@@ -58,16 +62,33 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 	accountAddress := c.Locals("account_address").(string)
 	ownerAddress := c.Locals("owner_address").(string)
 
-	log.Println("📥 SignRequest received")
-	log.Printf("🔐 Wallet: %s, Account: %s, Owner: %s", walletName, accountAddress, ownerAddress)
+	ctx := c.UserContext()
+	log := logging.FromContext(ctx).With("wallet", walletName, "sender", accountAddress)
+	log.Debug("sign request received", "owner", ownerAddress)
 
 	account := common.HexToAddress(accountAddress)
 
+	callData := hexToBytes(req.CallData)
+	if len(req.Calls) > 0 {
+		if req.CallData != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "call_data and calls are exclusive"})
+		}
+		var err error
+		callData, err = h.encodeCalls(ctx, account, req.Calls)
+		if errors.Is(err, errInvalidCall) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Error("failed to encode calls", "calls", len(req.Calls), "error", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
+		}
+	}
+
 	userOP := &types.PackedUserOperation{
 		Sender:             account,
-		Nonce:              h.SmartSigner.EthClient.GetNonce(account),
-		InitCode:           h.SmartSigner.EthClient.AccountNeedsInitialization(account, common.HexToAddress(ownerAddress)),
-		CallData:           hexToBytes(req.CallData),
+		Nonce:              h.SmartSigner.EthClient.GetNonce(ctx, account),
+		InitCode:           h.SmartSigner.EthClient.AccountNeedsInitialization(ctx, account, common.HexToAddress(ownerAddress)),
+		CallData:           callData,
 		AccountGasLimits:   hexToBytes32(req.AccountGasLimits),
 		PreVerificationGas: hexToBigInt(req.PreVerificationGas),
 		GasFees:            hexToBytes32(req.GasFees),
@@ -75,10 +96,55 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 		Signature:          []byte{},
 	}
 
-	userOpHash := h.SmartSigner.EthClient.GetUserOpHash(userOP)
-	sig, err := h.SmartSigner.TurnkeyClient.SignHash(ownerAddress, userOpHash.Hex())
+	// The paymaster data is part of the userOpHash, so the paymasters come before signing.
+	// Paying in a token is the user's choice; otherwise the op is sponsored when the policy allows.
+	pm := h.SmartSigner.Paymaster
+	sponsored := false
+	var gasPayment *paymaster.TokenQuote
+	switch {
+	case req.GasToken != "":
+		if pm == nil || !pm.AcceptsTokens() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "paying gas in tokens is not enabled"})
+		}
+		quote, err := pm.PayWithToken(ctx, userOP, req.GasToken)
+		if errors.Is(err, paymaster.ErrUnsupportedToken) || errors.Is(err, paymaster.ErrInsufficientTokenBalance) ||
+			errors.Is(err, paymaster.ErrInsufficientAllowance) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			log.Error("failed to quote gas in token", "token", req.GasToken, "error", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
+		}
+		gasPayment = quote
+		log = log.With("gas_token", quote.Symbol)
+	case pm != nil && pm.Sponsoring():
+		if _, err := pm.Sponsor(ctx, walletName, userOP); err == nil {
+			sponsored = true
+		} else if errors.Is(err, paymaster.ErrNotSponsored) {
+			log.Info("user operation not sponsored", "reason", err)
+		} else {
+			log.Error("failed to sponsor user operation", "error", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
+		}
+	}
+
+	userOpHash := h.SmartSigner.EthClient.GetUserOpHash(ctx, userOP)
+	log = log.With("userOpHash", userOpHash.Hex(), "sponsored", sponsored)
+
+	// Gives the budget back when the op doesn't reach the bundler's mempool.
+	release := func() {
+		if !sponsored {
+			return
+		}
+		if err := pm.Release(context.Background(), userOpHash); err != nil {
+			log.Error("failed to release sponsorship", "error", err)
+		}
+	}
+
+	sig, err := h.SmartSigner.TurnkeyClient.SignHash(ctx, ownerAddress, userOpHash.Hex())
 	if err != nil {
-		log.Printf("failed to sign user operation: %v", err)
+		log.Error("failed to sign user operation", "error", err)
+		release()
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
 	}
 
@@ -86,9 +152,9 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 		Sender:             userOP.Sender.Hex(),
 		Nonce:              toHexMin1Byte(userOP.Nonce),
 		InitCode:           "0x" + hex.EncodeToString(userOP.InitCode),
-		CallData:           req.CallData,
-		AccountGasLimits:   req.AccountGasLimits,
-		PreVerificationGas: req.PreVerificationGas,
+		CallData:           "0x" + hex.EncodeToString(userOP.CallData),
+		AccountGasLimits:   "0x" + hex.EncodeToString(userOP.AccountGasLimits[:]),
+		PreVerificationGas: toHexMin1Byte(userOP.PreVerificationGas),
 		GasFees:            req.GasFees,
 		PaymasterAndData:   "0x" + hex.EncodeToString(userOP.PaymasterAndData),
 		Signature:          sig,
@@ -107,11 +173,26 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 	}
 
 	jsonBytes, _ := json.Marshal(body)
-	fmt.Printf("Sending User Operation: %s\n", userOpHash.Hex())
+	log.Info("sending user operation to bundlr", "nonce", userOP.Nonce.String())
+
+	sendCtx, sendSpan := tracing.StartClient(ctx, "bundlr.sendUserOp", attribute.String("userop.hash", userOpHash.Hex()))
+	defer sendSpan.End()
+
+	bundlrReq, err := http.NewRequestWithContext(sendCtx, http.MethodPost, h.BundlrURL+"/rpc/sendUserOp", bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		log.Error("failed to build bundlr request", "error", err)
+		release()
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
+	}
+	bundlrReq.Header.Set("Content-Type", "application/json")
+	bundlrReq.Header.Set(logging.REQUEST_ID_HEADER, logging.RequestID(ctx))
+	tracing.Inject(sendCtx, propagation.HeaderCarrier(bundlrReq.Header))
 
-	resp, err := http.Post("http://127.0.0.1:8181/rpc/sendUserOp", "application/json", bytes.NewBuffer(jsonBytes))
+	resp, err := http.DefaultClient.Do(bundlrReq)
 	if err != nil {
-		log.Printf("failed to send user operation: %v", err)
+		sendSpan.RecordError(err)
+		log.Error("failed to send user operation", "error", err)
+		release()
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 	defer resp.Body.Close()
@@ -124,12 +205,14 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
-		log.Printf("❌ Failed to decode Bundlr response: %v", err)
+		log.Error("failed to decode bundlr response", "status", resp.StatusCode, "error", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "invalid bundlr response"})
 	}
 
 	if rpcResp.Error != nil {
-		log.Printf("⛔ Bundlr error: %s", rpcResp.Error.Message)
+		sendSpan.SetAttributes(attribute.Int("rpc.error_code", rpcResp.Error.Code))
+		log.Warn("bundlr rejected user operation", "code", rpcResp.Error.Code, "error", rpcResp.Error.Message)
+		release()
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"error": rpcResp.Error.Message,
 			"code":  rpcResp.Error.Code,
@@ -137,9 +220,32 @@ func (h *Handler) SignHandler(c *fiber.Ctx) error {
 	}
 
 	if err := h.SmartSigner.DB.UpdateLastLogin(context.Background(), walletName); err != nil {
-		log.Printf("failed to update last login: %v", err)
+		log.Error("failed to update last login", "error", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal error"})
 	}
 
-	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"userOpHash": userOpHash.Hex()})
+	log.Info("user operation accepted by bundlr")
+
+	result := fiber.Map{"userOpHash": userOpHash.Hex(), "sponsored": sponsored, "preVerificationGas": userOpJson.PreVerificationGas}
+	if plan, err := h.callPlan(userOP.CallData); err == nil {
+		result["plan"] = plan
+	}
+	if gasPayment != nil {
+		result["gasPayment"] = gasPayment
+	}
+	return c.Status(fiber.StatusAccepted).JSON(result)
+}
+
+// GasTokensHandler lists the tokens /sign accepts in gas_token, with their current exchange
+// rate (token units worth 1e18 wei, markup included).
+func (h *Handler) GasTokensHandler(c *fiber.Ctx) error {
+	pm := h.SmartSigner.Paymaster
+	if pm == nil || !pm.AcceptsTokens() {
+		return c.JSON(fiber.Map{"tokens": []paymaster.TokenQuote{}})
+	}
+
+	return c.JSON(fiber.Map{
+		"paymaster": pm.TokenPaymasterAddress().Hex(),
+		"tokens":    pm.Quotes(c.UserContext()),
+	})
 }
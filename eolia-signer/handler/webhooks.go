@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"eolia-common/logging"
+	"eolia-signer/db"
+	"eolia-signer/models"
+	"eolia-signer/webhook"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Dead letters listed per request.
+const DEAD_LETTER_PAGE = 100
+
+// SetupWebhookRoutes registers the webhook administration under /admin/webhooks, behind auth.
+func (h *Handler) SetupWebhookRoutes(app *fiber.App, auth fiber.Handler) {
+	admin := app.Group("/admin/webhooks", auth)
+	admin.Post("/", h.CreateWebhookHandler)
+	admin.Get("/", h.ListWebhooksHandler)
+	admin.Get("/dead-letters", h.ListDeadLettersHandler)
+	admin.Post("/dead-letters/replay", h.ReplayDeadLettersHandler)
+	admin.Post("/dead-letters/:id/replay", h.ReplayDeadLetterHandler)
+	admin.Delete("/:id", h.DeleteWebhookHandler)
+}
+
+// CreateWebhookHandler registers an endpoint. The answer holds the signing secret, which
+// is never shown again.
+func (h *Handler) CreateWebhookHandler(c *fiber.Ctx) error {
+	var req models.CreateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "url must be an http(s) URL"})
+	}
+	for _, eventType := range req.EventTypes {
+		if !webhook.IsEventType(eventType) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":       "unknown event type: " + eventType,
+				"event_types": webhook.EventTypes,
+			})
+		}
+	}
+	senders := make([]string, 0, len(req.Senders))
+	for _, sender := range req.Senders {
+		if !common.IsHexAddress(sender) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid sender address: " + sender})
+		}
+		senders = append(senders, strings.ToLower(common.HexToAddress(sender).Hex()))
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate secret"})
+	}
+
+	endpoint := models.WebhookEndpoint{
+		URL:        req.URL,
+		Secret:     hex.EncodeToString(secret[:]),
+		EventTypes: req.EventTypes,
+		Senders:    senders,
+	}
+	if endpoint.EventTypes == nil {
+		endpoint.EventTypes = []string{}
+	}
+
+	if err := h.SmartSigner.DB.AddWebhookEndpoint(c.UserContext(), &endpoint); err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to add webhook endpoint", "url", req.URL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(endpoint)
+}
+
+func (h *Handler) ListWebhooksHandler(c *fiber.Ctx) error {
+	endpoints, err := h.SmartSigner.DB.GetWebhookEndpoints(c.UserContext())
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to list webhook endpoints", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	return c.JSON(fiber.Map{"webhooks": endpoints})
+}
+
+// DeleteWebhookHandler removes an endpoint along with its pending deliveries and dead letters.
+func (h *Handler) DeleteWebhookHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid webhook id"})
+	}
+
+	if err := h.SmartSigner.DB.DeleteWebhookEndpoint(c.UserContext(), id); err != nil {
+		if errors.Is(err, db.ErrWebhookNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		logging.FromContext(c.UserContext()).Error("failed to delete webhook endpoint", "id", id, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// ListDeadLettersHandler lists the latest dead letters, of one endpoint with ?endpoint_id=.
+func (h *Handler) ListDeadLettersHandler(c *fiber.Ctx) error {
+	endpointID := c.QueryInt("endpoint_id")
+
+	letters, err := h.SmartSigner.DB.GetWebhookDeadLetters(c.UserContext(), endpointID, DEAD_LETTER_PAGE)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to list webhook dead letters", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	return c.JSON(fiber.Map{"dead_letters": letters})
+}
+
+// ReplayDeadLetterHandler queues a dead letter for delivery again, with a fresh set of attempts.
+func (h *Handler) ReplayDeadLetterHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid dead letter id"})
+	}
+
+	return h.replayDeadLetters(c, id, 0)
+}
+
+// ReplayDeadLettersHandler queues every dead letter of ?endpoint_id= for delivery again,
+// e.g. once the endpoint is fixed.
+func (h *Handler) ReplayDeadLettersHandler(c *fiber.Ctx) error {
+	endpointID := c.QueryInt("endpoint_id")
+	if endpointID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "endpoint_id is required"})
+	}
+
+	return h.replayDeadLetters(c, 0, endpointID)
+}
+
+func (h *Handler) replayDeadLetters(c *fiber.Ctx, deadLetterID int64, endpointID int) error {
+	replayed, err := h.SmartSigner.DB.ReplayWebhookDeadLetters(c.UserContext(), deadLetterID, endpointID)
+	if err != nil {
+		logging.FromContext(c.UserContext()).Error("failed to replay webhook dead letters", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	if deadLetterID != 0 && replayed == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "dead letter not found"})
+	}
+
+	if h.Webhooks != nil {
+		h.Webhooks.Wake()
+	}
+	return c.JSON(fiber.Map{"replayed": replayed})
+}
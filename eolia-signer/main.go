@@ -20,40 +20,112 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"eolia-common/health"
+	"eolia-common/logging"
+	"eolia-common/settings"
+	"eolia-common/tracing"
+	"eolia-signer/config"
 	"eolia-signer/db"
 	"eolia-signer/ethclient"
 	"eolia-signer/handler"
 	"eolia-signer/middleware"
+	"eolia-signer/paymaster"
 	"eolia-signer/signer"
 	"eolia-signer/turnkey"
+	"eolia-signer/utils"
+	"eolia-signer/webhook"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
 )
 
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func main() {
 	godotenv.Load()
 
+	var overrides settings.Overrides
+	configPath := flag.String("config", "config.yaml", "path to the optional config file")
+	flag.Var(&overrides, "set", "override a setting, e.g. -set listen=:9090 (repeatable)")
+	flag.Parse()
+
+	cfg := config.LoadConfig(*configPath, isFlagSet("config"), overrides)
+	if err := logging.Setup(cfg.Log, "eolia-signer"); err != nil {
+		log.Fatalf("invalid log config: %v", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, "eolia-signer")
+	if err != nil {
+		log.Fatalf("invalid tracing config: %v", err)
+	}
+	utils.SetJWTSecret(cfg.JWTSecret)
+
 	smartSigner := &signer.SmartSigner{
-		TurnkeyClient: turnkey.CreateTurnkeyClient(),
-		DB:            db.CreateDBPool(),
-		EthClient:     ethclient.NewClient("https://rpc.xlayer.tech", "0x379FF91b96c038ECb0dc6aCFb44366a39f0de566", "0xC924da88e33fD1eD04f4A8a1f6BD14Ad030a3dC9"),
+		TurnkeyClient: turnkey.CreateTurnkeyClient(cfg.TurnkeyAPIKeyName, cfg.TurnkeyOrganization),
+		DB:            db.CreateDBPool(cfg.DBConnStr),
+		EthClient:     ethclient.NewClient(cfg.RPCURLs, cfg.EntryPoint, cfg.Factory),
+	}
+
+	if cfg.Paymaster.Enabled() {
+		pm, err := paymaster.New(cfg.Paymaster, smartSigner.EthClient, smartSigner.DB, smartSigner.TurnkeyClient)
+		if err != nil {
+			log.Fatalf("invalid paymaster config: %v", err)
+		}
+		smartSigner.Paymaster = pm
+		slog.Info("paymasters enabled", "verifying_paymaster", pm.Address().Hex(), "token_paymaster", pm.TokenPaymasterAddress().Hex(), "verifying_signer", pm.Signer().Hex())
 	}
 
 	h := &handler.Handler{
 		SmartSigner: smartSigner,
+		BundlrURL:   strings.TrimSuffix(cfg.BundlrURL, "/"),
+
+		BundlrAdminToken: cfg.BundlrAdminToken,
+	}
+
+	// Cancelled on shutdown, stopping the background workers.
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	if smartSigner.Paymaster != nil && smartSigner.Paymaster.Sponsoring() {
+		go smartSigner.Paymaster.Settle(ctx)
+	}
+
+	if cfg.Webhooks.Enabled {
+		h.Webhooks = webhook.NewDispatcher(smartSigner.DB, cfg.Webhooks)
+		listener := &webhook.Listener{
+			DB:               smartSigner.DB,
+			Eth:              smartSigner.EthClient,
+			BundlrURL:        h.BundlrWebSocketURL(),
+			BundlrAdminToken: cfg.BundlrAdminToken,
+			Dispatcher:       h.Webhooks,
+		}
+		go h.Webhooks.Run(ctx)
+		go listener.Run(ctx)
 	}
 
 	app := fiber.New()
+	app.Use(logging.RequestLogger(slog.LevelInfo))
+	app.Use(middleware.RequestTracer())
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "http://localhost:3000",
+		AllowOrigins:     cfg.CORSOrigins,
 		AllowCredentials: true,
 	}))
 
+	// Liveness only needs the process to serve HTTP; readiness checks the dependencies.
+	app.Get("/healthz", handler.HealthHandler(&health.Checker{}))
+	app.Get("/readyz", handler.HealthHandler(h.Readiness()))
+
 	app.Post("/auth", h.AuthLoginHandler)
 	app.Post("/auth/register", h.AuthRegisterHandler)
 	app.Post("/auth/logout", h.AuthLogoutHandler)
@@ -64,9 +136,53 @@ func main() {
 	app.Post("/sign", middleware.RequireJWT(), h.SignHandler)
 	app.Post("/addTx", middleware.RequireJWT(), h.AddTxHandler)
 	app.Get("/txHistory", middleware.RequireJWT(), h.GetTxHandler)
+	app.Get("/userOpEvents", middleware.RequireJWT(), h.UserOpEventsHandler)
+	app.Get("/gasTokens", middleware.RequireJWT(), h.GasTokensHandler)
+	sponsoring := smartSigner.Paymaster != nil && smartSigner.Paymaster.Sponsoring()
+	if sponsoring {
+		app.Post("/paymaster", h.PaymasterRPCHandler)
+	}
 
-	log.Println("SmartSigner server running on http://localhost:8080")
-	if err := app.Listen(":8080"); err != nil {
-		log.Fatal(err)
+	if cfg.AdminToken != "" {
+		effective := settings.Redact(cfg)
+		app.Get("/admin/config", middleware.RequireAdminToken(cfg.AdminToken), func(c *fiber.Ctx) error {
+			return c.JSON(effective)
+		})
+		h.SetupWebhookRoutes(app, middleware.RequireAdminToken(cfg.AdminToken))
+		if sponsoring {
+			h.SetupPaymasterKeyRoutes(app, middleware.RequireAdminToken(cfg.AdminToken))
+		}
 	}
+
+	// Stop on SIGINT/SIGTERM so the spans still buffered get exported.
+	go func() {
+		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-sigCtx.Done()
+		stopWorkers()
+		app.ShutdownWithTimeout(SHUTDOWN_TIMEOUT)
+	}()
+
+	slog.Info("SmartSigner server running", "addr", cfg.Listen)
+	if err := app.Listen(cfg.Listen); err != nil {
+		slog.Error("fiber listen failed", "error", err)
+		os.Exit(1)
+	}
+	smartSigner.EthClient.Close()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
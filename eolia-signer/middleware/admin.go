@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAdminToken only lets through requests carrying "Authorization: Bearer <token>".
+func RequireAdminToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		got := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"fmt"
+
+	"eolia-common/logging"
+	"eolia-common/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// RequestTracer opens the server span of each request and puts it in the user context,
+// so Turnkey, RPC and bundler calls made by the handler become its children. Log lines
+// of the request get the trace_id. Must run after logging.RequestLogger.
+func RequestTracer() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, span := tracing.StartServer(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()), c.Method()+" "+c.Path(),
+			attribute.String("http.request.method", c.Method()),
+			attribute.String("url.path", c.Path()),
+		)
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", sc.TraceID().String()))
+		}
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		span.SetName(c.Method() + " " + c.Route().Path)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+
+		spanErr := err
+		if spanErr == nil && status >= fiber.StatusInternalServerError {
+			spanErr = fmt.Errorf("HTTP %d", status)
+		}
+		tracing.End(span, spanErr)
+		return err
+	}
+}
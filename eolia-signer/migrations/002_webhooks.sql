@@ -0,0 +1,58 @@
+-- Outbound webhooks for user operation outcomes
+-- 用户操作结果的出站 Webhook
+-- Endpoints registered by integrators, the delivery queue and the dead letters
+-- 集成方注册的端点、投递队列和死信
+
+-- Registered endpoints / 已注册的端点
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    senders TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per event and endpoint / 每个事件和端点一行
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint_id INTEGER NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id VARCHAR(100) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (endpoint_id, event_id)
+);
+
+-- Deliveries that ran out of attempts / 重试次数用尽的投递
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT UNIQUE NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    endpoint_id INTEGER NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_status_code INTEGER,
+    last_error TEXT,
+    failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance / 创建索引以提高性能
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_endpoint ON webhook_dead_letters(endpoint_id, failed_at);
+
+-- Add comments for documentation / 添加注释用于文档说明
+COMMENT ON TABLE webhook_endpoints IS 'Integrator endpoints notified of user operation outcomes';
+COMMENT ON COLUMN webhook_endpoints.secret IS 'HMAC-SHA256 key of the X-Eolia-Signature header';
+COMMENT ON COLUMN webhook_endpoints.event_types IS 'Event types delivered to the endpoint, empty for all';
+COMMENT ON COLUMN webhook_endpoints.senders IS 'Lower-case account addresses the endpoint follows, empty for all';
+COMMENT ON TABLE webhook_deliveries IS 'Webhook delivery queue; status is pending, delivered or dead';
+COMMENT ON COLUMN webhook_deliveries.event_id IS 'Stable event id (<userOpHash>:<status>), the same across retries and replays';
+COMMENT ON COLUMN webhook_deliveries.next_attempt_at IS 'When the delivery is due; pushed ahead while a worker holds it';
+COMMENT ON TABLE webhook_dead_letters IS 'Deliveries that failed every attempt, kept until replayed';
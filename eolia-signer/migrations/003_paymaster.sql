@@ -0,0 +1,25 @@
+-- Gas sponsorship by the VerifyingPaymaster
+-- VerifyingPaymaster 的 Gas 赞助
+-- One row per sponsored op, counted against the daily budgets of the paymaster policy
+-- 每个被赞助的操作一行，计入 paymaster 策略的每日预算
+
+-- Sponsored operations / 被赞助的操作
+CREATE TABLE IF NOT EXISTS paymaster_sponsorships (
+    id BIGSERIAL PRIMARY KEY,
+    user_op_hash VARCHAR(66) UNIQUE NOT NULL,
+    wallet_name VARCHAR(255) NOT NULL,
+    sender VARCHAR(42) NOT NULL,
+    targets TEXT[] NOT NULL DEFAULT '{}',
+    max_cost NUMERIC(78, 0) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+-- Create indexes for better performance / 创建索引以提高性能
+CREATE INDEX IF NOT EXISTS idx_paymaster_sponsorships_created_at ON paymaster_sponsorships(created_at);
+CREATE INDEX IF NOT EXISTS idx_paymaster_sponsorships_wallet ON paymaster_sponsorships(wallet_name, created_at);
+
+-- Add comments for documentation / 添加注释用于文档说明
+COMMENT ON TABLE paymaster_sponsorships IS 'User operations whose gas the paymaster agreed to pay';
+COMMENT ON COLUMN paymaster_sponsorships.targets IS 'Contracts called by the operation';
+COMMENT ON COLUMN paymaster_sponsorships.max_cost IS 'Gas limit times maxFeePerGas in wei, the most the operation can cost';
+COMMENT ON COLUMN paymaster_sponsorships.created_at IS 'UTC time of the sponsorship; budgets reset at UTC midnight';
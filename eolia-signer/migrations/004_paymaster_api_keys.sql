@@ -0,0 +1,33 @@
+-- Paymaster API keys for the ERC-7677 paymaster RPC
+-- ERC-7677 Paymaster RPC 的 API 密钥
+-- External dapps request sponsorship with a key; each key has its own daily quotas
+-- 外部 dapp 使用密钥请求赞助；每个密钥有自己的每日配额
+
+-- API keys, stored as their SHA-256 / API 密钥，以 SHA-256 哈希存储
+CREATE TABLE IF NOT EXISTS paymaster_api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    targets TEXT[] NOT NULL DEFAULT '{}',
+    daily_ops INTEGER NOT NULL DEFAULT 0,
+    daily_budget NUMERIC(78, 0) NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+-- Sponsorships requested with a key have no wallet / 通过密钥请求的赞助没有钱包
+ALTER TABLE paymaster_sponsorships ALTER COLUMN wallet_name DROP NOT NULL;
+ALTER TABLE paymaster_sponsorships ADD COLUMN IF NOT EXISTS api_key_id INTEGER REFERENCES paymaster_api_keys(id);
+
+-- Create indexes for better performance / 创建索引以提高性能
+CREATE INDEX IF NOT EXISTS idx_paymaster_sponsorships_api_key ON paymaster_sponsorships(api_key_id, created_at);
+
+-- Add comments for documentation / 添加注释用于文档说明
+COMMENT ON TABLE paymaster_api_keys IS 'Keys of the dapps allowed to request sponsorship over the paymaster RPC';
+COMMENT ON COLUMN paymaster_api_keys.key_hash IS 'Hex SHA-256 of the key; the key itself is only shown when created';
+COMMENT ON COLUMN paymaster_api_keys.key_prefix IS 'Start of the key, to recognize it in listings';
+COMMENT ON COLUMN paymaster_api_keys.targets IS 'Contracts the sponsored operations may call, on top of the policy targets; any when empty';
+COMMENT ON COLUMN paymaster_api_keys.daily_ops IS 'Sponsored operations per UTC day, 0 for no limit';
+COMMENT ON COLUMN paymaster_api_keys.daily_budget IS 'Wei sponsored per UTC day, 0 for no limit';
+COMMENT ON COLUMN paymaster_sponsorships.api_key_id IS 'Key the sponsorship was requested with, NULL for the signer''s own users';
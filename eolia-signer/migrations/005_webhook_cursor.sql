@@ -0,0 +1,14 @@
+-- Progress of the webhook listener through the chain
+-- Webhook 监听器在链上的进度
+-- The last block whose UserOperationEvents were queued, so that outcomes missed while the
+-- bundler connection was down are backfilled from the EntryPoint logs
+-- 最后一个已入队 UserOperationEvent 的区块，用于在与 bundler 断开期间从 EntryPoint 日志补齐遗漏的结果
+
+-- A single row / 仅一行
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_block BIGINT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE webhook_cursor IS 'Last block the webhook listener backfilled op outcomes up to';
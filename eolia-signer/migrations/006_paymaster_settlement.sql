@@ -0,0 +1,25 @@
+-- Settlement of sponsorships
+-- 赞助的结算
+-- A sponsorship that expires without its op being included on chain gives its budget back;
+-- API keys get a budget of their own, separate from the signer users'
+-- 在有效期内未上链的赞助会退还其预算；API 密钥拥有独立于签名服务用户的预算
+
+-- Validity and inclusion of each sponsorship / 每个赞助的有效期和上链状态
+ALTER TABLE paymaster_sponsorships ADD COLUMN IF NOT EXISTS valid_until TIMESTAMP;
+ALTER TABLE paymaster_sponsorships ADD COLUMN IF NOT EXISTS included BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Progress of the settlement through the chain, a single row / 结算在链上的进度，仅一行
+CREATE TABLE IF NOT EXISTS paymaster_cursor (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_block BIGINT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance / 创建索引以提高性能
+CREATE INDEX IF NOT EXISTS idx_paymaster_sponsorships_unsettled ON paymaster_sponsorships(valid_until) WHERE NOT included;
+
+-- Add comments for documentation / 添加注释用于文档说明
+COMMENT ON COLUMN paymaster_sponsorships.valid_until IS 'UTC end of the validity window signed into paymasterAndData; NULL for sponsorships older than settlement';
+COMMENT ON COLUMN paymaster_sponsorships.included IS 'Whether a UserOperationEvent of the operation was seen; sponsorships still not included after valid_until are deleted';
+COMMENT ON COLUMN paymaster_api_keys.daily_budget IS 'Wei sponsored per UTC day; keys without one are not sponsored';
+COMMENT ON TABLE paymaster_cursor IS 'Last block the sponsorship settlement read UserOperationEvents up to';
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"math/big"
+	"time"
+)
+
+// Sponsorship is the gas of one op taken on by the paymaster, counted against the budgets
+// at its maximum cost. It was requested either by a signer user (WalletName) or with a
+// paymaster API key (APIKeyID).
+type Sponsorship struct {
+	UserOpHash string
+	WalletName string
+	APIKeyID   int
+	Sender     string
+	Targets    []string
+	MaxCost    *big.Int
+	// End of the validity window signed into paymasterAndData. A sponsorship whose op isn't
+	// included by then is released.
+	ValidUntil time.Time
+}
+
+// SponsorshipUsage is what the sponsorships of the current (UTC) day add up to.
+type SponsorshipUsage struct {
+	// Max cost of every op sponsored for the signer's users, in wei.
+	Spent *big.Int
+	// Max cost of the user's sponsored ops, in wei.
+	UserSpent *big.Int
+	UserOps   int
+	// Max cost of the ops sponsored with any API key, in wei.
+	KeysSpent *big.Int
+	// Max cost of the ops sponsored with the API key, in wei.
+	KeySpent *big.Int
+	KeyOps   int
+}
+
+// PaymasterAPIKey lets a dapp request sponsorship over the ERC-7677 paymaster RPC.
+// DailyBudget is required; a zero DailyOps doesn't restrict.
+type PaymasterAPIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Only set when the key is created.
+	Key       string `json:"key,omitempty"`
+	KeyPrefix string `json:"key_prefix"`
+	// Contracts the ops may call, on top of the policy targets; any when empty.
+	Targets     []string  `json:"targets"`
+	DailyOps    int       `json:"daily_ops"`
+	DailyBudget *big.Int  `json:"daily_budget"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreatePaymasterKeyRequest struct {
+	Name        string   `json:"name"`
+	Targets     []string `json:"targets"`
+	DailyOps    int      `json:"daily_ops"`
+	DailyBudget *big.Int `json:"daily_budget"`
+}
+
+// PaymasterKeyUsage is what a key had sponsored on one UTC day.
+type PaymasterKeyUsage struct {
+	Day   string   `json:"day"`
+	Ops   int      `json:"ops"`
+	Spent *big.Int `json:"spent"`
+}
@@ -1,10 +1,28 @@
 package models
 
 type SignRequest struct {
-	CallData           string `json:"call_data"`
+	CallData string `json:"call_data"`
+	// Calls the op runs, in order, instead of call_data; the signer encodes them into
+	// execute or executeBatch.
+	Calls              []Call `json:"calls,omitempty"`
 	AccountGasLimits   string `json:"account_gas_limits"`
 	PreVerificationGas string `json:"pre_verification_gas"`
 	GasFees            string `json:"gas_fees"`
+	// Symbol or address of a token to pay the gas in, through the token paymaster.
+	GasToken string `json:"gas_token,omitempty"`
+}
+
+// Call is one call of an account, with hex-encoded value (wei) and data.
+type Call struct {
+	To    string `json:"to"`
+	Value string `json:"value,omitempty"`
+	Data  string `json:"data,omitempty"`
+}
+
+// CallPlan is what a signed op runs, decoded from its callData: the account method and its calls.
+type CallPlan struct {
+	Method string `json:"method"`
+	Calls  []Call `json:"calls"`
 }
 
 type SignResponse struct {
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type WebhookEndpoint struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	Senders    []string  `json:"senders"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL string `json:"url"`
+	// Empty for every event type.
+	EventTypes []string `json:"event_types"`
+	// Account addresses to follow, empty for every account.
+	Senders []string `json:"senders"`
+}
+
+// WebhookDelivery is a due delivery claimed by the dispatcher, with its endpoint.
+type WebhookDelivery struct {
+	ID         int64
+	EndpointID int
+	EventID    string
+	EventType  string
+	Payload    json.RawMessage
+	Attempts   int
+	URL        string
+	Secret     string
+}
+
+type WebhookDeadLetter struct {
+	ID             int64           `json:"id"`
+	DeliveryID     int64           `json:"delivery_id"`
+	EndpointID     int             `json:"endpoint_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	FailedAt       time.Time       `json:"failed_at"`
+}
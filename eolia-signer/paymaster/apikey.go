@@ -0,0 +1,126 @@
+package paymaster
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"eolia-common/tracing"
+	"eolia-common/userop"
+	"eolia-signer/models"
+	"eolia-signer/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// A signature of the right length that recovers to some address, standing in for the
+// verifyingSigner's during gas estimation. It has no zero byte, so no real signature costs
+// more calldata gas.
+var stubSignature = hexutil.MustDecode("0x16f9db3cac6c9a5c443c0459935d24c5ff9bb0a68937d166b8fcc41f858822b57ead500c8092b5b2e40ecf2df46c1f08a9f6599070a2bff3d7c518d41521378e1c")
+
+// Stands in for the validUntil of a quote; no real one has more non-zero bytes.
+var stubValidUntil = uint256Word(1<<48 - 1)
+
+// StubPaymasterAndData is the paymasterAndData of a VerifyingPaymaster sponsorship with a
+// placeholder signature, for estimating the gas of an op before it is sponsored.
+func (p *Paymaster) StubPaymasterAndData() ([]byte, error) {
+	paymasterAndData, err := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     p.address,
+		PaymasterVerificationGasLimit: p.verificationGasLimit,
+		PaymasterPostOpGasLimit:       new(big.Int),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(paymasterAndData, paymasterData(stubSignature, stubValidUntil, uint256Word(0))...), nil
+}
+
+// CheckKeyCall tells whether the op's calls may be sponsored with key, before its gas is
+// known. Budgets are only checked by SponsorForKey.
+func (p *Paymaster) CheckKeyCall(key *models.PaymasterAPIKey, op *types.PackedUserOperation) error {
+	targets, err := p.eth.CallTargets(op.CallData)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotSponsored, err)
+	}
+	return p.checkKeyCall(key, targets)
+}
+
+// SponsorForKey is Sponsor for an op a dapp submitted with a paymaster API key: the policy's
+// targets, max_op_cost and keys_daily_budget apply, along with the key's own targets and quotas.
+// verificationGasLimit is the paymaster verification gas limit the op was estimated with,
+// nil for the default.
+func (p *Paymaster) SponsorForKey(ctx context.Context, key *models.PaymasterAPIKey, op *types.PackedUserOperation, verificationGasLimit *big.Int) (_ common.Hash, err error) {
+	ctx, span := tracing.Start(ctx, "paymaster.SponsorForKey", attribute.String("userop.sender", op.Sender.Hex()), attribute.Int("paymaster.api_key", key.ID))
+	defer func() { endSponsorSpan(span, err) }()
+
+	targets, err := p.eth.CallTargets(op.CallData)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("%w: %v", ErrNotSponsored, err)
+	}
+	if err := p.checkKeyCall(key, targets); err != nil {
+		return common.Hash{}, err
+	}
+
+	if verificationGasLimit == nil || verificationGasLimit.Sign() == 0 {
+		verificationGasLimit = p.verificationGasLimit
+	}
+
+	// The SDK estimated the op with the stub paymasterAndData, which is as long as the final
+	// one and no cheaper, so its preVerificationGas already covers the paymaster fields.
+	// ERC-7677 has no way to hand back a raised one.
+	stub, err := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     p.address,
+		PaymasterVerificationGasLimit: verificationGasLimit,
+		PaymasterPostOpGasLimit:       new(big.Int),
+		PaymasterData:                 paymasterData(stubSignature, stubValidUntil, uint256Word(0)),
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	op.PaymasterAndData = stub
+	return p.sponsor(ctx, op, verificationGasLimit, targets, &models.Sponsorship{APIKeyID: key.ID}, func(usage models.SponsorshipUsage, maxCost *big.Int) error {
+		return p.checkKeyBudgets(key, usage, maxCost)
+	})
+}
+
+// checkKeyCall accepts calls to the policy targets and the key's targets; any call when
+// neither lists any.
+func (p *Paymaster) checkKeyCall(key *models.PaymasterAPIKey, targets []common.Address) error {
+	if p.targets == nil && len(key.Targets) == 0 {
+		return nil
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%w: op calls no allowed contract", ErrNotSponsored)
+	}
+
+	for _, target := range targets {
+		allowed := p.targets[target]
+		for _, t := range key.Targets {
+			allowed = allowed || common.HexToAddress(t) == target
+		}
+		if !allowed {
+			return fmt.Errorf("%w: contract %s is not sponsored", ErrNotSponsored, target.Hex())
+		}
+	}
+	return nil
+}
+
+// checkKeyBudgets holds the key to its own daily budget, which it must have, and to the
+// budget of all keys. Keys don't spend the daily_budget of the signer's users.
+func (p *Paymaster) checkKeyBudgets(key *models.PaymasterAPIKey, usage models.SponsorshipUsage, maxCost *big.Int) error {
+	if key.DailyBudget == nil || key.DailyBudget.Sign() <= 0 {
+		return fmt.Errorf("%w: API key has no daily budget", ErrNotSponsored)
+	}
+	if key.DailyOps > 0 && usage.KeyOps >= key.DailyOps {
+		return fmt.Errorf("%w: daily op quota of the API key reached", ErrNotSponsored)
+	}
+	if new(big.Int).Add(usage.KeySpent, maxCost).Cmp(key.DailyBudget) > 0 {
+		return fmt.Errorf("%w: daily budget of the API key exhausted", ErrNotSponsored)
+	}
+	if p.policy.KeysDailyBudget > 0 && overBudget(usage.KeysSpent, maxCost, p.policy.KeysDailyBudget) {
+		return fmt.Errorf("%w: daily budget of API keys exhausted", ErrNotSponsored)
+	}
+	return nil
+}
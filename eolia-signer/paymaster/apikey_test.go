@@ -0,0 +1,82 @@
+package paymaster
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"eolia-signer/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckKeyCall(t *testing.T) {
+	tests := []struct {
+		name          string
+		policyTargets []string
+		keyTargets    []string
+		targets       []common.Address
+		wantErr       bool
+	}{
+		{name: "no targets anywhere", targets: []common.Address{otherTarget}},
+		{name: "no targets anywhere, no call"},
+		{name: "policy target", policyTargets: []string{testTarget.Hex()}, targets: []common.Address{testTarget}},
+		{name: "key target", keyTargets: []string{testTarget.Hex()}, targets: []common.Address{testTarget}},
+		{name: "key target in lower case", keyTargets: []string{"0x00000000000000000000000000000000000000c1"}, targets: []common.Address{testTarget}},
+		{name: "batch over policy and key targets", policyTargets: []string{testTarget.Hex()}, keyTargets: []string{otherTarget.Hex()}, targets: []common.Address{testTarget, otherTarget}},
+		{name: "target of neither", keyTargets: []string{testTarget.Hex()}, targets: []common.Address{otherTarget}, wantErr: true},
+		{name: "batch with one target of neither", policyTargets: []string{testTarget.Hex()}, targets: []common.Address{testTarget, otherTarget}, wantErr: true},
+		{name: "no call with key targets", keyTargets: []string{testTarget.Hex()}, wantErr: true},
+	}
+	for _, tt := range tests {
+		p := newTestPaymaster(t, Config{Policy: Policy{Targets: tt.policyTargets}}, nil)
+		err := p.checkKeyCall(&models.PaymasterAPIKey{Targets: tt.keyTargets}, tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkKeyCall = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrNotSponsored) {
+			t.Errorf("%s: checkKeyCall = %v, want an ErrNotSponsored", tt.name, err)
+		}
+	}
+}
+
+func TestCheckKeyBudgets(t *testing.T) {
+	usage := func(keysSpent, keySpent int64, keyOps int) models.SponsorshipUsage {
+		return models.SponsorshipUsage{
+			Spent: big.NewInt(1e18), UserSpent: big.NewInt(1e18), UserOps: 1000,
+			KeysSpent: big.NewInt(keysSpent), KeySpent: big.NewInt(keySpent), KeyOps: keyOps,
+		}
+	}
+	key := func(dailyBudget *big.Int, dailyOps int) *models.PaymasterAPIKey {
+		return &models.PaymasterAPIKey{DailyBudget: dailyBudget, DailyOps: dailyOps}
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		key     *models.PaymasterAPIKey
+		usage   models.SponsorshipUsage
+		cost    int64
+		wantErr bool
+	}{
+		{name: "within the key budget", key: key(big.NewInt(100), 0), usage: usage(0, 60, 5), cost: 40},
+		{name: "key without a budget", key: key(nil, 0), usage: usage(0, 0, 0), cost: 1, wantErr: true},
+		{name: "key with a zero budget", key: key(big.NewInt(0), 0), usage: usage(0, 0, 0), cost: 0, wantErr: true},
+		{name: "key budget exceeded", key: key(big.NewInt(100), 0), usage: usage(0, 60, 5), cost: 41, wantErr: true},
+		{name: "key op quota left", key: key(big.NewInt(100), 3), usage: usage(0, 0, 2), cost: 1},
+		{name: "key op quota reached", key: key(big.NewInt(100), 3), usage: usage(0, 0, 3), cost: 1, wantErr: true},
+		{name: "keys budget spent exactly", policy: Policy{KeysDailyBudget: 1000}, key: key(big.NewInt(100), 0), usage: usage(900, 0, 0), cost: 100},
+		{name: "keys budget exceeded", policy: Policy{KeysDailyBudget: 1000}, key: key(big.NewInt(100), 0), usage: usage(901, 0, 0), cost: 100, wantErr: true},
+		{name: "user budgets don't apply to keys", policy: Policy{DailyBudget: 1, UserDailyBudget: 1, UserDailyOps: 1}, key: key(big.NewInt(100), 0), usage: usage(0, 0, 0), cost: 100},
+	}
+	for _, tt := range tests {
+		p := &Paymaster{policy: tt.policy}
+		err := p.checkKeyBudgets(tt.key, tt.usage, big.NewInt(tt.cost))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkKeyBudgets = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrNotSponsored) {
+			t.Errorf("%s: checkKeyBudgets = %v, want an ErrNotSponsored", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package paymaster
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+
+	"eolia-signer/turnkey"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// KeySigner holds the key of the paymasters' verifyingSigner. It signs hashes as eth_sign
+// messages, which the contracts recover with ECDSA, with v as 27 or 28.
+type KeySigner interface {
+	Address() common.Address
+	SignHash(ctx context.Context, hash common.Hash) ([]byte, error)
+}
+
+// NewKeySigner returns the Turnkey account of turnkey_signer when set, otherwise signer_key.
+func NewKeySigner(cfg Config, tk *turnkey.TurnkeyClient) (KeySigner, error) {
+	if cfg.TurnkeySigner != "" {
+		if !common.IsHexAddress(cfg.TurnkeySigner) {
+			return nil, fmt.Errorf("invalid paymaster turnkey signer")
+		}
+		return &turnkeyKey{client: tk, address: common.HexToAddress(cfg.TurnkeySigner)}, nil
+	}
+
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(cfg.SignerKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid paymaster signer key")
+	}
+	return &localKey{key: key}, nil
+}
+
+// localKey signs with a private key held in the config.
+type localKey struct {
+	key *ecdsa.PrivateKey
+}
+
+func (k *localKey) Address() common.Address {
+	return crypto.PubkeyToAddress(k.key.PublicKey)
+}
+
+func (k *localKey) SignHash(_ context.Context, hash common.Hash) ([]byte, error) {
+	signature, err := crypto.Sign(accounts.TextHash(hash[:]), k.key)
+	if err != nil {
+		return nil, err
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return signature, nil
+}
+
+// turnkeyKey signs with a Turnkey account, so that the key never leaves Turnkey.
+type turnkeyKey struct {
+	client  *turnkey.TurnkeyClient
+	address common.Address
+}
+
+func (k *turnkeyKey) Address() common.Address {
+	return k.address
+}
+
+func (k *turnkeyKey) SignHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	signature, err := k.client.SignHash(ctx, k.address.Hex(), hexutil.Encode(accounts.TextHash(hash[:])))
+	if err != nil {
+		return nil, fmt.Errorf("turnkey signing failed: %w", err)
+	}
+	return hexutil.Decode(signature)
+}
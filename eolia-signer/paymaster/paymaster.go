@@ -0,0 +1,359 @@
+// Package paymaster fills in the paymasterAndData of user operations for the paymasters of
+// eolia-contracts/contracts/paymasters: the VerifyingPaymaster, which sponsors the gas of the
+// ops its policy accepts (recorded against daily budgets), and the TokenPaymaster, which
+// lets accounts pay for gas in an ERC-20 token at a quoted exchange rate.
+package paymaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"eolia-common/tracing"
+	"eolia-common/userop"
+	"eolia-signer/db"
+	"eolia-signer/ethclient"
+	"eolia-signer/models"
+	"eolia-signer/turnkey"
+	"eolia-signer/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DEFAULT_VERIFICATION_GAS_LIMIT = 60000
+	DEFAULT_VALIDITY               = 10 * time.Minute
+)
+
+// ErrNotSponsored wraps the reason the policy turned an op down. The op can still be
+// sent, with the account paying for its gas.
+var ErrNotSponsored = errors.New("not sponsored")
+
+type Config struct {
+	// VerifyingPaymaster contract; sponsorship is off without one.
+	Address string `yaml:"address"`
+	// Key of the verifyingSigner of both contracts.
+	SignerKey string `yaml:"signer_key" secret:"true"`
+	// Turnkey account signing instead of signer_key, so that the key stays in Turnkey.
+	TurnkeySigner string `yaml:"turnkey_signer"`
+	// Paymaster verification gas limit written in paymasterAndData (0 = 60000).
+	VerificationGasLimit uint64 `yaml:"verification_gas_limit"`
+	// How long a sponsorship signature stays valid (0 = 10m).
+	Validity time.Duration `yaml:"validity"`
+	Policy   Policy        `yaml:"policy"`
+
+	// ERC-20 gas payment through a TokenPaymaster.
+	Token TokenConfig `yaml:"token"`
+}
+
+// Policy decides which ops get sponsored. Empty lists and zero limits don't restrict.
+// Budgets are in wei and count the maximum cost of each op; they reset at UTC midnight.
+type Policy struct {
+	// Wallet names that may be sponsored.
+	Users []string `yaml:"users"`
+	// Wallet names that are never sponsored.
+	BlockedUsers []string `yaml:"blocked_users"`
+	// Contracts the op may call, directly or in a batch.
+	Targets []string `yaml:"targets"`
+	// Highest maximum cost of one op.
+	MaxOpCost uint64 `yaml:"max_op_cost"`
+	// Sponsored per day, all users together.
+	DailyBudget uint64 `yaml:"daily_budget"`
+	// Sponsored per day with paymaster API keys, all keys together. Each key also has a
+	// daily budget of its own.
+	KeysDailyBudget uint64 `yaml:"keys_daily_budget"`
+	// Sponsored per day and user.
+	UserDailyBudget uint64 `yaml:"user_daily_budget"`
+	// Sponsored ops per day and user.
+	UserDailyOps int `yaml:"user_daily_ops"`
+}
+
+// Enabled reports whether either paymaster is configured.
+func (c Config) Enabled() bool {
+	return c.Address != "" || c.Token.Address != ""
+}
+
+type Paymaster struct {
+	address              common.Address
+	signer               KeySigner
+	verificationGasLimit *big.Int
+	validity             time.Duration
+	policy               Policy
+	targets              map[common.Address]bool
+
+	token *tokenPaymaster
+
+	eth *ethclient.Client
+	db  *db.DB
+}
+
+func New(cfg Config, eth *ethclient.Client, database *db.DB, tk *turnkey.TurnkeyClient) (*Paymaster, error) {
+	signer, err := NewKeySigner(cfg, tk)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Paymaster{
+		address:              common.HexToAddress(cfg.Address),
+		signer:               signer,
+		verificationGasLimit: new(big.Int).SetUint64(cfg.VerificationGasLimit),
+		validity:             cfg.Validity,
+		policy:               cfg.Policy,
+		eth:                  eth,
+		db:                   database,
+	}
+	if cfg.VerificationGasLimit == 0 {
+		p.verificationGasLimit.SetUint64(DEFAULT_VERIFICATION_GAS_LIMIT)
+	}
+	if p.validity == 0 {
+		p.validity = DEFAULT_VALIDITY
+	}
+	if len(cfg.Policy.Targets) > 0 {
+		p.targets = make(map[common.Address]bool)
+		for _, target := range cfg.Policy.Targets {
+			p.targets[common.HexToAddress(target)] = true
+		}
+	}
+	if cfg.Token.Address != "" {
+		p.token = newTokenPaymaster(cfg.Token)
+	}
+	return p, nil
+}
+
+// Sponsoring reports whether a VerifyingPaymaster is configured.
+func (p *Paymaster) Sponsoring() bool {
+	return p.address != (common.Address{})
+}
+
+func (p *Paymaster) Address() common.Address {
+	return p.address
+}
+
+// Signer is the address the contracts' verifyingSigner must be set to.
+func (p *Paymaster) Signer() common.Address {
+	return p.signer.Address()
+}
+
+// Sponsor fills in the op's paymasterAndData if the policy accepts it, and records the
+// sponsorship under the op's final userOpHash, which it returns. Call it once every other
+// field but the signature is set; the preVerificationGas is raised for the paymaster
+// fields. It returns an ErrNotSponsored error, and leaves the op untouched, when the
+// policy turns the op down.
+func (p *Paymaster) Sponsor(ctx context.Context, walletName string, op *types.PackedUserOperation) (_ common.Hash, err error) {
+	ctx, span := tracing.Start(ctx, "paymaster.Sponsor", attribute.String("userop.sender", op.Sender.Hex()))
+	defer func() { endSponsorSpan(span, err) }()
+
+	targets, err := p.eth.CallTargets(op.CallData)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("%w: %v", ErrNotSponsored, err)
+	}
+	if err := p.checkCall(walletName, targets); err != nil {
+		return common.Hash{}, err
+	}
+
+	return p.sponsor(ctx, op, p.verificationGasLimit, targets, &models.Sponsorship{WalletName: walletName}, p.checkBudgets)
+}
+
+// sponsor signs the op for the VerifyingPaymaster with the given paymaster verification gas
+// limit and reserves its maximum cost, if it stays within max_op_cost and check accepts the
+// usage of the day. The op's preVerificationGas is raised to pay for the paymasterAndData
+// calldata beyond what its current paymasterAndData costs.
+func (p *Paymaster) sponsor(ctx context.Context, op *types.PackedUserOperation, verificationGasLimit *big.Int, targets []common.Address, s *models.Sponsorship,
+	check func(models.SponsorshipUsage, *big.Int) error) (common.Hash, error) {
+	paymasterAndData, err := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     p.address,
+		PaymasterVerificationGasLimit: verificationGasLimit,
+		PaymasterPostOpGasLimit:       new(big.Int),
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	sponsored := *op
+	sponsored.PaymasterAndData = paymasterAndData
+	if err := p.coverPaymasterData(op, &sponsored, paymasterData(stubSignature, stubValidUntil, uint256Word(0))); err != nil {
+		return common.Hash{}, err
+	}
+
+	_, maxFeePerGas := userop.UnpackGasFees(sponsored.GasFees)
+	maxCost := new(big.Int).Mul(new(big.Int).SetUint64(sponsored.TotalGasLimit()), maxFeePerGas)
+	if p.policy.MaxOpCost > 0 && maxCost.Cmp(new(big.Int).SetUint64(p.policy.MaxOpCost)) > 0 {
+		return common.Hash{}, fmt.Errorf("%w: op may cost %s wei, more than max_op_cost", ErrNotSponsored, maxCost)
+	}
+
+	validUntil := time.Now().Add(p.validity)
+	quote := [][]byte{uint256Word(uint64(validUntil.Unix())), uint256Word(0)}
+	signature, err := p.sign(ctx, p.hash(&sponsored, p.address, quote...))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	sponsored.PaymasterAndData = append(sponsored.PaymasterAndData, paymasterData(signature, quote...)...)
+
+	userOpHash := p.eth.GetUserOpHash(ctx, &sponsored)
+	targetHexes := make([]string, len(targets))
+	for i, target := range targets {
+		targetHexes[i] = strings.ToLower(target.Hex())
+	}
+
+	s.UserOpHash = userOpHash.Hex()
+	s.Sender = strings.ToLower(op.Sender.Hex())
+	s.Targets = targetHexes
+	s.MaxCost = maxCost
+	s.ValidUntil = validUntil
+	err = p.db.ReserveSponsorship(ctx, s, func(usage models.SponsorshipUsage) error {
+		return check(usage, maxCost)
+	})
+	if errors.Is(err, db.ErrSponsorshipExists) {
+		return common.Hash{}, fmt.Errorf("%w: %v", ErrNotSponsored, err)
+	}
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	op.PaymasterAndData = sponsored.PaymasterAndData
+	op.PreVerificationGas = sponsored.PreVerificationGas
+	return userOpHash, nil
+}
+
+// coverPaymasterData raises the preVerificationGas of paid, which is op with the paymaster
+// fields but without paymasterData yet, by the calldata gas they add to handleOps compared
+// to op. The bundler requires the op to pay for those bytes, and the preVerificationGas
+// estimated for op doesn't. data stands in for the paymasterData to come.
+func (p *Paymaster) coverPaymasterData(op, paid *types.PackedUserOperation, data []byte) error {
+	if paid.PreVerificationGas == nil {
+		paid.PreVerificationGas = new(big.Int)
+	}
+	unpaid := *op
+	unpaid.PreVerificationGas = paid.PreVerificationGas
+	before, err := p.eth.HandleOpsCalldataGas(&unpaid)
+	if err != nil {
+		return fmt.Errorf("failed to price calldata: %w", err)
+	}
+
+	stub := *paid
+	stub.PaymasterAndData = append(append([]byte{}, paid.PaymasterAndData...), data...)
+	after, err := p.eth.HandleOpsCalldataGas(&stub)
+	if err != nil {
+		return fmt.Errorf("failed to price calldata: %w", err)
+	}
+
+	if after > before {
+		paid.PreVerificationGas = new(big.Int).Add(paid.PreVerificationGas, new(big.Int).SetUint64(after-before))
+	}
+	return nil
+}
+
+// endSponsorSpan ends a sponsorship span, recording a policy refusal as an attribute rather
+// than an error.
+func endSponsorSpan(span trace.Span, err error) {
+	if !errors.Is(err, ErrNotSponsored) {
+		tracing.End(span, err)
+		return
+	}
+	span.SetAttributes(attribute.String("paymaster.rejected", err.Error()))
+	span.End()
+}
+
+// Release gives back the budget of a sponsored op that was not sent after all. Ops that
+// are sent but never included are released by Settle once their sponsorship expired.
+func (p *Paymaster) Release(ctx context.Context, userOpHash common.Hash) error {
+	return p.db.ReleaseSponsorship(ctx, userOpHash.Hex())
+}
+
+func (p *Paymaster) checkCall(walletName string, targets []common.Address) error {
+	for _, blocked := range p.policy.BlockedUsers {
+		if blocked == walletName {
+			return fmt.Errorf("%w: user is blocked", ErrNotSponsored)
+		}
+	}
+	if len(p.policy.Users) > 0 {
+		allowed := false
+		for _, user := range p.policy.Users {
+			allowed = allowed || user == walletName
+		}
+		if !allowed {
+			return fmt.Errorf("%w: user is not eligible", ErrNotSponsored)
+		}
+	}
+
+	if p.targets != nil {
+		if len(targets) == 0 {
+			return fmt.Errorf("%w: op calls no allowed contract", ErrNotSponsored)
+		}
+		for _, target := range targets {
+			if !p.targets[target] {
+				return fmt.Errorf("%w: contract %s is not sponsored", ErrNotSponsored, target.Hex())
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Paymaster) checkBudgets(usage models.SponsorshipUsage, maxCost *big.Int) error {
+	if p.policy.UserDailyOps > 0 && usage.UserOps >= p.policy.UserDailyOps {
+		return fmt.Errorf("%w: daily op limit of the user reached", ErrNotSponsored)
+	}
+	if p.policy.UserDailyBudget > 0 && overBudget(usage.UserSpent, maxCost, p.policy.UserDailyBudget) {
+		return fmt.Errorf("%w: daily budget of the user exhausted", ErrNotSponsored)
+	}
+	if p.policy.DailyBudget > 0 && overBudget(usage.Spent, maxCost, p.policy.DailyBudget) {
+		return fmt.Errorf("%w: daily budget exhausted", ErrNotSponsored)
+	}
+	return nil
+}
+
+func overBudget(spent, cost *big.Int, budget uint64) bool {
+	return new(big.Int).Add(spent, cost).Cmp(new(big.Int).SetUint64(budget)) > 0
+}
+
+// hash is the getHash of the paymaster contracts: the op without its signature and
+// paymaster data, bound to the chain and the paymaster, followed by the contract's quote
+// (32-byte words: the validity window, preceded by the token and rate for the TokenPaymaster).
+func (p *Paymaster) hash(op *types.PackedUserOperation, paymaster common.Address, quote ...[]byte) common.Hash {
+	nonce, preVerificationGas := op.Nonce, op.PreVerificationGas
+	if nonce == nil {
+		nonce = new(big.Int)
+	}
+	if preVerificationGas == nil {
+		preVerificationGas = new(big.Int)
+	}
+
+	return crypto.Keccak256Hash(append([][]byte{
+		common.LeftPadBytes(op.Sender[:], 32),
+		math.U256Bytes(new(big.Int).Set(nonce)),
+		crypto.Keccak256(op.InitCode),
+		crypto.Keccak256(op.CallData),
+		op.AccountGasLimits[:],
+		op.PaymasterAndData[userop.PAYMASTER_VALIDATION_GAS_OFFSET:userop.PAYMASTER_DATA_OFFSET],
+		math.U256Bytes(new(big.Int).Set(preVerificationGas)),
+		op.GasFees[:],
+		math.U256Bytes(p.eth.ChainID()),
+		common.LeftPadBytes(paymaster[:], 32),
+	}, quote...)...)
+}
+
+// sign signs hash with the verifyingSigner key.
+func (p *Paymaster) sign(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return p.signer.SignHash(ctx, hash)
+}
+
+func uint256Word(v uint64) []byte {
+	return math.U256Bytes(new(big.Int).SetUint64(v))
+}
+
+// paymasterData is the contract's part of paymasterAndData: the abi-encoded quote
+// followed by the signature.
+func paymasterData(signature []byte, quote ...[]byte) []byte {
+	var data []byte
+	for _, word := range quote {
+		data = append(data, word...)
+	}
+	return append(data, signature...)
+}
@@ -0,0 +1,364 @@
+package paymaster
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eolia-common/userop"
+	"eolia-signer/ethclient"
+	"eolia-signer/models"
+	"eolia-signer/types"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Key of the verifyingSigner in these tests.
+const testSignerKey = "0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+var (
+	testEntryPoint = common.HexToAddress("0x379FF91b96c038ECb0dc6aCFb44366a39f0de566")
+	testPaymaster  = common.HexToAddress("0x00000000000000000000000000000000000000fa")
+	testTarget     = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	otherTarget    = common.HexToAddress("0x00000000000000000000000000000000000000c2")
+
+	testChainID = big.NewInt(196)
+)
+
+// testChain answers eth_chainId and eth_blockNumber, and eth_call with call(to, input).
+type testChain struct {
+	call func(to common.Address, input []byte) []byte
+}
+
+func (c testChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	switch req.Method {
+	case "eth_chainId":
+		resp["result"] = "0xc4"
+	case "eth_blockNumber":
+		resp["result"] = "0x1"
+	case "eth_call":
+		var msg struct {
+			To    common.Address `json:"to"`
+			Input hexutil.Bytes  `json:"input"`
+			Data  hexutil.Bytes  `json:"data"`
+		}
+		json.Unmarshal(req.Params[0], &msg)
+		if msg.Input == nil {
+			msg.Input = msg.Data
+		}
+		resp["result"] = hexutil.Bytes(c.call(msg.To, msg.Input))
+	default:
+		resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newTestClient connects an ethclient to chain. NewClient reads the ABIs relative to the
+// module root.
+func newTestClient(t *testing.T, chain testChain) *ethclient.Client {
+	t.Helper()
+	server := httptest.NewServer(chain)
+	t.Cleanup(server.Close)
+
+	t.Chdir("..")
+	client := ethclient.NewClient([]string{server.URL}, testEntryPoint.Hex(), "0x00000000000000000000000000000000000000f0")
+	t.Cleanup(client.Close)
+	return client
+}
+
+// newTestPaymaster is a VerifyingPaymaster signing with testSignerKey.
+func newTestPaymaster(t *testing.T, cfg Config, eth *ethclient.Client) *Paymaster {
+	t.Helper()
+	cfg.Address = testPaymaster.Hex()
+	cfg.SignerKey = testSignerKey
+	p, err := New(cfg, eth, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testOp(t *testing.T) *types.PackedUserOperation {
+	t.Helper()
+	accountGasLimits, err := userop.PackAccountGasLimits(big.NewInt(100_000), big.NewInt(200_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasFees, err := userop.PackGasFees(big.NewInt(1e9), big.NewInt(2e9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymasterAndData, err := userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     testPaymaster,
+		PaymasterVerificationGasLimit: big.NewInt(60_000),
+		PaymasterPostOpGasLimit:       big.NewInt(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.PackedUserOperation{
+		Sender:             common.HexToAddress("0x00000000000000000000000000000000000000a1"),
+		Nonce:              big.NewInt(7),
+		InitCode:           []byte{0x01, 0x02},
+		CallData:           []byte{0xb6, 0x1d, 0x27, 0xf6},
+		AccountGasLimits:   accountGasLimits,
+		PreVerificationGas: big.NewInt(50_000),
+		GasFees:            gasFees,
+		PaymasterAndData:   paymasterAndData,
+		Signature:          []byte{},
+	}
+}
+
+func TestCheckCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wallet  string
+		targets []common.Address
+		wantErr bool
+	}{
+		{name: "open policy", wallet: "alice", targets: []common.Address{otherTarget}},
+		{name: "open policy, no call", wallet: "alice"},
+		{name: "eligible user", policy: Policy{Users: []string{"bob", "alice"}}, wallet: "alice"},
+		{name: "ineligible user", policy: Policy{Users: []string{"bob"}}, wallet: "alice", wantErr: true},
+		{name: "blocked user", policy: Policy{BlockedUsers: []string{"alice"}}, wallet: "alice", wantErr: true},
+		{name: "blocked beats eligible", policy: Policy{Users: []string{"alice"}, BlockedUsers: []string{"alice"}}, wallet: "alice", wantErr: true},
+		{name: "allowed target", policy: Policy{Targets: []string{testTarget.Hex()}}, wallet: "alice", targets: []common.Address{testTarget}},
+		{name: "batch with another target", policy: Policy{Targets: []string{testTarget.Hex()}}, wallet: "alice", targets: []common.Address{testTarget, otherTarget}, wantErr: true},
+		{name: "no call with targets", policy: Policy{Targets: []string{testTarget.Hex()}}, wallet: "alice", wantErr: true},
+	}
+	for _, tt := range tests {
+		p := newTestPaymaster(t, Config{Policy: tt.policy}, nil)
+		err := p.checkCall(tt.wallet, tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkCall = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrNotSponsored) {
+			t.Errorf("%s: checkCall = %v, want an ErrNotSponsored", tt.name, err)
+		}
+	}
+}
+
+func TestCheckBudgets(t *testing.T) {
+	usage := func(spent, userSpent int64, userOps int) models.SponsorshipUsage {
+		return models.SponsorshipUsage{Spent: big.NewInt(spent), UserSpent: big.NewInt(userSpent), UserOps: userOps}
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		usage   models.SponsorshipUsage
+		cost    int64
+		wantErr bool
+	}{
+		{name: "no limits", usage: usage(1e18, 1e18, 1000), cost: 1e18},
+		{name: "user op quota left", policy: Policy{UserDailyOps: 3}, usage: usage(0, 0, 2), cost: 1},
+		{name: "user op quota reached", policy: Policy{UserDailyOps: 3}, usage: usage(0, 0, 3), cost: 1, wantErr: true},
+		{name: "user budget spent exactly", policy: Policy{UserDailyBudget: 100}, usage: usage(0, 60, 1), cost: 40},
+		{name: "user budget exceeded", policy: Policy{UserDailyBudget: 100}, usage: usage(0, 60, 1), cost: 41, wantErr: true},
+		{name: "daily budget spent exactly", policy: Policy{DailyBudget: 1000}, usage: usage(900, 0, 0), cost: 100},
+		{name: "daily budget exceeded", policy: Policy{DailyBudget: 1000}, usage: usage(900, 0, 0), cost: 101, wantErr: true},
+		{name: "keys budget doesn't apply to users", policy: Policy{KeysDailyBudget: 1}, usage: usage(900, 0, 0), cost: 100},
+	}
+	for _, tt := range tests {
+		p := &Paymaster{policy: tt.policy}
+		err := p.checkBudgets(tt.usage, big.NewInt(tt.cost))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkBudgets = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrNotSponsored) {
+			t.Errorf("%s: checkBudgets = %v, want an ErrNotSponsored", tt.name, err)
+		}
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	tests := []struct {
+		spent, cost int64
+		budget      uint64
+		want        bool
+	}{
+		{0, 0, 0, false},
+		{0, 1, 0, true},
+		{40, 60, 100, false},
+		{40, 61, 100, true},
+		{101, 0, 100, true},
+	}
+	for _, tt := range tests {
+		if got := overBudget(big.NewInt(tt.spent), big.NewInt(tt.cost), tt.budget); got != tt.want {
+			t.Errorf("overBudget(%d, %d, %d) = %v, want %v", tt.spent, tt.cost, tt.budget, got, tt.want)
+		}
+	}
+}
+
+// contractHash is getHash of the paymaster contracts, abi.encode-ing the op fields and then
+// the quote: (validUntil, validAfter), preceded by (token, exchangeRate) for the TokenPaymaster.
+func contractHash(t *testing.T, op *types.PackedUserOperation, paymaster common.Address, quote ...interface{}) common.Hash {
+	t.Helper()
+	typeOf := func(name string) abi.Type {
+		typ, err := abi.NewType(name, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return typ
+	}
+
+	var args abi.Arguments
+	for _, name := range []string{"address", "uint256", "bytes32", "bytes32", "bytes32", "uint256", "uint256", "bytes32", "uint256", "address"} {
+		args = append(args, abi.Argument{Type: typeOf(name)})
+	}
+	if len(quote) == 4 {
+		args = append(args, abi.Argument{Type: typeOf("address")}, abi.Argument{Type: typeOf("uint256")})
+	}
+	args = append(args, abi.Argument{Type: typeOf("uint48")}, abi.Argument{Type: typeOf("uint48")})
+
+	var paymasterGasLimits [32]byte
+	copy(paymasterGasLimits[:], op.PaymasterAndData[userop.PAYMASTER_VALIDATION_GAS_OFFSET:userop.PAYMASTER_DATA_OFFSET])
+	encoded, err := args.Pack(append([]interface{}{
+		op.Sender,
+		op.Nonce,
+		crypto.Keccak256Hash(op.InitCode),
+		crypto.Keccak256Hash(op.CallData),
+		op.AccountGasLimits,
+		new(big.Int).SetBytes(paymasterGasLimits[:]),
+		op.PreVerificationGas,
+		op.GasFees,
+		testChainID,
+		paymaster,
+	}, quote...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.Keccak256Hash(encoded)
+}
+
+func TestHash(t *testing.T) {
+	p := newTestPaymaster(t, Config{}, newTestClient(t, testChain{}))
+
+	token := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	rate := new(big.Int).Mul(big.NewInt(45_500_000), big.NewInt(1e12))
+	validUntil := big.NewInt(1_760_000_000)
+
+	withSignature := testOp(t)
+	withSignature.Signature = []byte{0x01}
+	withData := testOp(t)
+	withData.PaymasterAndData = append(withData.PaymasterAndData, 0x42)
+
+	tests := []struct {
+		name  string
+		got   common.Hash
+		want  common.Hash
+		equal bool
+	}{
+		{
+			name:  "VerifyingPaymaster",
+			got:   p.hash(testOp(t), testPaymaster, uint256Word(validUntil.Uint64()), uint256Word(0)),
+			want:  contractHash(t, testOp(t), testPaymaster, validUntil, big.NewInt(0)),
+			equal: true,
+		},
+		{
+			name:  "TokenPaymaster",
+			got:   p.hash(testOp(t), testPaymaster, common.LeftPadBytes(token[:], 32), common.LeftPadBytes(rate.Bytes(), 32), uint256Word(validUntil.Uint64()), uint256Word(0)),
+			want:  contractHash(t, testOp(t), testPaymaster, token, rate, validUntil, big.NewInt(0)),
+			equal: true,
+		},
+		{
+			name:  "signature is not covered",
+			got:   p.hash(withSignature, testPaymaster, uint256Word(validUntil.Uint64()), uint256Word(0)),
+			want:  contractHash(t, testOp(t), testPaymaster, validUntil, big.NewInt(0)),
+			equal: true,
+		},
+		{
+			name:  "paymaster data is not covered",
+			got:   p.hash(withData, testPaymaster, uint256Word(validUntil.Uint64()), uint256Word(0)),
+			want:  contractHash(t, testOp(t), testPaymaster, validUntil, big.NewInt(0)),
+			equal: true,
+		},
+		{
+			name: "bound to the paymaster",
+			got:  p.hash(testOp(t), otherTarget, uint256Word(validUntil.Uint64()), uint256Word(0)),
+			want: contractHash(t, testOp(t), testPaymaster, validUntil, big.NewInt(0)),
+		},
+	}
+	for _, tt := range tests {
+		if (tt.got == tt.want) != tt.equal {
+			t.Errorf("%s: hash = %s, getHash = %s, want equal %v", tt.name, tt.got.Hex(), tt.want.Hex(), tt.equal)
+		}
+	}
+}
+
+func TestLocalKeySignHash(t *testing.T) {
+	signer, err := NewKeySigner(Config{SignerKey: testSignerKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[byte]bool)
+	for i := 0; i < 16; i++ {
+		hash := crypto.Keccak256Hash([]byte{byte(i)})
+		signature, err := signer.SignHash(t.Context(), hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signature) != 65 {
+			t.Fatalf("signature of %s has %d bytes, want 65", hash.Hex(), len(signature))
+		}
+
+		v := signature[crypto.RecoveryIDOffset]
+		if v != 27 && v != 28 {
+			t.Errorf("signature of %s has v = %d, want 27 or 28", hash.Hex(), v)
+			continue
+		}
+		seen[v] = true
+
+		// The contracts recover from the eth_sign message hash, with v in {27, 28}.
+		sig := append([]byte{}, signature...)
+		sig[crypto.RecoveryIDOffset] -= 27
+		pub, err := crypto.SigToPub(accounts.TextHash(hash[:]), sig)
+		if err != nil {
+			t.Errorf("signature of %s doesn't recover: %v", hash.Hex(), err)
+			continue
+		}
+		if got := crypto.PubkeyToAddress(*pub); got != signer.Address() {
+			t.Errorf("signature of %s recovers to %s, want %s", hash.Hex(), got.Hex(), signer.Address().Hex())
+		}
+	}
+	if !seen[27] || !seen[28] {
+		t.Errorf("16 signatures only had v in %v", seen)
+	}
+}
+
+func TestNewKeySigner(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		wantErr bool
+	}{
+		{cfg: Config{SignerKey: testSignerKey}},
+		{cfg: Config{SignerKey: testSignerKey[2:]}},
+		{cfg: Config{SignerKey: "0x1234"}, wantErr: true},
+		{cfg: Config{}, wantErr: true},
+		{cfg: Config{TurnkeySigner: "0x00000000000000000000000000000000000000d1"}},
+		{cfg: Config{TurnkeySigner: "signer", SignerKey: testSignerKey}, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := NewKeySigner(tt.cfg, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewKeySigner(turnkey %q) error = %v, want error %v", tt.cfg.TurnkeySigner, err, tt.wantErr)
+		}
+	}
+}
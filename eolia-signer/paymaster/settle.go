@@ -0,0 +1,87 @@
+package paymaster
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// How often sponsorships are settled against the chain.
+	SETTLE_INTERVAL = time.Minute
+	// Blocks per eth_getLogs request of the settlement.
+	SETTLE_BLOCK_RANGE = 1000
+	// How long past its validity a sponsorship waits for inclusion, covering node lag and
+	// clock skew.
+	SETTLE_MARGIN = time.Minute
+)
+
+// Settle releases, every SETTLE_INTERVAL until ctx is done, the sponsorships whose op
+// wasn't included before their signature expired. Budgets count a sponsorship when it is
+// signed, but a dapp may never send the op, or the bundler may drop it; once expired, the
+// paymaster can't pay for it anymore.
+func (p *Paymaster) Settle(ctx context.Context) {
+	ticker := time.NewTicker(SETTLE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := p.settle(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("failed to settle sponsorships", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// settle marks the sponsorships included by the UserOperationEvents since the cursor, then,
+// once the cursor reached the head, releases the expired ones still not included.
+func (p *Paymaster) settle(ctx context.Context) error {
+	now := time.Now()
+	head, err := p.eth.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	cursor, ok, err := p.db.GetPaymasterCursor(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return p.db.SetPaymasterCursor(ctx, head)
+	}
+
+	for from := cursor + 1; from <= head; from += SETTLE_BLOCK_RANGE {
+		to := min(from+SETTLE_BLOCK_RANGE-1, head)
+		events, err := p.eth.UserOperationEvents(ctx, from, to)
+		if err != nil {
+			return err
+		}
+
+		var included []string
+		for _, e := range events {
+			if e.Paymaster == p.address {
+				included = append(included, e.UserOpHash.Hex())
+			}
+		}
+		if len(included) > 0 {
+			if err := p.db.MarkSponsorshipsIncluded(ctx, included); err != nil {
+				return err
+			}
+		}
+
+		if err := p.db.SetPaymasterCursor(ctx, to); err != nil {
+			return err
+		}
+	}
+
+	released, err := p.db.ReleaseExpiredSponsorships(ctx, now.Add(-SETTLE_MARGIN))
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		slog.Info("released expired sponsorships", "count", released)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package paymaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"eolia-common/tracing"
+	"eolia-common/userop"
+	"eolia-signer/types"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	DEFAULT_POST_OP_GAS_LIMIT = 60000
+	DEFAULT_ORACLE_MAX_AGE    = time.Hour
+)
+
+var (
+	ErrUnsupportedToken         = errors.New("token is not accepted for gas")
+	ErrInsufficientTokenBalance = errors.New("token balance too low to pay for gas")
+	ErrInsufficientAllowance    = errors.New("token allowance of the paymaster too low to pay for gas")
+)
+
+// Exchange rates are token units worth 1e18 wei, as the TokenPaymaster expects them.
+var rateDenominator = big.NewInt(1e18)
+
+type TokenConfig struct {
+	// TokenPaymaster contract; paying for gas in tokens is off without one.
+	Address string `yaml:"address"`
+	// Post-op gas limit written in paymasterAndData, spent on the token transfer (0 = 60000).
+	PostOpGasLimit uint64 `yaml:"post_op_gas_limit"`
+	// Percent added to the exchange rate, covering price moves within the validity.
+	MarkupPercent uint64 `yaml:"markup_percent"`
+	// Tokens accepted for gas.
+	Tokens []GasToken `yaml:"tokens"`
+}
+
+// GasToken is an ERC-20 accepted for gas and its price source: an on-chain oracle or a fixed price.
+type GasToken struct {
+	Symbol   string `yaml:"symbol"`
+	Address  string `yaml:"address"`
+	Decimals uint8  `yaml:"decimals"`
+	// Chainlink-style aggregator answering the price of the native token in this token
+	// (e.g. an OKB/USD feed for a USD stablecoin). Takes precedence over price.
+	Oracle string `yaml:"oracle"`
+	// Oldest oracle answer used (0 = 1h).
+	OracleMaxAge time.Duration `yaml:"oracle_max_age"`
+	// Fixed price of one native token in this token, e.g. 45.5 (USDT per OKB).
+	Price float64 `yaml:"price"`
+}
+
+// TokenQuote is what an op pays for gas in a token.
+type TokenQuote struct {
+	Symbol string `json:"symbol"`
+	Token  string `json:"token"`
+	// Token units worth 1e18 wei, markup included.
+	ExchangeRate string `json:"exchange_rate"`
+	// Taken from the account during validation, at the op's gas limits; what the actual
+	// gas cost doesn't use is refunded in postOp.
+	MaxTokenCost string `json:"max_token_cost,omitempty"`
+}
+
+type tokenPaymaster struct {
+	address        common.Address
+	postOpGasLimit *big.Int
+	markupPercent  uint64
+	tokens         []GasToken
+}
+
+func newTokenPaymaster(cfg TokenConfig) *tokenPaymaster {
+	t := &tokenPaymaster{
+		address:        common.HexToAddress(cfg.Address),
+		postOpGasLimit: new(big.Int).SetUint64(cfg.PostOpGasLimit),
+		markupPercent:  cfg.MarkupPercent,
+		tokens:         cfg.Tokens,
+	}
+	if cfg.PostOpGasLimit == 0 {
+		t.postOpGasLimit.SetUint64(DEFAULT_POST_OP_GAS_LIMIT)
+	}
+	return t
+}
+
+// lookup finds a gas token by symbol (case-insensitive) or address.
+func (t *tokenPaymaster) lookup(token string) (*GasToken, error) {
+	for i := range t.tokens {
+		gt := &t.tokens[i]
+		if strings.EqualFold(gt.Symbol, token) || (common.IsHexAddress(token) && common.HexToAddress(gt.Address) == common.HexToAddress(token)) {
+			return gt, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedToken, token)
+}
+
+// AcceptsTokens reports whether a TokenPaymaster is configured.
+func (p *Paymaster) AcceptsTokens() bool {
+	return p.token != nil
+}
+
+func (p *Paymaster) TokenPaymasterAddress() common.Address {
+	if p.token == nil {
+		return common.Address{}
+	}
+	return p.token.address
+}
+
+// Quotes returns the current exchange rate of every accepted token. A token whose price
+// can't be read is left out.
+func (p *Paymaster) Quotes(ctx context.Context) []TokenQuote {
+	if p.token == nil {
+		return nil
+	}
+
+	quotes := []TokenQuote{}
+	for i := range p.token.tokens {
+		gt := &p.token.tokens[i]
+		rate, err := p.exchangeRate(ctx, gt)
+		if err != nil {
+			continue
+		}
+		quotes = append(quotes, TokenQuote{Symbol: gt.Symbol, Token: common.HexToAddress(gt.Address).Hex(), ExchangeRate: rate.String()})
+	}
+	return quotes
+}
+
+// PayWithToken makes the op pay for its gas in token (a symbol or an address) through the
+// TokenPaymaster: it quotes the exchange rate, checks that the account's balance and its
+// allowance to the paymaster cover the op's maximum cost, and fills in the signed
+// paymasterAndData. The paymaster takes that cost during validation, before the op's calls
+// run, so the approve can't be part of the op itself. Call it once every other field but
+// the signature is set; the preVerificationGas is raised for the paymaster fields first,
+// so the quote covers it.
+func (p *Paymaster) PayWithToken(ctx context.Context, op *types.PackedUserOperation, token string) (_ *TokenQuote, err error) {
+	ctx, span := tracing.Start(ctx, "paymaster.PayWithToken", attribute.String("userop.sender", op.Sender.Hex()), attribute.String("token", token))
+	defer func() { tracing.End(span, err) }()
+
+	if p.token == nil {
+		return nil, fmt.Errorf("%w: no token paymaster", ErrUnsupportedToken)
+	}
+	gt, err := p.token.lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	tokenAddress := common.HexToAddress(gt.Address)
+
+	rate, err := p.exchangeRate(ctx, gt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to price %s: %w", gt.Symbol, err)
+	}
+
+	paid := *op
+	paid.PaymasterAndData, err = userop.PackPaymasterAndData(&userop.PaymasterFields{
+		Paymaster:                     p.token.address,
+		PaymasterVerificationGasLimit: p.verificationGasLimit,
+		PaymasterPostOpGasLimit:       p.token.postOpGasLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokenWords := [][]byte{common.LeftPadBytes(tokenAddress[:], 32), common.LeftPadBytes(rate.Bytes(), 32)}
+	if err := p.coverPaymasterData(op, &paid, paymasterData(stubSignature, append(tokenWords, stubValidUntil, uint256Word(0))...)); err != nil {
+		return nil, err
+	}
+
+	allowance, err := p.eth.TokenAllowance(ctx, tokenAddress, op.Sender, p.token.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowance: %w", err)
+	}
+
+	maxTokenCost := tokenCost(&paid, rate)
+	quote := &TokenQuote{Symbol: gt.Symbol, Token: tokenAddress.Hex(), ExchangeRate: rate.String(), MaxTokenCost: maxTokenCost.String()}
+	if allowance.Cmp(maxTokenCost) < 0 {
+		return nil, fmt.Errorf("%w: approve %s to %s for at least %s %s", ErrInsufficientAllowance, gt.Symbol, p.token.address.Hex(), maxTokenCost, gt.Symbol)
+	}
+
+	balance, err := p.eth.TokenBalance(ctx, tokenAddress, op.Sender)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balance: %w", err)
+	}
+	if balance.Cmp(maxTokenCost) < 0 {
+		return nil, fmt.Errorf("%w: %s %s needed, %s held", ErrInsufficientTokenBalance, maxTokenCost, gt.Symbol, balance)
+	}
+
+	words := append(tokenWords, uint256Word(uint64(time.Now().Add(p.validity).Unix())), uint256Word(0))
+	signature, err := p.sign(ctx, p.hash(&paid, p.token.address, words...))
+	if err != nil {
+		return nil, err
+	}
+	paid.PaymasterAndData = append(paid.PaymasterAndData, paymasterData(signature, words...)...)
+
+	*op = paid
+	return quote, nil
+}
+
+// exchangeRate is the token amount worth 1e18 wei, markup included.
+func (p *Paymaster) exchangeRate(ctx context.Context, gt *GasToken) (*big.Int, error) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(gt.Decimals)), nil)
+
+	var rate *big.Int
+	if gt.Oracle != "" {
+		answer, decimals, updatedAt, err := p.eth.OraclePrice(ctx, common.HexToAddress(gt.Oracle))
+		if err != nil {
+			return nil, err
+		}
+		maxAge := gt.OracleMaxAge
+		if maxAge == 0 {
+			maxAge = DEFAULT_ORACLE_MAX_AGE
+		}
+		if time.Since(updatedAt) > maxAge {
+			return nil, fmt.Errorf("oracle answer is stale (updated %s)", updatedAt.UTC().Format(time.RFC3339))
+		}
+		if answer.Sign() <= 0 {
+			return nil, fmt.Errorf("oracle answer %s is not a price", answer)
+		}
+		rate = new(big.Int).Mul(answer, unit)
+		rate.Div(rate, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	} else {
+		rate, _ = new(big.Float).Mul(big.NewFloat(gt.Price), new(big.Float).SetInt(unit)).Int(nil)
+	}
+
+	rate.Mul(rate, new(big.Int).SetUint64(100+p.token.markupPercent))
+	rate.Div(rate, big.NewInt(100))
+	if rate.Sign() <= 0 {
+		return nil, fmt.Errorf("exchange rate of %s is zero", gt.Symbol)
+	}
+	return rate, nil
+}
+
+// tokenCost is the op's maximum gas cost (its gas limits at maxFeePerGas) in token units, rounded up.
+func tokenCost(op *types.PackedUserOperation, rate *big.Int) *big.Int {
+	_, maxFeePerGas := userop.UnpackGasFees(op.GasFees)
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(op.TotalGasLimit()), maxFeePerGas)
+	cost.Mul(cost, rate)
+	cost.Add(cost, new(big.Int).Sub(rateDenominator, big.NewInt(1)))
+	return cost.Div(cost, rateDenominator)
+}
@@ -0,0 +1,171 @@
+package paymaster
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTokenCost(t *testing.T) {
+	// testOp has 410k gas in total at a 2 gwei maxFeePerGas: 8.2e14 wei. Like
+	// TokenPaymaster._tokenAmount, the cost in tokens is rounded up.
+	tests := []struct {
+		rate *big.Int
+		want *big.Int
+	}{
+		{big.NewInt(1e18), big.NewInt(8.2e14)},
+		{big.NewInt(5e4), big.NewInt(41)},
+		{big.NewInt(1e4), big.NewInt(9)},
+		{big.NewInt(45_500_000), big.NewInt(37_310)},
+		{big.NewInt(45_500_001), big.NewInt(37_311)},
+		{big.NewInt(1), big.NewInt(1)},
+		{big.NewInt(0), big.NewInt(0)},
+	}
+	for _, tt := range tests {
+		if got := tokenCost(testOp(t), tt.rate); got.Cmp(tt.want) != 0 {
+			t.Errorf("tokenCost at rate %s = %s, want %s", tt.rate, got, tt.want)
+		}
+	}
+}
+
+// oracleAnswer is the latest round of a price feed.
+type oracleAnswer struct {
+	answer    *big.Int
+	decimals  uint8
+	updatedAt time.Time
+}
+
+// oracleChain serves the latestRoundData and decimals of a price feed at each address.
+func oracleChain(t *testing.T, feeds map[common.Address]oracleAnswer) testChain {
+	data, err := os.ReadFile("../ethclient/abi/aggregator.abi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregator, err := abi.JSON(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testChain{call: func(to common.Address, input []byte) []byte {
+		feed, ok := feeds[to]
+		if !ok {
+			t.Errorf("unexpected call to %s", to.Hex())
+			return nil
+		}
+		method, err := aggregator.MethodById(input)
+		if err != nil {
+			t.Errorf("unexpected aggregator call %x", input)
+			return nil
+		}
+		var out []byte
+		if method.Name == "decimals" {
+			out, err = method.Outputs.Pack(feed.decimals)
+		} else {
+			out, err = method.Outputs.Pack(big.NewInt(1), feed.answer, big.NewInt(feed.updatedAt.Unix()), big.NewInt(feed.updatedAt.Unix()), big.NewInt(1))
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		return out
+	}}
+}
+
+func TestExchangeRate(t *testing.T) {
+	fresh := common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	stale := common.HexToAddress("0x00000000000000000000000000000000000000e2")
+	zero := common.HexToAddress("0x00000000000000000000000000000000000000e3")
+	negative := common.HexToAddress("0x00000000000000000000000000000000000000e4")
+
+	// 45.5 tokens per native token, with 8 decimals.
+	price := big.NewInt(4_550_000_000)
+	eth := newTestClient(t, oracleChain(t, map[common.Address]oracleAnswer{
+		fresh:    {answer: price, decimals: 8, updatedAt: time.Now().Add(-time.Minute)},
+		stale:    {answer: price, decimals: 8, updatedAt: time.Now().Add(-2 * time.Hour)},
+		zero:     {answer: big.NewInt(0), decimals: 8, updatedAt: time.Now()},
+		negative: {answer: big.NewInt(-1), decimals: 8, updatedAt: time.Now()},
+	}))
+
+	tests := []struct {
+		name    string
+		token   GasToken
+		markup  uint64
+		want    int64
+		wantErr bool
+	}{
+		{name: "fixed price", token: GasToken{Decimals: 6, Price: 45.5}, want: 45_500_000},
+		{name: "fixed price with markup", token: GasToken{Decimals: 6, Price: 45.5}, markup: 10, want: 50_050_000},
+		{name: "fixed price of an 18 decimals token", token: GasToken{Decimals: 18, Price: 1}, want: 1e18},
+		{name: "fixed price below one unit", token: GasToken{Decimals: 6, Price: 1e-7}, wantErr: true},
+		{name: "oracle", token: GasToken{Decimals: 6, Oracle: fresh.Hex()}, want: 45_500_000},
+		{name: "oracle with markup", token: GasToken{Decimals: 6, Oracle: fresh.Hex()}, markup: 2, want: 46_410_000},
+		{name: "oracle takes precedence over price", token: GasToken{Decimals: 6, Oracle: fresh.Hex(), Price: 1}, want: 45_500_000},
+		{name: "stale oracle", token: GasToken{Decimals: 6, Oracle: stale.Hex()}, wantErr: true},
+		{name: "stale oracle within oracle_max_age", token: GasToken{Decimals: 6, Oracle: stale.Hex(), OracleMaxAge: 3 * time.Hour}, want: 45_500_000},
+		{name: "zero oracle answer", token: GasToken{Decimals: 6, Oracle: zero.Hex()}, wantErr: true},
+		{name: "negative oracle answer", token: GasToken{Decimals: 6, Oracle: negative.Hex()}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt.token.Symbol = "USDT"
+		p := &Paymaster{token: newTokenPaymaster(TokenConfig{MarkupPercent: tt.markup}), eth: eth}
+
+		rate, err := p.exchangeRate(t.Context(), &tt.token)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: exchangeRate = %s, want an error", tt.name, rate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: exchangeRate: %v", tt.name, err)
+			continue
+		}
+		if rate.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("%s: exchangeRate = %s, want %d", tt.name, rate, tt.want)
+		}
+	}
+
+	// A token that can't be priced is left out of the quotes.
+	p := &Paymaster{eth: eth, token: newTokenPaymaster(TokenConfig{Tokens: []GasToken{
+		{Symbol: "USDT", Address: "0x00000000000000000000000000000000000000d1", Decimals: 6, Oracle: fresh.Hex()},
+		{Symbol: "USDC", Address: "0x00000000000000000000000000000000000000d2", Decimals: 6, Oracle: stale.Hex()},
+	}})}
+	quotes := p.Quotes(t.Context())
+	if len(quotes) != 1 || quotes[0].Symbol != "USDT" || quotes[0].ExchangeRate != "45500000" {
+		t.Errorf("Quotes = %+v, want USDT at 45500000 alone", quotes)
+	}
+}
+
+func TestTokenLookup(t *testing.T) {
+	tp := newTokenPaymaster(TokenConfig{Tokens: []GasToken{
+		{Symbol: "USDT", Address: "0x1E4a5963aBFD975d8c9021ce480b42188849D41d"},
+	}})
+
+	tests := []struct {
+		token   string
+		wantErr bool
+	}{
+		{token: "USDT"},
+		{token: "usdt"},
+		{token: "0x1e4a5963abfd975d8c9021ce480b42188849d41d"},
+		{token: "USDC", wantErr: true},
+		{token: "0x00000000000000000000000000000000000000d2", wantErr: true},
+	}
+	for _, tt := range tests {
+		gt, err := tp.lookup(tt.token)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnsupportedToken) {
+				t.Errorf("lookup(%s) = %v, want ErrUnsupportedToken", tt.token, err)
+			}
+			continue
+		}
+		if err != nil || gt.Symbol != "USDT" {
+			t.Errorf("lookup(%s) = %v, %v, want USDT", tt.token, gt, err)
+		}
+	}
+}
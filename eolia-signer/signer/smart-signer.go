@@ -3,6 +3,7 @@ package signer
 import (
 	"eolia-signer/db"
 	"eolia-signer/ethclient"
+	"eolia-signer/paymaster"
 	"eolia-signer/turnkey"
 )
 
@@ -10,4 +11,6 @@ type SmartSigner struct {
 	TurnkeyClient *turnkey.TurnkeyClient
 	EthClient     *ethclient.Client
 	DB            *db.DB
+	// Sponsors the gas of ops; nil when no paymaster is configured.
+	Paymaster *paymaster.Paymaster
 }
@@ -1,29 +1,36 @@
 package turnkey
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
 
+	"eolia-common/tracing"
+
 	"github.com/tkhq/go-sdk"
+	"github.com/tkhq/go-sdk/pkg/api/client/sessions"
 	"github.com/tkhq/go-sdk/pkg/api/client/signing"
 	"github.com/tkhq/go-sdk/pkg/api/client/wallets"
 	"github.com/tkhq/go-sdk/pkg/api/models"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TurnkeyClient struct {
 	*sdk.Client
+	organizationID string
 }
 
-func CreateTurnkeyClient() *TurnkeyClient {
-	client, err := sdk.New(sdk.WithAPIKeyName("smart-apikey"))
+func CreateTurnkeyClient(apiKeyName string, organizationID string) *TurnkeyClient {
+	client, err := sdk.New(sdk.WithAPIKeyName(apiKeyName))
 	if err != nil {
-		fmt.Printf("failed to create new SDK client: %v\n", err)
+		slog.Error("failed to create Turnkey SDK client", "error", err)
 		os.Exit(1)
 	}
-	return &TurnkeyClient{Client: client}
+	return &TurnkeyClient{Client: client, organizationID: organizationID}
 }
 
 func RequestTimestamp() *string {
@@ -32,7 +39,10 @@ func RequestTimestamp() *string {
 	return &ts
 }
 
-func (t *TurnkeyClient) CreateWallet(_walletname string) (string, string, error) {
+func (t *TurnkeyClient) CreateWallet(ctx context.Context, _walletname string) (_ string, _ string, err error) {
+	_, span := tracing.StartClient(ctx, "turnkey.CreateWallet")
+	defer func() { tracing.End(span, err) }()
+
 	walletName := _walletname
 	path := "m/44'/60'/0'/0/0"
 
@@ -84,7 +94,10 @@ func (t *TurnkeyClient) GetAccountAddress(walletID string) (string, error) {
 	return *resp.Payload.Accounts[0].Address, nil
 }
 
-func (t *TurnkeyClient) SignHash(accountAddress, hash string) (string, error) {
+func (t *TurnkeyClient) SignHash(ctx context.Context, accountAddress, hash string) (_ string, err error) {
+	_, span := tracing.StartClient(ctx, "turnkey.SignHash", attribute.String("turnkey.sign_with", accountAddress))
+	defer func() { tracing.End(span, err) }()
+
 	params := signing.NewSignRawPayloadParams().WithBody(&models.SignRawPayloadRequest{
 		OrganizationID: t.DefaultOrganization(),
 		TimestampMs:    RequestTimestamp(),
@@ -165,7 +178,7 @@ func (t *TurnkeyClient) SignMultipleHash(accountAddress string, hash []string) (
 		S := *sig.S
 		V := *sig.V
 
-		fmt.Println("R:", R, "S:", S, "V:", V)
+		slog.Debug("turnkey signature", "r", R, "s", S, "v", V)
 
 		if len(R) != 64 || len(S) != 64 {
 			return nil, fmt.Errorf("invalid R or S length: R=%d S=%d", len(R), len(S))
@@ -204,9 +217,20 @@ func (t *TurnkeyClient) SignMultipleHash(accountAddress string, hash []string) (
 	return processedSignatures, nil
 }
 
-// DefaultOrganization returns the hardcoded organization ID for Turnkey API calls
-// 返回硬编码的组织 ID 用于 Turnkey API 调用
+// Ping checks that the Turnkey API is reachable and accepts the configured API key,
+// with a whoami call on the organization.
+func (t *TurnkeyClient) Ping(ctx context.Context) error {
+	params := sessions.NewGetWhoamiParams().WithContext(ctx).WithBody(&models.GetWhoamiRequest{
+		OrganizationID: t.DefaultOrganization(),
+	})
+
+	_, err := t.V0().Sessions.GetWhoami(params, t.Authenticator)
+	return err
+}
+
+// DefaultOrganization returns the configured organization ID for Turnkey API calls
+// 返回配置的组织 ID 用于 Turnkey API 调用
 func (t *TurnkeyClient) DefaultOrganization() *string {
-	orgID := "6c00de4c-46f5-4519-822e-4ab049960f41"
+	orgID := t.organizationID
 	return &orgID
 }
@@ -1,75 +1,9 @@
 package types
 
-import (
-	"math/big"
+import "eolia-common/userop"
 
-	"github.com/ethereum/go-ethereum/common"
-)
-
-type UserOperation struct {
-	// The Account making the UserOperation.
-	Sender common.Address
-
-	// Anti-replay parameter.
-	Nonce string
-
-	// Account Factory for new Accounts OR 0x7702 flag for EIP-7702 Accounts, otherwise address(0).
-	Factory string
-
-	// Data for the Account Factory if factory is provided OR EIP-7702 initialization data, or empty array.
-	FactoryData []byte
-
-	// The data to pass to the sender during the main execution call.
-	CallData []byte
-
-	// The amount of gas to allocate the main execution call.
-	CallGasLimit string
-
-	// The amount of gas to allocate for the verification step.
-	VerificationGasLimit string
-
-	// Extra gas to pay the bundler.
-	PreVerificationGas string
-
-	// Maximum fee per gas.
-	MaxFeePerGas string
-
-	// Maximum priority fee per gas.
-	MaxPriorityFeePerGas string
-
-	// Address of paymaster contract, (or empty, if the sender pays for gas by itself).
-	Paymaster string
-
-	// The amount of gas to allocate for the paymaster validation code (only if paymaster exists).
-	PaymasterVerificationGasLimit string
-
-	// The amount of gas to allocate for the paymaster post-operation code (only if paymaster exists).
-	PaymasterPostOpGasLimit string
-
-	// Data for paymaster (only if paymaster exists).
-	PaymasterData []byte
-
-	// Data passed into the sender to verify authorization
-	Signature []byte
-}
-
-type RawUserOperation struct {
-	Sender                        string
-	Nonce                         string
-	Factory                       string
-	FactoryData                   string
-	CallData                      string
-	CallGasLimit                  string
-	VerificationGasLimit          string
-	PreVerificationGas            string
-	MaxFeePerGas                  string
-	MaxPriorityFeePerGas          string
-	Paymaster                     string
-	PaymasterVerificationGasLimit string
-	PaymasterPostOpGasLimit       string
-	PaymasterData                 string
-	Signature                     string
-}
+// UserOperation is the unpacked v0.7 RPC format, shared with eolia-bundlr through eolia-common/userop.
+type UserOperation = userop.UserOperation
 
 type RawPackedUserOperation struct {
 	// The Account making the UserOperation.
@@ -100,14 +34,5 @@ type RawPackedUserOperation struct {
 	Signature string `json:"signature"`
 }
 
-type PackedUserOperation struct {
-	Sender             common.Address `json:"sender"`
-	Nonce              *big.Int       `json:"nonce"`
-	InitCode           []byte         `json:"initCode"`
-	CallData           []byte         `json:"callData"`
-	AccountGasLimits   [32]byte       `json:"accountGasLimits"`
-	PreVerificationGas *big.Int       `json:"preVerificationGas"`
-	GasFees            [32]byte       `json:"gasFees"`
-	PaymasterAndData   []byte         `json:"paymasterAndData"`
-	Signature          []byte         `json:"signature"`
-}
+// PackedUserOperation is shared with eolia-bundlr through eolia-common/userop.
+type PackedUserOperation = userop.PackedUserOperation
@@ -2,13 +2,17 @@ package utils
 
 import (
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+var jwtSecret []byte
+
+// SetJWTSecret sets the key tokens are signed and verified with. Call it before serving.
+func SetJWTSecret(secret string) {
+	jwtSecret = []byte(secret)
+}
 
 type CustomClaims struct {
 	WalletName     string `json:"wallet_name"`
@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eolia-signer/db"
+	"eolia-signer/models"
+)
+
+const (
+	// Deliveries claimed per round.
+	DISPATCH_BATCH = 32
+	// How often the queue is polled for retries that came due.
+	DISPATCH_POLL_INTERVAL = 5 * time.Second
+
+	RETRY_MIN_DELAY = 10 * time.Second
+	RETRY_MAX_DELAY = time.Hour
+	// Bytes of a failed response kept as last_error.
+	MAX_ERROR_BODY = 512
+)
+
+// Dispatcher POSTs the queued deliveries. Several signer replicas can run one: claimed
+// deliveries are leased, so each attempt is made by a single replica.
+type Dispatcher struct {
+	DB     *db.DB
+	Config Config
+
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewDispatcher(database *db.DB, cfg Config) *Dispatcher {
+	return &Dispatcher{
+		DB:     database,
+		Config: cfg,
+		client: &http.Client{
+			Timeout: cfg.timeout(),
+			// A redirect could send the signed payload somewhere the operator didn't register.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run look for due deliveries now rather than at the next poll.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(DISPATCH_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatch sends due deliveries until none is left.
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	// Long enough for a whole batch to time out one after the other.
+	lease := DISPATCH_BATCH*d.Config.timeout() + time.Minute
+
+	for ctx.Err() == nil {
+		deliveries, err := d.DB.ClaimWebhookDeliveries(ctx, DISPATCH_BATCH, lease)
+		if err != nil {
+			slog.Error("failed to claim webhook deliveries", "error", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			d.deliver(ctx, &delivery)
+		}
+		if len(deliveries) < DISPATCH_BATCH {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	log := slog.With("delivery", delivery.ID, "endpoint", delivery.EndpointID, "event", delivery.EventType, "attempt", delivery.Attempts+1)
+
+	statusCode, err := d.post(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the attempt is made again.
+		return
+	}
+	if err == nil {
+		if err := d.DB.MarkWebhookDelivered(ctx, delivery.ID, statusCode); err != nil {
+			log.Error("failed to mark webhook delivered", "error", err)
+		}
+		log.Debug("webhook delivered", "status", statusCode)
+		return
+	}
+
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	if delivery.Attempts+1 >= d.Config.maxAttempts() {
+		log.Warn("webhook delivery failed for good, moved to dead letters", "status", statusCode, "error", err)
+		if err := d.DB.DeadLetterWebhookDelivery(ctx, delivery.ID, code, err.Error()); err != nil {
+			log.Error("failed to dead-letter webhook delivery", "error", err)
+		}
+		return
+	}
+
+	backoff := retryDelay(delivery.Attempts)
+	log.Info("webhook delivery failed, retrying", "status", statusCode, "error", err, "retry_in", backoff)
+	if err := d.DB.RetryWebhookDelivery(ctx, delivery.ID, code, err.Error(), backoff); err != nil {
+		log.Error("failed to schedule webhook retry", "error", err)
+	}
+}
+
+// post sends the delivery and returns the response status, 0 when there was none.
+// Anything but a 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "eolia-signer-webhooks")
+	req.Header.Set(HEADER_EVENT, delivery.EventType)
+	req.Header.Set(HEADER_DELIVERY, delivery.EventID)
+	req.Header.Set(HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HEADER_SIGNATURE, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, MAX_ERROR_BODY))
+		return resp.StatusCode, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, MAX_ERROR_BODY))
+	return resp.StatusCode, fmt.Errorf("endpoint answered %s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+// retryDelay is the wait after the attempts-th failed attempt (0-based): 10s doubling up
+// to an hour, plus up to 20% jitter so retries of a burst spread out.
+func retryDelay(attempts int) time.Duration {
+	delay := RETRY_MAX_DELAY
+	if attempts < 10 {
+		delay = min(RETRY_MIN_DELAY<<attempts, RETRY_MAX_DELAY)
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"eolia-common/userop"
+	"eolia-signer/db"
+	"eolia-signer/ethclient"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// Subscription type of the bundler's eth_subscribe.
+	USEROP_SUBSCRIPTION = "userOperationStatus"
+
+	RECONNECT_MIN_DELAY = time.Second
+	RECONNECT_MAX_DELAY = 30 * time.Second
+
+	// Blocks per eth_getLogs request of the backfill.
+	BACKFILL_BLOCK_RANGE = 1000
+	// How often the cursor moves forward while connected, so a later backfill stays short.
+	BACKFILL_INTERVAL = time.Minute
+)
+
+// Listener follows every op of the bundler and queues a delivery of each final outcome
+// for the endpoints that want it. Following every op takes the bundler's admin token.
+// Outcomes missed while disconnected are backfilled from the EntryPoint logs, for the
+// accounts of the signer's users.
+type Listener struct {
+	DB *db.DB
+	// Reads the EntryPoint logs of the backfill.
+	Eth *ethclient.Client
+	// JSON-RPC WebSocket endpoint of the bundler (ws://.../rpc).
+	BundlrURL string
+	// admin_token of the bundler.
+	BundlrAdminToken string
+	// Woken up after events were queued; may be nil.
+	Dispatcher *Dispatcher
+}
+
+// Run subscribes until ctx is done, reconnecting with backoff when the bundler goes away.
+func (l *Listener) Run(ctx context.Context) {
+	delay := RECONNECT_MIN_DELAY
+	for {
+		started := time.Now()
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A subscription that lived a while was healthy; start over from the shortest delay.
+		if time.Since(started) > RECONNECT_MAX_DELAY {
+			delay = RECONNECT_MIN_DELAY
+		}
+		slog.Warn("webhook op subscription ended, reconnecting", "error", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, RECONNECT_MAX_DELAY)
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer "+l.BundlrAdminToken)
+	client, err := rpc.DialOptions(ctx, l.BundlrURL, rpc.WithHeaders(headers))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	events := make(chan json.RawMessage, 64)
+	sub, err := client.EthSubscribe(ctx, events, USEROP_SUBSCRIPTION, struct{}{})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	// Subscribed first, then backfilled: an outcome seen both ways is queued once.
+	if err := l.backfill(ctx); err != nil {
+		return fmt.Errorf("backfill failed: %w", err)
+	}
+
+	ticker := time.NewTicker(BACKFILL_INTERVAL)
+	defer ticker.Stop()
+
+	slog.Info("webhooks following bundler op events", "url", l.BundlrURL)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			return err
+		case <-ticker.C:
+			if err := l.backfill(ctx); err != nil {
+				slog.Warn("webhook backfill failed", "error", err)
+			}
+		case raw := <-events:
+			l.enqueue(ctx, raw)
+		}
+	}
+}
+
+// backfill queues the outcomes the EntryPoint logged since the cursor for the accounts of
+// the signer's users, and moves the cursor to the chain head. On the first run there is
+// nothing to catch up on: the cursor starts at the head.
+func (l *Listener) backfill(ctx context.Context) error {
+	head, err := l.Eth.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	cursor, ok, err := l.DB.GetWebhookCursor(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return l.DB.SetWebhookCursor(ctx, head)
+	}
+
+	for from := cursor + 1; from <= head; from += BACKFILL_BLOCK_RANGE {
+		to := min(from+BACKFILL_BLOCK_RANGE-1, head)
+		events, err := l.Eth.UserOperationEvents(ctx, from, to)
+		if err != nil {
+			return err
+		}
+
+		senders := make([]string, len(events))
+		for i, e := range events {
+			senders[i] = e.Sender.Hex()
+		}
+		known, err := l.DB.KnownAccounts(ctx, senders)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if known[strings.ToLower(e.Sender.Hex())] {
+				l.enqueueLogged(ctx, e)
+			}
+		}
+
+		if err := l.DB.SetWebhookCursor(ctx, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueLogged queues an outcome read from the EntryPoint logs, as a bundler status event
+// marked "backfilled". Its receipt only carries what the logs tell.
+func (l *Listener) enqueueLogged(ctx context.Context, e ethclient.UserOperationEvent) {
+	status := "included"
+	if !e.Success {
+		status = "reverted"
+	}
+
+	data := map[string]any{
+		"userOpHash":      e.UserOpHash.Hex(),
+		"sender":          e.Sender.Hex(),
+		"nonce":           hexutil.EncodeBig(e.Nonce),
+		"status":          status,
+		"transactionHash": e.TxHash.Hex(),
+		"backfilled":      true,
+		"receipt": map[string]any{
+			"userOpHash":    e.UserOpHash.Hex(),
+			"sender":        e.Sender.Hex(),
+			"nonce":         hexutil.EncodeBig(e.Nonce),
+			"paymaster":     e.Paymaster.Hex(),
+			"success":       e.Success,
+			"actualGasUsed": hexutil.EncodeBig(e.ActualGasUsed),
+			"actualGasCost": hexutil.EncodeBig(e.ActualGasCost),
+			"receipt": map[string]any{
+				"transactionHash": e.TxHash.Hex(),
+				"blockHash":       e.BlockHash.Hex(),
+				"blockNumber":     hexutil.EncodeUint64(e.BlockNumber),
+			},
+		},
+	}
+	if !e.Success {
+		data["revertReason"] = userop.DecodeRevertReason(e.RevertReason)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("failed to encode backfilled op event", "userOpHash", e.UserOpHash.Hex(), "error", err)
+		return
+	}
+	l.enqueue(ctx, raw)
+}
+
+func (l *Listener) enqueue(ctx context.Context, raw json.RawMessage) {
+	var ev opEvent
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		slog.Warn("skipping malformed op event", "error", err)
+		return
+	}
+	eventType := eventType(ev.Status)
+	if eventType == "" {
+		return
+	}
+
+	payload, err := json.Marshal(Payload{
+		ID:        ev.UserOpHash + ":" + ev.Status,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      raw,
+	})
+	if err != nil {
+		slog.Error("failed to encode webhook payload", "userOpHash", ev.UserOpHash, "error", err)
+		return
+	}
+
+	queued, err := l.DB.EnqueueWebhookEvent(ctx, ev.UserOpHash+":"+ev.Status, eventType, strings.ToLower(ev.Sender), payload)
+	if err != nil {
+		slog.Error("failed to queue webhook deliveries", "userOpHash", ev.UserOpHash, "event", eventType, "error", err)
+		return
+	}
+	if queued > 0 {
+		slog.Debug("queued webhook deliveries", "userOpHash", ev.UserOpHash, "event", eventType, "endpoints", queued)
+		if l.Dispatcher != nil {
+			l.Dispatcher.Wake()
+		}
+	}
+}
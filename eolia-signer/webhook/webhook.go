@@ -0,0 +1,119 @@
+// Package webhook delivers the final outcome of user operations (included, reverted,
+// dropped) to the HTTP endpoints registered by operators. Events come from the bundler's
+// userOperationStatus subscription and are queued in Postgres, so deliveries survive
+// restarts, are retried with backoff and end up in the dead letters when they keep failing.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Event types, i.e. the op statuses a webhook can follow.
+const (
+	EVENT_USEROP_INCLUDED = "userop.included"
+	EVENT_USEROP_REVERTED = "userop.reverted"
+	EVENT_USEROP_DROPPED  = "userop.dropped"
+)
+
+var EventTypes = []string{EVENT_USEROP_INCLUDED, EVENT_USEROP_REVERTED, EVENT_USEROP_DROPPED}
+
+// Headers of a delivery.
+const (
+	HEADER_EVENT     = "X-Eolia-Event"
+	HEADER_DELIVERY  = "X-Eolia-Delivery"
+	HEADER_TIMESTAMP = "X-Eolia-Timestamp"
+	HEADER_SIGNATURE = "X-Eolia-Signature"
+)
+
+const (
+	DEFAULT_MAX_ATTEMPTS = 8
+	DEFAULT_TIMEOUT      = 10 * time.Second
+)
+
+type Config struct {
+	// Follow the bundler and deliver events; endpoints can be managed either way.
+	Enabled bool `yaml:"enabled"`
+	// Attempts before a delivery goes to the dead letters (0 = 8).
+	MaxAttempts int `yaml:"max_attempts"`
+	// Timeout of one delivery request (0 = 10s).
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c Config) Validate() error {
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+func (c Config) maxAttempts() int {
+	if c.MaxAttempts == 0 {
+		return DEFAULT_MAX_ATTEMPTS
+	}
+	return c.MaxAttempts
+}
+
+func (c Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return DEFAULT_TIMEOUT
+	}
+	return c.Timeout
+}
+
+func IsEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// opEvent holds the fields of a bundler status event the signer routes on; the event
+// itself is forwarded untouched as the payload's data.
+type opEvent struct {
+	UserOpHash string `json:"userOpHash"`
+	Sender     string `json:"sender"`
+	Status     string `json:"status"`
+}
+
+// eventType maps a bundler op status to its event type, "" for intermediate statuses.
+func eventType(status string) string {
+	switch status {
+	case "included":
+		return EVENT_USEROP_INCLUDED
+	case "reverted":
+		return EVENT_USEROP_REVERTED
+	case "dropped":
+		return EVENT_USEROP_DROPPED
+	}
+	return ""
+}
+
+// Payload is the body POSTed to the endpoints.
+type Payload struct {
+	// Unique per op and outcome (<userOpHash>:<status>); receivers can dedupe on it.
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the X-Eolia-Signature of a delivery: the hex HMAC-SHA256, keyed with the
+// endpoint secret, of "<timestamp>.<body>".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
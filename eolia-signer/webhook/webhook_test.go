@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Vectors from Python's hmac.new(secret, b"<timestamp>." + body, hashlib.sha256).
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{"whsec_test", 1700000000, `{"id":"0xab:included"}`, "sha256=8bf48539e98412ae91a5b5b15972b3df7f712646fb78350193ed317c210dbdac"},
+		{"", 0, ``, "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %d, %s) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+
+	if Sign("whsec_test", 1700000001, []byte(`{"id":"0xab:included"}`)) == tests[0].want {
+		t.Error("signature doesn't cover the timestamp")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{5, 320 * time.Second},
+		{8, 2560 * time.Second},
+		{9, time.Hour},
+		{10, time.Hour},
+		{64, time.Hour},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := retryDelay(tt.attempts)
+			if got < tt.base || got > tt.base+tt.base/5 {
+				t.Errorf("retryDelay(%d) = %s, want within [%s, %s]", tt.attempts, got, tt.base, tt.base+tt.base/5)
+				break
+			}
+		}
+	}
+}
+
+func TestEventType(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"included", EVENT_USEROP_INCLUDED},
+		{"reverted", EVENT_USEROP_REVERTED},
+		{"dropped", EVENT_USEROP_DROPPED},
+		{"pending", ""},
+		{"bundled", ""},
+		{"submitted", ""},
+		{"Included", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := eventType(tt.status); got != tt.want {
+			t.Errorf("eventType(%q) = %q, want %q", tt.status, got, tt.want)
+		}
+		if tt.want != "" && !IsEventType(tt.want) {
+			t.Errorf("IsEventType(%q) = false", tt.want)
+		}
+	}
+	if IsEventType("userop.pending") {
+		t.Error("IsEventType(userop.pending) = true")
+	}
+}